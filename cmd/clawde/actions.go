@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mattduck/clawde/internal/control"
+	"github.com/mattduck/clawde/internal/diffparser"
+	"github.com/mattduck/clawde/internal/tmux"
+	"github.com/mattduck/clawde/internal/vt"
+)
+
+// overlayDuration is how long status messages stay on screen
+const overlayDuration = 3 * time.Second
+
+// setFileWatching starts or stops the file watcher
+func (w *CLIWrapper) setFileWatching(enabled bool) error {
+	w.watcherMutex.Lock()
+	defer w.watcherMutex.Unlock()
+	return w.setFileWatchingLocked(enabled)
+}
+
+// toggleFileWatching starts the file watcher if it's stopped, or stops it,
+// and returns whether it's now running. Starting it walks the watch
+// directory, so call it outside the input loop.
+func (w *CLIWrapper) toggleFileWatching() (bool, error) {
+	w.watcherMutex.Lock()
+	defer w.watcherMutex.Unlock()
+	enabled := w.fileWatcher == nil
+	if err := w.setFileWatchingLocked(enabled); err != nil {
+		return false, err
+	}
+	return enabled, nil
+}
+
+func (w *CLIWrapper) setFileWatchingLocked(enabled bool) error {
+	if !enabled {
+		if w.fileWatcher != nil {
+			w.fileWatcher.Close()
+			w.fileWatcher = nil
+			w.index.Unwatch()
+			logger.Info("Stopped file watcher")
+		}
+		return nil
+	}
+	if w.fileWatcher != nil {
+		return nil
+	}
+
+	watchDir := w.watchDir
+	if watchDir == "" {
+		watchDir = "."
+	}
+	fileWatcher, err := setupFileWatcher(watchDir, w)
+	if err != nil {
+		return err
+	}
+	w.fileWatcher = fileWatcher
+	return nil
+}
+
+// fileWatching reports whether the file watcher is running
+func (w *CLIWrapper) fileWatching() bool {
+	w.watcherMutex.Lock()
+	defer w.watcherMutex.Unlock()
+	return w.fileWatcher != nil
+}
+
+// statusLine summarises clawde's state for the status overlay
+func (w *CLIWrapper) statusLine() string {
+	state := w.screenState()
+	mode := string(state.VimMode)
+	if mode == "" {
+		mode = "unknown"
+	}
+	activity := "unknown"
+	switch {
+	case state.PermissionPrompt:
+		activity = "permission prompt"
+	case state.Busy:
+		activity = "busy"
+	case state.SlashMenu:
+		activity = "slash menu"
+	case state.Idle:
+		activity = "idle"
+	}
+
+	parts := []string{
+		"clawde",
+		"mode: " + mode,
+		"claude: " + activity,
+		"watching: " + onOff(w.fileWatching()),
+		"throttling: " + onOff(w.outputThrottling.Load()),
+	}
+	if w.comments != nil {
+		if pending, stuck, abandoned := w.comments.Counts(); pending > 0 || abandoned > 0 {
+			summary := fmt.Sprintf("comments: %d pending, %d unresolved", pending, abandoned)
+			if stuck > 0 {
+				summary += fmt.Sprintf(", %d stuck in queue", stuck)
+			}
+			parts = append(parts, summary)
+		}
+	}
+	if prefix := w.keymap.Prefix(); prefix != "" {
+		parts = append(parts, "prefix: "+prefix)
+	}
+	return strings.Join(parts, " | ")
+}
+
+// toggled reports a feature that was turned on or off
+func (w *CLIWrapper) toggled(feature string, enabled bool) {
+	go w.showOverlay(fmt.Sprintf("clawde: %s %s", feature, onOff(enabled)))
+	logger.Info("Toggled feature", "feature", feature, "enabled", enabled)
+	w.publish(control.EventToggle, map[string]interface{}{"feature": feature, "enabled": enabled})
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+// showOverlay draws a message over the top line of the screen for a few
+// seconds, then puts back what the wrapped program had drawn there
+func (w *CLIWrapper) showOverlay(message string) {
+	cols, _ := w.screen.Size()
+	message = vt.Truncate(message, cols)
+
+	// Hold the output lock so the overlay isn't interleaved with a redraw.
+	// The cursor goes back to where the screen model has it, rather than
+	// using the terminal's saved cursor, which the program may be using. The
+	// model can be ahead of the terminal while output is throttled, so that
+	// output is flushed first.
+	w.outputBuffer.mutex.Lock()
+	w.outputBuffer.flush()
+	fmt.Fprintf(os.Stdout, "\x1b[1;1H\x1b[2K\x1b[7m%s\x1b[0m%s", message, cursorPosition(w.screen.Cursor()))
+	w.outputBuffer.mutex.Unlock()
+
+	time.AfterFunc(overlayDuration, func() {
+		// The screen model only has text, so colours on this line are lost
+		// until the program redraws it
+		w.outputBuffer.mutex.Lock()
+		w.outputBuffer.flush()
+		line := w.screen.Lines()[0]
+		fmt.Fprintf(os.Stdout, "\x1b[1;1H\x1b[2K%s%s", line, cursorPosition(w.screen.Cursor()))
+		w.outputBuffer.mutex.Unlock()
+	})
+}
+
+// cursorPosition returns the sequence that moves the cursor to c
+func cursorPosition(c vt.Cursor) string {
+	return fmt.Sprintf("\x1b[%d;%dH", c.Y+1, c.X+1)
+}
+
+// lastDiff finds the most recent diff claude has shown, searching the
+// scrollback as well as the visible screen
+func (w *CLIWrapper) lastDiff() (diffparser.FileDiff, bool) {
+	lines := append(w.screen.Scrollback(), w.screen.Lines()...)
+	diffs := diffparser.Parse(strings.Join(lines, "\n"))
+	if len(diffs) == 0 {
+		return diffparser.FileDiff{}, false
+	}
+	return diffs[len(diffs)-1], true
+}
+
+// openLastDiff writes the most recent diff to a temp file and opens it in a
+// tmux split, or shows the file path if we're not in tmux
+func (w *CLIWrapper) openLastDiff() {
+	diff, ok := w.lastDiff()
+	if !ok {
+		w.showOverlay("clawde: no diff found")
+		return
+	}
+
+	f, err := os.CreateTemp("", "clawde-*.diff")
+	if err != nil {
+		logger.Error("Failed to create diff file", "error", err)
+		w.showOverlay("clawde: failed to write diff")
+		return
+	}
+	_, err = f.WriteString(diff.ToUnified())
+	f.Close()
+	if err != nil {
+		logger.Error("Failed to write diff file", "error", err)
+		w.showOverlay("clawde: failed to write diff")
+		return
+	}
+	logger.Info("Wrote last diff", "file", diff.Path, "path", f.Name())
+
+	if !tmux.IsRunningInTmux() {
+		w.showOverlay("clawde: diff for " + diff.Path + " written to " + f.Name())
+		return
+	}
+
+	pager := os.Getenv("PAGER")
+	if pager == "" {
+		pager = "less -R"
+	}
+	if err := tmux.SplitWindow(pager + " " + shellQuote(f.Name())); err != nil {
+		logger.Error("Failed to open diff pane", "error", err)
+		w.showOverlay("clawde: diff written to " + f.Name())
+	}
+}
+
+// shellQuote quotes s for use as a single shell word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// clawdeFlagPrefix marks clawde's own flags when they are mixed in with
+// claude's arguments, e.g. --clawde-watch-dir=src
+const clawdeFlagPrefix = "--clawde-"
+
+// cliOptions holds clawde's command-line flags. Flags override the config
+// files and environment.
+type cliOptions struct {
+	WatchDir       string
+	LogFile        string
+	NoThrottle     bool
+	ConfigFile     string
+	RecordFile     string
+	TranscriptFile string
+	Version        bool
+}
+
+func newFlagSet(opts *cliOptions, output io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("clawde", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&opts.WatchDir, "watch-dir", "", "Watch this directory for AI comments (enables file watching)")
+	fs.StringVar(&opts.LogFile, "log-file", "", "Write logs to this file")
+	fs.BoolVar(&opts.NoThrottle, "no-throttle", false, "Disable output throttling")
+	fs.StringVar(&opts.ConfigFile, "config", "", "Path to the user config file")
+	fs.StringVar(&opts.RecordFile, "record", "", "Record the session to this asciicast file")
+	fs.StringVar(&opts.TranscriptFile, "transcript", "", "Write a transcript to this file (.md or .jsonl)")
+	fs.BoolVar(&opts.Version, "version", false, "Print the clawde version and exit")
+	fs.Usage = func() {
+		fmt.Fprintf(output, "Usage: clawde [clawde flags] -- [claude args]\n")
+		fmt.Fprintf(output, "   or: clawde [claude args] [--clawde-<flag>...]\n")
+		fmt.Fprintf(output, "   or: clawde replay [--speed N] FILE\n")
+		fmt.Fprintf(output, "   or: clawde processed [list|clear|path]\n\nclawde flags:\n")
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs separates clawde's flags from the arguments passed through to
+// claude. If there is a "--" with only clawde flags before it, everything
+// after it is for claude. Otherwise only --clawde-* flags are taken and the
+// rest goes to claude unchanged, including any "--" of claude's own. The old
+// --watch=DIR form is still accepted as the last argument.
+func parseArgs(args []string, output io.Writer) (*cliOptions, []string, error) {
+	opts := &cliOptions{}
+	fs := newFlagSet(opts, output)
+
+	if i := clawdeSeparator(fs, args); i >= 0 {
+		var ours []string
+		for _, a := range args[:i] {
+			ours = append(ours, stripClawdePrefix(a))
+		}
+		if err := fs.Parse(ours); err != nil {
+			return nil, nil, err
+		}
+		return opts, args[i+1:], nil
+	}
+
+	var ours, passthrough []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if i == len(args)-1 && strings.HasPrefix(arg, "--watch=") {
+			ours = append(ours, "--watch-dir="+strings.TrimPrefix(arg, "--watch="))
+			continue
+		}
+		if !strings.HasPrefix(arg, clawdeFlagPrefix) {
+			passthrough = append(passthrough, arg)
+			continue
+		}
+
+		flagArg := stripClawdePrefix(arg)
+		ours = append(ours, flagArg)
+
+		// Value flags can take their value from the next argument
+		name := strings.TrimPrefix(flagArg, "--")
+		if !strings.Contains(name, "=") && isValueFlag(fs, name) && i+1 < len(args) {
+			i++
+			ours = append(ours, args[i])
+		}
+	}
+
+	if err := fs.Parse(ours); err != nil {
+		return nil, nil, err
+	}
+	return opts, passthrough, nil
+}
+
+// clawdeSeparator returns the index of the "--" that ends clawde's flags, or
+// -1 if the arguments don't start with clawde flags followed by "--". A "--"
+// that is a flag's value doesn't count.
+func clawdeSeparator(fs *flag.FlagSet, args []string) int {
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--" {
+			return i
+		}
+		if !strings.HasPrefix(args[i], "-") {
+			return -1
+		}
+		name := strings.TrimLeft(stripClawdePrefix(args[i]), "-")
+		name, _, hasValue := strings.Cut(name, "=")
+		if fs.Lookup(name) == nil {
+			return -1
+		}
+		if !hasValue && isValueFlag(fs, name) {
+			i++
+		}
+	}
+	return -1
+}
+
+// stripClawdePrefix turns --clawde-foo into --foo
+func stripClawdePrefix(arg string) string {
+	if strings.HasPrefix(arg, clawdeFlagPrefix) {
+		return "--" + strings.TrimPrefix(arg, clawdeFlagPrefix)
+	}
+	return arg
+}
+
+// isValueFlag reports whether the named flag needs a value
+func isValueFlag(fs *flag.FlagSet, name string) bool {
+	f := fs.Lookup(name)
+	if f == nil {
+		return false
+	}
+	if bf, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && bf.IsBoolFlag() {
+		return false
+	}
+	return true
+}
+
+// apply overrides config options with any flags that were set
+func (opts *cliOptions) apply(cfg *Config) {
+	if opts.WatchDir != "" {
+		cfg.WatchDir = opts.WatchDir
+		cfg.EnableWatchFiles = true
+	}
+	if opts.LogFile != "" {
+		cfg.LogFile = opts.LogFile
+	}
+	if opts.RecordFile != "" {
+		cfg.RecordFile = opts.RecordFile
+	}
+	if opts.TranscriptFile != "" {
+		cfg.TranscriptFile = opts.TranscriptFile
+	}
+	if opts.NoThrottle {
+		cfg.EnableOutputThrottling = false
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		wantOpts    cliOptions
+		wantClaude  []string
+		expectError bool
+	}{
+		{
+			name:       "no args",
+			args:       nil,
+			wantClaude: nil,
+		},
+		{
+			name:       "claude args pass through",
+			args:       []string{"--resume", "-p", "hello"},
+			wantClaude: []string{"--resume", "-p", "hello"},
+		},
+		{
+			name:       "flags before double dash",
+			args:       []string{"--watch-dir", "src", "--no-throttle", "--", "--resume"},
+			wantOpts:   cliOptions{WatchDir: "src", NoThrottle: true},
+			wantClaude: []string{"--resume"},
+		},
+		{
+			name:       "prefixed flags before double dash",
+			args:       []string{"--clawde-log-file=/tmp/x.log", "--"},
+			wantOpts:   cliOptions{LogFile: "/tmp/x.log"},
+			wantClaude: []string{},
+		},
+		{
+			name:       "prefixed flags mixed with claude args",
+			args:       []string{"--resume", "--clawde-config", "my.toml", "-p", "hi", "--clawde-no-throttle"},
+			wantOpts:   cliOptions{ConfigFile: "my.toml", NoThrottle: true},
+			wantClaude: []string{"--resume", "-p", "hi"},
+		},
+		{
+			name:       "prefixed version",
+			args:       []string{"--clawde-version"},
+			wantOpts:   cliOptions{Version: true},
+			wantClaude: nil,
+		},
+		{
+			name:       "legacy watch as last argument",
+			args:       []string{"--resume", "--watch=src"},
+			wantOpts:   cliOptions{WatchDir: "src"},
+			wantClaude: []string{"--resume"},
+		},
+		{
+			name:       "claude args with double dash",
+			args:       []string{"--resume", "--", "-p"},
+			wantClaude: []string{"--resume", "--", "-p"},
+		},
+		{
+			name:       "claude prompt after double dash",
+			args:       []string{"-p", "--", "--not-a-flag"},
+			wantClaude: []string{"-p", "--", "--not-a-flag"},
+		},
+		{
+			name:       "prefixed flags with claude's double dash",
+			args:       []string{"--clawde-no-throttle", "--model", "opus", "--", "hi"},
+			wantOpts:   cliOptions{NoThrottle: true},
+			wantClaude: []string{"--model", "opus", "--", "hi"},
+		},
+		{
+			name:       "flag value that looks like an argument",
+			args:       []string{"--watch-dir", "--", "--", "-p"},
+			wantOpts:   cliOptions{WatchDir: "--"},
+			wantClaude: []string{"-p"},
+		},
+		{
+			name:       "value flag missing its value",
+			args:       []string{"--log-file", "--"},
+			wantClaude: []string{"--log-file", "--"},
+		},
+		{
+			name:        "unknown prefixed flag",
+			args:        []string{"--clawde-nope"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, claudeArgs, err := parseArgs(tt.args, io.Discard)
+			if tt.expectError {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseArgs() error = %v", err)
+			}
+			if *opts != tt.wantOpts {
+				t.Errorf("options = %+v, want %+v", *opts, tt.wantOpts)
+			}
+			if !reflect.DeepEqual(claudeArgs, tt.wantClaude) {
+				t.Errorf("claude args = %q, want %q", claudeArgs, tt.wantClaude)
+			}
+		})
+	}
+}
+
+func TestCLIOptionsApply(t *testing.T) {
+	cfg := defaultConfig()
+	opts := &cliOptions{WatchDir: "src", LogFile: "clawde.log", NoThrottle: true}
+	opts.apply(cfg)
+
+	if !cfg.EnableWatchFiles || cfg.WatchDir != "src" {
+		t.Errorf("expected watching to be enabled for src, got %v %q", cfg.EnableWatchFiles, cfg.WatchDir)
+	}
+	if cfg.LogFile != "clawde.log" {
+		t.Errorf("expected log file to be set, got %q", cfg.LogFile)
+	}
+	if cfg.EnableOutputThrottling {
+		t.Error("expected output throttling to be disabled")
+	}
+
+	// Unset flags leave the config alone
+	cfg = defaultConfig()
+	(&cliOptions{}).apply(cfg)
+	if !reflect.DeepEqual(cfg, defaultConfig()) {
+		t.Errorf("expected config to be unchanged, got %+v", cfg)
+	}
+}
@@ -7,9 +7,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/mattduck/clawde/internal/lexer"
+	"github.com/mattduck/clawde/internal/processed"
 )
 
 // AIComment represents an AI-related comment found in source code
@@ -21,93 +24,57 @@ type AIComment struct {
 	FullLine     string   // The complete line containing the comment
 	ContextLines []string // Surrounding lines for context
 	ActionType   string   // "?" for questions, "!" for commands, ":" for context
+	Marker       string   // The marker found, as configured, e.g. "AI?"
+	Anchor       string   // The nearest code around the comment, used in the fingerprint
 	Hash         string   // Fingerprint for caching/deduplication
-}
-
-// MultilineCommentPair represents a paired start/end pattern for multiline comments
-type MultilineCommentPair struct {
-	Start *regexp.Regexp // Pattern to match comment start (e.g., /*)
-	End   *regexp.Regexp // Pattern to match comment end (e.g., */)
-}
 
-// MultilineTokenPair represents the actual tokens for multiline comments
-type MultilineTokenPair struct {
-	Start string // The start token (e.g., "/*")
-	End   string // The end token (e.g., "*/")
+	// The function, method, type or class the comment is in or directly above.
+	// Name is empty if there isn't one.
+	Symbol lexer.Symbol
 }
 
-// CommentPattern defines how to detect comments in different file types
-type CommentPattern struct {
-	SingleLine       []*regexp.Regexp       // Multiple single-line comment patterns
-	Multiline        []MultilineCommentPair // Paired start/end patterns for multiline comments
-	SingleLineTokens []string               // The actual single-line tokens (e.g., "//", "#")
-	MultilineTokens  []MultilineTokenPair   // The actual multiline tokens
+// markerWords make a comment an AI comment when followed by an action type,
+// e.g. "AI!". They are matched case-insensitively and can be changed in the
+// config.
+var markerWords = []string{"AI"}
+
+// matchMarker checks a lowercased line for a marker with the given action
+// type. ! and ? markers can be at the start or end of the line, : markers only
+// at the start. It returns the marker as configured.
+func matchMarker(lowerLine, actionType string) (string, bool) {
+	for _, word := range markerWords {
+		marker := strings.ToLower(word) + actionType
+		if strings.HasPrefix(lowerLine, marker) {
+			return word + actionType, true
+		}
+		if actionType != ":" && strings.HasSuffix(lowerLine, " "+marker) {
+			return word + actionType, true
+		}
+	}
+	return "", false
 }
 
-// Comment patterns for different file extensions
-var commentPatterns = map[string]CommentPattern{
-	".go": {
-		SingleLine: []*regexp.Regexp{
-			regexp.MustCompile(`(?i)^\s*//\s*(.*AI[?!:].*)`),
-		},
-		Multiline: []MultilineCommentPair{
-			{
-				Start: regexp.MustCompile(`/\*`),
-				End:   regexp.MustCompile(`\*/`),
-			},
-		},
-		SingleLineTokens: []string{"//"},
-		MultilineTokens: []MultilineTokenPair{
-			{Start: "/*", End: "*/"},
-		},
-	},
-	".js": {
-		SingleLine: []*regexp.Regexp{
-			regexp.MustCompile(`(?i)^\s*//\s*(.*AI[?!:].*)`),
-		},
-		Multiline: []MultilineCommentPair{
-			{
-				Start: regexp.MustCompile(`/\*`),
-				End:   regexp.MustCompile(`\*/`),
-			},
-		},
-		SingleLineTokens: []string{"//"},
-		MultilineTokens: []MultilineTokenPair{
-			{Start: "/*", End: "*/"},
-		},
-	},
-	".py": {
-		SingleLine: []*regexp.Regexp{
-			regexp.MustCompile(`(?i)^\s*#\s*(.*AI[?!:].*)`),
-		},
-		Multiline: []MultilineCommentPair{
-			{
-				Start: regexp.MustCompile(`"""`),
-				End:   regexp.MustCompile(`"""`),
-			},
-			{
-				Start: regexp.MustCompile(`'''`),
-				End:   regexp.MustCompile(`'''`),
-			},
-		},
-		SingleLineTokens: []string{"#"},
-		MultilineTokens: []MultilineTokenPair{
-			{Start: `"""`, End: `"""`},
-			{Start: `'''`, End: `'''`},
-		},
-	},
+// primaryMarker returns the marker for an action type using the first marker
+// word, for prompts that don't refer to one comment
+func primaryMarker(actionType string) string {
+	return markerWords[0] + actionType
 }
 
-// Cache for processed comments to avoid reprocessing
-var processedComments = make(map[string]bool)
+// Comments that have already been sent, to avoid reprocessing. main replaces
+// this with a store saved to disk, see openProcessedStore.
+var processedComments = processed.New("", 0)
 
 // Size limits to prevent performance issues with large files/lines
 const (
-	maxFileSize      = 10 * 1024 * 1024 // 10MB - skip files larger than this
-	maxLineLength    = 10 * 1024        // 10KB - skip lines longer than this
-	maxTotalLines    = 50000            // Skip files with more lines than this
-	maxFilesToSearch = 10000            // Stop searching after this many files
-	maxCommentLength = 1000             // Maximum comment content length before truncation
+	maxLineLength    = 10 * 1024 // 10KB - skip lines longer than this
+	maxTotalLines    = 50000     // Skip files with more lines than this
+	maxCommentLength = 1000      // Maximum comment content length before truncation
+)
+
+// These limits can be changed in the config, see Config
+var (
+	maxFileSize      int64 = 10 * 1024 * 1024 // 10MB - skip files larger than this
+	maxFilesToSearch       = 10000            // Stop searching after this many files
 )
 
 // truncateComment truncates comment content if it exceeds maxCommentLength
@@ -118,83 +85,55 @@ func truncateComment(content string) string {
 	return content[:maxCommentLength] + "...(truncated)"
 }
 
-// checkForOptOut scans file content for NO_CLAWDE marker in any comment type
-func checkForOptOut(content string, ext string) bool {
-	lines := strings.Split(content, "\n")
-
-	// Get comment patterns for this file extension
-	patterns, exists := commentPatterns[ext]
-	if !exists {
-		return false
-	}
-
-	// Check single-line comments for NO_CLAWDE
-	for _, line := range lines {
-		for _, token := range patterns.SingleLineTokens {
-			if strings.Contains(line, token) {
-				// Extract comment content after the token
-				parts := strings.Split(line, token)
-				if len(parts) >= 2 {
-					commentContent := strings.Join(parts[1:], token)
-					if strings.Contains(strings.ToLower(commentContent), "no_clawde") {
-						logger.Debug("Found NO_CLAWDE opt-out marker in file, skipping comment processing")
-						return true
-					}
-				}
+// registerLanguages adds the languages declared in the config to the
+// language registry
+func registerLanguages(configs []LanguageConfig) {
+	for _, lc := range configs {
+		lang := &lexer.Language{
+			Name:      lc.Name,
+			Filenames: lc.Filenames,
+			Syntax: lexer.Syntax{
+				LineComments:   lc.LineComments,
+				NestedComments: lc.NestedComments,
+			},
+		}
+		for _, ext := range lc.Extensions {
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
 			}
+			lang.Extensions = append(lang.Extensions, ext)
+		}
+		for _, pair := range lc.BlockComments {
+			lang.Syntax.BlockComments = append(lang.Syntax.BlockComments, lexer.Delimiters{Start: pair[0], End: pair[1]})
 		}
+		for _, delim := range lc.Strings {
+			lang.Syntax.Strings = append(lang.Syntax.Strings, lexer.StringSyntax{Delim: delim})
+		}
+		// Longer delimiters first, so """ isn't read as an empty "" string
+		sort.SliceStable(lang.Syntax.Strings, func(i, j int) bool {
+			return len(lang.Syntax.Strings[i].Delim) > len(lang.Syntax.Strings[j].Delim)
+		})
+		lexer.Register(lang)
+		logger.Debug("Registered language from config", "name", lang.Name, "extensions", lang.Extensions, "filenames", lang.Filenames)
 	}
+}
 
-	// Check multiline comments for NO_CLAWDE
-	for _, pair := range patterns.Multiline {
-		inComment := false
-		var commentLines []string
-
-		for _, line := range lines {
-			if !inComment && pair.Start.MatchString(line) {
-				inComment = true
-				commentLines = []string{line}
-
-				// Check if end pattern is also on the same line (single-line multiline comment)
-				if pair.End.MatchString(line) {
-					// Process the comment immediately
-					fullComment := strings.Join(commentLines, "\n")
-					if strings.Contains(strings.ToLower(fullComment), "no_clawde") {
-						logger.Debug("Found NO_CLAWDE opt-out marker in file, skipping comment processing")
-						return true
-					}
-					inComment = false
-					commentLines = nil
-				}
-				continue
-			}
-
-			if inComment {
-				commentLines = append(commentLines, line)
-				if pair.End.MatchString(line) {
-					// Check the complete multiline comment
-					fullComment := strings.Join(commentLines, "\n")
-					if strings.Contains(strings.ToLower(fullComment), "no_clawde") {
-						logger.Debug("Found NO_CLAWDE opt-out marker in file, skipping comment processing")
-						return true
-					}
-					inComment = false
-					commentLines = nil
-				}
-			}
+// hasOptOut checks the file's comments for a NO_CLAWDE marker
+func hasOptOut(comments []lexer.Comment) bool {
+	for _, c := range comments {
+		if strings.Contains(strings.ToLower(c.Text), "no_clawde") {
+			return true
 		}
 	}
-
 	return false
 }
 
 // ExtractAIComments scans a file for AI-related comments
 func ExtractAIComments(filePath string) ([]AIComment, error) {
-	// Get file extension to determine comment patterns
-	ext := filepath.Ext(filePath)
-	patterns, exists := commentPatterns[ext]
-	if !exists {
-		logger.Debug("No comment patterns defined for file extension", "ext", ext)
+	// Get the file's language to determine comment syntax
+	lang := lexer.ForFile(filePath)
+	if lang == nil {
+		logger.Debug("No language defined for file", "file", filePath)
 		return nil, nil
 	}
 
@@ -214,11 +153,6 @@ func ExtractAIComments(filePath string) ([]AIComment, error) {
 		return nil, fmt.Errorf("failed to read file %s: %w", filePath, err)
 	}
 
-	// Check if file has opted out of comment detection
-	if checkForOptOut(string(content), ext) {
-		return nil, nil
-	}
-
 	lines := strings.Split(string(content), "\n")
 
 	// Check total line count
@@ -227,368 +161,172 @@ func ExtractAIComments(filePath string) ([]AIComment, error) {
 		return nil, nil
 	}
 
-	var comments []AIComment
+	sourceComments := lexer.Comments(string(content), &lang.Syntax)
 
-	// Check single-line comments
-	for _, pattern := range patterns.SingleLine {
-		foundComments := extractSingleLineComments(filePath, lines, pattern)
-		comments = append(comments, foundComments...)
+	// Check if file has opted out of comment detection
+	if hasOptOut(sourceComments) {
+		logger.Debug("Found NO_CLAWDE opt-out marker in file, skipping comment processing", "file", filePath)
+		return nil, nil
 	}
 
-	// Check multiline comments
-	for _, pair := range patterns.Multiline {
-		foundComments := extractMultilineComments(filePath, lines, pair)
-		comments = append(comments, foundComments...)
+	comments := findAIComments(filePath, lines, sourceComments)
+	if len(comments) > 0 {
+		resolveSymbols(comments, lexer.Symbols(string(content), lang))
 	}
-
 	logger.Info("Found AI comments", "count", len(comments), "file", filePath)
 	return comments, nil
 }
 
-// extractSingleLineComments finds AI comments in single-line comment patterns
-func extractSingleLineComments(filePath string, lines []string, pattern *regexp.Regexp) []AIComment {
+// findAIComments picks out the comments with AI markers. Consecutive
+// whole-line comments are grouped into one.
+func findAIComments(filePath string, lines []string, sourceComments []lexer.Comment) []AIComment {
 	var comments []AIComment
-	processedLines := make(map[int]bool) // Track which lines we've already processed
-
-	// Determine comment prefix from file extension
-	ext := filepath.Ext(filePath)
-	var commentPrefix string
-	switch ext {
-	case ".py":
-		commentPrefix = "#"
-	case ".go", ".js":
-		commentPrefix = "//"
-	default:
-		commentPrefix = "//" // Default fallback
-	}
 
-	for i, line := range lines {
-		if processedLines[i] {
-			continue // Skip lines already processed as part of a comment block
-		}
+	for i := 0; i < len(sourceComments); i++ {
+		c := sourceComments[i]
+		start := c.Line - 1
 
 		// Check line length
-		if len(line) > maxLineLength {
-			logger.Debug("Skipping line: length exceeds limit", "line", i+1, "file", filePath, "length", len(line), "limit", maxLineLength)
+		if len(lines[start]) > maxLineLength {
+			logger.Debug("Skipping line: length exceeds limit", "line", c.Line, "file", filePath, "length", len(lines[start]), "limit", maxLineLength)
 			continue
 		}
 
-		// Check if this line contains a comment at all
-		if !strings.Contains(line, commentPrefix) {
+		if c.Block {
+			if comment, ok := blockAIComment(filePath, lines, c); ok {
+				comments = append(comments, comment)
+				logger.Debug("Found multiline AI comment", "file", filePath, "line", c.Line, "content", comment.Content)
+			}
 			continue
 		}
 
-		// Check if this is a whole-line comment vs inline comment
-		beforeComment := strings.Split(line, commentPrefix)[0]
-		isWholeLine := strings.TrimSpace(beforeComment) == ""
-
-		if isWholeLine {
-			// Look for consecutive whole-line comments to group them
-			commentLines := []string{line}
-			endLine := i
-
-			// Check subsequent lines for consecutive whole-line comments
-			for j := i + 1; j < len(lines); j++ {
-				// Check if the line contains a comment (even without AI marker)
-				if strings.Contains(lines[j], commentPrefix) {
-					beforeNextComment := strings.Split(lines[j], commentPrefix)[0]
-					if strings.TrimSpace(beforeNextComment) == "" {
-						// This is also a whole-line comment
-						commentLines = append(commentLines, lines[j])
-						processedLines[j] = true
-						endLine = j
-					} else {
-						break // Next comment is inline, don't group it
-					}
-				} else {
-					break // Next line is not a comment
-				}
-			}
-
-			// Extract all comment content and combine
-			var allContent []string
-			for _, commentLine := range commentLines {
-				// Extract comment content after comment prefix
-				if parts := strings.Split(commentLine, commentPrefix); len(parts) >= 2 {
-					content := strings.TrimSpace(strings.Join(parts[1:], commentPrefix))
-					if content != "" {
-						allContent = append(allContent, content)
-					}
-				}
-			}
-			combinedContent := truncateComment(strings.Join(allContent, " "))
+		if c.Inline {
+			// Handle inline comments individually (don't group them)
+			commentContent := truncateComment(strings.TrimSpace(c.Text))
 
-			// Check if any line in the comment block has AI markers
-			// Priority: AI! and AI? take precedence over AI:
-			// AI: is only supported at the start, not at the end
-			actionType := checkAIMarkerInLines(allContent)
+			// Check if it contains AI markers
+			actionType, marker := checkAIMarkerInLines([]string{commentContent})
 			if actionType == "" {
-				// No AI marker found in any line - skip this comment
 				continue
 			}
 
 			comment := AIComment{
 				FilePath:   filePath,
-				LineNumber: i + 1,       // 1-indexed
-				EndLine:    endLine + 1, // End line (1-indexed), same as start for single line
-				Content:    combinedContent,
-				FullLine:   strings.Join(commentLines, "\n"),
+				LineNumber: c.Line,
+				EndLine:    0, // 0 indicates single-line comment
+				Content:    commentContent,
+				FullLine:   lines[start],
 				ActionType: actionType,
+				Marker:     marker,
 			}
-
-			// For single-line blocks, set EndLine to 0 to indicate single-line
-			if len(commentLines) == 1 {
-				comment.EndLine = 0
-			}
-
-			// Generate hash for caching
+			comment.Anchor = commentAnchor(lines, start, start)
 			comment.Hash = generateCommentHash(comment)
-
-			// Add context lines (5 lines before and after)
-			comment.ContextLines = extractContextLines(lines, i, 5)
+			comment.ContextLines = extractContextLines(lines, start, 5)
 
 			comments = append(comments, comment)
-			if len(commentLines) == 1 {
-				logger.Debug("Found single-line AI comment", "file", filePath, "line", i+1, "content", combinedContent)
-			} else {
-				logger.Debug("Found multi-line single-line AI comment block", "file", filePath, "start", i+1, "end", endLine+1, "content", combinedContent)
-			}
-		} else {
-			// Handle inline comments individually (don't group them)
-			// Extract comment content after comment prefix
-			if parts := strings.Split(line, commentPrefix); len(parts) >= 2 {
-				commentContent := truncateComment(strings.TrimSpace(strings.Join(parts[1:], commentPrefix)))
-
-				// Check if it contains AI markers
-				actionType := checkAIMarkerInLines([]string{commentContent})
-				if actionType == "" {
-					// No AI marker found - skip this comment
-					continue
-				}
-
-				comment := AIComment{
-					FilePath:   filePath,
-					LineNumber: i + 1, // 1-indexed
-					EndLine:    0,     // 0 indicates single-line comment
-					Content:    commentContent,
-					FullLine:   line,
-					ActionType: actionType,
-				}
-
-				// Generate hash for caching
-				comment.Hash = generateCommentHash(comment)
-
-				// Add context lines (5 lines before and after)
-				comment.ContextLines = extractContextLines(lines, i, 5)
-
-				comments = append(comments, comment)
-				logger.Debug("Found inline AI comment", "file", filePath, "line", i+1, "content", commentContent)
-			}
-		}
-	}
-
-	return comments
-}
-
-// extractMultilineComments finds AI comments in multiline comment blocks
-func extractMultilineComments(filePath string, lines []string, pair MultilineCommentPair) []AIComment {
-	var comments []AIComment
-	inComment := false
-	var commentLines []string
-	var startLine int
-
-	for i, line := range lines {
-		// Check line length
-		if len(line) > maxLineLength {
-			logger.Debug("Skipping line: length exceeds limit", "line", i+1, "file", filePath, "length", len(line), "limit", maxLineLength)
+			logger.Debug("Found inline AI comment", "file", filePath, "line", c.Line, "content", commentContent)
 			continue
 		}
 
-		if !inComment && pair.Start.MatchString(line) {
-			inComment = true
-			startLine = i
-			commentLines = []string{line}
-
-			// Check if end pattern is also on the same line (single-line multiline comment)
-			// Only treat as single-line if there's actual content between start and end markers
-			if pair.End.MatchString(line) && hasContentBetweenMarkers(line, pair) {
-				// Process the comment immediately
-				fullComment := strings.Join(commentLines, "\n")
-				if hasValidAIMarker(fullComment, filepath.Ext(filePath)) {
-					actionType := determineActionType(fullComment, filepath.Ext(filePath))
-
-					// Extract content by removing comment markers
-					content := truncateComment(extractMultilineContentForExt(fullComment, filepath.Ext(filePath)))
-
-					comment := AIComment{
-						FilePath:   filePath,
-						LineNumber: startLine + 1, // 1-indexed
-						EndLine:    i + 1,         // End line (1-indexed) - same as start for single-line multiline
-						Content:    content,
-						FullLine:   fullComment,
-						ActionType: actionType,
-					}
-
-					// Generate hash for caching
-					comment.Hash = generateCommentHash(comment)
-
-					// Add context lines
-					comment.ContextLines = extractContextLines(lines, startLine, 5)
-
-					comments = append(comments, comment)
-					logger.Debug("Found multiline AI comment", "file", filePath, "line", startLine+1, "content", content)
-				}
-
-				inComment = false
-				commentLines = nil
+		// Group consecutive whole-line comments
+		group := []lexer.Comment{c}
+		for i+1 < len(sourceComments) {
+			next := sourceComments[i+1]
+			if next.Block || next.Inline || next.Line != group[len(group)-1].Line+1 {
+				break
 			}
-			continue
+			group = append(group, next)
+			i++
 		}
+		end := group[len(group)-1].Line - 1
 
-		if inComment {
-			commentLines = append(commentLines, line)
-			if pair.End.MatchString(line) {
-				// Check if the comment block contains valid AI markers
-				fullComment := strings.Join(commentLines, "\n")
-				if hasValidAIMarker(fullComment, filepath.Ext(filePath)) {
-					actionType := determineActionType(fullComment, filepath.Ext(filePath))
-
-					// Extract content by removing comment markers.
-					content := truncateComment(extractMultilineContentForExt(fullComment, filepath.Ext(filePath)))
-
-					comment := AIComment{
-						FilePath:   filePath,
-						LineNumber: startLine + 1, // 1-indexed
-						EndLine:    i + 1,         // End line (1-indexed)
-						Content:    content,
-						FullLine:   fullComment,
-						ActionType: actionType,
-					}
-
-					// Generate hash for caching
-					comment.Hash = generateCommentHash(comment)
-
-					// Add context lines
-					comment.ContextLines = extractContextLines(lines, startLine, 5)
-
-					comments = append(comments, comment)
-					logger.Debug("Found multiline AI comment", "file", filePath, "line", startLine+1, "content", content)
-				}
-
-				inComment = false
-				commentLines = nil
+		var allContent []string
+		for _, gc := range group {
+			if content := strings.TrimSpace(gc.Text); content != "" {
+				allContent = append(allContent, content)
 			}
 		}
-	}
-
-	return comments
-}
-
-// hasContentBetweenMarkers checks if there's actual content between start and end markers on the same line
-func hasContentBetweenMarkers(line string, pair MultilineCommentPair) bool {
-	// For symmetric markers (like """ or '''), check if there's content between them
-	if pair.Start.String() == pair.End.String() {
-		// Find all matches of the marker
-		matches := pair.Start.FindAllStringIndex(line, -1)
-		if len(matches) >= 2 {
-			// Check if there's non-whitespace content between first and last match
-			start := matches[0][1]            // End of first marker
-			end := matches[len(matches)-1][0] // Start of last marker
-			between := strings.TrimSpace(line[start:end])
-			return between != ""
-		}
-		return false
-	}
-
-	// For asymmetric markers (like /* */), check if there's content between them
-	startLoc := pair.Start.FindStringIndex(line)
-	endLoc := pair.End.FindStringIndex(line)
-	if startLoc != nil && endLoc != nil && startLoc[1] <= endLoc[0] {
-		between := strings.TrimSpace(line[startLoc[1]:endLoc[0]])
-		return between != ""
-	}
-
-	return false
-}
-
-// hasValidAIMarker checks if a multiline comment has AI markers at valid positions
-func hasValidAIMarker(fullComment string, ext string) bool {
-	// Get the cleaned lines using the language-specific token removal
-	lines := extractMultilineContentLines(fullComment, ext)
 
-	for _, line := range lines {
-		if line == "" {
+		// Check if any line in the comment block has AI markers
+		// Priority: AI! and AI? take precedence over AI:
+		// AI: is only supported at the start, not at the end
+		actionType, marker := checkAIMarkerInLines(allContent)
+		if actionType == "" {
 			continue
 		}
 
-		// Trim trailing space for consistent marker detection
-		lowerLine := strings.ToLower(strings.TrimSpace(line))
-
-		// Check if AI? or AI! is at the end of the line
-		if strings.HasSuffix(lowerLine, " ai?") || lowerLine == "ai?" ||
-			strings.HasSuffix(lowerLine, " ai!") || lowerLine == "ai!" {
-			return true
+		comment := AIComment{
+			FilePath:   filePath,
+			LineNumber: c.Line,
+			EndLine:    end + 1,
+			Content:    truncateComment(strings.Join(allContent, " ")),
+			FullLine:   strings.Join(lines[start:end+1], "\n"),
+			ActionType: actionType,
+			Marker:     marker,
 		}
 
-		// Check if AI: is at the start of the line
-		if strings.HasPrefix(lowerLine, "ai:") {
-			return true
+		// For single-line blocks, set EndLine to 0 to indicate single-line
+		if len(group) == 1 {
+			comment.EndLine = 0
 		}
 
-		// Also check for AI? or AI! at the start (for consistency with single-line)
-		if strings.HasPrefix(lowerLine, "ai?") || strings.HasPrefix(lowerLine, "ai!") {
-			return true
+		comment.Anchor = commentAnchor(lines, start, end)
+		comment.Hash = generateCommentHash(comment)
+		comment.ContextLines = extractContextLines(lines, start, 5)
+
+		comments = append(comments, comment)
+		if len(group) == 1 {
+			logger.Debug("Found single-line AI comment", "file", filePath, "line", c.Line, "content", comment.Content)
+		} else {
+			logger.Debug("Found multi-line single-line AI comment block", "file", filePath, "start", c.Line, "end", end+1, "content", comment.Content)
 		}
 	}
 
-	return false
+	return comments
 }
 
-// determineActionType determines the action type based on AI markers in the comment
-func determineActionType(fullComment string, ext string) string {
-	// Get the cleaned lines using the language-specific token removal
-	lines := extractMultilineContentLines(fullComment, ext)
-
-	hasQuestion := false
-	hasCommand := false
-	hasContext := false
-
-	for _, line := range lines {
-		if line == "" {
-			continue
-		}
-
-		// Trim trailing space for consistent marker detection
-		lowerLine := strings.ToLower(strings.TrimSpace(line))
-
-		// Check for ! (highest priority)
-		if strings.HasSuffix(lowerLine, " ai!") || lowerLine == "ai!" || strings.HasPrefix(lowerLine, "ai!") {
-			hasCommand = true
-		}
-
-		// Check for ?
-		if strings.HasSuffix(lowerLine, " ai?") || lowerLine == "ai?" || strings.HasPrefix(lowerLine, "ai?") {
-			hasQuestion = true
-		}
-
-		// Check for :
-		if strings.HasPrefix(lowerLine, "ai:") {
-			hasContext = true
-		}
+// blockAIComment converts a block comment to an AIComment if it has an AI
+// marker at a valid position
+func blockAIComment(filePath string, lines []string, c lexer.Comment) (AIComment, bool) {
+	contentLines := blockContentLines(c.Text)
+	actionType, marker := blockMarker(contentLines)
+	if actionType == "" {
+		return AIComment{}, false
 	}
 
-	// Priority: AI! > AI? > AI:
-	if hasCommand {
-		return "!"
-	} else if hasQuestion {
-		return "?"
-	} else if hasContext {
-		return ":"
+	start, end := c.Line-1, c.EndLine-1
+	comment := AIComment{
+		FilePath:   filePath,
+		LineNumber: c.Line,
+		EndLine:    c.EndLine,
+		Content:    truncateComment(strings.TrimSpace(strings.Join(contentLines, ""))),
+		FullLine:   strings.Join(lines[start:end+1], "\n"),
+		ActionType: actionType,
+		Marker:     marker,
 	}
+	comment.Anchor = commentAnchor(lines, start, end)
+	comment.Hash = generateCommentHash(comment)
+	comment.ContextLines = extractContextLines(lines, start, 5)
+	return comment, true
+}
 
-	// This should never happen if hasValidAIMarker returned true
-	logger.Error("Internal error: determineActionType called but no valid AI marker found in comment", "comment", fullComment)
-	panic("Internal error: determineActionType called but no valid AI marker found")
+// blockMarker finds the AI marker in the lines of a block comment. Markers
+// can be on any line, and AI! takes precedence over AI?, then AI:. It returns
+// an empty action type if there is no marker.
+func blockMarker(lines []string) (actionType, marker string) {
+	for _, candidate := range []string{"!", "?", ":"} {
+		for _, line := range lines {
+			// Trim trailing space for consistent marker detection
+			lowerLine := strings.ToLower(strings.TrimSpace(line))
+			if lowerLine == "" {
+				continue
+			}
+			if marker, ok := matchMarker(lowerLine, candidate); ok {
+				return candidate, marker
+			}
+		}
+	}
+	return "", ""
 }
 
 // extractContextLines gets N lines before and after the target line
@@ -614,91 +352,91 @@ func extractContextLines(lines []string, targetLine, contextSize int) []string {
 	return context
 }
 
-// extractMultilineContentLines removes comment markers and returns individual lines
-func extractMultilineContentLines(fullComment string, ext string) []string {
-	content := fullComment
-
-	// Get tokens for this file extension
-	if ext != "" {
-		if patterns, exists := commentPatterns[ext]; exists {
-			// Remove multiline comment markers using language-specific tokens
-			for _, tokenPair := range patterns.MultilineTokens {
-				content = strings.ReplaceAll(content, tokenPair.Start, "")
-				content = strings.ReplaceAll(content, tokenPair.End, "")
-			}
-		}
-	} else {
-		// Fallback: remove all known multiline comment markers for backward compatibility
-		for _, patterns := range commentPatterns {
-			for _, tokenPair := range patterns.MultilineTokens {
-				content = strings.ReplaceAll(content, tokenPair.Start, "")
-				content = strings.ReplaceAll(content, tokenPair.End, "")
-			}
-		}
-	}
-
-	// Split into lines and clean each line
-	lines := strings.Split(content, "\n")
+// blockContentLines splits the text of a block comment into lines, removing
+// the leading * of C-style comments
+func blockContentLines(text string) []string {
 	var cleanLines []string
-	for _, line := range lines {
-		// Remove leading * and whitespace (for C-style comments)
+	for _, line := range strings.Split(text, "\n") {
 		cleaned := strings.TrimSpace(line)
 		cleaned = strings.TrimPrefix(cleaned, "*")
 		cleaned = strings.TrimSpace(cleaned)
 		if cleaned != "" {
 			// Ensure proper spacing between lines by adding a space at the end
-			// if the line doesn't already end with whitespace
-			if !strings.HasSuffix(cleaned, " ") {
-				cleaned += " "
-			}
-			cleanLines = append(cleanLines, cleaned)
+			cleanLines = append(cleanLines, cleaned+" ")
 		}
 	}
-
 	return cleanLines
 }
 
-// extractMultilineContentForExt removes comment markers using language-specific tokens
-func extractMultilineContentForExt(fullComment string, ext string) string {
-	cleanLines := extractMultilineContentLines(fullComment, ext)
-	// Join without additional spaces since each line already has proper spacing
-	joined := strings.Join(cleanLines, "")
-	// Trim any trailing space that might have been added to the last line
-	return strings.TrimSpace(joined)
+// commentAnchor returns the nearest non-blank lines before and after the
+// comment on lines start to end, with whitespace normalised. It tells apart
+// identical comments in one file without depending on line numbers.
+func commentAnchor(lines []string, start, end int) string {
+	var before, after string
+	for i := start - 1; i >= 0; i-- {
+		if before = normalizeSpace(lines[i]); before != "" {
+			break
+		}
+	}
+	for i := end + 1; i < len(lines); i++ {
+		if after = normalizeSpace(lines[i]); after != "" {
+			break
+		}
+	}
+	return before + "\n" + after
 }
 
-// extractMultilineContent removes comment markers from multiline comment content
-func extractMultilineContent(fullComment string) string {
-	return extractMultilineContentForExt(fullComment, "")
+func normalizeSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
 }
 
-// generateCommentHash creates a fingerprint for comment caching
+// generateCommentHash creates a fingerprint for comment caching. It doesn't
+// include the line number, so a comment keeps its fingerprint when lines are
+// added or removed elsewhere in the file.
 func generateCommentHash(comment AIComment) string {
-	data := fmt.Sprintf("%s:%d:%s:%s", comment.FilePath, comment.LineNumber, comment.Content, comment.ActionType)
+	path := comment.FilePath
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	data := fmt.Sprintf("%s\x00%s\x00%s\x00%s", path, normalizeSpace(strings.ToLower(comment.Content)), comment.ActionType, comment.Anchor)
 	hash := sha256.Sum256([]byte(data))
 	return fmt.Sprintf("%x", hash[:8]) // Use first 8 bytes for shorter hash
 }
 
 // isCommentProcessed checks if a comment has already been processed.
 func isCommentProcessed(comment AIComment) bool {
-	return processedComments[comment.Hash]
+	return processedComments.Contains(comment.Hash)
 }
 
-// markCommentProcessed marks a comment as processed in the cache
-func markCommentProcessed(comment AIComment) {
-	processedComments[comment.Hash] = true
+// markCommentsProcessed marks comments as processed in the cache
+func markCommentsProcessed(comments ...AIComment) {
+	entries := make([]processed.Entry, 0, len(comments))
+	for _, comment := range comments {
+		entries = append(entries, processed.Entry{
+			Fingerprint: comment.Hash,
+			File:        comment.FilePath,
+			Line:        comment.LineNumber,
+			Content:     comment.Content,
+			ActionType:  comment.ActionType,
+		})
+	}
+	if err := processedComments.AddAll(entries); err != nil {
+		logger.Warn("Failed to save processed comment", "path", processedComments.Path(), "error", err)
+	}
 }
 
 // clearProcessedCache clears the processed comments cache
 func clearProcessedCache() {
-	processedComments = make(map[string]bool)
+	if err := processedComments.Clear(); err != nil {
+		logger.Warn("Failed to clear processed comments", "path", processedComments.Path(), "error", err)
+	}
 }
 
 // checkAIMarkerInLines checks if any line in a slice of lines contains AI markers
-// Returns the action type ("!", "?", ":") or empty string if no marker found
+// Returns the action type ("!", "?", ":") and marker, or empty strings if no marker found
 // Returns the first non-colon marker found, or ":" if only colon markers exist
-func checkAIMarkerInLines(lines []string) string {
-	hasContext := false
+func checkAIMarkerInLines(lines []string) (actionType, marker string) {
+	var contextMarker string
 
 	for _, line := range lines {
 		if line == "" {
@@ -708,22 +446,22 @@ func checkAIMarkerInLines(lines []string) string {
 		lowerLine := strings.ToLower(line)
 
 		// Check for ! or ? - return immediately if found (first non-colon marker wins)
-		if strings.HasSuffix(lowerLine, " ai!") || lowerLine == "ai!" || strings.HasPrefix(lowerLine, "ai!") {
-			return "!"
+		if marker, ok := matchMarker(lowerLine, "!"); ok {
+			return "!", marker
 		}
-		if strings.HasSuffix(lowerLine, " ai?") || lowerLine == "ai?" || strings.HasPrefix(lowerLine, "ai?") {
-			return "?"
+		if marker, ok := matchMarker(lowerLine, "?"); ok {
+			return "?", marker
 		}
 		// Remember if we saw a colon marker, but don't return it yet
-		if strings.HasPrefix(lowerLine, "ai:") {
-			hasContext = true
+		if marker, ok := matchMarker(lowerLine, ":"); ok && contextMarker == "" {
+			contextMarker = marker
 		}
 	}
 
 	// Only return ":" if we found colon markers but no ! or ? markers
-	if hasContext {
-		return ":"
+	if contextMarker != "" {
+		return ":", contextMarker
 	}
 
-	return ""
+	return "", ""
 }
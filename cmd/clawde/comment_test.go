@@ -4,9 +4,10 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
-	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/mattduck/clawde/internal/lexer"
 )
 
 // NO_CLAWDE - This test file contains AI marker examples and should be excluded from comment detection
@@ -23,28 +24,12 @@ func initTestLogger() {
 
 // extractAICommentsFromString parses AI comments from string content instead of file
 func extractAICommentsFromString(content, filePath string) ([]AIComment, error) {
-	// Get file extension to determine comment patterns
-	ext := filepath.Ext(filePath)
-	patterns, exists := commentPatterns[ext]
-	if !exists {
+	lang := lexer.ForFile(filePath)
+	if lang == nil {
 		return nil, nil
 	}
-
-	lines := strings.Split(content, "\n")
-	var comments []AIComment
-
-	// Check single-line comments
-	for _, pattern := range patterns.SingleLine {
-		foundComments := extractSingleLineComments(filePath, lines, pattern)
-		comments = append(comments, foundComments...)
-	}
-
-	// Check multiline comments
-	for _, pair := range patterns.Multiline {
-		foundComments := extractMultilineComments(filePath, lines, pair)
-		comments = append(comments, foundComments...)
-	}
-
+	comments := findAIComments(filePath, strings.Split(content, "\n"), lexer.Comments(content, &lang.Syntax))
+	resolveSymbols(comments, lexer.Symbols(content, lang))
 	return comments, nil
 }
 
@@ -168,6 +153,16 @@ func TestJavaScriptSingleLineComments(t *testing.T) {
 			expected: 1,
 			wantType: ":",
 		},
+		{
+			name:     "Comment token in template literal",
+			content:  "const s = `\n// Refactor this function AI!\n`;",
+			expected: 0, // Should not match strings
+		},
+		{
+			name:     "Comment token in regex literal",
+			content:  "const re = /https?:\\/\\/example.com AI?/;",
+			expected: 0, // Should not match regexes
+		},
 	}
 
 	for _, tt := range tests {
@@ -227,6 +222,22 @@ func TestPythonSingleLineComments(t *testing.T) {
 			content:  "# Visiting hawaii?",
 			expected: 0, // Should not match
 		},
+		{
+			name:     "Comment token in string literal",
+			content:  "print(\"# Refactor this function AI!\")",
+			expected: 0, // Should not match strings
+		},
+		{
+			name:     "Triple-quoted string that is not a docstring",
+			content:  "message = \"\"\"Refactor this function AI!\"\"\"",
+			expected: 0, // Only standalone triple-quoted strings count as comments
+		},
+		{
+			name:     "Comment after string containing hash",
+			content:  "color = \"#fff\"  # Why this color AI?",
+			expected: 1,
+			wantType: "?",
+		},
 	}
 
 	for _, tt := range tests {
@@ -464,7 +475,7 @@ func TestCaching(t *testing.T) {
 	}
 
 	// Mark as processed
-	markCommentProcessed(comment)
+	markCommentsProcessed(comment)
 
 	// Should now be processed
 	if !isCommentProcessed(comment) {
@@ -524,6 +535,26 @@ func TestEdgeCases(t *testing.T) {
 			content:  `fmt.Println("This AI? is in a string")`,
 			expected: 0, // Should not match strings
 		},
+		{
+			name:     "URL in string literal",
+			content:  `fetch("http://example.com/path AI?")`,
+			expected: 0, // Should not match "//" inside strings
+		},
+		{
+			name:     "Comment token in string literal",
+			content:  `s := "// Refactor this function AI!"`,
+			expected: 0, // Should not match strings
+		},
+		{
+			name:     "Comment token in raw string literal",
+			content:  "s := `\n/* Refactor this function AI! */\n`",
+			expected: 0, // Should not match strings
+		},
+		{
+			name:     "Comment after string containing URL",
+			content:  `url := "http://example.com" // Why this host AI?`,
+			expected: 1, // The real comment still matches
+		},
 		{
 			name:     "Comment ending with word containing ai?",
 			content:  "// Traveling to hawaii?",
@@ -603,9 +634,9 @@ func TestRenderCommentPrompt(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := renderCommentPrompt(tt.comment, nil)
+			result := mustRenderPrompt(t, promptComments, tt.comment)
 			if result != tt.expected {
-				t.Errorf("renderCommentPrompt() = %q, want %q", result, tt.expected)
+				t.Errorf("renderPrompt() = %q, want %q", result, tt.expected)
 			}
 		})
 	}
@@ -642,10 +673,10 @@ func main() {
 	}
 
 	// Test the rendered prompt
-	prompt := renderCommentPrompt(comment, nil)
+	prompt := mustRenderPrompt(t, promptComments, comment)
 	expected := "See test.go at lines 3-7 and surrounding context. Answer the question(s), but DO NOT MAKE CHANGES. Replace the AI? marker with [ai] when done."
 	if prompt != expected {
-		t.Errorf("renderCommentPrompt() = %q, want %q", prompt, expected)
+		t.Errorf("renderPrompt() = %q, want %q", prompt, expected)
 	}
 }
 
@@ -788,13 +819,13 @@ func main() {}`,
 
 				// Test the rendered prompt for multi-line blocks
 				if comment.EndLine > 0 {
-					prompt := renderCommentPrompt(comment, nil)
+					prompt := mustRenderPrompt(t, promptComments, comment)
 					expectedPrompt := fmt.Sprintf("See test.go at lines %d-%d and surrounding context. Answer the question(s), but DO NOT MAKE CHANGES. Replace the AI? marker with [ai] when done.", comment.LineNumber, comment.EndLine)
 					if tt.wantType == "!" {
 						expectedPrompt = fmt.Sprintf("See test.go at lines %d-%d and surrounding context. Make the appropriate changes. YOU MUST replace the AI! marker with [ai] when done.", comment.LineNumber, comment.EndLine)
 					}
 					if prompt != expectedPrompt {
-						t.Errorf("renderCommentPrompt() = %q, want %q", prompt, expectedPrompt)
+						t.Errorf("renderPrompt() = %q, want %q", prompt, expectedPrompt)
 					}
 				}
 			}
@@ -1200,3 +1231,98 @@ func test() {}`,
 		})
 	}
 }
+
+func TestOtherLanguages(t *testing.T) {
+	tests := []struct {
+		name        string
+		file        string
+		content     string
+		wantType    string
+		wantContent string
+	}{
+		{"TypeScript", "app.ts", "const url = 'http://x'; // Why this host AI?", "?", "Why this host AI?"},
+		{"Rust nested block", "lib.rs", "/* outer /* inner */\n * Fix this AI!\n */\nfn main() {}", "!", "outer /* inner */ Fix this AI!"},
+		{"SQL", "report.sql", "-- Add an index AI!\nSELECT 1;", "!", "Add an index AI!"},
+		{"Lua block", "init.lua", "--[[\nWhat does this do AI?\n]]", "?", "What does this do AI?"},
+		{"Haskell", "Main.hs", "{- Simplify this AI! -}", "!", "Simplify this AI!"},
+		{"Markdown", "README.md", "# Title\n<!-- Expand this section AI! -->", "!", "Expand this section AI!"},
+		{"Makefile", "Makefile", "# AI: targets are phony\nall:", ":", "AI: targets are phony"},
+		{"Shell", "run.sh", "echo \"# not this AI!\" # Quote this AI?", "?", "Quote this AI?"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comments, err := extractAICommentsFromString(tt.content, tt.file)
+			if err != nil {
+				t.Fatalf("extractAICommentsFromString() error = %v", err)
+			}
+			if len(comments) != 1 {
+				t.Fatalf("Expected 1 comment, got %d: %+v", len(comments), comments)
+			}
+			if comments[0].ActionType != tt.wantType {
+				t.Errorf("Expected ActionType %q, got %q", tt.wantType, comments[0].ActionType)
+			}
+			if comments[0].Content != tt.wantContent {
+				t.Errorf("Expected Content %q, got %q", tt.wantContent, comments[0].Content)
+			}
+		})
+	}
+}
+
+func TestCustomMarkerWords(t *testing.T) {
+	initTestLogger()
+	defer func(words []string) { markerWords = words }(markerWords)
+	markerWords = []string{"AI", "TODO(ai)", "@claude"}
+
+	tests := []struct {
+		content    string
+		expected   int
+		wantType   string
+		wantMarker string
+	}{
+		{"// Fix the retry logic TODO(ai)!", 1, "!", "TODO(ai)!"},
+		{"// Why is this needed @Claude?", 1, "?", "@claude?"},
+		{"// @claude: the cache is per request", 1, ":", "@claude:"},
+		{"// Still works AI?", 1, "?", "AI?"},
+		{"// Mentions @claude? in the middle", 0, "", ""},
+	}
+	for _, tt := range tests {
+		comments, err := extractAICommentsFromString(tt.content, "test.go")
+		if err != nil {
+			t.Fatalf("extractAICommentsFromString() error = %v", err)
+		}
+		if len(comments) != tt.expected {
+			t.Errorf("%q: expected %d comments, got %d", tt.content, tt.expected, len(comments))
+			continue
+		}
+		if tt.expected > 0 && (comments[0].ActionType != tt.wantType || comments[0].Marker != tt.wantMarker) {
+			t.Errorf("%q: got type %q marker %q, want %q %q", tt.content, comments[0].ActionType, comments[0].Marker, tt.wantType, tt.wantMarker)
+		}
+	}
+
+	comment := AIComment{FilePath: "test.go", LineNumber: 1, ActionType: "!", Marker: "TODO(ai)!"}
+	want := "See test.go at line 1 and surrounding context. Make the appropriate changes. YOU MUST replace the TODO(ai)! marker with [ai] when done."
+	if got := mustRenderPrompt(t, promptComments, comment); got != want {
+		t.Errorf("renderPrompt() = %q, want %q", got, want)
+	}
+}
+
+func TestRegisterLanguages(t *testing.T) {
+	initTestLogger()
+	t.Cleanup(lexer.Snapshot())
+	registerLanguages([]LanguageConfig{{
+		Name:          "Template",
+		Extensions:    []string{"tmplx"},
+		BlockComments: [][]string{{"{{/*", "*/}}"}},
+		Strings:       []string{`"`},
+	}})
+
+	content := "<p>{{ \"{{/* not AI! */}}\" }}</p>\n{{/* Explain this AI? */}}"
+	comments, err := extractAICommentsFromString(content, "page.tmplx")
+	if err != nil {
+		t.Fatalf("extractAICommentsFromString() error = %v", err)
+	}
+	if len(comments) != 1 || comments[0].ActionType != "?" || comments[0].LineNumber != 2 {
+		t.Errorf("unexpected comments %+v", comments)
+	}
+}
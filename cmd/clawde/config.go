@@ -1,8 +1,16 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/mattduck/clawde/internal/gitrepo"
 )
 
 // Config holds all configuration options for the CLI wrapper
@@ -11,28 +19,341 @@ type Config struct {
 	EnableInputThrottling    bool
 	EnableHeldEnterDetection bool
 	EnableWatchFiles         bool
+	WatchDir                 string // Directory to watch for AI comments
 	ForceAnsi                bool
 	BetterDefaults           bool
 	LogFile                  string
 	LogLevel                 string
+	StateDetection           string            // "screen" (built-in screen model) or "tmux"
+	StatePatterns            map[string]string // Overrides for the screen state matcher patterns
+	KeymapFile               string            // Path to the TOML key bindings file
+	PromptsDir               string            // Directory of the user's prompt templates
+	RecordFile               string            // Record the session to this asciicast file
+	RecordInput              bool              // Include what the user types in the recording
+	TranscriptFile           string            // Write a transcript of the conversation to this file
+	ControlSocket            bool              // Listen on a Unix socket for control commands
+	InlineSnippets           bool              // Include the code around AI comments in prompts
+
+	MaxFileSize          int64         // Skip files larger than this when searching for comments
+	MaxFilesToSearch     int           // Stop searching after this many files
+	ScanWorkers          int           // Files to search for comments at once, 0 for one per CPU
+	SnippetBudget        int           // Most lines of code to include in one prompt
+	ContextBudget        int           // Most tokens of AI: comments to include in one prompt, 0 for no limit
+	SubmitDelay          time.Duration // Pause between sending a prompt and pressing enter
+	ThrottleFastDelay    time.Duration // Output refresh interval while typing
+	ThrottleSlowDelay    time.Duration // Output refresh interval when idle
+	ThrottleInputTimeout time.Duration // How long after the last keypress to switch to the slow interval
+	TmuxPollInterval     time.Duration // How often to capture the pane for tmux state detection
+	ProcessedExpiry      time.Duration // Forget processed comments after this long, 0 to keep them
+	CommentTimeout       time.Duration // Report sent comments as abandoned if unresolved after this long
+	QueueTimeout         time.Duration // Report queued comments as stuck if not sent after this long
+	PromptIdleDelay      time.Duration // How long after the last keypress before a queued prompt is sent
+
+	MarkerWords []string         // Words that make an AI comment when followed by !, ? or :
+	Languages   []LanguageConfig // Extra languages to find AI comments in
+}
+
+// LanguageConfig declares a language for AI comment detection. Languages
+// declared in config files take precedence over the built-in ones.
+type LanguageConfig struct {
+	Name           string     `toml:"name"`
+	Extensions     []string   `toml:"extensions"`      // e.g. [".j2"]
+	Filenames      []string   `toml:"filenames"`       // e.g. ["Justfile"]
+	LineComments   []string   `toml:"line_comments"`   // e.g. ["--"]
+	BlockComments  [][]string `toml:"block_comments"`  // Start and end pairs, e.g. [["{#", "#}"]]
+	NestedComments bool       `toml:"nested_comments"` // Block comments can be nested
+	Strings        []string   `toml:"strings"`         // String delimiters, e.g. ['"']. Backslash escapes.
+}
+
+// projectConfigFile is the per-project config file, read from the root of the
+// repository the working directory is in, or else the working directory
+const projectConfigFile = ".clawde.toml"
+
+// fileConfig is the on-disk format of the config files. Fields are pointers
+// so that options missing from a file don't override earlier layers.
+type fileConfig struct {
+	OutputThrottling     *bool             `toml:"output_throttling"`
+	InputThrottling      *bool             `toml:"input_throttling"`
+	HeldEnterDetection   *bool             `toml:"held_enter_detection"`
+	WatchFiles           *bool             `toml:"watch_files"`
+	WatchDir             *string           `toml:"watch_dir"`
+	ForceAnsi            *bool             `toml:"force_ansi"`
+	BetterDefaults       *bool             `toml:"better_defaults"`
+	LogFile              *string           `toml:"log_file"`
+	LogLevel             *string           `toml:"log_level"`
+	StateDetection       *string           `toml:"state_detection"`
+	StatePatterns        map[string]string `toml:"state_patterns"`
+	KeymapFile           *string           `toml:"keymap_file"`
+	PromptsDir           *string           `toml:"prompts_dir"`
+	RecordFile           *string           `toml:"record_file"`
+	RecordInput          *bool             `toml:"record_input"`
+	TranscriptFile       *string           `toml:"transcript_file"`
+	ControlSocket        *bool             `toml:"control_socket"`
+	InlineSnippets       *bool             `toml:"inline_snippets"`
+	MaxFileSize          *int64            `toml:"max_file_size"`
+	MaxFilesToSearch     *int              `toml:"max_files_to_search"`
+	ScanWorkers          *int              `toml:"scan_workers"`
+	SnippetBudget        *int              `toml:"snippet_budget"`
+	ContextBudget        *int              `toml:"context_budget"`
+	SubmitDelay          *time.Duration    `toml:"submit_delay"`
+	ThrottleFastDelay    *time.Duration    `toml:"throttle_fast_delay"`
+	ThrottleSlowDelay    *time.Duration    `toml:"throttle_slow_delay"`
+	ThrottleInputTimeout *time.Duration    `toml:"throttle_input_timeout"`
+	TmuxPollInterval     *time.Duration    `toml:"tmux_poll_interval"`
+	ProcessedExpiry      *time.Duration    `toml:"processed_expiry"`
+	CommentTimeout       *time.Duration    `toml:"comment_timeout"`
+	QueueTimeout         *time.Duration    `toml:"queue_timeout"`
+	PromptIdleDelay      *time.Duration    `toml:"prompt_idle_delay"`
+	MarkerWords          *[]string         `toml:"marker_words"`
+	Languages            []LanguageConfig  `toml:"language"`
+}
+
+// userOnly returns the options set in the file that a project's .clawde.toml
+// can't set. They name files clawde writes or loads, or expose the session, so
+// a cloned repository mustn't control them.
+func (f *fileConfig) userOnly() []string {
+	var keys []string
+	for _, opt := range []struct {
+		key string
+		set bool
+	}{
+		{"watch_dir", f.WatchDir != nil},
+		{"log_file", f.LogFile != nil},
+		{"keymap_file", f.KeymapFile != nil},
+		{"prompts_dir", f.PromptsDir != nil},
+		{"record_file", f.RecordFile != nil},
+		{"record_input", f.RecordInput != nil},
+		{"transcript_file", f.TranscriptFile != nil},
+		{"control_socket", f.ControlSocket != nil},
+	} {
+		if opt.set {
+			keys = append(keys, opt.key)
+		}
+	}
+	return keys
 }
 
-// LoadConfig creates a new Config instance with values from environment variables
-// Environment variables should be prefixed with CLAWDE_
-func LoadConfig() *Config {
-	cfg := &Config{
-		// Default values
+// defaultConfig returns the built-in defaults
+func defaultConfig() *Config {
+	return &Config{
 		EnableOutputThrottling:   true,
 		EnableInputThrottling:    true,
 		EnableHeldEnterDetection: false,
 		EnableWatchFiles:         false,
+		WatchDir:                 ".",
 		ForceAnsi:                true,
 		BetterDefaults:           true,
 		LogFile:                  "",
 		LogLevel:                 "info",
+		StateDetection:           "screen",
+		ControlSocket:            true,
+		StatePatterns:            map[string]string{},
+		KeymapFile:               defaultKeymapPath(),
+		PromptsDir:               defaultPromptsDir(),
+		MaxFileSize:              10 * 1024 * 1024, // 10MB
+		MaxFilesToSearch:         10000,
+		SnippetBudget:            80,
+		ContextBudget:            1000,
+		SubmitDelay:              100 * time.Millisecond,
+		ThrottleFastDelay:        16 * time.Millisecond, // 60fps when typing
+		ThrottleSlowDelay:        33 * time.Millisecond, // 30fps when idle
+		ThrottleInputTimeout:     2 * time.Second,
+		TmuxPollInterval:         150 * time.Millisecond,
+		ProcessedExpiry:          30 * 24 * time.Hour,
+		CommentTimeout:           10 * time.Minute,
+		QueueTimeout:             5 * time.Minute,
+		PromptIdleDelay:          2 * time.Second,
+		MarkerWords:              []string{"AI"},
+	}
+}
+
+// defaultConfigPath returns the user config file location under the XDG config directory
+func defaultConfigPath() string {
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		configDir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(configDir, "clawde", "config.toml")
+}
+
+// defaultPromptsDir returns the user's prompt template directory under the
+// XDG config directory
+func defaultPromptsDir() string {
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		configDir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(configDir, "clawde", "prompts")
+}
+
+// LoadConfig builds the configuration from, in increasing order of
+// precedence: the built-in defaults, the user config file, the project's
+// .clawde.toml and CLAWDE_ environment variables. userFile overrides the user
+// config file location if it isn't empty. Command-line flags are applied on
+// top by the caller.
+func LoadConfig(userFile string) (*Config, error) {
+	if userFile == "" {
+		userFile = os.Getenv("CLAWDE_CONFIG_FILE")
+	}
+	if userFile == "" {
+		userFile = defaultConfigPath()
+	}
+	root, _ := gitrepo.Root(".")
+	return loadConfig(userFile, filepath.Join(root, projectConfigFile))
+}
+
+func loadConfig(userFile, projectFile string) (*Config, error) {
+	cfg := defaultConfig()
+	for _, file := range []struct {
+		path    string
+		project bool
+	}{{userFile, false}, {projectFile, true}} {
+		if file.path == "" {
+			continue
+		}
+		if err := cfg.loadFile(file.path, file.project); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile applies the options set in a config file. A missing file is not
+// an error. A project file can't set the user-only options.
+func (cfg *Config) loadFile(path string, project bool) error {
+	var file fileConfig
+	if _, err := toml.DecodeFile(path, &file); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to load config %s: %w", path, err)
+	}
+	if keys := file.userOnly(); project && len(keys) > 0 {
+		return fmt.Errorf("invalid config %s: %s can only be set in the user config", path, strings.Join(keys, ", "))
+	}
+
+	setIfPresent(&cfg.EnableOutputThrottling, file.OutputThrottling)
+	setIfPresent(&cfg.EnableInputThrottling, file.InputThrottling)
+	setIfPresent(&cfg.EnableHeldEnterDetection, file.HeldEnterDetection)
+	setIfPresent(&cfg.EnableWatchFiles, file.WatchFiles)
+	setIfPresent(&cfg.WatchDir, file.WatchDir)
+	setIfPresent(&cfg.ForceAnsi, file.ForceAnsi)
+	setIfPresent(&cfg.BetterDefaults, file.BetterDefaults)
+	setIfPresent(&cfg.LogFile, file.LogFile)
+	setIfPresent(&cfg.LogLevel, file.LogLevel)
+	setIfPresent(&cfg.StateDetection, file.StateDetection)
+	setIfPresent(&cfg.RecordFile, file.RecordFile)
+	setIfPresent(&cfg.RecordInput, file.RecordInput)
+	setIfPresent(&cfg.TranscriptFile, file.TranscriptFile)
+	setIfPresent(&cfg.ControlSocket, file.ControlSocket)
+	setIfPresent(&cfg.InlineSnippets, file.InlineSnippets)
+	setIfPresent(&cfg.MaxFileSize, file.MaxFileSize)
+	setIfPresent(&cfg.MaxFilesToSearch, file.MaxFilesToSearch)
+	setIfPresent(&cfg.ScanWorkers, file.ScanWorkers)
+	setIfPresent(&cfg.SnippetBudget, file.SnippetBudget)
+	setIfPresent(&cfg.ContextBudget, file.ContextBudget)
+	for _, d := range []struct {
+		key       string
+		val       *time.Duration
+		allowZero bool
+	}{
+		{"submit_delay", file.SubmitDelay, false},
+		{"throttle_fast_delay", file.ThrottleFastDelay, false},
+		{"throttle_slow_delay", file.ThrottleSlowDelay, false},
+		{"throttle_input_timeout", file.ThrottleInputTimeout, false},
+		{"tmux_poll_interval", file.TmuxPollInterval, false},
+		{"processed_expiry", file.ProcessedExpiry, true},
+		{"comment_timeout", file.CommentTimeout, true},
+		{"queue_timeout", file.QueueTimeout, true},
+		{"prompt_idle_delay", file.PromptIdleDelay, true},
+	} {
+		if d.val != nil {
+			if err := checkDuration(*d.val, d.allowZero); err != nil {
+				return fmt.Errorf("invalid config %s: %s %w", path, d.key, err)
+			}
+		}
+	}
+	setIfPresent(&cfg.SubmitDelay, file.SubmitDelay)
+	setIfPresent(&cfg.ThrottleFastDelay, file.ThrottleFastDelay)
+	setIfPresent(&cfg.ThrottleSlowDelay, file.ThrottleSlowDelay)
+	setIfPresent(&cfg.ThrottleInputTimeout, file.ThrottleInputTimeout)
+	setIfPresent(&cfg.TmuxPollInterval, file.TmuxPollInterval)
+	setIfPresent(&cfg.ProcessedExpiry, file.ProcessedExpiry)
+	setIfPresent(&cfg.CommentTimeout, file.CommentTimeout)
+	setIfPresent(&cfg.QueueTimeout, file.QueueTimeout)
+	setIfPresent(&cfg.PromptIdleDelay, file.PromptIdleDelay)
+	setIfPresent(&cfg.MarkerWords, file.MarkerWords)
+	if len(cfg.MarkerWords) == 0 {
+		return fmt.Errorf("invalid config %s: marker_words must not be empty", path)
+	}
+	for _, word := range cfg.MarkerWords {
+		if strings.TrimSpace(word) == "" {
+			return fmt.Errorf("invalid config %s: marker_words can't contain an empty word", path)
+		}
+	}
+
+	for _, lang := range file.Languages {
+		if err := lang.validate(); err != nil {
+			return fmt.Errorf("invalid language %q in %s: %w", lang.Name, path, err)
+		}
+		cfg.Languages = append(cfg.Languages, lang)
+	}
+
+	if file.KeymapFile != nil {
+		// Relative paths are relative to the config file
+		cfg.KeymapFile = *file.KeymapFile
+		if cfg.KeymapFile != "" && !filepath.IsAbs(cfg.KeymapFile) {
+			cfg.KeymapFile = filepath.Join(filepath.Dir(path), cfg.KeymapFile)
+		}
+	}
+
+	if file.PromptsDir != nil {
+		cfg.PromptsDir = *file.PromptsDir
+		if cfg.PromptsDir != "" && !filepath.IsAbs(cfg.PromptsDir) {
+			cfg.PromptsDir = filepath.Join(filepath.Dir(path), cfg.PromptsDir)
+		}
+	}
+
+	for name, pattern := range file.StatePatterns {
+		cfg.StatePatterns[strings.ToLower(name)] = pattern
+	}
+	return nil
+}
+
+func (l LanguageConfig) validate() error {
+	if len(l.Extensions) == 0 && len(l.Filenames) == 0 {
+		return errors.New("needs extensions or filenames")
 	}
+	if len(l.LineComments) == 0 && len(l.BlockComments) == 0 {
+		return errors.New("needs line_comments or block_comments")
+	}
+	for _, pair := range l.BlockComments {
+		if len(pair) != 2 || pair[0] == "" || pair[1] == "" {
+			return fmt.Errorf("block comment %q is not a start and end pair", pair)
+		}
+	}
+	return nil
+}
+
+func setIfPresent[T any](dst *T, val *T) {
+	if val != nil {
+		*dst = *val
+	}
+}
 
-	// Override with environment variables if set
+// loadEnv applies CLAWDE_ environment variables
+func (cfg *Config) loadEnv() error {
 	if val := os.Getenv("CLAWDE_OUTPUT_THROTTLING"); val != "" {
 		cfg.EnableOutputThrottling = parseBool(val)
 	}
@@ -49,6 +370,10 @@ func LoadConfig() *Config {
 		cfg.EnableWatchFiles = parseBool(val)
 	}
 
+	if val := os.Getenv("CLAWDE_WATCH_DIR"); val != "" {
+		cfg.WatchDir = val
+	}
+
 	if val := os.Getenv("CLAWDE_LOG_FILE"); val != "" {
 		cfg.LogFile = val
 	}
@@ -65,7 +390,133 @@ func LoadConfig() *Config {
 		cfg.BetterDefaults = parseBool(val)
 	}
 
-	return cfg
+	if val := os.Getenv("CLAWDE_STATE_DETECTION"); val != "" {
+		cfg.StateDetection = val
+	}
+
+	for name, pattern := range statePatternOverridesFromEnv() {
+		cfg.StatePatterns[name] = pattern
+	}
+
+	if val := os.Getenv("CLAWDE_KEYMAP_FILE"); val != "" {
+		cfg.KeymapFile = val
+	}
+
+	if val := os.Getenv("CLAWDE_PROMPTS_DIR"); val != "" {
+		cfg.PromptsDir = val
+	}
+
+	if val := os.Getenv("CLAWDE_RECORD_FILE"); val != "" {
+		cfg.RecordFile = val
+	}
+
+	if val := os.Getenv("CLAWDE_RECORD_INPUT"); val != "" {
+		cfg.RecordInput = parseBool(val)
+	}
+
+	if val := os.Getenv("CLAWDE_TRANSCRIPT_FILE"); val != "" {
+		cfg.TranscriptFile = val
+	}
+
+	if val := os.Getenv("CLAWDE_CONTROL_SOCKET"); val != "" {
+		cfg.ControlSocket = parseBool(val)
+	}
+
+	if val := os.Getenv("CLAWDE_MAX_FILE_SIZE"); val != "" {
+		n, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid CLAWDE_MAX_FILE_SIZE: %w", err)
+		}
+		cfg.MaxFileSize = n
+	}
+
+	if val := os.Getenv("CLAWDE_MAX_FILES_TO_SEARCH"); val != "" {
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return fmt.Errorf("invalid CLAWDE_MAX_FILES_TO_SEARCH: %w", err)
+		}
+		cfg.MaxFilesToSearch = n
+	}
+
+	if val := os.Getenv("CLAWDE_SCAN_WORKERS"); val != "" {
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return fmt.Errorf("invalid CLAWDE_SCAN_WORKERS: %w", err)
+		}
+		cfg.ScanWorkers = n
+	}
+
+	if val := os.Getenv("CLAWDE_INLINE_SNIPPETS"); val != "" {
+		cfg.InlineSnippets = parseBool(val)
+	}
+
+	if val := os.Getenv("CLAWDE_SNIPPET_BUDGET"); val != "" {
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return fmt.Errorf("invalid CLAWDE_SNIPPET_BUDGET: %w", err)
+		}
+		cfg.SnippetBudget = n
+	}
+
+	if val := os.Getenv("CLAWDE_CONTEXT_BUDGET"); val != "" {
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return fmt.Errorf("invalid CLAWDE_CONTEXT_BUDGET: %w", err)
+		}
+		cfg.ContextBudget = n
+	}
+
+	if val := os.Getenv("CLAWDE_MARKER_WORDS"); val != "" {
+		var words []string
+		for _, word := range strings.Split(val, ",") {
+			if word = strings.TrimSpace(word); word != "" {
+				words = append(words, word)
+			}
+		}
+		if len(words) == 0 {
+			return errors.New("invalid CLAWDE_MARKER_WORDS: no words")
+		}
+		cfg.MarkerWords = words
+	}
+
+	durations := []struct {
+		env       string
+		dst       *time.Duration
+		allowZero bool
+	}{
+		{"CLAWDE_SUBMIT_DELAY", &cfg.SubmitDelay, false},
+		{"CLAWDE_THROTTLE_FAST_DELAY", &cfg.ThrottleFastDelay, false},
+		{"CLAWDE_THROTTLE_SLOW_DELAY", &cfg.ThrottleSlowDelay, false},
+		{"CLAWDE_THROTTLE_INPUT_TIMEOUT", &cfg.ThrottleInputTimeout, false},
+		{"CLAWDE_TMUX_POLL_INTERVAL", &cfg.TmuxPollInterval, false},
+		{"CLAWDE_PROCESSED_EXPIRY", &cfg.ProcessedExpiry, true},
+		{"CLAWDE_COMMENT_TIMEOUT", &cfg.CommentTimeout, true},
+		{"CLAWDE_QUEUE_TIMEOUT", &cfg.QueueTimeout, true},
+		{"CLAWDE_PROMPT_IDLE_DELAY", &cfg.PromptIdleDelay, true},
+	}
+	for _, d := range durations {
+		if val := os.Getenv(d.env); val != "" {
+			parsed, err := time.ParseDuration(val)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", d.env, err)
+			}
+			if err := checkDuration(parsed, d.allowZero); err != nil {
+				return fmt.Errorf("invalid %s: %w", d.env, err)
+			}
+			*d.dst = parsed
+		}
+	}
+
+	return nil
+}
+
+// checkDuration rejects negative durations, and zero for options where it
+// would mean polling or flushing in a busy loop
+func checkDuration(d time.Duration, allowZero bool) error {
+	if d < 0 || d == 0 && !allowZero {
+		return fmt.Errorf("must be positive, got %s", d)
+	}
+	return nil
 }
 
 // parseBool converts string to bool, treating "true", "1", "yes", "on" as true (case-insensitive)
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, dir, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigDefaults(t *testing.T) {
+	dir := t.TempDir()
+	cfg, err := loadConfig(filepath.Join(dir, "missing.toml"), filepath.Join(dir, ".clawde.toml"))
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+
+	if !cfg.EnableOutputThrottling || cfg.EnableWatchFiles {
+		t.Errorf("unexpected defaults: %+v", cfg)
+	}
+	if cfg.SubmitDelay != 100*time.Millisecond {
+		t.Errorf("expected default submit delay 100ms, got %v", cfg.SubmitDelay)
+	}
+	if cfg.MaxFilesToSearch != 10000 {
+		t.Errorf("expected default max files 10000, got %d", cfg.MaxFilesToSearch)
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	userDir := t.TempDir()
+	userFile := writeConfigFile(t, userDir, `
+watch_files = true
+log_level = "debug"
+submit_delay = "200ms"
+max_files_to_search = 50
+throttle_slow_delay = "50ms"
+keymap_file = "keys.toml"
+prompts_dir = "prompts"
+
+[state_patterns]
+busy = "user-busy"
+idle = "user-idle"
+`)
+	projectDir := t.TempDir()
+	projectFile := writeConfigFile(t, projectDir, `
+submit_delay = "300ms"
+max_files_to_search = 500
+
+[state_patterns]
+busy = "project-busy"
+`)
+
+	t.Setenv("CLAWDE_MAX_FILES_TO_SEARCH", "5000")
+	t.Setenv("CLAWDE_STATE_PATTERN_IDLE", "env-idle")
+
+	cfg, err := loadConfig(userFile, projectFile)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+
+	// Only set in the user file
+	if !cfg.EnableWatchFiles || cfg.LogLevel != "debug" || cfg.ThrottleSlowDelay != 50*time.Millisecond {
+		t.Errorf("expected user file options to apply: %+v", cfg)
+	}
+	// Project file overrides the user file
+	if cfg.SubmitDelay != 300*time.Millisecond {
+		t.Errorf("expected project submit delay 300ms, got %v", cfg.SubmitDelay)
+	}
+	if cfg.StatePatterns["busy"] != "project-busy" {
+		t.Errorf("expected project busy pattern, got %q", cfg.StatePatterns["busy"])
+	}
+	// Environment overrides both
+	if cfg.MaxFilesToSearch != 5000 {
+		t.Errorf("expected env max files 5000, got %d", cfg.MaxFilesToSearch)
+	}
+	if cfg.StatePatterns["idle"] != "env-idle" {
+		t.Errorf("expected env idle pattern, got %q", cfg.StatePatterns["idle"])
+	}
+	// Relative keymap path is resolved against the file that set it
+	if want := filepath.Join(userDir, "keys.toml"); cfg.KeymapFile != want {
+		t.Errorf("expected keymap file %q, got %q", want, cfg.KeymapFile)
+	}
+	if want := filepath.Join(userDir, "prompts"); cfg.PromptsDir != want {
+		t.Errorf("expected prompts dir %q, got %q", want, cfg.PromptsDir)
+	}
+	// Untouched options keep their defaults
+	if !cfg.ForceAnsi {
+		t.Error("expected ForceAnsi default to be kept")
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	badFile := writeConfigFile(t, t.TempDir(), `submit_delay = "soon"`)
+	if _, err := loadConfig(badFile, ""); err == nil {
+		t.Error("expected error for invalid duration in config file")
+	}
+
+	for _, content := range []string{
+		"marker_words = []",
+		"marker_words = [\"AI\", \" \"]",
+		"[[language]]\nname = \"x\"\nline_comments = [\"#\"]",
+		"[[language]]\nname = \"x\"\nextensions = [\".x\"]",
+		"[[language]]\nname = \"x\"\nextensions = [\".x\"]\nblock_comments = [[\"{#\"]]",
+	} {
+		badFile := writeConfigFile(t, t.TempDir(), content)
+		if _, err := loadConfig(badFile, ""); err == nil {
+			t.Errorf("expected error for config %q", content)
+		}
+	}
+
+	t.Setenv("CLAWDE_SUBMIT_DELAY", "soon")
+	if _, err := loadConfig("", ""); err == nil {
+		t.Error("expected error for invalid duration in environment")
+	}
+}
+
+func TestProjectConfigCantSetUserOnlyOptions(t *testing.T) {
+	for _, content := range []string{
+		`record_file = "../../.bashrc"`,
+		`transcript_file = "out.md"`,
+		`log_file = "clawde.log"`,
+		`keymap_file = "keys.toml"`,
+		`prompts_dir = "prompts"`,
+		`watch_dir = "/"`,
+		`control_socket = true`,
+	} {
+		projectFile := writeConfigFile(t, t.TempDir(), content)
+		key, _, _ := strings.Cut(content, " ")
+		if _, err := loadConfig("", projectFile); err == nil || !strings.Contains(err.Error(), key) {
+			t.Errorf("expected an error naming %s for a project file, got %v", key, err)
+		}
+
+		// The same options are fine in the user config
+		if _, err := loadConfig(writeConfigFile(t, t.TempDir(), content), ""); err != nil {
+			t.Errorf("expected %s to be allowed in the user config, got %v", key, err)
+		}
+	}
+}
+
+func TestConfigDurationsMustBePositive(t *testing.T) {
+	for _, content := range []string{`tmux_poll_interval = "0s"`, `throttle_slow_delay = "-1ms"`} {
+		badFile := writeConfigFile(t, t.TempDir(), content)
+		key, _, _ := strings.Cut(content, " ")
+		if _, err := loadConfig(badFile, ""); err == nil || !strings.Contains(err.Error(), key) {
+			t.Errorf("expected an error naming %s for %q, got %v", key, content, err)
+		}
+	}
+
+	// Zero turns these off
+	okFile := writeConfigFile(t, t.TempDir(), "processed_expiry = \"0s\"\ncomment_timeout = \"0s\"")
+	if _, err := loadConfig(okFile, ""); err != nil {
+		t.Errorf("expected zero to be allowed, got %v", err)
+	}
+
+	t.Setenv("CLAWDE_SUBMIT_DELAY", "-100ms")
+	if _, err := loadConfig("", ""); err == nil || !strings.Contains(err.Error(), "CLAWDE_SUBMIT_DELAY") {
+		t.Errorf("expected an error naming CLAWDE_SUBMIT_DELAY, got %v", err)
+	}
+}
+
+func TestProjectConfigFromRepoRoot(t *testing.T) {
+	root := t.TempDir()
+	os.Mkdir(filepath.Join(root, ".git"), 0755)
+	os.WriteFile(filepath.Join(root, projectConfigFile), []byte("context_budget = 42"), 0644)
+	sub := filepath.Join(root, "pkg", "sub")
+	os.MkdirAll(sub, 0755)
+
+	wd, _ := os.Getwd()
+	if err := os.Chdir(sub); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	cfg, err := LoadConfig(filepath.Join(root, "missing.toml"))
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.ContextBudget != 42 {
+		t.Errorf("expected the project config at the repository root to be read from a subdirectory")
+	}
+}
+
+func TestConfigLanguagesAndMarkers(t *testing.T) {
+	userFile := writeConfigFile(t, t.TempDir(), `
+marker_words = ["AI", "@claude"]
+
+[[language]]
+name = "Jinja"
+extensions = [".j2"]
+block_comments = [["{#", "#}"]]
+`)
+	projectFile := writeConfigFile(t, t.TempDir(), `
+[[language]]
+name = "Just"
+filenames = ["Justfile"]
+line_comments = ["#"]
+strings = ['"']
+`)
+
+	cfg, err := loadConfig(userFile, projectFile)
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if len(cfg.Languages) != 2 || cfg.Languages[0].Name != "Jinja" || cfg.Languages[1].Name != "Just" {
+		t.Errorf("expected languages from both files, got %+v", cfg.Languages)
+	}
+	if len(cfg.MarkerWords) != 2 || cfg.MarkerWords[1] != "@claude" {
+		t.Errorf("unexpected marker words %q", cfg.MarkerWords)
+	}
+
+	t.Setenv("CLAWDE_MARKER_WORDS", "TODO(ai), @bot")
+	cfg, err = loadConfig(userFile, "")
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if len(cfg.MarkerWords) != 2 || cfg.MarkerWords[0] != "TODO(ai)" || cfg.MarkerWords[1] != "@bot" {
+		t.Errorf("expected env marker words, got %q", cfg.MarkerWords)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/mattduck/clawde/internal/control"
+	"github.com/mattduck/clawde/internal/input"
+)
+
+// stateEventInterval is how often the screen state is checked for changes to
+// report to subscribers
+const stateEventInterval = 250 * time.Millisecond
+
+// startControlServer listens on the session's control socket so that other
+// programs can drive clawde
+func (w *CLIWrapper) startControlServer() error {
+	srv, err := control.Listen(control.SocketPath(os.Getpid()))
+	if err != nil {
+		return err
+	}
+
+	srv.Handle(control.MethodSendText, w.handleSendText)
+	srv.Handle(control.MethodSubmit, func(params json.RawMessage) (interface{}, error) {
+		_, err := w.stdin.Write([]byte{13})
+		return err == nil, err
+	})
+	srv.Handle(control.MethodSendKeys, w.handleSendKeys)
+	srv.Handle(control.MethodGetScreen, w.handleGetScreen)
+	srv.Handle(control.MethodGetState, func(params json.RawMessage) (interface{}, error) {
+		return w.controlState(), nil
+	})
+	srv.Handle(control.MethodTriggerCommentSearch, func(params json.RawMessage) (interface{}, error) {
+		go triggerAICommentSearch(w)
+		return true, nil
+	})
+
+	srv.Handle(control.MethodListComments, w.handleListComments)
+
+	w.controlServer = srv
+
+	// Register the session so that clawdectl can find it
+	dir, _ := os.Getwd()
+	w.unregister, err = control.Register(control.SessionInfo{
+		PID:      os.Getpid(),
+		Socket:   srv.Path(),
+		Dir:      dir,
+		Started:  time.Now(),
+		Version:  version,
+		TmuxPane: os.Getenv("TMUX_PANE"),
+	})
+	if err != nil {
+		logger.Warn("Failed to register session", "error", err)
+	}
+
+	go func() {
+		if err := srv.Serve(); err != nil {
+			logger.Error("Control server stopped", "error", err)
+		}
+	}()
+	w.stopState = make(chan struct{})
+	go w.publishStateChanges(w.stopState)
+
+	logger.Info("Control socket listening", "path", srv.Path())
+	return nil
+}
+
+func (w *CLIWrapper) handleSendText(params json.RawMessage) (interface{}, error) {
+	var p control.SendTextParams
+	if err := control.DecodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Submit {
+		if err := w.SendCommand(p.Text); err != nil {
+			return nil, err
+		}
+		return true, nil
+	}
+	_, err := w.stdin.Write([]byte(p.Text))
+	return err == nil, err
+}
+
+func (w *CLIWrapper) handleSendKeys(params json.RawMessage) (interface{}, error) {
+	var p control.SendKeysParams
+	if err := control.DecodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	var data []byte
+	for _, name := range p.Keys {
+		b, err := input.ParseKey(name)
+		if err != nil {
+			return nil, control.Errorf(control.CodeInvalidParams, "%v", err)
+		}
+		data = append(data, b...)
+	}
+	_, err := w.stdin.Write(data)
+	return err == nil, err
+}
+
+func (w *CLIWrapper) handleGetScreen(params json.RawMessage) (interface{}, error) {
+	var p control.GetScreenParams
+	if len(params) > 0 {
+		if err := control.DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+	}
+
+	cols, rows := w.screen.Size()
+	cursor := w.screen.Cursor()
+	screen := control.Screen{
+		Cols:      cols,
+		Rows:      rows,
+		Lines:     w.screen.Lines(),
+		CursorX:   cursor.X,
+		CursorY:   cursor.Y,
+		Alternate: w.screen.IsAlternate(),
+	}
+	if p.Scrollback {
+		screen.Scrollback = w.screen.Scrollback()
+	}
+	return screen, nil
+}
+
+func (w *CLIWrapper) handleListComments(params json.RawMessage) (interface{}, error) {
+	var p control.ListCommentsParams
+	if len(params) > 0 {
+		if err := control.DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+	}
+	return w.comments.List(p.All), nil
+}
+
+// controlState reports the screen state and clawde's own toggles
+func (w *CLIWrapper) controlState() control.State {
+	state := w.screenState()
+	return control.State{
+		VimMode:          string(state.VimMode),
+		PermissionPrompt: state.PermissionPrompt,
+		Busy:             state.Busy,
+		Idle:             state.Idle,
+		SlashMenu:        state.SlashMenu,
+		FileWatching:     w.fileWatching(),
+		OutputThrottling: w.outputThrottling.Load(),
+	}
+}
+
+// publishStateChanges sends a state event whenever the state changes, until
+// stop is closed
+func (w *CLIWrapper) publishStateChanges(stop <-chan struct{}) {
+	ticker := time.NewTicker(stateEventInterval)
+	defer ticker.Stop()
+
+	var last control.State
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			state := w.controlState()
+			if state != last {
+				w.publish(control.EventState, state)
+				last = state
+			}
+		}
+	}
+}
+
+// publish sends an event to control socket subscribers, if there is a server
+func (w *CLIWrapper) publish(eventType string, data interface{}) {
+	if w.controlServer != nil {
+		w.controlServer.Publish(control.NewEvent(eventType, data))
+	}
+}
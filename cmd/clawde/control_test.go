@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/mattduck/clawde/internal/control"
+	"github.com/mattduck/clawde/internal/vt"
+)
+
+func TestControlHandlers(t *testing.T) {
+	var stdin bytes.Buffer
+	w := &CLIWrapper{
+		stdin:  &stdin,
+		screen: vt.New(20, 3),
+		config: defaultConfig(),
+	}
+	w.screen.Write([]byte("hello\r\n  -- INSERT --"))
+
+	if _, err := w.handleSendText(json.RawMessage(`{"text": "draft"}`)); err != nil {
+		t.Fatalf("send_text error = %v", err)
+	}
+	if _, err := w.handleSendKeys(json.RawMessage(`{"keys": ["C-a", "esc", "up"]}`)); err != nil {
+		t.Fatalf("send_keys error = %v", err)
+	}
+	if got, want := stdin.String(), "draft\x01\x1b\x1b[A"; got != want {
+		t.Errorf("stdin = %q, want %q", got, want)
+	}
+
+	if _, err := w.handleSendKeys(json.RawMessage(`{"keys": ["nonsense"]}`)); err == nil {
+		t.Error("expected error for unknown key")
+	}
+
+	result, err := w.handleGetScreen(nil)
+	if err != nil {
+		t.Fatalf("get_screen error = %v", err)
+	}
+	screen := result.(control.Screen)
+	if screen.Cols != 20 || screen.Rows != 3 || screen.Lines[0] != "hello" || screen.CursorY != 1 {
+		t.Errorf("unexpected screen %+v", screen)
+	}
+}
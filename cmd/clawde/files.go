@@ -1,123 +1,32 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/mattduck/clawde/internal/gitignore"
+	"github.com/mattduck/clawde/internal/gitrepo"
+	"github.com/mattduck/clawde/internal/lexer"
+	"github.com/mattduck/clawde/internal/scan"
 )
 
-// GitIgnoreCache stores git-ignored files for fast lookup
-type GitIgnoreCache struct {
-	ignoredFiles map[string]bool
-	isGitRepo    bool
-	mu           sync.RWMutex
-}
-
-// NewGitIgnoreCache creates a new GitIgnoreCache and populates it
-func NewGitIgnoreCache(watchDir string) *GitIgnoreCache {
-	cache := &GitIgnoreCache{
-		ignoredFiles: make(map[string]bool),
-		isGitRepo:    false,
-	}
-
-	// Check if we're in a git repository
-	gitDir := filepath.Join(watchDir, ".git")
-	if info, err := os.Stat(gitDir); err == nil && info.IsDir() {
-		cache.isGitRepo = true
-		logger.Info("Git repository detected", "dir", watchDir)
-		cache.loadGitIgnoredFiles(watchDir)
-	} else {
-		logger.Info("Not a git repository", "dir", watchDir)
-	}
-
-	return cache
-}
-
-// loadGitIgnoredFiles runs git ls-files to get ignored files
-func (g *GitIgnoreCache) loadGitIgnoredFiles(watchDir string) {
-	// First, find the git repository root
-	cmd := exec.Command("git", "rev-parse", "--show-toplevel")
-	cmd.Dir = watchDir
-
-	var rootOut bytes.Buffer
-	cmd.Stdout = &rootOut
-
-	err := cmd.Run()
-	if err != nil {
-		logger.Warn("Failed to find git root", "error", err)
-		return
-	}
-
-	gitRoot := strings.TrimSpace(rootOut.String())
-	logger.Info("Git repository root", "root", gitRoot)
-
-	// Now get the list of ignored files from the git root
-	cmd = exec.Command("git", "ls-files", "--ignored", "--exclude-standard", "--others")
-	cmd.Dir = gitRoot
-
-	var out bytes.Buffer
-	cmd.Stdout = &out
-
-	err = cmd.Run()
-	if err != nil {
-		logger.Warn("Failed to run git ls-files", "error", err)
-		return
-	}
-
-	g.mu.Lock()
-	defer g.mu.Unlock()
-
-	// Parse the output and store in map
-	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
-	for _, line := range lines {
-		if line != "" {
-			// Git ls-files returns paths relative to git root
-			// Convert to absolute path by joining with git root
-			absPath := filepath.Join(gitRoot, line)
-			g.ignoredFiles[absPath] = true
-		}
-	}
-
-	logger.Info("Loaded git-ignored files into cache", "count", len(g.ignoredFiles))
-}
-
-// IsIgnored checks if a path is git-ignored
-func (g *GitIgnoreCache) IsIgnored(path string) bool {
-	g.mu.RLock()
-	defer g.mu.RUnlock()
-
-	// Check exact path match
-	if g.ignoredFiles[path] {
-		return true
-	}
-
-	// Check if any parent directory is ignored
-	dir := path
-	for {
-		dir = filepath.Dir(dir)
-		if dir == "." || dir == "/" {
-			break
-		}
-		if g.ignoredFiles[dir] {
-			return true
-		}
-	}
-
-	return false
-}
-
 // FileWatcher manages file system monitoring
 type FileWatcher struct {
 	watcher      *fsnotify.Watcher
 	watchDir     string
 	onFileChange func(string) // Callback for file changes
-	gitIgnore    *GitIgnoreCache
+	onRemove     func(string) // Callback for removed or renamed files and directories
+	onDirAdded   func(string) // Callback for new directories, whose files don't get events
+	ignore       *gitignore.Matcher
 }
 
 // NewFileWatcher creates a new file watcher
@@ -134,14 +43,19 @@ func NewFileWatcher(watchDir string, onFileChange func(string)) (*FileWatcher, e
 		return nil, fmt.Errorf("failed to create file watcher: %w", err)
 	}
 
-	// Initialize git ignore cache
-	gitIgnore := NewGitIgnoreCache(watchDir)
+	root, isRepo := gitrepo.Root(watchDir)
+	ignore := gitignore.New(root)
+	if isRepo {
+		logger.Info("Git repository detected", "dir", watchDir, "root", root, "git_dir", gitrepo.Dir(root))
+	} else {
+		logger.Info("Not a git repository", "dir", watchDir)
+	}
 
 	fw := &FileWatcher{
 		watcher:      watcher,
 		watchDir:     watchDir,
 		onFileChange: onFileChange,
-		gitIgnore:    gitIgnore,
+		ignore:       ignore,
 	}
 
 	logger.Info("File watcher created successfully", "dir", watchDir)
@@ -157,6 +71,18 @@ func (fw *FileWatcher) Start() error {
 		return fmt.Errorf("failed to add directories to watcher: %w", err)
 	}
 
+	// The git directory isn't watched with the rest of the tree, but its
+	// exclude file is an ignore file. For worktrees and submodules it's
+	// somewhere else.
+	if gitDir := gitrepo.Dir(fw.ignore.Root()); gitDir != "" {
+		infoDir := filepath.Join(gitrepo.CommonDir(gitDir), "info")
+		if info, err := os.Stat(infoDir); err == nil && info.IsDir() {
+			if err := fw.watcher.Add(infoDir); err != nil {
+				logger.Warn("Failed to add directory to watcher", "path", infoDir, "error", err)
+			}
+		}
+	}
+
 	// Start the event processing goroutine
 	go fw.processEvents()
 
@@ -219,6 +145,25 @@ func (fw *FileWatcher) processEvents() {
 				logger.Debug("CHMOD event", "file", event.Name)
 			}
 
+			// Files that were ignored may not be any more, and the other way
+			// round, so the affected directory is searched again
+			if event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Remove|fsnotify.Rename) != 0 && fw.ignore.Changed(event.Name) {
+				// Exclude files and git config apply to the whole tree, and a
+				// .git appearing makes its directory a nested repository
+				dir := filepath.Dir(event.Name)
+				perDir := gitignore.IsIgnoreFile(event.Name) || filepath.Base(event.Name) == ".git"
+				if !perDir || !isWithin(fw.watchDir, dir) {
+					dir = fw.watchDir
+				}
+				logger.Info("Ignore rules changed", "file", event.Name, "dir", dir)
+				if err := fw.addDirectoriesRecursively(dir); err != nil {
+					logger.Warn("Failed to add directories to watcher", "dir", dir, "error", err)
+				}
+				if fw.onDirAdded != nil {
+					fw.onDirAdded(dir)
+				}
+			}
+
 			// Handle directory creation events - add new directories to watcher
 			if event.Op&fsnotify.Create == fsnotify.Create {
 				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
@@ -231,34 +176,40 @@ func (fw *FileWatcher) processEvents() {
 						} else {
 							logger.Info("Successfully added new directory and subdirectories to watcher", "name", event.Name)
 						}
+						if fw.onDirAdded != nil {
+							fw.onDirAdded(event.Name)
+						}
 					}
 				}
 			}
 
+			// Removed files, and the old names of renamed ones, no longer have
+			// comments. A rename's new name gets a create event.
+			if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 && fw.onRemove != nil {
+				fw.onRemove(event.Name)
+			}
+
 			// React to write and create events on specific file types
 			// Many editors use atomic replacement (create temp file, rename) instead of direct writes
 			if event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Create == fsnotify.Create {
 				// Skip files in ignored directories
 				if fw.shouldIgnoreDirectory(filepath.Dir(event.Name)) {
 					logger.Debug("Ignoring file in ignored directory", "name", event.Name)
-				} else if fw.gitIgnore != nil && fw.gitIgnore.isGitRepo && fw.gitIgnore.IsIgnored(event.Name) {
-					logger.Debug("Ignoring git-ignored file", "name", event.Name)
+				} else if fw.ignore.Ignored(event.Name, false) {
+					logger.Debug("Ignoring file matched by ignore rules", "name", event.Name)
 				} else {
-					ext := filepath.Ext(event.Name)
-					logger.Debug("File extension detected", "ext", ext, "file", event.Name)
-
 					// Skip temporary files (ending with ~, .tmp, .swp, etc.)
 					if strings.HasSuffix(event.Name, "~") ||
 						strings.HasSuffix(event.Name, ".tmp") ||
 						strings.HasSuffix(event.Name, ".swp") ||
 						strings.Contains(event.Name, ".#") {
 						logger.Debug("Ignoring temporary file", "name", event.Name)
-					} else if ext == ".py" || ext == ".js" || ext == ".go" {
+					} else if lang := lexer.ForFile(event.Name); lang != nil {
 						// Skip test files (contain false positives)
 						if filepath.Base(event.Name) == "test_comments.go" || filepath.Base(event.Name) == "comment_test.go" {
 							logger.Debug("Ignoring test file", "name", event.Name)
 						} else {
-							logger.Info("File change detected for monitored extension", "name", event.Name)
+							logger.Info("File change detected for supported language", "name", event.Name, "language", lang.Name)
 
 							// Call the callback function with the file path
 							if fw.onFileChange != nil {
@@ -266,7 +217,7 @@ func (fw *FileWatcher) processEvents() {
 							}
 						}
 					} else {
-						logger.Debug("Ignoring file change for unmonitored extension", "ext", ext, "file", event.Name)
+						logger.Debug("Ignoring file change for unsupported language", "file", event.Name)
 					}
 				}
 			} else {
@@ -285,11 +236,8 @@ func (fw *FileWatcher) processEvents() {
 
 // shouldIgnoreDirectory checks if a directory should be ignored
 func (fw *FileWatcher) shouldIgnoreDirectory(dirPath string) bool {
-	// First check git ignore cache if available
-	if fw.gitIgnore != nil && fw.gitIgnore.isGitRepo {
-		if fw.gitIgnore.IsIgnored(dirPath) {
-			return true
-		}
+	if fw.ignore.Ignored(dirPath, true) {
+		return true
 	}
 
 	dirName := filepath.Base(dirPath)
@@ -358,110 +306,127 @@ func (fw *FileWatcher) addDirectoriesRecursively(rootDir string) error {
 	})
 }
 
+// scanWorkers is how many files are searched for AI markers at once
+var scanWorkers = runtime.NumCPU()
+
+// scanProgressInterval is how often a long search logs its progress
+const scanProgressInterval = 2 * time.Second
+
 // FindFilesWithAIComments searches for files containing AI-related comments.
 // this is a basic search to prune the potential files that we need to search in
-// more depth.
-func FindFilesWithAIComments(rootDir string, gitIgnore *GitIgnoreCache) ([]string, error) {
-	var files []string
-	var mutex sync.Mutex
-	var wg sync.WaitGroup
-	var fileCount int
-
+// more depth. It stops early if ctx is cancelled.
+func FindFilesWithAIComments(ctx context.Context, rootDir string, ignore *gitignore.Matcher) ([]string, error) {
 	logger.Debug("Starting search for files with AI comments", "directory", rootDir)
 
-	err := filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			logger.Warn("Error accessing path", "path", path, "error", err)
-			return nil // Continue walking even if one path fails
-		}
+	var needles []string
+	for _, word := range markerWords {
+		needles = append(needles, word+"?", word+"!", word+":")
+	}
+	scanner := &scan.Scanner{
+		Needles:          needles,
+		MaxFileSize:      maxFileSize,
+		Workers:          scanWorkers,
+		ProgressInterval: scanProgressInterval,
+		Progress: func(stats scan.Stats) {
+			logger.Info("Searching for AI comments", "directory", rootDir, "files", stats.Files, "matched", stats.Matched)
+		},
+	}
 
-		// Only process files (not directories)
-		if !info.IsDir() {
-			// Check if file has a supported extension first
-			ext := filepath.Ext(path)
-			if _, exists := commentPatterns[ext]; exists {
-				// Check file count limit for supported files only
-				fileCount++
-				if fileCount > maxFilesToSearch {
-					logger.Warn("Stopping file search: reached limit", "limit", maxFilesToSearch)
-					return filepath.SkipAll
-				}
-				// Skip ignored directories and files
-				dirPath := filepath.Dir(path)
-
-				// Check git ignore cache if available
-				if gitIgnore != nil && gitIgnore.isGitRepo {
-					// Check both the file and its directory
-					if gitIgnore.IsIgnored(path) || gitIgnore.IsIgnored(dirPath) {
-						return nil
-					}
-				}
+	paths := make(chan string, scanWorkers)
+	walkDone := make(chan error, 1)
+	go func() {
+		defer close(paths)
+		walkDone <- walkSourceFiles(ctx, rootDir, ignore, paths)
+	}()
 
-				// Check standard ignore patterns
-				dirName := filepath.Base(dirPath)
-				if dirName == ".git" || strings.HasPrefix(dirName, ".") && dirName != "." {
-					return nil
-				}
+	files, stats, err := scanner.Run(ctx, paths)
+	if walkErr := <-walkDone; walkErr != nil && err == nil {
+		err = walkErr
+	}
+	logger.Info("Searched for AI comments",
+		"directory", rootDir,
+		"files", stats.Files,
+		"matched", stats.Matched,
+		"binary", stats.Binary,
+		"too_large", stats.TooLarge,
+		"errors", stats.Errors,
+		"bytes", stats.Bytes,
+		"duration", stats.Duration)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search directory %s: %w", rootDir, err)
+	}
 
-				// Check common ignored directories
-				ignoredDirs := []string{"node_modules", "__pycache__", ".pytest_cache", "vendor", "build", "dist"}
-				for _, ignored := range ignoredDirs {
-					if dirName == ignored {
-						return nil
-					}
-				}
+	sort.Strings(files)
+	return files, nil
+}
 
-				// Skip test files (contain false positives)
-				if filepath.Base(path) == "test_comments.go" || filepath.Base(path) == "comment_test.go" {
-					return nil
-				}
+// walkSourceFiles sends the files under rootDir in a supported language to
+// paths, skipping ignored directories
+func walkSourceFiles(ctx context.Context, rootDir string, ignore *gitignore.Matcher, paths chan<- string) error {
+	var fileCount int
+	err := filepath.WalkDir(rootDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			logger.Warn("Error accessing path", "path", path, "error", err)
+			return nil // Continue walking even if one path fails
+		}
 
-				wg.Add(1)
-				go func(filePath string) {
-					defer wg.Done()
-					if hasAIComments(filePath) {
-						mutex.Lock()
-						files = append(files, filePath)
-						mutex.Unlock()
-					}
-				}(path)
+		if d.IsDir() {
+			if path != rootDir && isIgnoredSearchDir(path, ignore) {
+				return filepath.SkipDir
 			}
+			return nil
 		}
 
-		return nil
-	})
-
-	wg.Wait()
+		// Check if file is in a supported language first
+		if lexer.ForFile(path) == nil {
+			return nil
+		}
+		// Check file count limit for supported files only
+		fileCount++
+		if fileCount > maxFilesToSearch {
+			logger.Warn("Stopping file search: reached limit", "limit", maxFilesToSearch)
+			return filepath.SkipAll
+		}
+		if ignore.Ignored(path, false) {
+			return nil
+		}
+		// Skip test files (contain false positives)
+		if filepath.Base(path) == "test_comments.go" || filepath.Base(path) == "comment_test.go" {
+			return nil
+		}
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to walk directory %s: %w", rootDir, err)
+		select {
+		case paths <- path:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	if err != nil && !errors.Is(err, ctx.Err()) {
+		return fmt.Errorf("failed to walk directory %s: %w", rootDir, err)
 	}
-
-	logger.Debug("Found files with AI comments", "count", len(files))
-	return files, nil
+	return nil
 }
 
-// hasAIComments quickly checks if a file contains AI-related comments
-func hasAIComments(filePath string) bool {
-	// Check file size before reading
-	fileInfo, err := os.Stat(filePath)
-	if err != nil {
-		logger.Warn("Failed to stat file", "file", filePath, "error", err)
-		return false
-	}
-	if fileInfo.Size() > maxFileSize {
-		logger.Debug("Skipping large file", "file", filePath, "size", fileInfo.Size(), "limit", maxFileSize)
-		return false
+// isIgnoredSearchDir reports whether a directory is left out of searches for
+// AI comments
+func isIgnoredSearchDir(path string, ignore *gitignore.Matcher) bool {
+	if ignore.Ignored(path, true) {
+		return true
 	}
 
-	// Read file contents
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		logger.Warn("Failed to read file", "file", filePath, "error", err)
-		return false
+	// Check standard ignore patterns
+	dirName := filepath.Base(path)
+	if dirName == ".git" || strings.HasPrefix(dirName, ".") && dirName != "." {
+		return true
 	}
 
-	// Simple case-insensitive search for AI markers
-	lowerContent := strings.ToLower(string(content))
-	return strings.Contains(lowerContent, "ai?") || strings.Contains(lowerContent, "ai!") || strings.Contains(lowerContent, "ai:")
+	// Check common ignored directories
+	ignoredDirs := []string{"node_modules", "__pycache__", ".pytest_cache", "vendor", "build", "dist"}
+	for _, ignored := range ignoredDirs {
+		if dirName == ignored {
+			return true
+		}
+	}
+	return false
 }
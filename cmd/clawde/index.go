@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/mattduck/clawde/internal/gitignore"
+)
+
+// commentIndex holds the AI comments of every file in the watched directory,
+// so that finding the AI: comments doesn't mean searching the whole tree.
+// While the file watcher runs the index is kept current from its events.
+// Otherwise it can't be trusted, and each lookup searches again.
+type commentIndex struct {
+	mutex   sync.Mutex
+	root    string
+	live    bool                   // Kept current by the file watcher
+	gen     int                    // Incremented by Watch, so searches from before it are discarded
+	ctx     context.Context        // For the searches started since Watch
+	cancel  context.CancelFunc     // Stops them
+	built   chan struct{}          // Closed when the first search after Watch finishes
+	files   map[string][]AIComment // By cleaned path. Only files with comments are included.
+	scans   int                    // Searches running
+	touched map[string]bool        // Paths changed while a search was running
+	context []AIComment            // The AI: comments, nil when they need collecting again
+}
+
+func newCommentIndex() *commentIndex {
+	return &commentIndex{
+		root:    ".",
+		files:   make(map[string][]AIComment),
+		touched: make(map[string]bool),
+	}
+}
+
+// Watch searches root for AI comments in the background. From then on the
+// index relies on Update, Remove and AddDir to keep it current.
+func (ix *commentIndex) Watch(root string) {
+	ix.mutex.Lock()
+	if ix.cancel != nil {
+		ix.cancel()
+	}
+	ix.ctx, ix.cancel = context.WithCancel(context.Background())
+	ix.root = root
+	ix.live = true
+	ix.gen++
+	ix.built = make(chan struct{})
+	ix.files = make(map[string][]AIComment)
+	ix.context = nil
+	ctx, gen, built := ix.ctx, ix.gen, ix.built
+	ix.scans++
+	ix.mutex.Unlock()
+
+	go func() {
+		found := searchComments(ctx, root)
+		ix.merge(gen, root, found)
+		close(built)
+		logger.Info("Built AI comment index", "root", root, "files", len(found))
+	}()
+}
+
+// Unwatch stops relying on the index, since changes are no longer seen, and
+// cancels any searches
+func (ix *commentIndex) Unwatch() {
+	ix.mutex.Lock()
+	defer ix.mutex.Unlock()
+	if ix.cancel != nil {
+		ix.cancel()
+		ix.cancel = nil
+	}
+	ix.live = false
+	ix.files = make(map[string][]AIComment)
+	ix.context = nil
+}
+
+// Update replaces the comments of a file that has changed
+func (ix *commentIndex) Update(path string, comments []AIComment) {
+	ix.mutex.Lock()
+	defer ix.mutex.Unlock()
+	path = filepath.Clean(path)
+	if len(comments) == 0 {
+		delete(ix.files, path)
+	} else {
+		ix.files[path] = comments
+	}
+	ix.changed(path)
+}
+
+// Remove drops a file, or everything in a directory, that was removed or
+// renamed
+func (ix *commentIndex) Remove(path string) {
+	ix.mutex.Lock()
+	defer ix.mutex.Unlock()
+	path = filepath.Clean(path)
+	for file := range ix.files {
+		if isWithin(path, file) {
+			delete(ix.files, file)
+		}
+	}
+	ix.changed(path)
+}
+
+// AddDir searches a directory that was created or moved in, in the
+// background. Its files don't get events of their own.
+func (ix *commentIndex) AddDir(dir string) {
+	ix.mutex.Lock()
+	if !ix.live {
+		ix.mutex.Unlock()
+		return
+	}
+	ctx, gen := ix.ctx, ix.gen
+	ix.scans++
+	ix.mutex.Unlock()
+
+	go ix.merge(gen, dir, searchComments(ctx, dir))
+}
+
+// changed records a change to path. Called with the lock held.
+func (ix *commentIndex) changed(path string) {
+	ix.context = nil
+	if ix.scans > 0 {
+		ix.touched[path] = true
+	}
+}
+
+// merge adds the results of a search of dir. Files that changed while it
+// ran already have newer comments, or none.
+func (ix *commentIndex) merge(gen int, dir string, found map[string][]AIComment) {
+	ix.mutex.Lock()
+	defer ix.mutex.Unlock()
+
+	ix.scans--
+	if gen == ix.gen && ix.live {
+		for file := range ix.files {
+			if isWithin(dir, file) && !ix.wasTouched(file) {
+				delete(ix.files, file)
+			}
+		}
+		for file, comments := range found {
+			if !ix.wasTouched(file) {
+				ix.files[file] = comments
+			}
+		}
+		ix.context = nil
+	}
+	if ix.scans == 0 {
+		ix.touched = make(map[string]bool)
+	}
+}
+
+// wasTouched reports whether a file, or a directory it's in, changed while a
+// search was running
+func (ix *commentIndex) wasTouched(file string) bool {
+	for path := file; ; path = filepath.Dir(path) {
+		if ix.touched[path] {
+			return true
+		}
+		if parent := filepath.Dir(path); parent == path {
+			return false
+		}
+	}
+}
+
+// ContextComments returns the AI: comments, ordered by file and line. The
+// slice is shared, so it must not be modified.
+func (ix *commentIndex) ContextComments() []AIComment {
+	ix.mutex.Lock()
+	live, root, built := ix.live, ix.root, ix.built
+	ix.mutex.Unlock()
+
+	if !live {
+		return collectAllContextComments(root)
+	}
+	<-built
+
+	ix.mutex.Lock()
+	defer ix.mutex.Unlock()
+	if ix.context == nil {
+		ix.context = contextCommentsOf(ix.files)
+		logger.Debug("Collected context comments from index", "count", len(ix.context))
+	}
+	return ix.context
+}
+
+// contextCommentsOf returns the AI: comments from files found by
+// searchComments, ordered by file and line
+func contextCommentsOf(files map[string][]AIComment) []AIComment {
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	context := []AIComment{}
+	for _, path := range paths {
+		for _, comment := range files[path] {
+			if comment.ActionType == ":" {
+				context = append(context, comment)
+			}
+		}
+	}
+	return context
+}
+
+// searchComments finds the files under root with AI comments and extracts
+// them, by cleaned path
+func searchComments(ctx context.Context, root string) map[string][]AIComment {
+	found := make(map[string][]AIComment)
+	files, err := FindFilesWithAIComments(ctx, root, gitignore.New(repoRoot(root)))
+	if ctx.Err() != nil {
+		logger.Debug("Search for AI comments cancelled", "root", root)
+		return found
+	} else if err != nil {
+		logger.Error("Failed to search for AI comments", "error", err)
+		return found
+	}
+	for _, file := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		comments, err := ExtractAIComments(file)
+		if err != nil {
+			logger.Error("Failed to extract AI comments", "file", file, "error", err)
+			continue
+		}
+		if len(comments) > 0 {
+			found[filepath.Clean(file)] = comments
+		}
+	}
+	return found
+}
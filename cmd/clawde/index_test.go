@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func contextContents(comments []AIComment) []string {
+	var contents []string
+	for _, c := range comments {
+		contents = append(contents, c.Content)
+	}
+	return contents
+}
+
+func TestCommentIndex(t *testing.T) {
+	initTestLogger()
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.go")
+	b := filepath.Join(dir, "b.go")
+	writeFile(t, a, "package main\n\n// AI: a is first\nfunc a() {}\n\n// fix AI!\n")
+
+	ix := newCommentIndex()
+	ix.Watch(dir)
+	if got := contextContents(ix.ContextComments()); !slices.Equal(got, []string{"AI: a is first"}) {
+		t.Fatalf("after Watch, context = %q", got)
+	}
+
+	// Changes come from the watcher rather than searching again
+	writeFile(t, b, "package main\n\n// AI: b is second\n")
+	comments, _ := ExtractAIComments(b)
+	ix.Update(b, comments)
+	if got := contextContents(ix.ContextComments()); !slices.Equal(got, []string{"AI: a is first", "AI: b is second"}) {
+		t.Errorf("after Update, context = %q", got)
+	}
+	ix.Remove(a)
+	if got := contextContents(ix.ContextComments()); !slices.Equal(got, []string{"AI: b is second"}) {
+		t.Errorf("after Remove, context = %q", got)
+	}
+	writeFile(t, a, "package main\n\n// AI: not seen until an event\n")
+	if got := contextContents(ix.ContextComments()); !slices.Equal(got, []string{"AI: b is second"}) {
+		t.Errorf("expected the index not to search again, context = %q", got)
+	}
+
+	// A new directory's files are found in the background
+	writeFile(t, filepath.Join(dir, "sub", "c.go"), "package sub\n\n// AI: c is in sub\n")
+	ix.AddDir(filepath.Join(dir, "sub"))
+	want := []string{"AI: b is second", "AI: c is in sub"}
+	deadline := time.Now().Add(2 * time.Second)
+	for got := contextContents(ix.ContextComments()); !slices.Equal(got, want); got = contextContents(ix.ContextComments()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("after AddDir, context = %q, want %q", got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Without the watcher, lookups search the tree
+	ix.Unwatch()
+	want = []string{"AI: not seen until an event", "AI: b is second", "AI: c is in sub"}
+	if got := contextContents(ix.ContextComments()); !slices.Equal(got, want) {
+		t.Errorf("after Unwatch, context = %q, want %q", got, want)
+	}
+}
+
+func TestCommentIndexKeepsChangesDuringSearch(t *testing.T) {
+	initTestLogger()
+	ix := newCommentIndex()
+	ix.live = true
+	ix.built = make(chan struct{})
+	close(ix.built)
+
+	// A search starts, then the watcher reports a newer version of a file and
+	// removes another before the search finishes
+	ix.scans++
+	newer := AIComment{FilePath: "x.go", Content: "AI: newer", ActionType: ":"}
+	ix.Update("x.go", []AIComment{newer})
+	ix.Remove("gone")
+	ix.merge(ix.gen, ".", map[string][]AIComment{
+		"x.go":      {{FilePath: "x.go", Content: "AI: older", ActionType: ":"}},
+		"gone/y.go": {{FilePath: "gone/y.go", Content: "AI: removed", ActionType: ":"}},
+		"z.go":      {{FilePath: "z.go", Content: "AI: unchanged", ActionType: ":"}},
+	})
+
+	if got := contextContents(ix.ContextComments()); !slices.Equal(got, []string{"AI: newer", "AI: unchanged"}) {
+		t.Errorf("context = %q", got)
+	}
+	if len(ix.touched) != 0 {
+		t.Errorf("expected touched paths to be cleared once no search is running, got %v", ix.touched)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/mattduck/clawde/internal/input"
+)
+
+// Keymap actions
+const (
+	actionPassthrough   = "passthrough"    // Send the key unchanged (use to unbind a default)
+	actionSendKeys      = "send-keys"      // Send other keys instead, e.g. arg = "esc" or "C-a C-k"
+	actionSendText      = "send-text"      // Send arg as literal text
+	actionSubmit        = "submit"         // Send a real Enter
+	actionNewline       = "newline"        // Insert a newline (backslash + Enter) without submitting
+	actionCommentSearch = "comment-search" // Search the repo for AI: comments
+	actionSuspend       = "suspend"        // Suspend clawde
+	actionToggle        = "toggle"         // Toggle the feature named by arg
+	actionStatus        = "status"         // Show a status overlay
+	actionOpenDiff      = "open-diff"      // Open claude's most recent diff
+	actionSendPrefix    = "send-prefix"    // Send the prefix key itself
+	actionIgnore        = "ignore"         // Discard the key
+)
+
+// Features that can be switched on and off at runtime with the toggle action
+const (
+	featureOutputThrottling   = "output-throttling"
+	featureHeldEnterDetection = "held-enter-detection"
+	featureFileWatching       = "file-watching"
+)
+
+// Binding maps a key to an action, optionally only in one vim mode
+type Binding struct {
+	Key    string  // Canonical key name, see the input package
+	Action string  // One of the action* constants
+	Arg    string  // Action argument (keys, text or feature name)
+	Mode   VimMode // Only apply in this mode; empty for any mode
+
+	sendBytes []byte // Parsed Arg for send-keys
+}
+
+// Keymap is an ordered list of bindings. The first binding that matches the
+// key and current mode wins. Keys pressed after the prefix key are looked up in
+// a separate list of prefix bindings.
+type Keymap struct {
+	bindings       []Binding
+	prefix         string // Canonical name of the prefix key, or "" if disabled
+	prefixBytes    []byte
+	prefixBindings []Binding
+}
+
+// defaultPrefix is the prefix key used when the keymap file doesn't set one
+const defaultPrefix = "C-]"
+
+// defaultBindings reproduces clawde's built-in key behaviour
+var defaultBindings = []Binding{
+	{Key: "C-/", Action: actionCommentSearch},
+	{Key: "C-z", Action: actionSuspend},
+	{Key: "C-n", Action: actionSendKeys, Arg: "down"},
+	{Key: "C-p", Action: actionSendKeys, Arg: "up"},
+	{Key: "C-g", Action: actionSendKeys, Arg: "esc"},
+	{Key: "C-j", Action: actionSubmit},
+	{Key: "enter", Action: actionNewline, Mode: VimModeInsert},
+}
+
+// defaultPrefixBindings are the clawde commands available after the prefix key
+var defaultPrefixBindings = []Binding{
+	{Key: "/", Action: actionCommentSearch},
+	{Key: "c", Action: actionCommentSearch},
+	{Key: "w", Action: actionToggle, Arg: featureFileWatching},
+	{Key: "t", Action: actionToggle, Arg: featureOutputThrottling},
+	{Key: "s", Action: actionStatus},
+	{Key: "d", Action: actionOpenDiff},
+	{Key: "z", Action: actionSuspend},
+	{Key: "esc", Action: actionIgnore},
+	{Key: "C-g", Action: actionIgnore},
+}
+
+// keymapFile is the on-disk format of the keymap
+type keymapFile struct {
+	ReplaceDefaults bool          `toml:"replace_defaults"`
+	Prefix          *string       `toml:"prefix"` // Empty string disables the prefix
+	Bind            []bindingFile `toml:"bind"`
+	PrefixBind      []bindingFile `toml:"prefix_bind"`
+}
+
+type bindingFile struct {
+	Key    string `toml:"key"`
+	Action string `toml:"action"`
+	Arg    string `toml:"arg"`
+	Mode   string `toml:"mode"`
+}
+
+// defaultKeymapPath returns the keymap location under the XDG config directory
+func defaultKeymapPath() string {
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		configDir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(configDir, "clawde", "keys.toml")
+}
+
+// NewKeymap validates the bindings and builds a keymap from them, without a
+// prefix key
+func NewKeymap(bindings []Binding) (*Keymap, error) {
+	km := &Keymap{}
+	for _, b := range bindings {
+		if err := b.prepare(); err != nil {
+			return nil, err
+		}
+		km.bindings = append(km.bindings, b)
+	}
+	return km, nil
+}
+
+// SetPrefix sets the prefix key and the bindings available after it. An empty
+// prefix disables prefix commands.
+func (k *Keymap) SetPrefix(prefix string, bindings []Binding) error {
+	k.prefix, k.prefixBytes, k.prefixBindings = "", nil, nil
+	if prefix == "" {
+		return nil
+	}
+
+	name, err := input.CanonicalName(prefix)
+	if err != nil {
+		return fmt.Errorf("invalid prefix key: %w", err)
+	}
+	prefixBytes, err := input.ParseKey(name)
+	if err != nil {
+		return fmt.Errorf("invalid prefix key: %w", err)
+	}
+
+	for _, b := range bindings {
+		if err := b.prepare(); err != nil {
+			return fmt.Errorf("prefix %w", err)
+		}
+		k.prefixBindings = append(k.prefixBindings, b)
+	}
+	k.prefix = name
+	k.prefixBytes = prefixBytes
+	return nil
+}
+
+// Prefix returns the canonical name of the prefix key, or "" if there isn't one
+func (k *Keymap) Prefix() string {
+	return k.prefix
+}
+
+// prepare canonicalises the key name and checks the action and its argument
+func (b *Binding) prepare() error {
+	key, err := input.CanonicalName(b.Key)
+	if err != nil {
+		return fmt.Errorf("invalid key binding: %w", err)
+	}
+	b.Key = key
+
+	switch b.Mode {
+	case VimModeUnknown, VimModeInsert, VimModeNormal:
+	default:
+		return fmt.Errorf("binding for %s: unknown mode %q (expected insert or normal)", b.Key, b.Mode)
+	}
+
+	switch b.Action {
+	case actionPassthrough, actionSubmit, actionNewline, actionCommentSearch, actionSuspend,
+		actionStatus, actionOpenDiff, actionSendPrefix, actionIgnore:
+	case actionSendText:
+		if b.Arg == "" {
+			return fmt.Errorf("binding for %s: send-text needs an arg", b.Key)
+		}
+	case actionSendKeys:
+		sendBytes, err := parseKeySequence(b.Arg)
+		if err != nil {
+			return fmt.Errorf("binding for %s: %w", b.Key, err)
+		}
+		b.sendBytes = sendBytes
+	case actionToggle:
+		switch b.Arg {
+		case featureOutputThrottling, featureHeldEnterDetection, featureFileWatching:
+		default:
+			return fmt.Errorf("binding for %s: unknown feature %q", b.Key, b.Arg)
+		}
+	default:
+		return fmt.Errorf("binding for %s: unknown action %q", b.Key, b.Action)
+	}
+	return nil
+}
+
+// parseKeySequence converts a space-separated list of key names to bytes
+func parseKeySequence(keys string) ([]byte, error) {
+	var out []byte
+	for _, name := range strings.Fields(keys) {
+		b, err := input.ParseKey(name)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b...)
+	}
+	if len(out) == 0 {
+		return nil, errors.New("send-keys needs at least one key")
+	}
+	return out, nil
+}
+
+// LoadKeymap builds the keymap from the defaults and the user's keymap file.
+// User bindings are checked before the defaults, so they override them. A
+// missing file is not an error.
+func LoadKeymap(path string) (*Keymap, error) {
+	var file keymapFile
+	if path != "" {
+		if _, err := toml.DecodeFile(path, &file); err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("failed to load keymap %s: %w", path, err)
+			}
+		} else {
+			logger.Info("Loaded keymap file", "path", path, "bindings", len(file.Bind))
+		}
+	}
+
+	bindings := fileBindings(file.Bind)
+	prefixBindings := fileBindings(file.PrefixBind)
+	if !file.ReplaceDefaults {
+		bindings = append(bindings, defaultBindings...)
+		prefixBindings = append(prefixBindings, defaultPrefixBindings...)
+	}
+
+	km, err := NewKeymap(bindings)
+	if err != nil {
+		return nil, err
+	}
+	prefix := defaultPrefix
+	if file.Prefix != nil {
+		prefix = *file.Prefix
+	}
+	if err := km.SetPrefix(prefix, prefixBindings); err != nil {
+		return nil, err
+	}
+	return km, nil
+}
+
+func fileBindings(entries []bindingFile) []Binding {
+	var bindings []Binding
+	for _, b := range entries {
+		bindings = append(bindings, Binding{
+			Key:    b.Key,
+			Action: b.Action,
+			Arg:    b.Arg,
+			Mode:   VimMode(b.Mode),
+		})
+	}
+	return bindings
+}
+
+// Lookup finds the binding for a key. currentMode is only called when a
+// candidate binding depends on the mode, since checking it reads the screen.
+func (k *Keymap) Lookup(key string, currentMode func() VimMode) (Binding, bool) {
+	return lookupBinding(k.bindings, key, currentMode)
+}
+
+// LookupPrefixed finds the binding for a key pressed after the prefix key
+func (k *Keymap) LookupPrefixed(key string, currentMode func() VimMode) (Binding, bool) {
+	return lookupBinding(k.prefixBindings, key, currentMode)
+}
+
+func lookupBinding(bindings []Binding, key string, currentMode func() VimMode) (Binding, bool) {
+	var mode VimMode
+	modeChecked := false
+	for _, b := range bindings {
+		if b.Key != key {
+			continue
+		}
+		if b.Mode != VimModeUnknown {
+			if !modeChecked {
+				mode = currentMode()
+				modeChecked = true
+			}
+			if b.Mode != mode {
+				continue
+			}
+		}
+		return b, true
+	}
+	return Binding{}, false
+}
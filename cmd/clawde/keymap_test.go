@@ -0,0 +1,170 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func modeIs(mode VimMode) func() VimMode {
+	return func() VimMode { return mode }
+}
+
+func TestDefaultKeymap(t *testing.T) {
+	km, err := NewKeymap(defaultBindings)
+	if err != nil {
+		t.Fatalf("NewKeymap() error = %v", err)
+	}
+
+	tests := []struct {
+		key        string
+		mode       VimMode
+		wantAction string
+		wantBytes  string
+	}{
+		{"C-/", VimModeNormal, actionCommentSearch, ""},
+		{"C-n", VimModeInsert, actionSendKeys, "\x1b[B"},
+		{"C-p", VimModeInsert, actionSendKeys, "\x1b[A"},
+		{"C-g", VimModeNormal, actionSendKeys, "\x1b"},
+		{"C-j", VimModeInsert, actionSubmit, ""},
+		{"enter", VimModeInsert, actionNewline, ""},
+	}
+	for _, tt := range tests {
+		b, ok := km.Lookup(tt.key, modeIs(tt.mode))
+		if !ok {
+			t.Errorf("Lookup(%q) found no binding", tt.key)
+			continue
+		}
+		if b.Action != tt.wantAction {
+			t.Errorf("Lookup(%q) action = %q, want %q", tt.key, b.Action, tt.wantAction)
+		}
+		if string(b.sendBytes) != tt.wantBytes {
+			t.Errorf("Lookup(%q) bytes = %q, want %q", tt.key, b.sendBytes, tt.wantBytes)
+		}
+	}
+
+	// Enter is only remapped in insert mode
+	if b, ok := km.Lookup("enter", modeIs(VimModeNormal)); ok {
+		t.Errorf("expected enter to be unbound in normal mode, got %+v", b)
+	}
+}
+
+func TestKeymapModeCheckedLazily(t *testing.T) {
+	km, err := NewKeymap(defaultBindings)
+	if err != nil {
+		t.Fatalf("NewKeymap() error = %v", err)
+	}
+
+	called := false
+	km.Lookup("C-n", func() VimMode {
+		called = true
+		return VimModeInsert
+	})
+	if called {
+		t.Error("expected mode not to be checked for a mode-independent binding")
+	}
+}
+
+func TestLoadKeymapFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "keys.toml")
+	content := `
+[[bind]]
+key = "C-j"
+action = "passthrough"
+
+[[bind]]
+key = "M-s"
+action = "send-text"
+arg = "/status"
+
+[[bind]]
+key = "C-t"
+action = "toggle"
+arg = "output-throttling"
+
+[[bind]]
+key = "enter"
+action = "submit"
+mode = "normal"
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	km, err := LoadKeymap(path)
+	if err != nil {
+		t.Fatalf("LoadKeymap() error = %v", err)
+	}
+
+	// User bindings override the defaults
+	if b, _ := km.Lookup("C-j", modeIs(VimModeInsert)); b.Action != actionPassthrough {
+		t.Errorf("expected C-j to be overridden, got %q", b.Action)
+	}
+	if b, _ := km.Lookup("M-s", modeIs(VimModeInsert)); b.Action != actionSendText || b.Arg != "/status" {
+		t.Errorf("unexpected M-s binding: %+v", b)
+	}
+	if b, _ := km.Lookup("enter", modeIs(VimModeNormal)); b.Action != actionSubmit {
+		t.Errorf("expected enter to submit in normal mode, got %q", b.Action)
+	}
+
+	// Defaults that weren't overridden still apply
+	if b, _ := km.Lookup("enter", modeIs(VimModeInsert)); b.Action != actionNewline {
+		t.Errorf("expected default enter binding in insert mode, got %q", b.Action)
+	}
+	if b, _ := km.Lookup("C-/", modeIs(VimModeInsert)); b.Action != actionCommentSearch {
+		t.Errorf("expected default C-/ binding, got %q", b.Action)
+	}
+}
+
+func TestLoadKeymapReplaceDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.toml")
+	content := `
+replace_defaults = true
+
+[[bind]]
+key = "C-x"
+action = "comment-search"
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	km, err := LoadKeymap(path)
+	if err != nil {
+		t.Fatalf("LoadKeymap() error = %v", err)
+	}
+	if _, ok := km.Lookup("C-/", modeIs(VimModeInsert)); ok {
+		t.Error("expected default bindings to be replaced")
+	}
+	if b, _ := km.Lookup("C-x", modeIs(VimModeInsert)); b.Action != actionCommentSearch {
+		t.Errorf("expected C-x binding, got %q", b.Action)
+	}
+}
+
+func TestLoadKeymapMissingFile(t *testing.T) {
+	km, err := LoadKeymap(filepath.Join(t.TempDir(), "missing.toml"))
+	if err != nil {
+		t.Fatalf("expected missing file to be ignored, got %v", err)
+	}
+	if _, ok := km.Lookup("C-/", modeIs(VimModeInsert)); !ok {
+		t.Error("expected default bindings")
+	}
+}
+
+func TestInvalidBindings(t *testing.T) {
+	invalid := []Binding{
+		{Key: "C-é", Action: actionSubmit},
+		{Key: "C-a", Action: "explode"},
+		{Key: "C-a", Action: actionSendKeys, Arg: "nonsense"},
+		{Key: "C-a", Action: actionSendKeys},
+		{Key: "C-a", Action: actionSendText},
+		{Key: "C-a", Action: actionToggle, Arg: "everything"},
+		{Key: "C-a", Action: actionSubmit, Mode: "visual"},
+	}
+	for _, b := range invalid {
+		if _, err := NewKeymap([]Binding{b}); err == nil {
+			t.Errorf("expected error for binding %+v", b)
+		}
+	}
+}
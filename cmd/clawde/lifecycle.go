@@ -0,0 +1,368 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattduck/clawde/internal/control"
+)
+
+// lifecycleInterval is how often sent comments are checked for claude
+// starting work on them, or timing out
+const lifecycleInterval = time.Second
+
+// maxFinishedComments is how many resolved and abandoned comments are kept
+// for list_comments
+const maxFinishedComments = 100
+
+// trackedComment is a comment that has been sent to claude
+type trackedComment struct {
+	comment AIComment
+	state   string
+	before  string // The state before the comment was removed
+	queued  time.Time
+	sent    time.Time
+	updated time.Time
+}
+
+func (t *trackedComment) status() control.CommentStatus {
+	return control.CommentStatus{
+		File:       t.comment.FilePath,
+		Line:       t.comment.LineNumber,
+		EndLine:    t.comment.EndLine,
+		Content:    t.comment.Content,
+		ActionType: t.comment.ActionType,
+		State:      t.state,
+		Queued:     t.queued,
+		Updated:    t.updated,
+	}
+}
+
+// waiting reports whether the comment hasn't been sent yet
+func (t *trackedComment) waiting() bool {
+	return t.state == control.CommentQueued || t.state == control.CommentStuck
+}
+
+// finished reports whether the comment has reached a final state. Abandoned
+// and removed comments can still be resolved if the marker is replaced later.
+func (t *trackedComment) finished() bool {
+	return t.state == control.CommentResolved || t.state == control.CommentAbandoned ||
+		t.state == control.CommentRemoved
+}
+
+// commentTracker follows the comments clawde sends until claude replaces
+// their markers, so that the ones it didn't get to can be reported
+type commentTracker struct {
+	timeout      time.Duration // For sent comments to be resolved
+	queueTimeout time.Duration // For queued comments to be sent
+	now          func() time.Time
+	onChange     func(control.CommentStatus) // Called outside the lock
+
+	mutex    sync.Mutex
+	comments map[string]*trackedComment // By trackerKey
+}
+
+func newCommentTracker(timeout, queueTimeout time.Duration) *commentTracker {
+	return &commentTracker{
+		timeout:      timeout,
+		queueTimeout: queueTimeout,
+		now:          time.Now,
+		comments:     make(map[string]*trackedComment),
+	}
+}
+
+// Queue starts tracking comments that are waiting to be sent. A comment that
+// is already waiting just has its details updated.
+func (t *commentTracker) Queue(comments []AIComment) {
+	t.update(func(now time.Time) []*trackedComment {
+		var changed []*trackedComment
+		for _, c := range comments {
+			if tc, ok := t.comments[trackerKey(c)]; ok && tc.waiting() {
+				tc.comment = c
+				continue
+			}
+			tc := &trackedComment{comment: c, state: control.CommentQueued, queued: now, updated: now}
+			t.comments[trackerKey(c)] = tc
+			changed = append(changed, tc)
+		}
+		return changed
+	})
+}
+
+// Forget stops tracking queued comments that were removed before they were
+// sent
+func (t *commentTracker) Forget(comments []AIComment) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, c := range comments {
+		if tc, ok := t.comments[trackerKey(c)]; ok && tc.waiting() {
+			delete(t.comments, trackerKey(c))
+		}
+	}
+}
+
+// Sent records that the prompt for queued comments was submitted
+func (t *commentTracker) Sent(comments []AIComment) {
+	t.setState(comments, control.CommentSent)
+}
+
+// Failed records that the prompt for queued comments couldn't be sent
+func (t *commentTracker) Failed(comments []AIComment) {
+	t.setState(comments, control.CommentAbandoned)
+}
+
+func (t *commentTracker) setState(comments []AIComment, state string) {
+	t.update(func(now time.Time) []*trackedComment {
+		var changed []*trackedComment
+		for _, c := range comments {
+			if tc, ok := t.comments[trackerKey(c)]; ok && tc.state != state {
+				tc.comment = c
+				tc.state = state
+				tc.updated = now
+				if state == control.CommentSent {
+					tc.sent = now
+				}
+				changed = append(changed, tc)
+			}
+		}
+		return changed
+	})
+}
+
+// Busy records that claude is working, which moves sent comments to
+// in-progress
+func (t *commentTracker) Busy() {
+	t.update(func(now time.Time) []*trackedComment {
+		var changed []*trackedComment
+		for _, tc := range t.comments {
+			if tc.state == control.CommentSent {
+				tc.state = control.CommentInProgress
+				tc.updated = now
+				changed = append(changed, tc)
+			}
+		}
+		return changed
+	})
+}
+
+// FileChanged checks a changed file's AI comments against the comments sent
+// from it. A sent comment whose marker has been replaced with [ai] has been
+// resolved. One whose marker has gone some other way has been removed, as have
+// the comments in a removed file or directory, which has no lines. A removed
+// comment that is found again, e.g. because an editor replaced the file, goes
+// back to the state it was in.
+func (t *commentTracker) FileChanged(path string, found []AIComment, lines []string) {
+	remaining := make(map[string]bool)
+	for _, c := range found {
+		remaining[commentKey(c)] = true
+	}
+
+	path = filepath.Clean(path)
+	t.update(func(now time.Time) []*trackedComment {
+		var changed []*trackedComment
+		for _, tc := range t.comments {
+			if tc.state == control.CommentResolved || tc.waiting() || !isWithin(path, filepath.Clean(tc.comment.FilePath)) {
+				continue
+			}
+			var state string
+			switch {
+			case remaining[commentKey(tc.comment)]:
+				if tc.state != control.CommentRemoved {
+					continue
+				}
+				state = tc.before
+			case markerReplaced(tc.comment, lines):
+				state = control.CommentResolved
+			default:
+				state = control.CommentRemoved
+			}
+			if state == tc.state {
+				continue
+			}
+			if state == control.CommentRemoved {
+				tc.before = tc.state
+			}
+			tc.state = state
+			tc.updated = now
+			changed = append(changed, tc)
+		}
+		return changed
+	})
+}
+
+// resolveWindow is how many lines a comment can move, through edits above it,
+// and still be found
+const resolveWindow = 100
+
+// markerReplaced reports whether the line with a comment's marker is still
+// near where it was, with [ai] in place of the marker
+func markerReplaced(c AIComment, lines []string) bool {
+	want := ""
+	for _, line := range strings.Split(c.FullLine+"\n"+c.Content, "\n") {
+		lower := strings.ToLower(line)
+		if i := strings.Index(lower, strings.ToLower(c.Marker)); c.Marker != "" && i >= 0 {
+			want = normalizeSpace(lower[:i] + "[ai]" + lower[i+len(c.Marker):])
+			break
+		}
+	}
+	if want == "" {
+		return false
+	}
+
+	end := max(c.LineNumber, c.EndLine)
+	for i := max(0, c.LineNumber-1-resolveWindow); i < min(len(lines), end+resolveWindow); i++ {
+		if strings.Contains(normalizeSpace(strings.ToLower(lines[i])), want) {
+			return true
+		}
+	}
+	return false
+}
+
+// commentKey identifies a comment by its text. Unlike the hash it doesn't
+// change when claude edits the code around the comment.
+func commentKey(c AIComment) string {
+	return c.ActionType + " " + strings.ToLower(normalizeSpace(c.Content))
+}
+
+// trackerKey identifies a comment by its file and text, so that it keeps its
+// place while lines around it change
+func trackerKey(c AIComment) string {
+	return filepath.Clean(c.FilePath) + "\x00" + commentKey(c)
+}
+
+// Expire abandons comments that have been sent for longer than the timeout
+// without being resolved, and marks comments that have been queued for longer
+// than the queue timeout as stuck
+func (t *commentTracker) Expire() {
+	t.update(func(now time.Time) []*trackedComment {
+		var changed []*trackedComment
+		for _, tc := range t.comments {
+			switch {
+			case t.timeout > 0 && (tc.state == control.CommentSent || tc.state == control.CommentInProgress) && now.Sub(tc.sent) >= t.timeout:
+				tc.state = control.CommentAbandoned
+			case t.queueTimeout > 0 && tc.state == control.CommentQueued && now.Sub(tc.queued) >= t.queueTimeout:
+				tc.state = control.CommentStuck
+			default:
+				continue
+			}
+			tc.updated = now
+			changed = append(changed, tc)
+		}
+		return changed
+	})
+}
+
+// update runs fn under the lock, then reports the comments it changed
+func (t *commentTracker) update(fn func(now time.Time) []*trackedComment) {
+	t.mutex.Lock()
+	changed := fn(t.now())
+	var statuses []control.CommentStatus
+	for _, tc := range changed {
+		statuses = append(statuses, tc.status())
+	}
+	t.prune()
+	t.mutex.Unlock()
+
+	if t.onChange != nil {
+		for _, s := range statuses {
+			t.onChange(s)
+		}
+	}
+}
+
+// prune drops the oldest finished comments beyond maxFinishedComments
+func (t *commentTracker) prune() {
+	var finished []string
+	for key, tc := range t.comments {
+		if tc.finished() {
+			finished = append(finished, key)
+		}
+	}
+	if len(finished) <= maxFinishedComments {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return t.comments[finished[i]].updated.Before(t.comments[finished[j]].updated)
+	})
+	for _, key := range finished[:len(finished)-maxFinishedComments] {
+		delete(t.comments, key)
+	}
+}
+
+// List returns the tracked comments in the order they were queued. Resolved
+// and abandoned comments are only included if all is set.
+func (t *commentTracker) List(all bool) []control.CommentStatus {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	statuses := []control.CommentStatus{}
+	for _, tc := range t.comments {
+		if all || !tc.finished() {
+			statuses = append(statuses, tc.status())
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		a, b := statuses[i], statuses[j]
+		if !a.Queued.Equal(b.Queued) {
+			return a.Queued.Before(b.Queued)
+		}
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	return statuses
+}
+
+// Counts returns how many comments are waiting on claude, how many of those
+// are stuck in the queue, and how many were abandoned
+func (t *commentTracker) Counts() (pending, stuck, abandoned int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, tc := range t.comments {
+		switch tc.state {
+		case control.CommentQueued, control.CommentSent, control.CommentInProgress:
+			pending++
+		case control.CommentStuck:
+			pending++
+			stuck++
+		case control.CommentAbandoned:
+			abandoned++
+		}
+	}
+	return pending, stuck, abandoned
+}
+
+// commentStateChanged logs a comment's new state and tells control socket
+// subscribers. Abandoned and stuck comments are also shown on screen.
+func (w *CLIWrapper) commentStateChanged(status control.CommentStatus) {
+	location := fmt.Sprintf("%s:%d", status.File, status.Line)
+	switch status.State {
+	case control.CommentAbandoned:
+		logger.Warn("AI comment was not resolved", "location", location, "content", status.Content)
+		go w.showOverlay("clawde: unresolved AI comment at " + location)
+	case control.CommentStuck:
+		logger.Warn("AI comment is still waiting for claude to be idle at an empty prompt", "location", location, "content", status.Content)
+		go w.showOverlay("clawde: AI comment at " + location + " is waiting for an empty prompt")
+	default:
+		logger.Info("AI comment state changed", "location", location, "state", status.State)
+	}
+	w.publish(control.EventComment, status)
+}
+
+// watchCommentLifecycle moves sent comments along as claude works on them
+func (w *CLIWrapper) watchCommentLifecycle() {
+	ticker := time.NewTicker(lifecycleInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if w.screenState().Busy {
+			w.comments.Busy()
+		}
+		w.comments.Expire()
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mattduck/clawde/internal/control"
+)
+
+func TestCommentLifecycle(t *testing.T) {
+	initTestLogger()
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tracker := newCommentTracker(10*time.Minute, time.Hour)
+	tracker.now = func() time.Time { return now }
+	var events []string
+	tracker.onChange = func(s control.CommentStatus) { events = append(events, s.Content+" "+s.State) }
+
+	fix := AIComment{FilePath: "main.go", LineNumber: 3, Content: "Fix this AI!", ActionType: "!", Marker: "AI!", Hash: "a"}
+	ask := AIComment{FilePath: "main.go", LineNumber: 9, Content: "Why? AI?", ActionType: "?", Marker: "AI?", Hash: "b"}
+	other := AIComment{FilePath: "other.go", LineNumber: 1, Content: "Tidy AI!", ActionType: "!", Marker: "AI!", Hash: "c"}
+
+	tracker.Queue([]AIComment{fix, ask})
+	tracker.Queue([]AIComment{other})
+	tracker.Failed([]AIComment{other})
+	tracker.Sent([]AIComment{fix, ask})
+	if pending, _, abandoned := tracker.Counts(); pending != 2 || abandoned != 1 {
+		t.Errorf("Counts() = %d, %d, want 2, 1", pending, abandoned)
+	}
+
+	tracker.Busy()
+	// Claude edited the code around the question and replaced the command's
+	// marker. The question is still there, on a different line.
+	moved := ask
+	moved.LineNumber, moved.Hash = 12, "moved"
+	lines := make([]string, 12)
+	lines[2], lines[11] = "// Fix this [ai]", "// Why? AI?"
+	tracker.FileChanged("./main.go", []AIComment{moved}, lines)
+
+	now = now.Add(10 * time.Minute)
+	tracker.Expire()
+
+	want := map[string]string{"main.go:3": control.CommentResolved, "main.go:9": control.CommentAbandoned, "other.go:1": control.CommentAbandoned}
+	statuses := tracker.List(true)
+	if len(statuses) != len(want) {
+		t.Fatalf("expected %d comments, got %+v", len(want), statuses)
+	}
+	for _, s := range statuses {
+		key := fmt.Sprintf("%s:%d", s.File, s.Line)
+		if s.State != want[key] {
+			t.Errorf("%s: state = %s, want %s", key, s.State, want[key])
+		}
+	}
+	if unresolved := tracker.List(false); len(unresolved) != 0 {
+		t.Errorf("expected no unfinished comments, got %+v", unresolved)
+	}
+
+	wantEvents := []string{
+		"Fix this AI! queued", "Why? AI? queued", "Tidy AI! queued", "Tidy AI! abandoned",
+		"Fix this AI! sent", "Why? AI? sent", "Fix this AI! in-progress", "Why? AI? in-progress",
+		"Fix this AI! resolved", "Why? AI? abandoned",
+	}
+	if len(events) != len(wantEvents) {
+		t.Fatalf("events = %q, want %q", events, wantEvents)
+	}
+	seen := make(map[string]bool)
+	for _, e := range events {
+		seen[e] = true
+	}
+	for _, e := range wantEvents {
+		if !seen[e] {
+			t.Errorf("missing event %q in %q", e, events)
+		}
+	}
+
+	// An abandoned comment is still resolved if claude gets to it later
+	lines[11] = "//   why? [ai]"
+	tracker.FileChanged("main.go", nil, lines)
+	if statuses := tracker.List(true); statuses[1].State != control.CommentResolved {
+		t.Errorf("expected late resolution, got %+v", statuses[1])
+	}
+}
+
+func TestRemovedComments(t *testing.T) {
+	tracker := newCommentTracker(10*time.Minute, time.Hour)
+	fix := AIComment{FilePath: "main.go", LineNumber: 2, FullLine: "\t// Fix this AI!", Content: "Fix this AI!", ActionType: "!", Marker: "AI!", Hash: "a"}
+	tracker.Queue([]AIComment{fix})
+	tracker.Sent([]AIComment{fix})
+
+	// The comment was reworded without the marker, so it wasn't answered
+	tracker.FileChanged("main.go", nil, []string{"package main", "\t// Fix this later"})
+	if statuses := tracker.List(true); statuses[0].State != control.CommentRemoved {
+		t.Errorf("expected the comment to be removed, got %+v", statuses[0])
+	}
+	if unresolved := tracker.List(false); len(unresolved) != 0 {
+		t.Errorf("expected removed comments to be finished, got %+v", unresolved)
+	}
+
+	// [ai] somewhere far from the comment doesn't resolve it
+	lines := make([]string, 500)
+	lines[400] = "\t// Fix this [ai]"
+	tracker.FileChanged("main.go", nil, lines)
+	if statuses := tracker.List(true); statuses[0].State != control.CommentRemoved {
+		t.Errorf("expected a distant [ai] to be ignored, got %+v", statuses[0])
+	}
+
+	lines[3] = "\t// Fix this [ai]"
+	tracker.FileChanged("main.go", nil, lines)
+	if statuses := tracker.List(true); statuses[0].State != control.CommentResolved {
+		t.Errorf("expected the replaced marker to resolve the comment, got %+v", statuses[0])
+	}
+}
+
+func TestCommentsInRemovedFiles(t *testing.T) {
+	tracker := newCommentTracker(10*time.Minute, time.Hour)
+	fix := AIComment{FilePath: "src/main.go", LineNumber: 2, Content: "Fix this AI!", ActionType: "!", Marker: "AI!", Hash: "a"}
+	tracker.Queue([]AIComment{fix})
+	tracker.Sent([]AIComment{fix})
+	tracker.Busy()
+
+	tracker.FileChanged("src", nil, nil)
+	if statuses := tracker.List(true); statuses[0].State != control.CommentRemoved {
+		t.Fatalf("expected a comment in a removed directory to be removed, got %+v", statuses[0])
+	}
+
+	// An editor that saves by replacing the file removes it first
+	tracker.FileChanged("src/main.go", []AIComment{fix}, []string{"", "// Fix this AI!"})
+	if statuses := tracker.List(true); statuses[0].State != control.CommentInProgress {
+		t.Errorf("expected the comment to be back in progress once the file returned, got %+v", statuses[0])
+	}
+}
+
+func TestStuckQueuedComments(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tracker := newCommentTracker(10*time.Minute, 5*time.Minute)
+	tracker.now = func() time.Time { return now }
+	fix := AIComment{FilePath: "main.go", LineNumber: 3, Content: "Fix this AI!", ActionType: "!", Hash: "a"}
+
+	tracker.Queue([]AIComment{fix})
+	now = now.Add(4 * time.Minute)
+	tracker.Expire()
+	if pending, stuck, _ := tracker.Counts(); pending != 1 || stuck != 0 {
+		t.Fatalf("Counts() = %d pending, %d stuck before the queue timeout", pending, stuck)
+	}
+
+	// Claude never looked ready, so the comment is reported but kept queued
+	now = now.Add(time.Minute)
+	tracker.Expire()
+	if pending, stuck, _ := tracker.Counts(); pending != 1 || stuck != 1 {
+		t.Errorf("Counts() = %d pending, %d stuck after the queue timeout", pending, stuck)
+	}
+	if statuses := tracker.List(false); len(statuses) != 1 || statuses[0].State != control.CommentStuck {
+		t.Errorf("expected list_comments to show the comment as stuck, got %+v", statuses)
+	}
+
+	tracker.Sent([]AIComment{fix})
+	if statuses := tracker.List(false); statuses[0].State != control.CommentSent {
+		t.Errorf("expected a stuck comment to be sent once claude is ready, got %+v", statuses[0])
+	}
+}
+
+func TestListCommentsHandler(t *testing.T) {
+	w := &CLIWrapper{comments: newCommentTracker(time.Minute, time.Hour)}
+	w.comments.Queue([]AIComment{{FilePath: "main.go", LineNumber: 1, Content: "Fix AI!", ActionType: "!", Hash: "a"}})
+
+	result, err := w.handleListComments(json.RawMessage(`{"all": true}`))
+	if err != nil {
+		t.Fatalf("list_comments error = %v", err)
+	}
+	statuses := result.([]control.CommentStatus)
+	if len(statuses) != 1 || statuses[0].State != control.CommentQueued || statuses[0].File != "main.go" {
+		t.Errorf("unexpected comments %+v", statuses)
+	}
+}
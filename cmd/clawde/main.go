@@ -1,19 +1,27 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/creack/pty"
+	"github.com/mattduck/clawde/internal/asciicast"
+	"github.com/mattduck/clawde/internal/control"
+	"github.com/mattduck/clawde/internal/input"
+	"github.com/mattduck/clawde/internal/transcript"
+	"github.com/mattduck/clawde/internal/vt"
 	"golang.org/x/term"
 )
 
@@ -67,13 +75,45 @@ func initLogging(config *Config) (*slog.Logger, *os.File, error) {
 }
 
 type CLIWrapper struct {
-	cmd          *exec.Cmd
-	ptmx         *os.File
-	stdin        io.Writer
-	stdout       io.Reader
-	outputBuffer *outputBuffer
-	config       *Config
-	tmuxDetector *TmuxInsertDetector
+	cmd           *exec.Cmd
+	ptmx          *os.File
+	stdin         io.Writer
+	stdout        io.Reader
+	outputBuffer  *outputBuffer
+	config        *Config
+	screen        *vt.Screen // In-process model of what the wrapped program has drawn
+	stateDetector ScreenStateDetector
+	keymap        *Keymap
+	prefix        prefixState // Only used from the input loop
+
+	watchDir     string
+	watcherMutex sync.Mutex
+	fileWatcher  *FileWatcher // nil when file watching is off
+
+	recorder   *asciicast.Recorder // nil unless the session is being recorded
+	recordFile *os.File
+
+	transcript     *transcript.Extractor // nil unless a transcript is being written
+	transcriptFile *os.File
+	transcriptOnce sync.Once
+
+	controlServer *control.Server // nil if the control socket is disabled
+	unregister    func()          // Removes the session from the registry
+	stopState     chan struct{}   // Closed to stop publishing state events
+
+	// Close can be called from main, the signal handler and the parent
+	// watcher, but only does anything the first time
+	closeOnce sync.Once
+	closeErr  error
+
+	comments     *commentTracker // Comments sent to claude and whether they were resolved
+	prompts      *promptQueue    // Comments waiting for claude to be ready
+	index        *commentIndex   // The AI comments in the codebase
+	lastKeypress atomic.Int64    // When the user last typed, in Unix nanoseconds
+
+	// Runtime copies of config options that can be toggled from the keymap
+	outputThrottling   atomic.Bool
+	heldEnterDetection atomic.Bool
 }
 
 type outputBuffer struct {
@@ -87,7 +127,15 @@ type outputBuffer struct {
 	inputTimeout time.Duration // How long to wait before switching to slow mode
 }
 
-func NewCLIWrapper(config *Config, command string, args ...string) (*CLIWrapper, error) {
+// flush writes the buffered output. The caller must hold the mutex.
+func (b *outputBuffer) flush() {
+	if len(b.data) > 0 {
+		os.Stdout.Write(b.data)
+		b.data = b.data[:0]
+	}
+}
+
+func NewCLIWrapper(config *Config, keymap *Keymap, command string, args ...string) (*CLIWrapper, error) {
 	cmd := exec.Command(command, args...)
 
 	// Set environment variables for the wrapped program
@@ -125,23 +173,40 @@ func NewCLIWrapper(config *Config, command string, args ...string) (*CLIWrapper,
 	}
 
 	wrapper := &CLIWrapper{
-		cmd:    cmd,
-		ptmx:   ptmx,
-		stdin:  ptmx,
-		stdout: ptmx,
-		config: config,
+		cmd:      cmd,
+		ptmx:     ptmx,
+		stdin:    ptmx,
+		stdout:   ptmx,
+		config:   config,
+		screen:   vt.New(80, 24),
+		keymap:   keymap,
+		comments: newCommentTracker(config.CommentTimeout, config.QueueTimeout),
+		prompts:  &promptQueue{},
+		index:    newCommentIndex(),
 		outputBuffer: &outputBuffer{
-			fastDelay:    16 * time.Millisecond,            // 60fps when typing
-			slowDelay:    33 * time.Millisecond,            // 30fps when idle
-			delay:        33 * time.Millisecond,            // Start in slow mode
-			inputTimeout: 2 * time.Second,                  // Switch to slow after 2s of no input
-			lastInput:    time.Now().Add(-3 * time.Second), // Start as "old" input
+			fastDelay:    config.ThrottleFastDelay,
+			slowDelay:    config.ThrottleSlowDelay,
+			delay:        config.ThrottleSlowDelay, // Start in slow mode
+			inputTimeout: config.ThrottleInputTimeout,
+			lastInput:    time.Now().Add(-config.ThrottleInputTimeout - time.Second), // Start as "old" input
 		},
 	}
 
+	wrapper.outputThrottling.Store(config.EnableOutputThrottling)
+	wrapper.heldEnterDetection.Store(config.EnableHeldEnterDetection)
+
 	// Set initial terminal size
 	if size, err := pty.GetsizeFull(os.Stdout); err == nil {
 		pty.Setsize(ptmx, size)
+		wrapper.resizeScreen(int(size.Cols), int(size.Rows))
+	}
+
+	// The recorder is set before anything that writes to it starts
+	if config.RecordFile != "" {
+		if err := wrapper.startRecording(config.RecordFile); err != nil {
+			wrapper.Close()
+			return nil, err
+		}
 	}
 
 	// Handle terminal resize events
@@ -150,12 +215,27 @@ func NewCLIWrapper(config *Config, command string, args ...string) (*CLIWrapper,
 	// this seemed to stop when we added the resize support.
 	wrapper.setupResizeHandler()
 
-	// Set up tmux-based INSERT mode detection if running in tmux
-	if IsRunningInTmux() {
-		wrapper.tmuxDetector = NewTmuxInsertDetector(150 * time.Millisecond)
-		wrapper.tmuxDetector.Start()
-		logger.Info("Tmux detected, using tmux-based INSERT mode detection")
+	// Screen state detection reads our own screen model by default. The older
+	// tmux capture-pane poller is still available as an opt-in.
+	matchers, err := buildStateMatchers(config.StatePatterns)
+	if err != nil {
+		wrapper.Close()
+		return nil, err
 	}
+	wrapper.stateDetector = newScreenStateDetector(wrapper.screen, matchers)
+	if strings.ToLower(config.StateDetection) == "tmux" {
+		if IsRunningInTmux() {
+			wrapper.stateDetector = newTmuxStateDetector(matchers, config.TmuxPollInterval)
+			logger.Info("Using tmux-based screen state detection")
+		} else {
+			logger.Warn("tmux state detection requested but not running in tmux, using screen model")
+		}
+	}
+	wrapper.stateDetector.Start()
+
+	wrapper.comments.onChange = wrapper.commentStateChanged
+	go wrapper.watchCommentLifecycle()
+	go wrapper.runPromptQueue()
 
 	return wrapper, nil
 }
@@ -170,162 +250,47 @@ func (w *CLIWrapper) SendCommand(command string) error {
 	// Add a pause before sending Enter key to submit.
 	// it seems that Claude requires both the pause and sending the byte like this (rather than \n),
 	// otherwise it just inserts the newline -- probably part of how it implements paste handling?
-	time.Sleep(100 * time.Millisecond)
+	time.Sleep(w.config.SubmitDelay)
 	_, err = w.stdin.Write([]byte{13}) // ASCII 13 = Enter key
+	if err == nil {
+		w.publish(control.EventPromptSent, command)
+	}
 	return err
 }
 
-// renderCommentPrompt creates a prompt for AI question comments
-func renderCommentPrompt(comment AIComment, contextComments []AIComment) string {
-	var locationStr string
-	if comment.EndLine == 0 || comment.EndLine == comment.LineNumber {
-		// Single-line comment
-		locationStr = fmt.Sprintf("at line %d", comment.LineNumber)
-	} else {
-		// Multiline comment
-		locationStr = fmt.Sprintf("at lines %d-%d", comment.LineNumber, comment.EndLine)
-	}
-
-	var prompt string
-	switch comment.ActionType {
-	case "!":
-		prompt = fmt.Sprintf("See %s %s and surrounding context. Make the appropriate changes. YOU MUST replace the AI! marker with [ai] when done.",
-			comment.FilePath, locationStr)
-	case "?":
-		prompt = fmt.Sprintf("See %s %s and surrounding context. Answer the question(s), but DO NOT MAKE CHANGES. Replace the AI? marker with [ai] when done.",
-			comment.FilePath, locationStr)
-	case ":":
-		prompt = fmt.Sprintf("Extra from code comment at %s %s",
-			comment.FilePath, locationStr)
-	default:
-		// TODO: should never happen, log?
-		prompt = fmt.Sprintf("See %s %s and surrounding context.",
-			comment.FilePath, locationStr)
-	}
-
-	// Add context comments if present
-	if len(contextComments) > 0 {
-		prompt += "\n\nRelated context:\n"
-		for _, ctx := range contextComments {
-			var ctxLocationStr string
-			if ctx.EndLine == 0 || ctx.EndLine == ctx.LineNumber {
-				ctxLocationStr = fmt.Sprintf("- line %d", ctx.LineNumber)
-			} else {
-				ctxLocationStr = fmt.Sprintf("- lines %d-%d", ctx.LineNumber, ctx.EndLine)
-			}
-			prompt += fmt.Sprintf("\n%s at %s:\n  %s\n", ctx.FilePath, ctxLocationStr, ctx.Content)
+func (w *CLIWrapper) Close() error {
+	w.closeOnce.Do(func() {
+		w.setFileWatching(false)
+		w.closeTranscript()
+		if w.unregister != nil {
+			w.unregister()
 		}
-	}
-
-	return prompt
-}
-
-// TODO: refactor this so that we use the logic from the single one, but it becomes one function
-//
-// renderMultipleCommentsPrompt creates a prompt for multiple AI comments (file watcher only)
-func renderMultipleCommentsPrompt(comments []AIComment, contextComments []AIComment) string {
-	// File watcher only processes ? and ! comments, so we don't need to separate by type
-	// Determine action type based on precedence (! takes precedence over ?)
-	hasAction := false
-	for _, comment := range comments {
-		if comment.ActionType == "!" {
-			hasAction = true
-			break
+		if w.stopState != nil {
+			close(w.stopState)
 		}
-	}
-
-	var prompt strings.Builder
-	if hasAction {
-		prompt.WriteString("Read the following locations and surrounding context, and make the appropriate changes mentioned in the comments. Replace the AI! markers with [ai] when done:\n\n")
-	} else {
-		prompt.WriteString("Read the following locations and surrounding context, and answer the question(s) in the comments. DO NOT MAKE CHANGES. Replace the AI? markers with [ai] when done:\n\n")
-	}
-
-	// Add bullet points for each comment
-	for _, comment := range comments {
-		var locationStr string
-		if comment.EndLine == 0 || comment.EndLine == comment.LineNumber {
-			locationStr = fmt.Sprintf("line %d", comment.LineNumber)
-		} else {
-			locationStr = fmt.Sprintf("lines %d-%d", comment.LineNumber, comment.EndLine)
+		if w.controlServer != nil {
+			w.controlServer.Close()
 		}
-
-		prompt.WriteString(fmt.Sprintf("• %s at %s\n", comment.FilePath, locationStr))
-	}
-
-	// Add context comments if present
-	if len(contextComments) > 0 {
-		prompt.WriteString("\nAdditional context:\n")
-		for _, ctx := range contextComments {
-			var ctxLocationStr string
-			if ctx.EndLine == 0 || ctx.EndLine == ctx.LineNumber {
-				ctxLocationStr = fmt.Sprintf("line %d", ctx.LineNumber)
-			} else {
-				ctxLocationStr = fmt.Sprintf("lines %d-%d", ctx.LineNumber, ctx.EndLine)
-			}
-			prompt.WriteString(fmt.Sprintf("\n%s at %s:\n%s", ctx.FilePath, ctxLocationStr, ctx.Content))
+		if w.recordFile != nil {
+			w.recordFile.Close()
 		}
-	}
-
-	return prompt.String()
-}
-
-// renderContextPrompt creates a prompt for single AI context comment
-func renderContextPrompt(comment AIComment) string {
-	var locationStr string
-	if comment.EndLine == 0 || comment.EndLine == comment.LineNumber {
-		locationStr = fmt.Sprintf("line %d", comment.LineNumber)
-	} else {
-		locationStr = fmt.Sprintf("lines %d-%d", comment.LineNumber, comment.EndLine)
-	}
-
-	return fmt.Sprintf("Context from %s at %s:\n%s", comment.FilePath, locationStr, comment.Content)
-}
-
-// renderMultipleContextPrompt creates a prompt for multiple AI context comments
-func renderMultipleContextPrompt(comments []AIComment) string {
-	var prompt strings.Builder
-
-	for i, comment := range comments {
-		var locationStr string
-		if comment.EndLine == 0 || comment.EndLine == comment.LineNumber {
-			locationStr = fmt.Sprintf("line %d", comment.LineNumber)
-		} else {
-			locationStr = fmt.Sprintf("lines %d-%d", comment.LineNumber, comment.EndLine)
+		if w.stateDetector != nil {
+			w.stateDetector.Stop()
 		}
-
-		if i > 0 {
-			prompt.WriteString("\n\n")
+		if w.ptmx != nil {
+			w.ptmx.Close()
 		}
-		prompt.WriteString(fmt.Sprintf("%s at %s:\n%s", comment.FilePath, locationStr, comment.Content))
-	}
-
-	return prompt.String()
-}
-
-func (w *CLIWrapper) Close() error {
-	if w.tmuxDetector != nil {
-		w.tmuxDetector.Stop()
-	}
-	if w.ptmx != nil {
-		w.ptmx.Close()
-	}
-	if w.cmd != nil && w.cmd.Process != nil {
-		return w.cmd.Process.Kill()
-	}
-	return nil
+		if w.cmd != nil && w.cmd.Process != nil {
+			w.closeErr = w.cmd.Process.Kill()
+		}
+	})
+	return w.closeErr
 }
 
 func (w *CLIWrapper) CopyOutput() {
-	if w.config.EnableOutputThrottling {
-		// Start throttled output copying
-		go w.startThrottledOutput()
-	} else {
-		// Simple direct copy
-		go func() {
-			io.Copy(os.Stdout, w.stdout)
-		}()
-	}
+	// Throttling can be toggled at runtime, so the same loop handles both
+	// throttled and direct output
+	go w.startThrottledOutput()
 }
 
 func (w *CLIWrapper) startThrottledOutput() {
@@ -337,15 +302,34 @@ func (w *CLIWrapper) startThrottledOutput() {
 		if err != nil {
 			// Handle any remaining data when reader finishes
 			buf.mutex.Lock()
-			if len(buf.data) > 0 {
-				os.Stdout.Write(buf.data)
-			}
+			buf.flush()
 			buf.mutex.Unlock()
 			return
 		}
 
 		if n > 0 {
+			// Keep the screen model current. This happens before throttling so
+			// that state checks don't lag behind what the program has sent.
+			// It's under the output lock so that once the buffer is flushed,
+			// the terminal matches the screen model.
 			buf.mutex.Lock()
+			w.screen.Write(buffer[:n])
+			if w.recorder != nil {
+				w.recorder.Output(buffer[:n])
+			}
+
+			if !w.outputThrottling.Load() {
+				// Throttling is off: flush anything still buffered from when it
+				// was on, then write straight through
+				if buf.timer != nil {
+					buf.timer.Stop()
+				}
+				buf.flush()
+				os.Stdout.Write(buffer[:n])
+				buf.mutex.Unlock()
+				continue
+			}
+
 			// Add the raw bytes to buffer (preserves \r, ANSI codes, etc.)
 			buf.data = append(buf.data, buffer[:n]...)
 
@@ -364,10 +348,7 @@ func (w *CLIWrapper) startThrottledOutput() {
 
 			buf.timer = time.AfterFunc(buf.delay, func() {
 				buf.mutex.Lock()
-				if len(buf.data) > 0 {
-					os.Stdout.Write(buf.data)
-					buf.data = buf.data[:0] // Reset buffer
-				}
+				buf.flush()
 				buf.mutex.Unlock()
 			})
 			buf.mutex.Unlock()
@@ -382,12 +363,17 @@ func (w *CLIWrapper) markUserInput() {
 	w.outputBuffer.mutex.Unlock()
 }
 
+// screenState returns the current state of the wrapped program's UI
+func (w *CLIWrapper) screenState() ScreenState {
+	if w.stateDetector == nil {
+		return ScreenState{}
+	}
+	return w.stateDetector.State()
+}
+
 // isInInsertMode safely checks if we're currently in INSERT mode
 func (w *CLIWrapper) isInInsertMode() bool {
-	if w.tmuxDetector != nil {
-		return w.tmuxDetector.IsInsertMode()
-	}
-	return false
+	return w.screenState().VimMode == VimModeInsert
 }
 
 // setupResizeHandler handles terminal window resize events
@@ -401,6 +387,7 @@ func (w *CLIWrapper) setupResizeHandler() {
 			if size, err := pty.GetsizeFull(os.Stdout); err == nil {
 				// Forward the new size to the wrapped program's PTY
 				pty.Setsize(w.ptmx, size)
+				w.resizeScreen(int(size.Cols), int(size.Rows))
 				logger.Info("Terminal resized", "cols", size.Cols, "rows", size.Rows)
 			} else {
 				logger.Warn("Failed to get terminal size on resize", "error", err)
@@ -409,6 +396,15 @@ func (w *CLIWrapper) setupResizeHandler() {
 	}()
 }
 
+// fileRemoved forgets the comments in a file or directory that was removed,
+// renamed or can't be read, so queued ones aren't sent and sent ones are
+// reported as removed
+func (w *CLIWrapper) fileRemoved(path string) {
+	w.index.Remove(path)
+	w.comments.FileChanged(path, nil, nil)
+	w.comments.Forget(w.prompts.Refresh(path, nil))
+}
+
 // handleFileChange processes file changes and extracts AI comments
 func handleFileChange(filePath string, wrapper *CLIWrapper) {
 	logger.Info("Processing file change", "file", filePath)
@@ -417,8 +413,19 @@ func handleFileChange(filePath string, wrapper *CLIWrapper) {
 	comments, err := ExtractAIComments(filePath)
 	if err != nil {
 		logger.Error("Failed to extract AI comments", "file", filePath, "error", err)
+		wrapper.fileRemoved(filePath)
 		return
 	}
+	wrapper.index.Update(filePath, comments)
+
+	// Comments sent earlier are resolved once their markers are replaced, and
+	// queued ones that have been deleted are no longer sent
+	var lines []string
+	if src, err := os.ReadFile(filePath); err == nil {
+		lines = strings.Split(string(src), "\n")
+	}
+	wrapper.comments.FileChanged(filePath, comments, lines)
+	wrapper.comments.Forget(wrapper.prompts.Refresh(filePath, comments))
 
 	if len(comments) == 0 {
 		logger.Info("No AI comments found", "file", filePath)
@@ -459,147 +466,49 @@ func handleFileChange(filePath string, wrapper *CLIWrapper) {
 		}
 	}
 
-	// Process all unprocessed comments together
+	// Claude might be busy, so the comments wait in the queue until it's
+	// ready. Comments from several changes are sent together.
 	if len(unprocessedComments) > 0 {
-		// Collect all context comments from the codebase
-		contextComments := collectAllContextComments(".")
-
-		var prompt string
-		if len(unprocessedComments) == 1 {
-			// Single comment - use existing template
-			prompt = renderCommentPrompt(unprocessedComments[0], contextComments)
-		} else {
-			// Multiple comments - use new template
-			prompt = renderMultipleCommentsPrompt(unprocessedComments, contextComments)
-		}
-
-		logger.Info("Sending prompt to underlying program", "prompt", prompt)
-
-		// Send the combined prompt to the wrapped program
-		if err := wrapper.SendCommand(prompt); err != nil {
-			logger.Error("Failed to send prompt to wrapped program", "error", err)
-		} else {
-			// Mark all processed comments as processed
-			for _, comment := range unprocessedComments {
-				markCommentProcessed(comment)
-			}
-			logger.Info("Successfully sent prompt and marked comments as processed", "comment_count", len(unprocessedComments))
-		}
+		wrapper.queuePrompt(unprocessedComments)
 	}
 
 	logger.Debug("=== END AI COMMENTS ===\n")
 }
 
-// collectAllContextComments finds all : (context) comments in the codebase
+// collectAllContextComments searches the codebase for all : (context)
+// comments
 func collectAllContextComments(rootDir string) []AIComment {
 	logger.Debug("Collecting all context comments", "root_dir", rootDir)
-
-	// Create git ignore cache for this search
-	gitIgnore := NewGitIgnoreCache(rootDir)
-
-	// Find all files with AI comments
-	files, err := FindFilesWithAIComments(rootDir, gitIgnore)
-	if err != nil {
-		logger.Error("Failed to search for AI comments", "error", err)
-		return nil
-	}
-
-	if len(files) == 0 {
-		logger.Debug("No files with AI comments found")
-		return nil
-	}
-
-	var contextComments []AIComment
-
-	for _, filePath := range files {
-		// Extract AI comments from the file
-		comments, err := ExtractAIComments(filePath)
-		if err != nil {
-			logger.Error("Failed to extract AI comments", "file", filePath, "error", err)
-			continue
-		}
-
-		for _, comment := range comments {
-			// Only collect : comments (context)
-			if comment.ActionType == ":" {
-				contextComments = append(contextComments, comment)
-			}
-		}
-	}
-
-	logger.Debug("Found context comments", "count", len(contextComments))
-	return contextComments
+	comments := contextCommentsOf(searchComments(context.Background(), rootDir))
+	logger.Debug("Found context comments", "count", len(comments))
+	return comments
 }
 
-// triggerAICommentSearch manually searches for files with AI comments and processes them
-func triggerAICommentSearch(rootDir string, wrapper *CLIWrapper) {
+// triggerAICommentSearch types the AI: comments in the codebase into claude,
+// without submitting them
+func triggerAICommentSearch(wrapper *CLIWrapper) {
 	logger.Info("=== MANUAL AI COMMENT SEARCH TRIGGERED ===")
 
-	// Create git ignore cache for this search
-	gitIgnore := NewGitIgnoreCache(rootDir)
-
-	// Find all files with AI comments
-	files, err := FindFilesWithAIComments(rootDir, gitIgnore)
-	if err != nil {
-		logger.Error("Failed to search for AI comments", "error", err)
-		return
-	}
-
-	if len(files) == 0 {
-		logger.Info("No files with AI comments found", "root_dir", rootDir)
-		return
-	}
-
-	logger.Info("Found AI comments in files", "file_count", len(files))
-
-	// Gather all unprocessed comments from all files
-	var allUnprocessedComments []AIComment
-
-	for _, filePath := range files {
-		logger.Debug("Processing file", "file_path", filePath)
-
-		// Extract AI comments from the file
-		comments, err := ExtractAIComments(filePath)
-		if err != nil {
-			logger.Error("Failed to extract AI comments", "file", filePath, "error", err)
-			continue
-		}
-
-		for i, comment := range comments {
-			logger.Debug("Processing comment",
-				"comment_number", i+1,
-				"file_path", comment.FilePath,
-				"line_number", comment.LineNumber,
-				"end_line", comment.EndLine,
-				"content", comment.Content,
-				"action_type", comment.ActionType,
-				"hash", comment.Hash)
-
-			// Manual invocation only processes : comments (context)
-			if comment.ActionType == ":" {
-				// AI comments are included for context in manual search
-				logger.Debug("Status: CONTEXT - will include")
-				allUnprocessedComments = append(allUnprocessedComments, comment)
-			} else if comment.ActionType == "?" || comment.ActionType == "!" {
-				// ? and ! comments are ignored by manual search (file watcher only)
-				logger.Debug("Status: QUICK ACTION - ignored by manual search")
-			} else {
-				logger.Debug("Status: UNSUPPORTED ACTION TYPE - skipping")
-			}
-			logger.Debug("---")
-		}
+	// Manual invocation only processes : comments (context). ? and ! comments
+	// are left to the file watcher.
+	allUnprocessedComments := wrapper.index.ContextComments()
+	for i, comment := range allUnprocessedComments {
+		logger.Debug("Processing comment",
+			"comment_number", i+1,
+			"file_path", comment.FilePath,
+			"line_number", comment.LineNumber,
+			"end_line", comment.EndLine,
+			"content", comment.Content,
+			"hash", comment.Hash)
 	}
 
 	// Process context comments (manual invocation only handles : comments)
 	if len(allUnprocessedComments) > 0 {
 		// All comments should be : (context) type for manual invocation
-		var prompt string
-		if len(allUnprocessedComments) == 1 {
-			// Single context comment
-			prompt = renderContextPrompt(allUnprocessedComments[0])
-		} else {
-			// Multiple context comments
-			prompt = renderMultipleContextPrompt(allUnprocessedComments)
+		prompt, err := renderPrompt(promptContext, allUnprocessedComments, nil)
+		if err != nil {
+			logger.Error("Failed to render context prompt", "error", err)
+			return
 		}
 
 		logger.Info("Sending context prompt to underlying program", "prompt", prompt)
@@ -610,6 +519,7 @@ func triggerAICommentSearch(rootDir string, wrapper *CLIWrapper) {
 		} else {
 			logger.Info("Successfully sent context (no auto-submit)", "comment_count", len(allUnprocessedComments))
 		}
+		wrapper.publish(control.EventCommentQuery, len(allUnprocessedComments))
 	} else {
 		logger.Info("No unprocessed AI comments found")
 	}
@@ -631,11 +541,16 @@ func setupFileWatcher(watchDir string, wrapper *CLIWrapper) (*FileWatcher, error
 		return nil, err
 	}
 
+	// Keep the comment index current while watching
+	fileWatcher.onRemove = wrapper.fileRemoved
+	fileWatcher.onDirAdded = wrapper.index.AddDir
+
 	err = fileWatcher.Start()
 	if err != nil {
 		fileWatcher.Close()
 		return nil, err
 	}
+	wrapper.index.Watch(watchDir)
 
 	return fileWatcher, nil
 }
@@ -745,99 +660,145 @@ var enterDetector = NewKeyRepeatDetector(3, 500*time.Millisecond)
 // Need a way to send deferred output to the wrapped program
 var deferredOutputChannel = make(chan []byte, 100)
 
-// processUserInput handles special key combinations and processes enter keys
-func processUserInput(input []byte, n int, wrapper *CLIWrapper) []byte {
-	processedInput := make([]byte, 0, n*2) // Allow space for potential expansion
+// escapeTimeout is how long to wait for the rest of an escape sequence before
+// treating a lone ESC as the escape key
+const escapeTimeout = 25 * time.Millisecond
 
-	for i := 0; i < n; i++ {
-		// Check for Ctrl+/ (ASCII 31) - trigger AI comment search
-		if input[i] == 31 {
-			logger.Info("Ctrl+/ detected - triggering AI comment search")
-			go func() {
-				triggerAICommentSearch(".", wrapper)
-			}()
-			// Don't add this to processedInput (consume the key)
+// processUserInput applies the keymap to each key and returns the bytes to
+// forward to the wrapped program
+func processUserInput(keys []input.Key, wrapper *CLIWrapper) []byte {
+	processedInput := make([]byte, 0, 64) // Allow space for potential expansion
+
+	for _, key := range keys {
+		if key.Paste {
+			// Pasted text goes through untouched, so newlines in it aren't
+			// turned into backslash+enter and control bytes don't run actions
+			processedInput = append(processedInput, key.Bytes...)
 			continue
 		}
+
+		binding, ok := wrapper.prefix.resolve(wrapper.keymap, key.Name(), func() VimMode {
+			return wrapper.screenState().VimMode
+		})
+		if !ok {
+			binding = Binding{Action: actionPassthrough}
+		}
+		processedInput = runKeyAction(binding, key, processedInput, wrapper)
+	}
+	return processedInput
+}
+
+// runKeyAction performs a key binding's action, appending any bytes that
+// should be sent to the wrapped program
+func runKeyAction(binding Binding, key input.Key, processedInput []byte, wrapper *CLIWrapper) []byte {
+	switch binding.Action {
+	case actionCommentSearch:
+		logger.Info("Comment search key detected - triggering AI comment search", "key", binding.Key)
+		go func() {
+			triggerAICommentSearch(wrapper)
+		}()
+		// Don't add this to processedInput (consume the key)
+
+	case actionSuspend:
 		// NOTE: suspend/restore doesn't work quite right
-		// Check for Ctrl+Z (ASCII 26) - suspend wrapper
-		if input[i] == 26 {
-			logger.Info("Ctrl+Z detected - suspending wrapper process")
+		logger.Info("Suspend key detected - suspending wrapper process", "key", binding.Key)
+		go func() {
+			syscall.Kill(syscall.Getpid(), syscall.SIGSTOP)
+		}()
+
+	case actionStatus:
+		go wrapper.showOverlay(wrapper.statusLine())
+
+	case actionOpenDiff:
+		go wrapper.openLastDiff()
+
+	case actionSendPrefix:
+		processedInput = append(processedInput, wrapper.keymap.prefixBytes...)
+
+	case actionIgnore:
+		// Consume the key
+
+	case actionSendKeys:
+		processedInput = append(processedInput, binding.sendBytes...)
+
+	case actionSendText:
+		processedInput = append(processedInput, binding.Arg...)
+
+	case actionSubmit:
+		// Reliable way to send actual Enter
+		processedInput = append(processedInput, 13)
+
+	case actionNewline:
+		processedInput = insertNewline(processedInput, wrapper)
+
+	case actionToggle:
+		switch binding.Arg {
+		case featureOutputThrottling:
+			enabled := !wrapper.outputThrottling.Load()
+			wrapper.outputThrottling.Store(enabled)
+			wrapper.toggled(binding.Arg, enabled)
+		case featureHeldEnterDetection:
+			enabled := !wrapper.heldEnterDetection.Load()
+			wrapper.heldEnterDetection.Store(enabled)
+			wrapper.toggled(binding.Arg, enabled)
+		case featureFileWatching:
+			// Starting the watcher walks the directory tree, which would
+			// hold up typing
 			go func() {
-				syscall.Kill(syscall.Getpid(), syscall.SIGSTOP)
+				enabled, err := wrapper.toggleFileWatching()
+				if err != nil {
+					logger.Error("Failed to toggle file watching", "error", err)
+				}
+				wrapper.toggled(featureFileWatching, enabled)
 			}()
-			// Don't add this to processedInput (consume the key)
-			continue
-		}
-		// Check for Ctrl+N (ASCII 14) - map to down arrow
-		if input[i] == 14 {
-			processedInput = append(processedInput, '\x1b', '[', 'B')
-			continue
 		}
-		// Check for Ctrl+P (ASCII 16) - map to up arrow
-		if input[i] == 16 {
-			processedInput = append(processedInput, '\x1b', '[', 'A')
-			continue
-		}
-		// Check for Ctrl+G (ASCII 7) - map to ESC
-		if input[i] == 7 {
-			processedInput = append(processedInput, '\x1b')
-			continue
+
+	default:
+		// Pass through unchanged
+		processedInput = append(processedInput, key.Bytes...)
+		// Reset enter detector on any non-enter input (this flushes pending)
+		if wrapper.heldEnterDetection.Load() {
+			enterDetector.Reset()
 		}
-		// Check for Ctrl+J (ASCII 10) - reliable way to send actual Enter
-		if input[i] == 10 {
-			// Ctrl+J: send actual enter
+	}
+	return processedInput
+}
+
+// insertNewline adds a newline to the prompt without submitting it, by sending
+// backslash followed by Enter
+func insertNewline(processedInput []byte, wrapper *CLIWrapper) []byte {
+	logger.Debug("Enter key pressed in INSERT mode")
+
+	if wrapper.heldEnterDetection.Load() {
+		// Check if this is a held Enter key
+		shouldSendRawEnter := enterDetector.CheckHeld()
+
+		if shouldSendRawEnter {
+			// Held Enter: cancel any pending and send actual enter
+			enterDetector.CancelPending()
 			processedInput = append(processedInput, 13)
-		} else if input[i] == 13 {
-			// Check INSERT mode status when Enter is pressed
-			insertMode := wrapper.isInInsertMode()
-			logger.Debug("Enter key pressed", "insert_mode", insertMode)
-
-			if insertMode {
-				// In INSERT mode: use backslash+enter behavior
-				if wrapper.config.EnableHeldEnterDetection {
-					// Check if this is a held Enter key
-					shouldSendRawEnter := enterDetector.CheckHeld()
-
-					if shouldSendRawEnter {
-						// Held Enter: cancel any pending and send actual enter
-						enterDetector.CancelPending()
-						processedInput = append(processedInput, 13)
-					} else if enterDetector.consecutiveCount == 1 {
-						// First Enter in potential sequence: defer sending backslash+enter
-						enterDetector.SetPendingAction(func() {
-							// Send backslash first, then enter after a short delay
-							// Two separate writes so Claude Code treats them as distinct keystrokes
-							deferredOutputChannel <- []byte{'\\'}
-							time.Sleep(5 * time.Millisecond)
-							deferredOutputChannel <- []byte{13}
-						})
-						// Don't add anything to processedInput yet
-					} else {
-						// Subsequent Enter in sequence but not yet held: send actual enter
-						processedInput = append(processedInput, 13)
-					}
-				} else {
-					// Simple mode in INSERT: send backslash, then enter after a short delay
-					// Sending as two separate writes so Claude Code treats them as distinct keystrokes
-					processedInput = append(processedInput, '\\')
-					go func() {
-						time.Sleep(5 * time.Millisecond)
-						deferredOutputChannel <- []byte{13}
-					}()
-				}
-			} else {
-				// Not in INSERT mode: send normal Enter
-				processedInput = append(processedInput, 13)
-			}
+		} else if enterDetector.consecutiveCount == 1 {
+			// First Enter in potential sequence: defer sending backslash+enter
+			enterDetector.SetPendingAction(func() {
+				// Send backslash first, then enter after a short delay
+				// Two separate writes so Claude Code treats them as distinct keystrokes
+				deferredOutputChannel <- []byte{'\\'}
+				time.Sleep(5 * time.Millisecond)
+				deferredOutputChannel <- []byte{13}
+			})
+			// Don't add anything to processedInput yet
 		} else {
-			// All other characters: pass through unchanged
-			processedInput = append(processedInput, input[i])
-			// Reset enter detector on any non-enter input (this flushes pending)
-			if wrapper.config.EnableHeldEnterDetection {
-				enterDetector.Reset()
-			}
+			// Subsequent Enter in sequence but not yet held: send actual enter
+			processedInput = append(processedInput, 13)
 		}
+	} else {
+		// Simple mode: send backslash, then enter after a short delay
+		// Sending as two separate writes so Claude Code treats them as distinct keystrokes
+		processedInput = append(processedInput, '\\')
+		go func() {
+			time.Sleep(5 * time.Millisecond)
+			deferredOutputChannel <- []byte{13}
+		}()
 	}
 	return processedInput
 }
@@ -850,50 +811,61 @@ func handleUserInput(wrapper *CLIWrapper) {
 		}
 	}()
 
-	if wrapper.config.EnableInputThrottling {
-		go func() {
-			// Create a buffer to intercept input and track typing activity
-			buffer := make([]byte, 1024)
-			for {
-				n, err := os.Stdin.Read(buffer)
-				if err != nil {
-					return
+	// Read stdin in its own goroutine so the processing loop can time out
+	// while waiting for the rest of an escape sequence
+	chunks := make(chan []byte)
+	go func() {
+		buffer := make([]byte, 1024)
+		for {
+			n, err := os.Stdin.Read(buffer)
+			if err != nil {
+				close(chunks)
+				return
+			}
+			if n > 0 {
+				if wrapper.recorder != nil && wrapper.config.RecordInput {
+					wrapper.recorder.Input(buffer[:n])
 				}
-				if n > 0 {
-					// Mark that user is typing
-					wrapper.markUserInput()
+				chunks <- append([]byte(nil), buffer[:n]...)
+			}
+		}
+	}()
 
-					// Process the input to handle special keys and replace enter with backslash+enter
-					processedInput := processUserInput(buffer, n, wrapper)
+	go func() {
+		var tokenizer input.Tokenizer
+		var flushTimer <-chan time.Time
 
-					// Forward the processed input to the wrapped program (if any)
-					if len(processedInput) > 0 {
-						wrapper.stdin.Write(processedInput)
-					}
-				}
-			}
-		}()
-	} else {
-		go func() {
-			// Simple input copy with enter key replacement
-			buffer := make([]byte, 1024)
-			for {
-				n, err := os.Stdin.Read(buffer)
-				if err != nil {
+		for {
+			var keys []input.Key
+			select {
+			case chunk, ok := <-chunks:
+				if !ok {
 					return
 				}
-				if n > 0 {
-					// Process the input to handle special keys and replace enter with backslash+enter
-					processedInput := processUserInput(buffer, n, wrapper)
-
-					// Forward the processed input to the wrapped program (if any)
-					if len(processedInput) > 0 {
-						wrapper.stdin.Write(processedInput)
-					}
+				wrapper.lastKeypress.Store(time.Now().UnixNano())
+				if wrapper.config.EnableInputThrottling {
+					// Mark that user is typing
+					wrapper.markUserInput()
 				}
+				keys = tokenizer.Feed(chunk)
+			case <-flushTimer:
+				keys = tokenizer.Flush()
 			}
-		}()
-	}
+
+			flushTimer = nil
+			if tokenizer.Pending() {
+				flushTimer = time.After(escapeTimeout)
+			}
+
+			// Process the keys to handle special keys and replace enter with backslash+enter
+			processedInput := processUserInput(keys, wrapper)
+
+			// Forward the processed input to the wrapped program (if any)
+			if len(processedInput) > 0 {
+				wrapper.stdin.Write(processedInput)
+			}
+		}
+	}()
 }
 
 // findClaudeBinary searches PATH for the claude binary, preferring native
@@ -927,12 +899,49 @@ func findClaudeBinary() (string, error) {
 }
 
 func main() {
-	// Load configuration from environment variables
-	config := LoadConfig()
+	// Subcommands that don't wrap claude
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(runReplay(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "processed" {
+		os.Exit(runProcessed(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
+	// Split clawde's own flags from the arguments for claude
+	opts, args, err := parseArgs(os.Args[1:], os.Stderr)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(2)
+	}
+	if opts.Version {
+		fmt.Printf("clawde %s\n", version)
+		return
+	}
+
+	// Load configuration from config files and environment variables, then
+	// apply command-line flags on top
+	config, err := LoadConfig(opts.ConfigFile)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	opts.apply(config)
+	maxFileSize = config.MaxFileSize
+	maxFilesToSearch = config.MaxFilesToSearch
+	if config.ScanWorkers > 0 {
+		scanWorkers = config.ScanWorkers
+	}
+	markerWords = config.MarkerWords
+	contextBudget = config.ContextBudget
+	if config.InlineSnippets {
+		snippetBudget = config.SnippetBudget
+	}
 
 	// Initialize logging based on configuration
 	var logFile *os.File
-	var err error
 	logger, logFile, err = initLogging(config)
 	if err != nil {
 		fmt.Printf("Failed to initialize logging: %v\n", err)
@@ -941,6 +950,15 @@ func main() {
 	if logFile != nil {
 		defer logFile.Close()
 	}
+	registerLanguages(config.Languages)
+
+	// Remember processed comments across restarts
+	if store, err := openProcessedStore(config.WatchDir, config.ProcessedExpiry); err != nil {
+		logger.Warn("Processed comments won't be remembered across restarts", "error", err)
+	} else {
+		processedComments = store
+		logger.Info("Loaded processed comments", "path", store.Path(), "count", len(store.Entries()))
+	}
 
 	// Find the claude binary, preferring the native binary over npm shims
 	command, err := findClaudeBinary()
@@ -949,17 +967,42 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Pass all arguments straight through to claude
-	args := os.Args[1:]
+	// Load key bindings (defaults plus the user's keymap file)
+	keymap, err := LoadKeymap(config.KeymapFile)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	promptTemplates, err = loadPromptTemplates(config.PromptsDir, projectPromptsDir)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
 
 	// Create the CLI wrapper first (program starts in canonical mode like normal shell)
-	wrapper, err := NewCLIWrapper(config, command, args...)
+	wrapper, err := NewCLIWrapper(config, keymap, command, args...)
 	if err != nil {
 		logger.Error("Failed to create CLI wrapper", "error", err)
 		os.Exit(1)
 	}
 	defer wrapper.Close()
 
+	if config.ControlSocket {
+		// Not fatal: clawde works without it
+		if err := wrapper.startControlServer(); err != nil {
+			logger.Error("Failed to start control socket", "error", err)
+		}
+	}
+
+	if config.TranscriptFile != "" {
+		if err := wrapper.startTranscript(config.TranscriptFile); err != nil {
+			logger.Error("Failed to start transcript", "error", err)
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	// Now set up raw mode for our input handling
 	var oldState *term.State
 	if term.IsTerminal(int(os.Stdin.Fd())) {
@@ -986,18 +1029,14 @@ func main() {
 	wrapper.CopyOutput()
 
 	// Set up file watching for current directory (if enabled)
+	// The watch directory is recorded even when watching is off, so it can
+	// be toggled on later
+	wrapper.watchDir = config.WatchDir
 	if config.EnableWatchFiles {
-		watchDir := "."
-		if len(os.Args) > 2 && strings.HasPrefix(os.Args[len(os.Args)-1], "--watch=") {
-			watchDir = strings.TrimPrefix(os.Args[len(os.Args)-1], "--watch=")
-		}
-
-		fileWatcher, err := setupFileWatcher(watchDir, wrapper)
-		if err != nil {
+		if err := wrapper.setFileWatching(true); err != nil {
 			logger.Error("Failed to setup file watcher", "error", err)
 			exitWithRestore(1)
 		}
-		defer fileWatcher.Close()
 	}
 
 	// Handle user input
@@ -1080,6 +1119,7 @@ func main() {
 			// Restore terminal size to wrapped program
 			if size, err := pty.GetsizeFull(os.Stdout); err == nil {
 				pty.Setsize(wrapper.ptmx, size)
+				wrapper.resizeScreen(int(size.Cols), int(size.Rows))
 				logger.Info("Restored terminal size after resume", "cols", size.Cols, "rows", size.Rows)
 			} else {
 				logger.Warn("Failed to restore terminal size after resume", "error", err)
@@ -1099,6 +1139,12 @@ func main() {
 		}
 	}
 
+	wrapper.publish(control.EventExit, exitCode)
+
+	// Deferred calls don't run on os.Exit, so close explicitly to finish the
+	// transcript and recording
+	wrapper.Close()
+
 	// Exit with the same code as the wrapped process
 	exitWithRestore(exitCode)
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"time"
+)
+
+// prefixTimeout is how long clawde waits for a command after the prefix key
+const prefixTimeout = 3 * time.Second
+
+// prefixState tracks tmux-style prefix commands: after the prefix key, the
+// next key is looked up in the keymap's prefix bindings instead of the normal
+// ones. It is only used from the input loop, so it isn't locked.
+type prefixState struct {
+	active  bool
+	started time.Time
+	now     func() time.Time // Overridable for tests
+}
+
+// resolve returns the binding for a key, taking the prefix into account. The
+// second result is false if the key has no binding and should be passed
+// through.
+func (p *prefixState) resolve(km *Keymap, key string, currentMode func() VimMode) (Binding, bool) {
+	now := time.Now
+	if p.now != nil {
+		now = p.now
+	}
+
+	if p.active {
+		p.active = false
+		if now().Sub(p.started) <= prefixTimeout {
+			if b, ok := km.LookupPrefixed(key, currentMode); ok {
+				return b, true
+			}
+			if key == km.Prefix() {
+				// Pressing the prefix twice sends it through, like tmux
+				return Binding{Key: key, Action: actionSendPrefix}, true
+			}
+			// Unknown command: swallow the key rather than sending it by surprise
+			logger.Info("No clawde command bound to key after prefix", "key", key)
+			return Binding{Key: key, Action: actionIgnore}, true
+		}
+		logger.Debug("Prefix timed out", "key", key)
+	}
+
+	if key != "" && key == km.Prefix() {
+		p.active = true
+		p.started = now()
+		logger.Debug("Prefix key pressed, waiting for command")
+		return Binding{Key: key, Action: actionIgnore}, true
+	}
+
+	return km.Lookup(key, currentMode)
+}
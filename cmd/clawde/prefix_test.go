@@ -0,0 +1,174 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattduck/clawde/internal/vt"
+)
+
+func testPrefixKeymap(t *testing.T) *Keymap {
+	t.Helper()
+	km, err := NewKeymap(defaultBindings)
+	if err != nil {
+		t.Fatalf("NewKeymap() error = %v", err)
+	}
+	if err := km.SetPrefix(defaultPrefix, defaultPrefixBindings); err != nil {
+		t.Fatalf("SetPrefix() error = %v", err)
+	}
+	return km
+}
+
+func TestPrefixCommands(t *testing.T) {
+	km := testPrefixKeymap(t)
+
+	tests := []struct {
+		name       string
+		keys       []string
+		wantAction string
+		wantArg    string
+	}{
+		{"comment search", []string{"C-]", "/"}, actionCommentSearch, ""},
+		{"toggle watching", []string{"C-]", "w"}, actionToggle, featureFileWatching},
+		{"toggle throttling", []string{"C-]", "t"}, actionToggle, featureOutputThrottling},
+		{"status", []string{"C-]", "s"}, actionStatus, ""},
+		{"open diff", []string{"C-]", "d"}, actionOpenDiff, ""},
+		{"prefix twice sends prefix", []string{"C-]", "C-]"}, actionSendPrefix, ""},
+		{"unbound key is swallowed", []string{"C-]", "q"}, actionIgnore, ""},
+		{"cancel", []string{"C-]", "esc"}, actionIgnore, ""},
+		{"normal binding without prefix", []string{"C-n"}, actionSendKeys, "down"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p prefixState
+			var b Binding
+			var ok bool
+			for _, key := range tt.keys {
+				b, ok = p.resolve(km, key, modeIs(VimModeInsert))
+			}
+			if !ok {
+				t.Fatalf("expected a binding for %q", tt.keys)
+			}
+			if b.Action != tt.wantAction || b.Arg != tt.wantArg {
+				t.Errorf("keys %q: got %s %q, want %s %q", tt.keys, b.Action, b.Arg, tt.wantAction, tt.wantArg)
+			}
+			if p.active {
+				t.Error("expected prefix to be inactive after a command")
+			}
+		})
+	}
+}
+
+func TestPrefixOnlyAppliesToNextKey(t *testing.T) {
+	km := testPrefixKeymap(t)
+	var p prefixState
+
+	if b, _ := p.resolve(km, "C-]", modeIs(VimModeInsert)); b.Action != actionIgnore {
+		t.Fatalf("expected prefix key to be consumed, got %q", b.Action)
+	}
+	if !p.active {
+		t.Fatal("expected prefix to be active")
+	}
+	p.resolve(km, "s", modeIs(VimModeInsert))
+
+	// Plain "s" after the command is just a character again
+	if b, ok := p.resolve(km, "s", modeIs(VimModeInsert)); ok {
+		t.Errorf("expected s to be unbound, got %+v", b)
+	}
+}
+
+func TestPrefixTimeout(t *testing.T) {
+	km := testPrefixKeymap(t)
+	now := time.Now()
+	p := prefixState{now: func() time.Time { return now }}
+
+	p.resolve(km, "C-]", modeIs(VimModeInsert))
+	now = now.Add(prefixTimeout + time.Second)
+
+	if b, ok := p.resolve(km, "s", modeIs(VimModeInsert)); ok {
+		t.Errorf("expected s to be passed through after timeout, got %+v", b)
+	}
+}
+
+func TestLoadKeymapPrefix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.toml")
+	content := `
+prefix = "C-a"
+
+[[prefix_bind]]
+key = "x"
+action = "send-text"
+arg = "/clear"
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	km, err := LoadKeymap(path)
+	if err != nil {
+		t.Fatalf("LoadKeymap() error = %v", err)
+	}
+	if km.Prefix() != "C-a" {
+		t.Fatalf("expected prefix C-a, got %q", km.Prefix())
+	}
+
+	var p prefixState
+	p.resolve(km, "C-a", modeIs(VimModeInsert))
+	if b, _ := p.resolve(km, "x", modeIs(VimModeInsert)); b.Action != actionSendText || b.Arg != "/clear" {
+		t.Errorf("unexpected prefix binding: %+v", b)
+	}
+	p.resolve(km, "C-a", modeIs(VimModeInsert))
+	if b, _ := p.resolve(km, "s", modeIs(VimModeInsert)); b.Action != actionStatus {
+		t.Errorf("expected default prefix bindings to apply, got %q", b.Action)
+	}
+
+	// The old prefix is no longer special
+	if _, ok := p.resolve(km, "C-]", modeIs(VimModeInsert)); ok {
+		t.Error("expected C-] to be unbound")
+	}
+}
+
+func TestLoadKeymapPrefixDisabled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.toml")
+	if err := os.WriteFile(path, []byte(`prefix = ""`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	km, err := LoadKeymap(path)
+	if err != nil {
+		t.Fatalf("LoadKeymap() error = %v", err)
+	}
+	var p prefixState
+	if _, ok := p.resolve(km, "C-]", modeIs(VimModeInsert)); ok {
+		t.Error("expected prefix to be disabled")
+	}
+}
+
+func TestLastDiffFromScreen(t *testing.T) {
+	w := &CLIWrapper{screen: vt.New(60, 6)}
+	w.screen.Write([]byte(strings.ReplaceAll(`⏺ Update(first.go)
+  ⎿  Added 1 line
+      1 +package first
+
+⏺ Update(second.go)
+  ⎿  Added 1 line, removed 1 line
+      3 -  old
+      3 +  new
+
+> `, "\n", "\r\n")))
+
+	diff, ok := w.lastDiff()
+	if !ok {
+		t.Fatal("expected a diff")
+	}
+	if diff.Path != "second.go" {
+		t.Errorf("expected last diff to be for second.go, got %q", diff.Path)
+	}
+	if unified := diff.ToUnified(); !strings.Contains(unified, "+  new") {
+		t.Errorf("unexpected diff:\n%s", unified)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mattduck/clawde/internal/processed"
+)
+
+// openProcessedStore loads the processed comment store for the repository
+// containing dir
+func openProcessedStore(dir string, expiry time.Duration) (*processed.Store, error) {
+	path := processed.DefaultPath(repoRoot(dir))
+	if path == "" {
+		return nil, fmt.Errorf("no state directory for the processed comment store")
+	}
+	store := processed.New(path, expiry)
+	if err := store.Load(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// runProcessed implements "clawde processed", which shows or clears the
+// comments that have already been sent to claude
+func runProcessed(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("clawde processed", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	dir := fs.String("dir", ".", "Directory in the repository")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: clawde processed [--dir DIR] [list|clear|path]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	command := "list"
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return 2
+	} else if fs.NArg() == 1 {
+		command = fs.Arg(0)
+	}
+
+	config, err := LoadConfig("")
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	store, err := openProcessedStore(*dir, config.ProcessedExpiry)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	switch command {
+	case "list":
+		entries := store.Entries()
+		if len(entries) == 0 {
+			fmt.Fprintln(stdout, "No processed comments")
+			return 0
+		}
+		tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "PROCESSED\tTYPE\tLOCATION\tCOMMENT")
+		for _, e := range entries {
+			fmt.Fprintf(tw, "%s\t%s\t%s:%d\t%s\n", e.Processed.Local().Format(time.DateTime), e.ActionType, e.File, e.Line, truncate(e.Content, 60))
+		}
+		tw.Flush()
+	case "clear":
+		n := len(store.Entries())
+		if err := store.Clear(); err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(stdout, "Cleared %d processed comments\n", n)
+	case "path":
+		fmt.Fprintln(stdout, store.Path())
+	default:
+		fs.Usage()
+		return 2
+	}
+	return 0
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
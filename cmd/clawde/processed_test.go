@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFingerprintIgnoresPosition(t *testing.T) {
+	initTestLogger()
+	original := "package main\n\nfunc a() {\n\t// Fix this AI!\n\treturn\n}\n"
+	shifted := "package main\n\nimport \"os\"\n\nfunc a() {\n\t// Fix this AI!\n\treturn\n}\n"
+	elsewhere := "package main\n\nfunc b() {\n\t// Fix this AI!\n\tpanic(1)\n}\n"
+
+	hash := func(content string) string {
+		comments, err := extractAICommentsFromString(content, "test.go")
+		if err != nil || len(comments) != 1 {
+			t.Fatalf("expected 1 comment, got %d (%v)", len(comments), err)
+		}
+		return comments[0].Hash
+	}
+
+	if hash(original) != hash(shifted) {
+		t.Error("expected the fingerprint to survive lines being added above")
+	}
+	if hash(original) == hash(elsewhere) {
+		t.Error("expected the same comment on different code to get a different fingerprint")
+	}
+}
+
+func TestRunProcessed(t *testing.T) {
+	initTestLogger()
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	repo := t.TempDir()
+	os.Mkdir(filepath.Join(repo, ".git"), 0755)
+	sub := filepath.Join(repo, "src")
+	os.Mkdir(sub, 0755)
+
+	store, err := openProcessedStore(sub, 0)
+	if err != nil {
+		t.Fatalf("openProcessedStore() error = %v", err)
+	}
+	previous := processedComments
+	processedComments = store
+	defer func() { processedComments = previous }()
+	markCommentsProcessed(AIComment{FilePath: "src/main.go", LineNumber: 4, Content: "Fix this AI!", ActionType: "!", Hash: "abc"})
+
+	var stdout, stderr bytes.Buffer
+	if code := runProcessed([]string{"--dir", repo, "list"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("list exited %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "src/main.go:4") || !strings.Contains(stdout.String(), "Fix this AI!") {
+		t.Errorf("expected the entry to be listed from the repository root, got:\n%s", stdout.String())
+	}
+
+	stdout.Reset()
+	if code := runProcessed([]string{"--dir", repo, "clear"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("clear exited %d: %s", code, stderr.String())
+	}
+	stdout.Reset()
+	runProcessed([]string{"--dir", repo}, &stdout, &stderr)
+	if !strings.Contains(stdout.String(), "No processed comments") {
+		t.Errorf("expected the store to be empty after clear, got:\n%s", stdout.String())
+	}
+}
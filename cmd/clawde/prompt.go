@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Prompt templates. Each can be overridden by a file with the same name in
+// the user or project prompts directory.
+const (
+	promptComments = "comments.tmpl" // AI! and AI? comments found by the file watcher
+	promptContext  = "context.tmpl"  // AI: comments from a manual search
+)
+
+// projectPromptsDir holds the project's prompt templates, relative to the
+// working directory
+const projectPromptsDir = ".clawde/prompts"
+
+//go:embed prompts/*.tmpl
+var defaultPromptFS embed.FS
+
+// promptTemplates are used to render every prompt clawde sends
+var promptTemplates = template.Must(template.ParseFS(defaultPromptFS, "prompts/*.tmpl"))
+
+// PromptData is what prompt templates are rendered with
+type PromptData struct {
+	Comments        []PromptComment // The comments the prompt is for
+	ContextComments []PromptComment // AI: comments from elsewhere in the codebase
+	Action          string          // "!" if any comment asks for changes, "?" if they are all questions, otherwise ":"
+	Markers         string          // The markers claude should replace, e.g. "AI! and @claude!"
+	Repo            RepoInfo
+	Branch          string // The current git branch, empty if it isn't known
+}
+
+// PromptComment is a comment as seen by prompt templates
+type PromptComment struct {
+	File         string
+	Line         int
+	EndLine      int    // 0 for single-line comments
+	Location     string // "line 5" or "lines 5-7"
+	Content      string
+	ActionType   string
+	Marker       string   // e.g. "AI!"
+	ContextLines []string // The code around the comment
+	Symbol       string   // Name of the function, method, type or class the comment is in, if known
+	SymbolKind   string   // e.g. "function"
+
+	// The enclosing function or the lines around the comment, if snippets are
+	// turned on and fit in the budget
+	Snippet         string
+	SnippetLocation string // e.g. "lines 10-42"
+	Language        string // Code fence language, e.g. "go"
+}
+
+// RepoInfo describes the repository clawde is running in
+type RepoInfo struct {
+	Root string // Absolute path
+	Name string // Base name of the root directory
+}
+
+// loadPromptTemplates reads overrides for the built-in prompt templates from
+// the user and project prompt directories. The project's templates take
+// precedence. Missing directories are skipped.
+func loadPromptTemplates(dirs ...string) (*template.Template, error) {
+	tmpl, err := template.ParseFS(defaultPromptFS, "prompts/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		for _, t := range tmpl.Templates() {
+			path := filepath.Join(dir, t.Name())
+			text, err := os.ReadFile(path)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			if _, err := tmpl.New(t.Name()).Parse(string(text)); err != nil {
+				return nil, fmt.Errorf("invalid prompt template %s: %w", path, err)
+			}
+			logger.Info("Loaded prompt template", "path", path)
+		}
+	}
+	return tmpl, nil
+}
+
+// renderPrompt renders one of the prompt templates for comments
+func renderPrompt(name string, comments, contextComments []AIComment) (string, error) {
+	var buf bytes.Buffer
+	if err := promptTemplates.ExecuteTemplate(&buf, name, newPromptData(comments, contextComments)); err != nil {
+		return "", fmt.Errorf("failed to render prompt %s: %w", name, err)
+	}
+	return buf.String(), nil
+}
+
+func newPromptData(comments, contextComments []AIComment) PromptData {
+	data := PromptData{Action: ":"}
+	for _, c := range comments {
+		data.Comments = append(data.Comments, newPromptComment(c))
+		if c.ActionType == "!" || (c.ActionType == "?" && data.Action == ":") {
+			data.Action = c.ActionType
+		}
+	}
+	for _, c := range contextComments {
+		data.ContextComments = append(data.ContextComments, newPromptComment(c))
+	}
+	data.Markers = commentMarkers(comments, data.Action)
+	if snippetBudget > 0 {
+		addSnippets(data.Comments, snippetBudget)
+	}
+
+	if wd, err := os.Getwd(); err == nil {
+		root := repoRoot(wd)
+		data.Repo = RepoInfo{Root: root, Name: filepath.Base(root)}
+		data.Branch = gitBranch(root)
+	}
+	return data
+}
+
+func newPromptComment(c AIComment) PromptComment {
+	location := fmt.Sprintf("line %d", c.LineNumber)
+	if c.EndLine != 0 && c.EndLine != c.LineNumber {
+		location = fmt.Sprintf("lines %d-%d", c.LineNumber, c.EndLine)
+	}
+	return PromptComment{
+		File:         c.FilePath,
+		Line:         c.LineNumber,
+		EndLine:      c.EndLine,
+		Location:     location,
+		Content:      c.Content,
+		ActionType:   c.ActionType,
+		Marker:       commentMarker(c),
+		ContextLines: c.ContextLines,
+		Symbol:       c.Symbol.Name,
+		SymbolKind:   c.Symbol.Kind,
+	}
+}
+
+// commentMarker returns the marker to mention in a comment's prompt
+func commentMarker(comment AIComment) string {
+	if comment.Marker != "" {
+		return comment.Marker
+	}
+	return primaryMarker(comment.ActionType)
+}
+
+// commentMarkers lists the distinct markers of the comments with an action
+// type, e.g. "AI! and @claude!"
+func commentMarkers(comments []AIComment, actionType string) string {
+	var markers []string
+	seen := make(map[string]bool)
+	for _, comment := range comments {
+		if comment.ActionType != actionType || seen[commentMarker(comment)] {
+			continue
+		}
+		seen[commentMarker(comment)] = true
+		markers = append(markers, commentMarker(comment))
+	}
+	if len(markers) == 0 {
+		return primaryMarker(actionType)
+	}
+	return strings.Join(markers, " and ")
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func mustRenderPrompt(t *testing.T, name string, comments ...AIComment) string {
+	t.Helper()
+	prompt, err := renderPrompt(name, comments, nil)
+	if err != nil {
+		t.Fatalf("renderPrompt() error = %v", err)
+	}
+	return prompt
+}
+
+func TestRenderMultipleComments(t *testing.T) {
+	comments := []AIComment{
+		{FilePath: "a.go", LineNumber: 3, ActionType: "?"},
+		{FilePath: "b.go", LineNumber: 5, EndLine: 8, ActionType: "!", Marker: "@claude!"},
+		{FilePath: "c.go", LineNumber: 1, ActionType: "!"},
+	}
+	context := []AIComment{{FilePath: "d.go", LineNumber: 2, Content: "Uses UTC AI:", ActionType: ":"}}
+
+	got, err := renderPrompt(promptComments, comments, context)
+	if err != nil {
+		t.Fatalf("renderPrompt() error = %v", err)
+	}
+	want := "Read the following locations and surrounding context, and make the appropriate changes mentioned in the comments. Replace the @claude! and AI! markers with [ai] when done:\n\n" +
+		"• a.go at line 3\n• b.go at lines 5-8\n• c.go at line 1\n" +
+		"\nAdditional context:\n\nd.go at line 2:\nUses UTC AI:"
+	if got != want {
+		t.Errorf("renderPrompt() = %q, want %q", got, want)
+	}
+}
+
+func TestRenderContextPrompt(t *testing.T) {
+	one := AIComment{FilePath: "a.go", LineNumber: 1, Content: "Uses UTC AI:", ActionType: ":"}
+	two := AIComment{FilePath: "b.go", LineNumber: 4, EndLine: 5, Content: "Retries\nare capped AI:", ActionType: ":"}
+
+	if got, want := mustRenderPrompt(t, promptContext, one), "Context from a.go at line 1:\nUses UTC AI:"; got != want {
+		t.Errorf("renderPrompt() = %q, want %q", got, want)
+	}
+	want := "a.go at line 1:\nUses UTC AI:\n\nb.go at lines 4-5:\nRetries\nare capped AI:"
+	if got := mustRenderPrompt(t, promptContext, one, two); got != want {
+		t.Errorf("renderPrompt() = %q, want %q", got, want)
+	}
+}
+
+func TestPromptTemplateOverrides(t *testing.T) {
+	initTestLogger()
+	userDir, projectDir := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(userDir, promptComments), []byte("user {{len .Comments}}"), 0644)
+	os.WriteFile(filepath.Join(userDir, promptContext), []byte("user context"), 0644)
+	os.WriteFile(filepath.Join(projectDir, promptComments),
+		[]byte(`{{range .Comments}}{{.Marker}} in {{$.Repo.Name}} on {{$.Branch}}: {{index .ContextLines 0}}{{end}}`), 0644)
+
+	tmpl, err := loadPromptTemplates(userDir, filepath.Join(projectDir, "missing"), projectDir)
+	if err != nil {
+		t.Fatalf("loadPromptTemplates() error = %v", err)
+	}
+	previous := promptTemplates
+	promptTemplates = tmpl
+	defer func() { promptTemplates = previous }()
+
+	comment := AIComment{FilePath: "a.go", LineNumber: 1, ActionType: "!", ContextLines: []string{"func a() {"}}
+	data := newPromptData([]AIComment{comment}, nil)
+	want := "AI! in " + data.Repo.Name + " on " + data.Branch + ": func a() {"
+	if got := mustRenderPrompt(t, promptComments, comment); got != want {
+		t.Errorf("expected the project template, got %q, want %q", got, want)
+	}
+	if got := mustRenderPrompt(t, promptContext, comment); got != "user context" {
+		t.Errorf("expected the user template, got %q", got)
+	}
+
+	os.WriteFile(filepath.Join(projectDir, promptContext), []byte("{{.Nope"), 0644)
+	if _, err := loadPromptTemplates(projectDir); err == nil || !strings.Contains(err.Error(), promptContext) {
+		t.Errorf("expected an error naming the broken template, got %v", err)
+	}
+}
+
+func TestGitBranch(t *testing.T) {
+	root := t.TempDir()
+	if got := gitBranch(root); got != "" {
+		t.Errorf("expected no branch outside a repository, got %q", got)
+	}
+	os.Mkdir(filepath.Join(root, ".git"), 0755)
+	os.WriteFile(filepath.Join(root, ".git", "HEAD"), []byte("ref: refs/heads/feature/x\n"), 0644)
+	if got := gitBranch(root); got != "feature/x" {
+		t.Errorf("gitBranch() = %q, want feature/x", got)
+	}
+	os.WriteFile(filepath.Join(root, ".git", "HEAD"), []byte("0123456789abcdef0123456789abcdef01234567\n"), 0644)
+	if got := gitBranch(root); got != "0123456789ab" {
+		t.Errorf("gitBranch() = %q for a detached HEAD", got)
+	}
+
+	// A worktree's HEAD is in its own git directory
+	linked := filepath.Join(root, ".git", "worktrees", "wt")
+	os.MkdirAll(linked, 0755)
+	os.WriteFile(filepath.Join(linked, "HEAD"), []byte("ref: refs/heads/wt-branch\n"), 0644)
+	worktree := filepath.Join(t.TempDir(), "wt")
+	os.Mkdir(worktree, 0755)
+	os.WriteFile(filepath.Join(worktree, ".git"), []byte("gitdir: "+linked+"\n"), 0644)
+	if got := gitBranch(repoRoot(worktree)); got != "wt-branch" {
+		t.Errorf("gitBranch() = %q for a worktree, want wt-branch", got)
+	}
+}
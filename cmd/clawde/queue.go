@@ -0,0 +1,150 @@
+package main
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// promptQueueInterval is how often queued prompts are checked against the
+// screen state
+const promptQueueInterval = 250 * time.Millisecond
+
+// promptQueue holds AI comments from the file watcher until claude is ready
+// for a prompt. Typing into claude while it's responding, or while the user
+// is halfway through a prompt, mixes the two together.
+type promptQueue struct {
+	mutex    sync.Mutex
+	comments []AIComment // In the order they were found
+}
+
+// Add queues comments that aren't already queued and returns the ones that
+// were added
+func (q *promptQueue) Add(comments []AIComment) []AIComment {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	queued := make(map[string]bool, len(q.comments))
+	for _, c := range q.comments {
+		queued[c.Hash] = true
+	}
+	var added []AIComment
+	for _, c := range comments {
+		if !queued[c.Hash] {
+			queued[c.Hash] = true
+			added = append(added, c)
+		}
+	}
+	q.comments = append(q.comments, added...)
+	return added
+}
+
+// Refresh updates the queued comments from a file that has changed, so they
+// have the current line numbers. Comments that are no longer in the file are
+// dropped and returned. For a removed file or directory, found is nil and
+// every comment in it is dropped.
+func (q *promptQueue) Refresh(path string, found []AIComment) (dropped []AIComment) {
+	current := make(map[string]AIComment, len(found))
+	for _, c := range found {
+		current[commentKey(c)] = c
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	path = filepath.Clean(path)
+	kept := q.comments[:0]
+	for _, c := range q.comments {
+		if !isWithin(path, filepath.Clean(c.FilePath)) {
+			kept = append(kept, c)
+		} else if updated, ok := current[commentKey(c)]; ok {
+			kept = append(kept, updated)
+		} else {
+			dropped = append(dropped, c)
+		}
+	}
+	q.comments = kept
+	return dropped
+}
+
+// Len returns the number of queued comments
+func (q *promptQueue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.comments)
+}
+
+// Take empties the queue. Everything that was queued goes into one prompt.
+func (q *promptQueue) Take() []AIComment {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	comments := q.comments
+	q.comments = nil
+	return comments
+}
+
+// readyForPrompt reports whether a prompt can be typed without getting mixed
+// up with claude's output or the user's typing: claude is idle at an empty
+// prompt and the user hasn't pressed a key for idleDelay.
+func readyForPrompt(state ScreenState, sinceKeypress, idleDelay time.Duration) bool {
+	return state.Idle && state.EmptyPrompt && !state.SlashMenu && sinceKeypress >= idleDelay
+}
+
+// queuePrompt queues comments for sendQueuedPrompts
+func (w *CLIWrapper) queuePrompt(comments []AIComment) {
+	// Tracked first, so Sent finds them even if the queue is taken as soon
+	// as they're added. Comments that are already queued are tracked already.
+	w.comments.Queue(comments)
+	added := w.prompts.Add(comments)
+	if len(added) == 0 {
+		return
+	}
+	logger.Info("Queued AI comments until claude is idle", "added", len(added), "queued", w.prompts.Len())
+}
+
+// sinceKeypress returns how long ago the user last pressed a key
+func (w *CLIWrapper) sinceKeypress() time.Duration {
+	return time.Since(time.Unix(0, w.lastKeypress.Load()))
+}
+
+// runPromptQueue sends queued comments whenever claude is ready for them
+func (w *CLIWrapper) runPromptQueue() {
+	ticker := time.NewTicker(promptQueueInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		w.sendQueuedPrompts()
+	}
+}
+
+// sendQueuedPrompts sends everything in the queue as one prompt, if claude is
+// ready for it
+func (w *CLIWrapper) sendQueuedPrompts() {
+	if w.prompts.Len() == 0 || !readyForPrompt(w.screenState(), w.sinceKeypress(), w.config.PromptIdleDelay) {
+		return
+	}
+	comments := w.prompts.Take()
+
+	// Include the context comments from the codebase that apply to these, most
+	// relevant first
+	contextComments := scopeContextComments(w.index.ContextComments(), comments)
+	contextComments = selectContextComments(contextComments, comments, contextBudget)
+
+	prompt, err := renderPrompt(promptComments, comments, contextComments)
+	if err != nil {
+		logger.Error("Failed to render prompt", "error", err)
+		w.comments.Failed(comments)
+		return
+	}
+
+	logger.Info("Sending prompt to underlying program", "prompt", prompt)
+
+	if err := w.SendCommand(prompt); err != nil {
+		logger.Error("Failed to send prompt to wrapped program", "error", err)
+		w.comments.Failed(comments)
+		return
+	}
+	markCommentsProcessed(comments...)
+	w.comments.Sent(comments)
+	logger.Info("Successfully sent prompt and marked comments as processed", "comment_count", len(comments))
+}
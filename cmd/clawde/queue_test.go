@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattduck/clawde/internal/control"
+	"github.com/mattduck/clawde/internal/processed"
+	"github.com/mattduck/clawde/internal/vt"
+)
+
+func TestPromptQueue(t *testing.T) {
+	var q promptQueue
+	fix := AIComment{FilePath: "main.go", LineNumber: 3, Content: "Fix this AI!", ActionType: "!", Hash: "a"}
+	ask := AIComment{FilePath: "main.go", LineNumber: 9, Content: "Why? AI?", ActionType: "?", Hash: "b"}
+	other := AIComment{FilePath: "other.go", LineNumber: 1, Content: "Tidy AI!", ActionType: "!", Hash: "c"}
+
+	if added := q.Add([]AIComment{fix, ask}); len(added) != 2 {
+		t.Errorf("expected 2 comments added, got %d", len(added))
+	}
+	// The same comments are found again when the file is saved again
+	if added := q.Add([]AIComment{fix, other}); len(added) != 1 || added[0].Hash != "c" {
+		t.Errorf("expected only the new comment to be added, got %+v", added)
+	}
+
+	// A line was added above the command and the question was deleted
+	moved := fix
+	moved.LineNumber, moved.Hash = 4, "moved"
+	dropped := q.Refresh("./main.go", []AIComment{moved})
+	if len(dropped) != 1 || dropped[0].Hash != "b" {
+		t.Errorf("expected the question to be dropped, got %+v", dropped)
+	}
+
+	comments := q.Take()
+	if len(comments) != 2 || comments[0].LineNumber != 4 || comments[1].Hash != "c" {
+		t.Errorf("unexpected queued comments %+v", comments)
+	}
+	if q.Len() != 0 {
+		t.Errorf("expected the queue to be empty after Take")
+	}
+}
+
+func TestRemovedFilesAreNotSent(t *testing.T) {
+	initTestLogger()
+	w := &CLIWrapper{
+		comments: newCommentTracker(time.Minute, time.Hour),
+		prompts:  &promptQueue{},
+		index:    newCommentIndex(),
+	}
+	a := AIComment{FilePath: "src/a.go", LineNumber: 1, Content: "Fix AI!", ActionType: "!", Hash: "a"}
+	b := AIComment{FilePath: "src/pkg/b.go", LineNumber: 1, Content: "Fix AI!", ActionType: "!", Hash: "b"}
+	other := AIComment{FilePath: "srcother.go", LineNumber: 1, Content: "Fix AI!", ActionType: "!", Hash: "c"}
+	w.queuePrompt([]AIComment{a, b, other})
+
+	w.fileRemoved("src/a.go")
+	w.fileRemoved("src/pkg")
+	if comments := w.prompts.Take(); len(comments) != 1 || comments[0].Hash != "c" {
+		t.Errorf("expected only the comment outside the removed paths to be sent, got %+v", comments)
+	}
+	if statuses := w.comments.List(true); len(statuses) != 1 || statuses[0].File != "srcother.go" {
+		t.Errorf("expected removed queued comments to be forgotten, got %+v", statuses)
+	}
+}
+
+func TestReadyForPrompt(t *testing.T) {
+	ready := ScreenState{Idle: true, EmptyPrompt: true}
+	tests := []struct {
+		name  string
+		state ScreenState
+		since time.Duration
+		want  bool
+	}{
+		{"idle at empty prompt", ready, 3 * time.Second, true},
+		{"user typed recently", ready, time.Second, false},
+		{"busy", ScreenState{Busy: true}, time.Minute, false},
+		{"draft at the prompt", ScreenState{Idle: true}, time.Minute, false},
+		{"slash menu open", ScreenState{Idle: true, EmptyPrompt: true, SlashMenu: true}, time.Minute, false},
+	}
+	for _, tt := range tests {
+		if got := readyForPrompt(tt.state, tt.since, 2*time.Second); got != tt.want {
+			t.Errorf("%s: readyForPrompt() = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSendQueuedPrompts(t *testing.T) {
+	initTestLogger()
+	// Context comments are collected from the working directory
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+	previous := processedComments
+	processedComments = processed.New("", 0)
+	defer func() { processedComments = previous }()
+
+	matchers, err := buildStateMatchers(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := defaultConfig()
+	config.SubmitDelay = 0
+	var stdin bytes.Buffer
+	w := &CLIWrapper{
+		stdin:    &stdin,
+		config:   config,
+		screen:   vt.New(40, 4),
+		comments: newCommentTracker(time.Minute, time.Hour),
+		prompts:  &promptQueue{},
+		index:    newCommentIndex(),
+	}
+	w.stateDetector = newScreenStateDetector(w.screen, matchers)
+
+	fix := AIComment{FilePath: "main.go", LineNumber: 3, Content: "Fix this AI!", ActionType: "!", Hash: "a"}
+	ask := AIComment{FilePath: "other.go", LineNumber: 9, Content: "Why? AI?", ActionType: "?", Hash: "b"}
+	w.queuePrompt([]AIComment{fix})
+	w.queuePrompt([]AIComment{ask})
+
+	// Claude is still working
+	w.screen.Write([]byte("✻ Thinking… (esc to interrupt)\r\n> \r\n  -- INSERT --"))
+	w.sendQueuedPrompts()
+	if stdin.Len() != 0 {
+		t.Fatalf("expected nothing to be sent while claude is busy, got %q", stdin.String())
+	}
+
+	// Done, and the user is typing
+	w.screen.Write([]byte("\x1b[2J\x1b[H> \r\n  -- INSERT --"))
+	w.lastKeypress.Store(time.Now().UnixNano())
+	w.sendQueuedPrompts()
+	if stdin.Len() != 0 {
+		t.Fatalf("expected nothing to be sent while the user is typing, got %q", stdin.String())
+	}
+
+	w.lastKeypress.Store(time.Now().Add(-time.Minute).UnixNano())
+	w.sendQueuedPrompts()
+	sent := stdin.String()
+	if strings.Count(sent, "\r") != 1 || !strings.Contains(sent, "• main.go at line 3") || !strings.Contains(sent, "• other.go at line 9") {
+		t.Errorf("expected both batches in one prompt, got %q", sent)
+	}
+	if !isCommentProcessed(fix) || !isCommentProcessed(ask) {
+		t.Error("expected sent comments to be marked processed")
+	}
+	for _, s := range w.comments.List(true) {
+		if s.State != control.CommentSent {
+			t.Errorf("expected %s:%d to be sent, got %s", s.File, s.Line, s.State)
+		}
+	}
+}
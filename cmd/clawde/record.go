@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/mattduck/clawde/internal/asciicast"
+)
+
+// startRecording records the session to an asciicast file
+func (w *CLIWrapper) startRecording(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create recording: %w", err)
+	}
+
+	cols, rows := w.screen.Size()
+	header := asciicast.Header{
+		Width:  cols,
+		Height: rows,
+		Title:  "clawde",
+		Env: map[string]string{
+			"SHELL": os.Getenv("SHELL"),
+			"TERM":  os.Getenv("TERM"),
+		},
+	}
+	recorder, err := asciicast.NewRecorder(f, header)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	w.recordFile = f
+	w.recorder = recorder
+	logger.Info("Recording session", "path", path)
+	return nil
+}
+
+// resizeScreen updates the screen model, and the recording if there is one,
+// after the terminal size changes
+func (w *CLIWrapper) resizeScreen(cols, rows int) {
+	w.screen.Resize(cols, rows)
+	if w.recorder != nil {
+		w.recorder.Resize(cols, rows)
+	}
+}
+
+// runReplay implements "clawde replay", which plays a recording back in the
+// terminal
+func runReplay(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("clawde replay", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	speed := fs.Float64("speed", 1, "Playback speed multiplier")
+	idleLimit := fs.Duration("idle-limit", 0, "Shorten pauses longer than this (e.g. 2s)")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: clawde replay [--speed N] [--idle-limit D] FILE\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	if *speed <= 0 {
+		fmt.Fprintf(stderr, "Error: --speed must be greater than 0\n")
+		return 2
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+
+	_, events, err := asciicast.Read(f)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s: %v\n", fs.Arg(0), err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = asciicast.Play(ctx, stdout, events, asciicast.PlayOptions{
+		Speed:     *speed,
+		IdleLimit: *idleLimit,
+	})
+	// Reset attributes in case playback stopped part way through
+	fmt.Fprint(stdout, "\x1b[0m")
+	if err != nil && ctx.Err() == nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
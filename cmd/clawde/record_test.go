@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.cast")
+	cast := `{"version": 2, "width": 80, "height": 24}
+[0.01, "o", "hello "]
+[0.02, "i", "typed"]
+[0.03, "o", "world"]
+`
+	if err := os.WriteFile(path, []byte(cast), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := runReplay([]string{"--speed", "100", path}, &stdout, &stderr); code != 0 {
+		t.Fatalf("runReplay() = %d, stderr: %s", code, stderr.String())
+	}
+	if !strings.HasPrefix(stdout.String(), "hello world") {
+		t.Errorf("unexpected output %q", stdout.String())
+	}
+}
+
+func TestRunReplayErrors(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := runReplay(nil, &stdout, &stderr); code == 0 {
+		t.Error("expected error without a file")
+	}
+	if code := runReplay([]string{"--speed", "0", "x.cast"}, &stdout, &stderr); code == 0 {
+		t.Error("expected error for zero speed")
+	}
+	if code := runReplay([]string{filepath.Join(t.TempDir(), "missing.cast")}, &stdout, &stderr); code == 0 {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestCloseTwice(t *testing.T) {
+	initTestLogger()
+	f, err := os.Create(filepath.Join(t.TempDir(), "session.cast"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &CLIWrapper{
+		recordFile:    f,
+		stateDetector: newTmuxStateDetector(nil, time.Second),
+		index:         newCommentIndex(),
+	}
+	// The signal handler and main can both close the wrapper
+	w.Close()
+	w.Close()
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mattduck/clawde/internal/lexer"
+)
+
+// contextBudget is roughly the most tokens of AI: comments to include in one
+// prompt. 0 is no limit.
+var contextBudget = 0
+
+// Points for each way an AI: comment can relate to a comment being sent
+const (
+	scoreSameSymbol   = 8
+	scoreSameFile     = 5
+	scoreSameDir      = 3
+	scoreImported     = 3 // The sent comment's file imports the AI: comment's package
+	scoreImports      = 2 // The AI: comment's file imports the sent comment's package
+	scoreNearbyDir    = 1
+	maxSharedIdents   = 4 // One point per shared identifier, up to this many
+	minIdentLength    = 4
+	contextTokenExtra = 8 // The file name and location around each comment in the prompt
+)
+
+var identPattern = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+
+// commonWords are left out when comparing identifiers, since sharing them
+// doesn't make two comments related
+var commonWords = map[string]bool{
+	"this": true, "that": true, "with": true, "from": true, "when": true,
+	"then": true, "else": true, "have": true, "should": true, "would": true,
+	"could": true, "here": true, "there": true, "what": true, "make": true,
+	"func": true, "function": true, "return": true, "import": true,
+	"const": true, "true": true, "false": true, "null": true, "none": true,
+	"self": true, "void": true, "string": true, "error": true,
+}
+
+// rankedContext is an AI: comment with how relevant it is to the prompt
+type rankedContext struct {
+	comment AIComment
+	score   int
+	reasons []string
+	tokens  int
+}
+
+// selectContextComments ranks AI: comments by how relevant they are to the
+// comments being sent, most relevant first, and keeps the ones that fit in
+// budget tokens
+func selectContextComments(contextComments, targets []AIComment, budget int) []AIComment {
+	if len(contextComments) == 0 {
+		return nil
+	}
+	scorer := newRelevanceScorer()
+	ranked := make([]rankedContext, 0, len(contextComments))
+	for _, c := range contextComments {
+		score, reasons := scorer.best(c, targets)
+		ranked = append(ranked, rankedContext{
+			comment: c,
+			score:   score,
+			reasons: reasons,
+			tokens:  estimateTokens(c.FilePath+c.Content) + contextTokenExtra,
+		})
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].score > ranked[j].score })
+
+	var selected []AIComment
+	used := 0
+	for _, r := range ranked {
+		location := fmt.Sprintf("%s:%d", r.comment.FilePath, r.comment.LineNumber)
+		if budget > 0 && used+r.tokens > budget {
+			logger.Debug("Context comment left out: over budget", "location", location, "score", r.score,
+				"reasons", strings.Join(r.reasons, "; "), "tokens", r.tokens, "used", used, "budget", budget)
+			continue
+		}
+		used += r.tokens
+		selected = append(selected, r.comment)
+		logger.Debug("Context comment selected", "location", location, "score", r.score,
+			"reasons", strings.Join(r.reasons, "; "), "tokens", r.tokens)
+	}
+	logger.Info("Selected context comments", "candidates", len(contextComments), "selected", len(selected), "tokens", used, "budget", budget)
+	return selected
+}
+
+// estimateTokens roughly counts the tokens in s, at four characters each
+func estimateTokens(s string) int {
+	return (utf8.RuneCountInString(s) + 3) / 4
+}
+
+// relevanceScorer compares comments. It caches the imports of the files it
+// reads.
+type relevanceScorer struct {
+	root    string
+	module  string              // Go module path, if the repo has a go.mod
+	imports map[string][]string // Absolute file path to the directories it imports
+}
+
+func newRelevanceScorer() *relevanceScorer {
+	r := &relevanceScorer{imports: make(map[string][]string)}
+	if wd, err := os.Getwd(); err == nil {
+		r.root = repoRoot(wd)
+		r.module = goModulePath(r.root)
+	}
+	return r
+}
+
+// best scores an AI: comment against each target and returns the highest
+// score, with the reasons for it
+func (r *relevanceScorer) best(context AIComment, targets []AIComment) (int, []string) {
+	best, bestReasons := -1, []string(nil)
+	for _, target := range targets {
+		if score, reasons := r.score(context, target); score > best {
+			best, bestReasons = score, reasons
+		}
+	}
+	return max(best, 0), bestReasons
+}
+
+func (r *relevanceScorer) score(context, target AIComment) (int, []string) {
+	score := 0
+	var reasons []string
+	add := func(points int, reason string) {
+		score += points
+		reasons = append(reasons, reason)
+	}
+
+	contextFile, targetFile := absPath(context.FilePath), absPath(target.FilePath)
+	contextDir, targetDir := filepath.Dir(contextFile), filepath.Dir(targetFile)
+	switch {
+	case contextFile == targetFile && context.Symbol.Name != "" &&
+		target.LineNumber >= context.Symbol.Line && target.LineNumber <= context.Symbol.EndLine:
+		add(scoreSameSymbol, "same "+context.Symbol.Kind+" "+context.Symbol.Name)
+	case contextFile == targetFile:
+		add(scoreSameFile, "same file")
+	case contextDir == targetDir:
+		add(scoreSameDir, "same directory")
+	default:
+		if slices.Contains(r.importsOf(targetFile), contextDir) {
+			add(scoreImported, target.FilePath+" imports its package")
+		} else if slices.Contains(r.importsOf(contextFile), targetDir) {
+			add(scoreImports, "imports the package of "+target.FilePath)
+		} else if filepath.Dir(contextDir) == filepath.Dir(targetDir) || isWithin(contextDir, targetDir) {
+			add(scoreNearbyDir, "nearby directory")
+		}
+	}
+
+	if shared := sharedIdentifiers(context, target); len(shared) > 0 {
+		if len(shared) > maxSharedIdents {
+			shared = shared[:maxSharedIdents]
+		}
+		add(len(shared), "shares "+strings.Join(shared, ", "))
+	}
+	return score, reasons
+}
+
+// importsOf returns the directories in the repo that a file imports
+func (r *relevanceScorer) importsOf(path string) []string {
+	if dirs, ok := r.imports[path]; ok {
+		return dirs
+	}
+	var dirs []string
+	if info, err := os.Stat(path); err == nil && info.Size() <= maxFileSize {
+		if data, err := os.ReadFile(path); err == nil {
+			dirs = fileImports(path, string(data), r.root, r.module)
+		}
+	}
+	r.imports[path] = dirs
+	return dirs
+}
+
+// sharedIdentifiers returns the identifiers in an AI: comment that also
+// appear in or around a target comment, in the order they appear
+func sharedIdentifiers(context, target AIComment) []string {
+	targetIdents := identifiers(append([]string{target.Content, target.Symbol.Name}, target.ContextLines...)...)
+	var shared []string
+	seen := make(map[string]bool)
+	for _, word := range identPattern.FindAllString(context.Content+" "+context.Symbol.Name, -1) {
+		word = strings.ToLower(word)
+		if targetIdents[word] && !seen[word] {
+			seen[word] = true
+			shared = append(shared, word)
+		}
+	}
+	return shared
+}
+
+// identifiers returns the distinct words in texts that are long and unusual
+// enough to suggest a connection, lowercased
+func identifiers(texts ...string) map[string]bool {
+	idents := make(map[string]bool)
+	for _, text := range texts {
+		for _, word := range identPattern.FindAllString(text, -1) {
+			word = strings.ToLower(word)
+			if len(word) >= minIdentLength && !commonWords[word] && !isMarkerWord(word) {
+				idents[word] = true
+			}
+		}
+	}
+	return idents
+}
+
+func isMarkerWord(word string) bool {
+	for _, m := range markerWords {
+		if strings.EqualFold(m, word) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	goImportPattern     = regexp.MustCompile(`^\s*(?:import\s+)?(?:[\w.]+\s+)?"([^"]+)"`)
+	jsImportPattern     = regexp.MustCompile(`(?:\bfrom\s+|\brequire\(\s*|\bimport\s*\(\s*|^\s*import\s+)['"](\.[^'"]+)['"]`)
+	pythonImportPattern = regexp.MustCompile(`^\s*(?:from\s+(\.*)([\w.]*)\s+import|import\s+([\w.]+))`)
+)
+
+// fileImports finds the import statements in a file and returns the
+// directories they refer to in the repo. Packages from outside the repo are
+// left out.
+func fileImports(path, src, root, module string) []string {
+	lang := lexer.ForFile(path)
+	if lang == nil {
+		return nil
+	}
+	dir := filepath.Dir(path)
+	seen := make(map[string]bool)
+	var dirs []string
+	addPath := func(p string) {
+		// An import names a package directory, or a file or module in one.
+		// Anything else is from outside the repo.
+		info, err := os.Stat(p)
+		switch {
+		case err == nil && info.IsDir():
+		case err == nil:
+			p = filepath.Dir(p)
+		default:
+			if matches, _ := filepath.Glob(p + ".*"); len(matches) == 0 {
+				return
+			}
+			p = filepath.Dir(p)
+		}
+		if !seen[p] && isWithin(root, p) {
+			seen[p] = true
+			dirs = append(dirs, p)
+		}
+	}
+
+	for _, line := range strings.Split(src, "\n") {
+		switch lang.Name {
+		case "Go":
+			if strings.HasPrefix(line, "func ") || strings.HasPrefix(line, "type ") {
+				return dirs // Imports come before any declarations
+			}
+			if m := goImportPattern.FindStringSubmatch(line); m != nil && module != "" && strings.HasPrefix(m[1], module+"/") {
+				addPath(filepath.Join(root, strings.TrimPrefix(m[1], module+"/")))
+			}
+		case "JavaScript", "TypeScript":
+			for _, m := range jsImportPattern.FindAllStringSubmatch(line, -1) {
+				addPath(filepath.Join(dir, m[1]))
+			}
+		case "Python":
+			m := pythonImportPattern.FindStringSubmatch(line)
+			switch {
+			case m == nil:
+			case m[1] != "": // Relative: each dot after the first goes up a package
+				base := dir
+				for i := 1; i < len(m[1]); i++ {
+					base = filepath.Dir(base)
+				}
+				addPath(filepath.Join(base, strings.ReplaceAll(m[2], ".", string(filepath.Separator))))
+			default:
+				name := m[2] + m[3]
+				addPath(filepath.Join(root, strings.ReplaceAll(name, ".", string(filepath.Separator))))
+			}
+		}
+	}
+	return dirs
+}
+
+// goModulePath returns the module path from root's go.mod, or "" if there
+// isn't one
+func goModulePath(root string) string {
+	data, err := os.ReadFile(filepath.Join(root, "go.mod"))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`)
+		}
+	}
+	return ""
+}
+
+// isWithin reports whether path is dir or below it
+func isWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSelectContextComments(t *testing.T) {
+	initTestLogger()
+	target := AIComment{
+		FilePath:     "pkg/api/server.go",
+		LineNumber:   10,
+		Content:      "Retry the request AI!",
+		ContextLines: []string{"resp, err := client.Do(req)"},
+		ActionType:   "!",
+	}
+	global := AIComment{FilePath: "other/x.go", LineNumber: 1, Content: "AI: @global British spelling", ActionType: ":"}
+	sameFile := AIComment{FilePath: "pkg/api/server.go", LineNumber: 1, Content: "AI: this is the API", ActionType: ":"}
+	sameDir := AIComment{FilePath: "pkg/api/conn.go", LineNumber: 1, Content: "AI: the client uses backoff", ActionType: ":"}
+	nearby := AIComment{FilePath: "pkg/web/x.go", LineNumber: 1, Content: "AI: templates", ActionType: ":"}
+	candidates := []AIComment{global, sameFile, sameDir, nearby}
+
+	got := selectContextComments(candidates, []AIComment{target}, 0)
+	want := []AIComment{sameFile, sameDir, nearby, global}
+	if len(got) != len(want) {
+		t.Fatalf("got %d comments, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].FilePath != want[i].FilePath || got[i].Content != want[i].Content {
+			t.Errorf("comment %d = %s %q, want %s %q", i, got[i].FilePath, got[i].Content, want[i].FilePath, want[i].Content)
+		}
+	}
+
+	scorer := newRelevanceScorer()
+	if score, reasons := scorer.score(sameDir, target); score != scoreSameDir+1 || len(reasons) != 2 || reasons[1] != "shares client" {
+		t.Errorf("score = %d %q, want %d with a shared identifier", score, reasons, scoreSameDir+1)
+	}
+
+	tokens := func(c AIComment) int { return estimateTokens(c.FilePath+c.Content) + contextTokenExtra }
+	got = selectContextComments(candidates, []AIComment{target}, tokens(sameFile)+tokens(sameDir))
+	if len(got) != 2 || got[0].Content != sameFile.Content || got[1].Content != sameDir.Content {
+		t.Errorf("expected the two most relevant comments within the budget, got %+v", got)
+	}
+}
+
+func TestFileImports(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"internal/db", "web/lib", "pkg/sub", "lib"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"web/util.ts", "lib/helpers.py"} {
+		if err := os.WriteFile(filepath.Join(root, file), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		file string
+		src  string
+		want []string
+	}{
+		{
+			file: "cmd/main.go",
+			src:  "package main\n\nimport (\n\t\"fmt\"\n\tdb \"example.com/app/internal/db\"\n)\n\nfunc main() {\n\tx := \"example.com/app/web\"\n}",
+			want: []string{"internal/db"},
+		},
+		{
+			file: "web/app.ts",
+			src:  "import { a } from './util'\nimport React from 'react'\nconst b = require('./lib')\nconst c = await import('../missing')",
+			want: []string{"web", "web/lib"},
+		},
+		{
+			file: "pkg/mod.py",
+			src:  "import os\nimport pkg.sub\nfrom . import x\nfrom ..lib.helpers import y",
+			want: []string{"pkg/sub", "pkg", "lib"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			path := filepath.Join(root, tt.file)
+			got := fileImports(path, tt.src, root, "example.com/app")
+			if len(got) != len(tt.want) {
+				t.Fatalf("fileImports() = %v, want %v", got, tt.want)
+			}
+			for i, dir := range tt.want {
+				if got[i] != filepath.Join(root, dir) {
+					t.Errorf("import %d = %s, want %s", i, got[i], filepath.Join(root, dir))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mattduck/clawde/internal/gitrepo"
+)
+
+// repoRoot returns the root of the repository, worktree or submodule that dir
+// is in, or dir itself if it isn't in one
+func repoRoot(dir string) string {
+	root, _ := gitrepo.Root(dir)
+	return root
+}
+
+// gitBranch returns the branch checked out in the repository at root, the
+// short commit hash if HEAD is detached, or "" if it can't be read
+func gitBranch(root string) string {
+	gitDir := gitrepo.Dir(root)
+	if gitDir == "" {
+		return ""
+	}
+	head, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return ""
+	}
+	ref := strings.TrimSpace(string(head))
+	if branch, ok := strings.CutPrefix(ref, "ref: refs/heads/"); ok {
+		return branch
+	}
+	if len(ref) > 12 {
+		return ref[:12]
+	}
+	return ref
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/mattduck/clawde/internal/lexer"
+)
+
+// Scopes of AI: comments, from narrowest to widest
+const (
+	scopeSymbol = "symbol" // The function, method, type or class the comment is in or above
+	scopeFile   = "file"
+	scopeDir    = "dir" // The comment's directory and everything below it
+	scopeGlobal = "global"
+)
+
+// resolveSymbols sets the symbol each comment is in. A comment directly above
+// a symbol, like a doc comment, belongs to that symbol rather than the one
+// around it.
+func resolveSymbols(comments []AIComment, symbols []lexer.Symbol) {
+	for i := range comments {
+		c := &comments[i]
+		end := c.EndLine
+		if end == 0 {
+			end = c.LineNumber
+		}
+		// Outer symbols come first, so the last match is the innermost
+		for _, s := range symbols {
+			if s.Line <= c.LineNumber && s.EndLine >= end {
+				c.Symbol = s
+			}
+		}
+		for _, s := range symbols {
+			if s.Line == end+1 {
+				c.Symbol = s
+				break
+			}
+		}
+	}
+}
+
+// contextScope returns what an AI: comment applies to. It's the symbol the
+// comment belongs to, or the file if it isn't in one, unless the comment says
+// otherwise with @symbol, @file, @dir or @global.
+func contextScope(c AIComment) string {
+	for _, word := range strings.Fields(strings.ToLower(c.Content)) {
+		switch strings.TrimRight(word, ".,;:") {
+		case "@symbol":
+			if c.Symbol.Name != "" {
+				return scopeSymbol
+			}
+		case "@file":
+			return scopeFile
+		case "@dir":
+			return scopeDir
+		case "@global":
+			return scopeGlobal
+		}
+	}
+	if c.Symbol.Name != "" {
+		return scopeSymbol
+	}
+	return scopeFile
+}
+
+// contextApplies reports whether an AI: comment is in scope for a comment
+// that is being sent
+func contextApplies(context, target AIComment) bool {
+	scope := contextScope(context)
+	if scope == scopeGlobal {
+		return true
+	}
+
+	contextFile, targetFile := absPath(context.FilePath), absPath(target.FilePath)
+	switch scope {
+	case scopeDir:
+		return isWithin(filepath.Dir(contextFile), targetFile)
+	case scopeFile:
+		return contextFile == targetFile
+	default:
+		return contextFile == targetFile &&
+			target.LineNumber >= context.Symbol.Line && target.LineNumber <= context.Symbol.EndLine
+	}
+}
+
+// scopeContextComments returns the AI: comments that are in scope for any of
+// the target comments
+func scopeContextComments(contextComments, targets []AIComment) []AIComment {
+	var scoped []AIComment
+	for _, context := range contextComments {
+		for _, target := range targets {
+			if contextApplies(context, target) {
+				scoped = append(scoped, context)
+				break
+			}
+		}
+	}
+	logger.Debug("Scoped context comments", "found", len(contextComments), "in_scope", len(scoped))
+	return scoped
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattduck/clawde/internal/lexer"
+)
+
+func TestResolveSymbols(t *testing.T) {
+	initTestLogger()
+	src := `package main
+
+// AI: handlers must not block
+func handle() {
+	// Retry here AI!
+	if err != nil {
+		return // why? AI?
+	}
+}
+
+type server struct {
+	port int // AI: @dir ports come from config
+}
+
+// Top-level note AI?
+
+var x = 1`
+
+	comments, err := extractAICommentsFromString(src, "main.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []lexer.Symbol{
+		{Kind: "function", Name: "handle", Line: 4, EndLine: 9},
+		{Kind: "function", Name: "handle", Line: 4, EndLine: 9},
+		{Kind: "function", Name: "handle", Line: 4, EndLine: 9},
+		{Kind: "type", Name: "server", Line: 11, EndLine: 13},
+		{},
+	}
+	if len(comments) != len(want) {
+		t.Fatalf("got %d comments, want %d", len(comments), len(want))
+	}
+	for i, c := range comments {
+		if c.Symbol != want[i] {
+			t.Errorf("comment at line %d: symbol = %+v, want %+v", c.LineNumber, c.Symbol, want[i])
+		}
+	}
+
+	prompt := mustRenderPrompt(t, promptComments, comments[1])
+	if !strings.Contains(prompt, "See main.go at line 5, in function `handle`, and surrounding context.") {
+		t.Errorf("prompt doesn't name the function:\n%s", prompt)
+	}
+	prompt = mustRenderPrompt(t, promptComments, comments[4])
+	if !strings.HasPrefix(prompt, "See main.go at line 15 and surrounding context.") {
+		t.Errorf("unexpected prompt without a symbol:\n%s", prompt)
+	}
+}
+
+func TestContextApplies(t *testing.T) {
+	handle := lexer.Symbol{Kind: "function", Name: "handle", Line: 4, EndLine: 9}
+	target := AIComment{FilePath: "pkg/api/main.go", LineNumber: 6, ActionType: "!"}
+
+	tests := []struct {
+		name    string
+		context AIComment
+		target  AIComment
+		want    bool
+	}{
+		{
+			name:    "symbol scope includes comments in the symbol",
+			context: AIComment{FilePath: "pkg/api/main.go", LineNumber: 3, Content: "AI: no blocking", Symbol: handle},
+			target:  target,
+			want:    true,
+		},
+		{
+			name:    "symbol scope excludes the rest of the file",
+			context: AIComment{FilePath: "pkg/api/main.go", LineNumber: 3, Content: "AI: no blocking", Symbol: handle},
+			target:  AIComment{FilePath: "pkg/api/main.go", LineNumber: 20},
+			want:    false,
+		},
+		{
+			name:    "file scope outside a symbol",
+			context: AIComment{FilePath: "./pkg/api/main.go", LineNumber: 1, Content: "AI: this is the API"},
+			target:  AIComment{FilePath: "pkg/api/main.go", LineNumber: 20},
+			want:    true,
+		},
+		{
+			name:    "file scope excludes other files",
+			context: AIComment{FilePath: "pkg/api/util.go", LineNumber: 1, Content: "AI: helpers"},
+			target:  target,
+			want:    false,
+		},
+		{
+			name:    "@file widens a symbol's comment",
+			context: AIComment{FilePath: "pkg/api/main.go", LineNumber: 3, Content: "AI: @file no blocking", Symbol: handle},
+			target:  AIComment{FilePath: "pkg/api/main.go", LineNumber: 20},
+			want:    true,
+		},
+		{
+			name:    "@dir includes subdirectories",
+			context: AIComment{FilePath: "pkg/doc.go", LineNumber: 1, Content: "AI: @dir use the logger"},
+			target:  target,
+			want:    true,
+		},
+		{
+			name:    "@dir excludes sibling directories",
+			context: AIComment{FilePath: "pkg/web/doc.go", LineNumber: 1, Content: "AI: @dir use templates"},
+			target:  target,
+			want:    false,
+		},
+		{
+			name:    "@global applies everywhere",
+			context: AIComment{FilePath: "docs/notes.md", LineNumber: 1, Content: "AI: @global British spelling"},
+			target:  target,
+			want:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := contextApplies(tt.context, tt.target); got != tt.want {
+				t.Errorf("contextApplies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mattduck/clawde/internal/lexer"
+)
+
+// snippetBudget is the most lines of code to include in one prompt. 0 leaves
+// code out, so claude reads the files itself.
+var snippetBudget = 0
+
+// snippetWindow is how many lines either side of a comment are shown when it
+// isn't in a block that fits the budget
+const snippetWindow = 10
+
+// minSnippetLines is the smallest snippet worth including. Once less budget
+// than this is left, the remaining comments go without code.
+const minSnippetLines = 3
+
+// addSnippets fills in the code around each comment, in order, until budget
+// lines have been used. Code that an earlier snippet already shows isn't
+// repeated.
+func addSnippets(comments []PromptComment, budget int) {
+	type source struct {
+		lines  []string
+		blocks []lexer.Block
+		lang   string
+	}
+	sources := make(map[string]*source)
+	shown := make(map[string][][2]int)
+
+	for i := range comments {
+		if budget < minSnippetLines {
+			return
+		}
+		c := &comments[i]
+		src, ok := sources[c.File]
+		if !ok {
+			src = &source{}
+			if lang := lexer.ForFile(c.File); lang != nil {
+				if data, err := os.ReadFile(c.File); err == nil {
+					text := strings.TrimSuffix(string(data), "\n")
+					src.lines = strings.Split(text, "\n")
+					src.blocks = lexer.Blocks(text, &lang.Syntax)
+					src.lang = fenceLanguage(lang.Name)
+				} else {
+					logger.Warn("Failed to read file for snippet", "file", c.File, "error", err)
+				}
+			}
+			sources[c.File] = src
+		}
+		if len(src.lines) == 0 || c.Line > len(src.lines) {
+			continue
+		}
+
+		end := c.EndLine
+		if end == 0 {
+			end = c.Line
+		}
+		from, to := snippetRange(src.blocks, len(src.lines), c.Line, end, budget)
+		if containsRange(shown[c.File], from, to) {
+			continue
+		}
+		shown[c.File] = append(shown[c.File], [2]int{from, to})
+
+		c.Snippet = strings.Join(src.lines[from-1:to], "\n")
+		c.SnippetLocation = fmt.Sprintf("lines %d-%d", from, to)
+		c.Language = src.lang
+		budget -= to - from + 1
+	}
+}
+
+// snippetRange picks the lines to show for a comment on lines start-end: the
+// outermost block around it that fits in maxLines, such as the function it's
+// in, or the block that follows it if it's just above one. Otherwise it's
+// the lines around the comment.
+func snippetRange(blocks []lexer.Block, numLines, start, end, maxLines int) (from, to int) {
+	// Outer blocks come first
+	for _, b := range blocks {
+		if b.Line <= start && b.EndLine >= end && b.EndLine-b.Line+1 <= maxLines {
+			return b.Line, b.EndLine
+		}
+	}
+	for _, b := range blocks {
+		if b.Line == end+1 && b.EndLine-start+1 <= maxLines {
+			return start, b.EndLine
+		}
+	}
+
+	from = max(1, start-snippetWindow)
+	to = min(numLines, end+snippetWindow)
+	if to-from+1 > maxLines {
+		// Keep the comment and as much as fits either side of it
+		spare := max(0, maxLines-(end-start+1))
+		from = max(1, start-spare/2)
+		to = min(numLines, from+maxLines-1)
+	}
+	return from, to
+}
+
+func containsRange(ranges [][2]int, from, to int) bool {
+	for _, r := range ranges {
+		if r[0] <= from && to <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// fenceLanguage returns the Markdown code fence name for a language
+func fenceLanguage(name string) string {
+	switch name {
+	case "C++":
+		return "cpp"
+	case "Shell":
+		return "sh"
+	}
+	return strings.ToLower(name)
+}
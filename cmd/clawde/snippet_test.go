@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mattduck/clawde/internal/lexer"
+)
+
+func TestSnippetRange(t *testing.T) {
+	blocks := []lexer.Block{{Line: 10, EndLine: 60}, {Line: 12, EndLine: 20}, {Line: 14, EndLine: 16}, {Line: 71, EndLine: 80}}
+	tests := []struct {
+		name             string
+		start, end       int
+		maxLines         int
+		wantFrom, wantTo int
+	}{
+		{"outermost block that fits", 15, 15, 80, 10, 60},
+		{"inner block when the outer one is too big", 15, 15, 20, 12, 20},
+		{"block below the comment", 69, 70, 80, 69, 80},
+		{"window outside any block", 65, 65, 80, 55, 75},
+		{"window trimmed to the budget", 65, 65, 5, 63, 67},
+		{"window clipped to the file", 3, 3, 80, 1, 13},
+	}
+	for _, tt := range tests {
+		from, to := snippetRange(blocks, 100, tt.start, tt.end, tt.maxLines)
+		if from != tt.wantFrom || to != tt.wantTo {
+			t.Errorf("%s: snippetRange() = %d-%d, want %d-%d", tt.name, from, to, tt.wantFrom, tt.wantTo)
+		}
+	}
+}
+
+func TestRenderPromptWithSnippets(t *testing.T) {
+	initTestLogger()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.go")
+	os.WriteFile(path, []byte(`package main
+
+func a() {
+	// Handle the error AI!
+	x()
+}
+
+func b() {
+	// And here AI!
+	y()
+}
+`), 0644)
+
+	previous := snippetBudget
+	defer func() { snippetBudget = previous }()
+	snippetBudget = 80
+
+	one := AIComment{FilePath: path, LineNumber: 4, ActionType: "!"}
+	got := mustRenderPrompt(t, promptComments, one)
+	want := "See " + path + " at line 4 and surrounding context. Make the appropriate changes. YOU MUST replace the AI! marker with [ai] when done.\n\n" +
+		path + " lines 3-6:\n```go\nfunc a() {\n\t// Handle the error AI!\n\tx()\n}\n```"
+	if got != want {
+		t.Errorf("renderPrompt() = %q, want %q", got, want)
+	}
+
+	// The second comment's function doesn't fit in what's left, and the
+	// third is in code that's already shown
+	snippetBudget = 6
+	two := AIComment{FilePath: path, LineNumber: 9, ActionType: "!"}
+	again := AIComment{FilePath: path, LineNumber: 5, ActionType: "!", Content: "x"}
+	got = mustRenderPrompt(t, promptComments, one, two, again)
+	if strings.Count(got, "```go") != 1 || !strings.Contains(got, path+" lines 3-6:") {
+		t.Errorf("expected one snippet within the budget, got:\n%s", got)
+	}
+}
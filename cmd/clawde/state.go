@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mattduck/clawde/internal/vt"
+)
+
+// VimMode is the vim mode indicated below Claude's prompt
+type VimMode string
+
+const (
+	VimModeUnknown VimMode = ""
+	VimModeInsert  VimMode = "insert"
+	VimModeNormal  VimMode = "normal"
+)
+
+// ScreenState is a structured view of what the wrapped program is showing
+type ScreenState struct {
+	VimMode          VimMode
+	PermissionPrompt bool     // A "Do you want to..." permission dialog is open
+	Busy             bool     // The model is thinking or running tools
+	Idle             bool     // Waiting at the input prompt, with nothing else going on
+	EmptyPrompt      bool     // Idle, and nothing has been typed at the prompt
+	SlashMenu        bool     // The slash-command completion menu is open
+	Matched          []string // Names of the matchers that matched, for logging
+}
+
+// String returns a compact representation for logs
+func (s ScreenState) String() string {
+	mode := string(s.VimMode)
+	if mode == "" {
+		mode = "unknown"
+	}
+	return fmt.Sprintf("vim=%s permission=%t busy=%t idle=%t empty_prompt=%t slash_menu=%t",
+		mode, s.PermissionPrompt, s.Busy, s.Idle, s.EmptyPrompt, s.SlashMenu)
+}
+
+// ScreenStateDetector reports the current state of the wrapped program's UI.
+// Implementations differ in where they get the screen contents from.
+type ScreenStateDetector interface {
+	Start()
+	Stop()
+	State() ScreenState
+}
+
+// Names of the state matchers. These are also the keys used to override
+// patterns from the environment (CLAWDE_STATE_PATTERN_<NAME>).
+const (
+	matcherInsert      = "insert"
+	matcherNormal      = "normal"
+	matcherPermission  = "permission"
+	matcherBusy        = "busy"
+	matcherIdle        = "idle"
+	matcherEmptyPrompt = "empty_prompt"
+	matcherSlashMenu   = "slash_menu"
+)
+
+// StateMatcher recognises one piece of state from the text on screen
+type StateMatcher struct {
+	Name    string
+	Pattern *regexp.Regexp
+	Lines   int // Only search the bottom N lines of the screen (0 = whole screen)
+}
+
+// defaultStatePatterns are the built-in patterns for Claude's UI. They can be
+// overridden by name when Claude changes its wording.
+var defaultStatePatterns = map[string]string{
+	matcherInsert:      `--\s*INSERT`,
+	matcherNormal:      `--\s*NORMAL`,
+	matcherPermission:  `(?i)do you want to .*\?|❯\s*1\.\s*Yes`,
+	matcherBusy:        `(?i)esc to interrupt`,
+	matcherIdle:        `(?m)^\s*[│|]?\s*>\s`,
+	matcherEmptyPrompt: `(?m)^\s*[│|]?\s*>\s*(?:Try "[^"]*"\s*)?[│|]?\s*$`,
+	matcherSlashMenu:   `(?m)^\s*/[a-z][a-z0-9:_-]*\s{2,}\S`,
+}
+
+// stateMatcherLines limits where on screen each matcher looks. Claude's
+// prompt, footer and menus are always drawn at the bottom, and searching the
+// whole screen would pick up old prompts in the conversation above.
+var stateMatcherLines = map[string]int{
+	matcherInsert:      0,
+	matcherNormal:      0,
+	matcherPermission:  20,
+	matcherBusy:        10,
+	matcherIdle:        8,
+	matcherEmptyPrompt: 8,
+	matcherSlashMenu:   15,
+}
+
+// buildStateMatchers compiles the default patterns with any overrides applied.
+// An empty override disables that matcher.
+func buildStateMatchers(overrides map[string]string) ([]StateMatcher, error) {
+	patterns := make(map[string]string, len(defaultStatePatterns))
+	for name, pattern := range defaultStatePatterns {
+		patterns[name] = pattern
+	}
+	for name, pattern := range overrides {
+		if _, known := defaultStatePatterns[name]; !known {
+			return nil, fmt.Errorf("unknown state matcher %q", name)
+		}
+		patterns[name] = pattern
+	}
+
+	names := make([]string, 0, len(patterns))
+	for name := range patterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var matchers []StateMatcher
+	for _, name := range names {
+		if patterns[name] == "" {
+			continue
+		}
+		re, err := regexp.Compile(patterns[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern for state matcher %q: %w", name, err)
+		}
+		matchers = append(matchers, StateMatcher{
+			Name:    name,
+			Pattern: re,
+			Lines:   stateMatcherLines[name],
+		})
+	}
+	return matchers, nil
+}
+
+// statePatternOverridesFromEnv reads CLAWDE_STATE_PATTERN_<NAME> variables
+func statePatternOverridesFromEnv() map[string]string {
+	const prefix = "CLAWDE_STATE_PATTERN_"
+	overrides := make(map[string]string)
+	for _, env := range os.Environ() {
+		key, value, ok := strings.Cut(env, "=")
+		if !ok || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		overrides[strings.ToLower(strings.TrimPrefix(key, prefix))] = value
+	}
+	return overrides
+}
+
+// evaluateState runs the matchers against the screen lines. typed is the same
+// lines with dim text removed, or nil where the screen's attributes aren't
+// known. Claude draws the placeholder in an empty prompt dim, so the
+// empty_prompt matcher uses typed when it can.
+func evaluateState(lines, typed []string, matchers []StateMatcher) ScreenState {
+	var state ScreenState
+	matched := make(map[string]bool)
+
+	for _, m := range matchers {
+		region := lines
+		if m.Name == matcherEmptyPrompt && typed != nil {
+			region = typed
+		}
+		if m.Lines > 0 {
+			region = bottomLines(region, m.Lines)
+		}
+		if m.Pattern.MatchString(strings.Join(region, "\n")) {
+			matched[m.Name] = true
+			state.Matched = append(state.Matched, m.Name)
+		}
+	}
+
+	switch {
+	case matched[matcherInsert]:
+		state.VimMode = VimModeInsert
+	case matched[matcherNormal]:
+		state.VimMode = VimModeNormal
+	}
+	state.PermissionPrompt = matched[matcherPermission]
+	state.Busy = matched[matcherBusy]
+	state.SlashMenu = matched[matcherSlashMenu]
+	state.Idle = matched[matcherIdle] && !state.Busy && !state.PermissionPrompt
+	state.EmptyPrompt = state.Idle && matched[matcherEmptyPrompt]
+
+	return state
+}
+
+// bottomLines returns the last n non-trailing-blank lines of the screen
+func bottomLines(lines []string, n int) []string {
+	end := len(lines)
+	for end > 0 && strings.TrimSpace(lines[end-1]) == "" {
+		end--
+	}
+	start := end - n
+	if start < 0 {
+		start = 0
+	}
+	return lines[start:end]
+}
+
+// screenStateDetector evaluates state on demand from the in-process screen
+// model. It has no background work, so Start and Stop do nothing.
+type screenStateDetector struct {
+	screen   *vt.Screen
+	matchers []StateMatcher
+}
+
+func newScreenStateDetector(screen *vt.Screen, matchers []StateMatcher) *screenStateDetector {
+	return &screenStateDetector{screen: screen, matchers: matchers}
+}
+
+func (d *screenStateDetector) Start() {}
+
+func (d *screenStateDetector) Stop() {}
+
+func (d *screenStateDetector) State() ScreenState {
+	return evaluateState(d.screen.Lines(), d.screen.UndimmedLines(), d.matchers)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattduck/clawde/internal/vt"
+)
+
+func TestEvaluateStateDefaults(t *testing.T) {
+	matchers, err := buildStateMatchers(nil)
+	if err != nil {
+		t.Fatalf("buildStateMatchers() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		screen string
+		want   ScreenState
+	}{
+		{
+			name: "idle in insert mode",
+			screen: `⏺ Done.
+
+────────────────────────────────
+> 
+────────────────────────────────
+  -- INSERT --`,
+			want: ScreenState{VimMode: VimModeInsert, Idle: true, EmptyPrompt: true},
+		},
+		{
+			name: "empty prompt in a box",
+			screen: `╭────────────────────────────────╮
+│ >                              │
+╰────────────────────────────────╯`,
+			want: ScreenState{Idle: true, EmptyPrompt: true},
+		},
+		{
+			name: "normal mode",
+			screen: `────────────────────────────────
+> some draft
+────────────────────────────────
+  -- NORMAL --`,
+			want: ScreenState{VimMode: VimModeNormal, Idle: true},
+		},
+		{
+			name: "busy",
+			screen: `✻ Thinking… (12s · esc to interrupt)
+
+────────────────────────────────
+> 
+────────────────────────────────
+  -- INSERT --`,
+			want: ScreenState{VimMode: VimModeInsert, Busy: true},
+		},
+		{
+			name: "permission prompt",
+			screen: ` Bash command
+   rm -rf build
+ Do you want to proceed?
+ ❯ 1. Yes
+   2. No`,
+			want: ScreenState{PermissionPrompt: true},
+		},
+		{
+			name: "slash menu",
+			screen: `> /c
+────────────────────────────────
+  /clear          Clear conversation history
+  /compact        Clear conversation history but keep a summary
+  /config         Open config panel`,
+			want: ScreenState{SlashMenu: true, Idle: true},
+		},
+		{
+			name: "old prompt in scrolled conversation is not idle",
+			screen: `> an earlier question
+⏺ Working on it
+line
+line
+line
+line
+line
+line
+line
+line
+✻ Thinking… (esc to interrupt)`,
+			want: ScreenState{Busy: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := evaluateState(strings.Split(tt.screen, "\n"), nil, matchers)
+			if got.String() != tt.want.String() {
+				t.Errorf("evaluateState() = %s, want %s (matched %v)", got, tt.want, got.Matched)
+			}
+		})
+	}
+}
+
+func TestBuildStateMatchersOverrides(t *testing.T) {
+	matchers, err := buildStateMatchers(map[string]string{
+		matcherInsert: `\[insert\]`,
+		matcherBusy:   "",
+	})
+	if err != nil {
+		t.Fatalf("buildStateMatchers() error = %v", err)
+	}
+
+	for _, m := range matchers {
+		if m.Name == matcherBusy {
+			t.Errorf("expected empty override to disable the busy matcher")
+		}
+	}
+
+	got := evaluateState([]string{"esc to interrupt", "[insert]"}, nil, matchers)
+	if got.VimMode != VimModeInsert {
+		t.Errorf("expected overridden insert pattern to match, got %s", got)
+	}
+	if got.Busy {
+		t.Errorf("expected busy matcher to be disabled, got %s", got)
+	}
+
+	if _, err := buildStateMatchers(map[string]string{"nonsense": "x"}); err == nil {
+		t.Errorf("expected error for unknown matcher name")
+	}
+	if _, err := buildStateMatchers(map[string]string{matcherIdle: "("}); err == nil {
+		t.Errorf("expected error for invalid pattern")
+	}
+}
+
+func TestScreenStateDetector(t *testing.T) {
+	matchers, err := buildStateMatchers(nil)
+	if err != nil {
+		t.Fatalf("buildStateMatchers() error = %v", err)
+	}
+
+	screen := vt.New(40, 6)
+	detector := newScreenStateDetector(screen, matchers)
+
+	screen.Write([]byte("> \r\n  -- NORMAL --"))
+	if mode := detector.State().VimMode; mode != VimModeNormal {
+		t.Errorf("expected normal mode, got %q", mode)
+	}
+
+	// Redraw the footer in place, the way Claude does when switching mode
+	screen.Write([]byte("\r\x1b[2K  -- INSERT --"))
+	if mode := detector.State().VimMode; mode != VimModeInsert {
+		t.Errorf("expected insert mode, got %q", mode)
+	}
+}
+
+func TestEmptyPromptWithPlaceholder(t *testing.T) {
+	matchers, err := buildStateMatchers(nil)
+	if err != nil {
+		t.Fatalf("buildStateMatchers() error = %v", err)
+	}
+	footer := "\r\n────────────────────────────────────────\r\n  -- INSERT --"
+
+	tests := []struct {
+		name   string
+		prompt string
+		want   bool
+	}{
+		{"dim placeholder", "> \x1b[2mTry \"write a test for parser.go\"\x1b[22m", true},
+		{"dim placeholder with other wording", "> \x1b[2mAsk anything\x1b[22m", true},
+		{"typed text", "> Try \"write a test\" and more", false},
+		{"typed text before a dim hint", "> fix it \x1b[2m(tab to complete)\x1b[22m", false},
+	}
+	for _, tt := range tests {
+		screen := vt.New(40, 6)
+		screen.Write([]byte("────────────────────────────────────────\r\n" + tt.prompt + footer))
+		state := newScreenStateDetector(screen, matchers).State()
+		if state.EmptyPrompt != tt.want {
+			t.Errorf("%s: EmptyPrompt = %t, want %t (%s)", tt.name, state.EmptyPrompt, tt.want, state)
+		}
+	}
+
+	// tmux captures have no attributes, so only the known wording is
+	// recognised
+	lines := []string{"────", `> Try "write a test for parser.go"`, "────", "  -- INSERT --"}
+	if state := evaluateState(lines, nil, matchers); !state.EmptyPrompt {
+		t.Errorf("expected the placeholder wording to count as an empty prompt, got %s", state)
+	}
+}
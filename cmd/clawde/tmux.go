@@ -8,15 +8,19 @@ import (
 	"time"
 )
 
-type TmuxInsertDetector struct {
-	isInsertMode bool
+// tmuxStateDetector polls tmux for the pane contents and evaluates the state
+// matchers against them
+type tmuxStateDetector struct {
+	matchers     []StateMatcher
+	state        ScreenState
 	mutex        sync.RWMutex
 	stopChan     chan struct{}
 	pollInterval time.Duration
 }
 
-func NewTmuxInsertDetector(pollInterval time.Duration) *TmuxInsertDetector {
-	return &TmuxInsertDetector{
+func newTmuxStateDetector(matchers []StateMatcher, pollInterval time.Duration) *tmuxStateDetector {
+	return &tmuxStateDetector{
+		matchers:     matchers,
 		pollInterval: pollInterval,
 		stopChan:     make(chan struct{}),
 	}
@@ -28,7 +32,7 @@ func IsRunningInTmux() bool {
 }
 
 // Start begins polling tmux for pane contents
-func (t *TmuxInsertDetector) Start() {
+func (t *tmuxStateDetector) Start() {
 	go func() {
 		ticker := time.NewTicker(t.pollInterval)
 		defer ticker.Stop()
@@ -38,17 +42,17 @@ func (t *TmuxInsertDetector) Start() {
 			case <-t.stopChan:
 				return
 			case <-ticker.C:
-				t.checkInsertMode()
+				t.checkState()
 			}
 		}
 	}()
 }
 
-func (t *TmuxInsertDetector) Stop() {
+func (t *tmuxStateDetector) Stop() {
 	close(t.stopChan)
 }
 
-func (t *TmuxInsertDetector) checkInsertMode() {
+func (t *tmuxStateDetector) checkState() {
 	// Capture entire visible pane
 	cmd := exec.Command("tmux", "capture-pane", "-p")
 	output, err := cmd.Output()
@@ -56,18 +60,18 @@ func (t *TmuxInsertDetector) checkInsertMode() {
 		return // Silently fail, keep previous state
 	}
 
-	newInsertMode := strings.Contains(string(output), "-- INSERT")
+	newState := evaluateState(strings.Split(string(output), "\n"), nil, t.matchers)
 
 	t.mutex.Lock()
-	if newInsertMode != t.isInsertMode {
-		logger.Debug("INSERT mode changed", "insert_mode", newInsertMode)
+	if newState.String() != t.state.String() {
+		logger.Debug("Screen state changed", "state", newState.String())
 	}
-	t.isInsertMode = newInsertMode
+	t.state = newState
 	t.mutex.Unlock()
 }
 
-func (t *TmuxInsertDetector) IsInsertMode() bool {
+func (t *tmuxStateDetector) State() ScreenState {
 	t.mutex.RLock()
 	defer t.mutex.RUnlock()
-	return t.isInsertMode
+	return t.state
 }
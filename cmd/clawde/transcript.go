@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mattduck/clawde/internal/transcript"
+)
+
+// startTranscript writes a transcript of the conversation to path as lines
+// scroll off the screen. The format is picked from the file extension.
+func (w *CLIWrapper) startTranscript(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create transcript: %w", err)
+	}
+	writer, err := transcript.NewWriter(f, transcript.FormatFromPath(path))
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	w.transcript = transcript.NewExtractor(writer)
+	w.transcriptFile = f
+	w.screen.SetScrollHandler(w.transcript.AddLine)
+	logger.Info("Writing transcript", "path", path)
+	return nil
+}
+
+// closeTranscript adds whatever is still on screen to the transcript and
+// closes the file
+func (w *CLIWrapper) closeTranscript() {
+	w.transcriptOnce.Do(func() {
+		if w.transcript == nil {
+			return
+		}
+		w.screen.SetScrollHandler(nil)
+		if err := w.transcript.Flush(w.screen.Lines()); err != nil {
+			logger.Error("Failed to write transcript", "error", err)
+		}
+		w.transcriptFile.Close()
+	})
+}
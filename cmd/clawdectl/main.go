@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mattduck/clawde/internal/control"
+)
+
+const usage = `Usage: clawdectl [-session ID] <command> [args]
+
+Commands:
+  list                  List running clawde sessions
+  send [-no-submit] TEXT
+                        Send a prompt to the session
+  screen [-scrollback]  Print the session's screen
+  status                Print the session's state
+  comments [-all]       List AI comments sent to claude that aren't resolved yet
+  watch-events          Print events from the session as JSON lines
+
+The session is chosen by -session, which is a pid or tmux pane ID. Without it
+the only running session is used, or the one started in the current directory.
+`
+
+func main() {
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	sessionFlag := flag.String("session", "", "Session pid or tmux pane ID")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	command, args := flag.Arg(0), flag.Args()[1:]
+
+	sessions, err := control.ListSessions()
+	if err != nil {
+		fail(err)
+	}
+	if command == "list" {
+		printSessions(os.Stdout, sessions)
+		return
+	}
+
+	cwd, _ := os.Getwd()
+	session, err := selectSession(sessions, *sessionFlag, cwd)
+	if err != nil {
+		fail(err)
+	}
+	client, err := control.Dial(session.Socket)
+	if err != nil {
+		fail(fmt.Errorf("failed to connect to session %d: %w", session.PID, err))
+	}
+	defer client.Close()
+
+	switch command {
+	case "send":
+		err = send(client, args)
+	case "screen":
+		err = screen(client, args)
+	case "status":
+		err = status(client)
+	case "comments":
+		err = comments(client, args)
+	case "watch-events":
+		err = watchEvents(client)
+	default:
+		fmt.Fprintf(os.Stderr, "error: unknown command %q\n\n", command)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	os.Exit(1)
+}
+
+func printSessions(w io.Writer, sessions []control.SessionInfo) {
+	if len(sessions) == 0 {
+		fmt.Fprintln(w, "No clawde sessions running")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PID\tPANE\tSTARTED\tDIR")
+	for _, s := range sessions {
+		pane := s.TmuxPane
+		if pane == "" {
+			pane = "-"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", s.PID, pane, s.Started.Format(time.DateTime), s.Dir)
+	}
+	tw.Flush()
+}
+
+// selectSession picks the session to talk to. want matches a pid or tmux
+// pane ID. Without it, a single running session is used, or else the one
+// whose directory is cwd.
+func selectSession(sessions []control.SessionInfo, want, cwd string) (control.SessionInfo, error) {
+	if len(sessions) == 0 {
+		return control.SessionInfo{}, errors.New("no clawde sessions running")
+	}
+
+	if want != "" {
+		pid, _ := strconv.Atoi(want)
+		for _, s := range sessions {
+			if (pid != 0 && s.PID == pid) || (s.TmuxPane != "" && s.TmuxPane == want) {
+				return s, nil
+			}
+		}
+		return control.SessionInfo{}, fmt.Errorf("no session matches %q", want)
+	}
+
+	if len(sessions) == 1 {
+		return sessions[0], nil
+	}
+	var matches []control.SessionInfo
+	for _, s := range sessions {
+		if s.Dir == cwd {
+			matches = append(matches, s)
+		}
+	}
+	if len(matches) == 1 {
+		return matches[0], nil
+	}
+
+	var pids []string
+	for _, s := range sessions {
+		pids = append(pids, strconv.Itoa(s.PID))
+	}
+	return control.SessionInfo{}, fmt.Errorf("%d sessions running (%s), choose one with -session", len(sessions), strings.Join(pids, ", "))
+}
+
+func send(client *control.Client, args []string) error {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	noSubmit := fs.Bool("no-submit", false, "Type the text without pressing enter")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return errors.New("send needs the text to send")
+	}
+	text := strings.Join(fs.Args(), " ")
+	return client.Call(control.MethodSendText, control.SendTextParams{Text: text, Submit: !*noSubmit}, nil)
+}
+
+func screen(client *control.Client, args []string) error {
+	fs := flag.NewFlagSet("screen", flag.ExitOnError)
+	scrollback := fs.Bool("scrollback", false, "Include lines that have scrolled off the screen")
+	fs.Parse(args)
+
+	var s control.Screen
+	if err := client.Call(control.MethodGetScreen, control.GetScreenParams{Scrollback: *scrollback}, &s); err != nil {
+		return err
+	}
+	for _, line := range s.Scrollback {
+		fmt.Println(line)
+	}
+	for _, line := range s.Lines {
+		fmt.Println(line)
+	}
+	return nil
+}
+
+func status(client *control.Client) error {
+	var state control.State
+	if err := client.Call(control.MethodGetState, nil, &state); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "vim mode:\t%s\n", state.VimMode)
+	fmt.Fprintf(tw, "busy:\t%t\n", state.Busy)
+	fmt.Fprintf(tw, "idle:\t%t\n", state.Idle)
+	fmt.Fprintf(tw, "permission prompt:\t%t\n", state.PermissionPrompt)
+	fmt.Fprintf(tw, "slash menu:\t%t\n", state.SlashMenu)
+	fmt.Fprintf(tw, "file watching:\t%t\n", state.FileWatching)
+	fmt.Fprintf(tw, "output throttling:\t%t\n", state.OutputThrottling)
+	return tw.Flush()
+}
+
+func comments(client *control.Client, args []string) error {
+	fs := flag.NewFlagSet("comments", flag.ExitOnError)
+	all := fs.Bool("all", false, "Include resolved and abandoned comments")
+	fs.Parse(args)
+
+	var statuses []control.CommentStatus
+	if err := client.Call(control.MethodListComments, control.ListCommentsParams{All: *all}, &statuses); err != nil {
+		return err
+	}
+	printComments(os.Stdout, statuses)
+	return nil
+}
+
+func printComments(w io.Writer, statuses []control.CommentStatus) {
+	if len(statuses) == 0 {
+		fmt.Fprintln(w, "No comments")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATE\tSENT\tLOCATION\tCOMMENT")
+	for _, s := range statuses {
+		fmt.Fprintf(tw, "%s\t%s\t%s:%d\t%s\n", s.State, s.Queued.Local().Format(time.TimeOnly), s.File, s.Line, strings.ReplaceAll(s.Content, "\n", " "))
+	}
+	tw.Flush()
+}
+
+func watchEvents(client *control.Client) error {
+	if err := client.Subscribe(); err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	for {
+		event, err := client.NextEvent()
+		if err != nil {
+			return err
+		}
+		if err := enc.Encode(event); err != nil {
+			return err
+		}
+		if event.Type == control.EventExit {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattduck/clawde/internal/control"
+)
+
+func TestSelectSession(t *testing.T) {
+	sessions := []control.SessionInfo{
+		{PID: 100, Dir: "/src/a", TmuxPane: "%1"},
+		{PID: 200, Dir: "/src/b", TmuxPane: "%2"},
+		{PID: 300, Dir: "/src/b"},
+	}
+
+	tests := []struct {
+		name    string
+		want    string
+		cwd     string
+		pid     int
+		wantErr bool
+	}{
+		{"by pid", "200", "", 200, false},
+		{"by pane", "%1", "", 100, false},
+		{"unknown", "999", "", 0, true},
+		{"by directory", "", "/src/a", 100, false},
+		{"ambiguous directory", "", "/src/b", 0, true},
+		{"no match", "", "/elsewhere", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectSession(sessions, tt.want, tt.cwd)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got.PID != tt.pid {
+				t.Errorf("got pid %d, want %d", got.PID, tt.pid)
+			}
+		})
+	}
+
+	if got, err := selectSession(sessions[:1], "", "/elsewhere"); err != nil || got.PID != 100 {
+		t.Errorf("expected the only session to be used, got %+v, %v", got, err)
+	}
+	if _, err := selectSession(nil, "", ""); err == nil {
+		t.Error("expected error with no sessions")
+	}
+}
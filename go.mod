@@ -3,6 +3,7 @@ module github.com/mattduck/clawde
 go 1.21
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/creack/pty v1.1.21
 	github.com/fsnotify/fsnotify v1.7.0
 	golang.org/x/term v0.15.0
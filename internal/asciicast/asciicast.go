@@ -0,0 +1,188 @@
+// Package asciicast records and plays back terminal sessions in the asciicast
+// v2 format used by asciinema (https://docs.asciinema.org/manual/asciicast/v2/).
+//
+// A recording is a JSON header line followed by one JSON array per event:
+// [seconds since start, event type, data].
+package asciicast
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Event types
+const (
+	EventOutput = "o" // Data written to the terminal
+	EventInput  = "i" // Data typed by the user
+	EventResize = "r" // Terminal resized, data is "COLSxROWS"
+)
+
+// Header is the first line of a recording
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Event is a single timestamped event
+type Event struct {
+	Time float64 // Seconds since the start of the recording
+	Type string
+	Data string
+}
+
+// Recorder writes events to an asciicast file. It is safe for concurrent use.
+type Recorder struct {
+	mutex sync.Mutex
+	w     io.Writer
+	start time.Time
+	now   func() time.Time
+
+	// Incomplete UTF-8 characters held back until the rest arrives, since
+	// the JSON strings in the file must be valid UTF-8
+	partial map[string][]byte
+}
+
+// NewRecorder writes the header and returns a recorder for the events
+func NewRecorder(w io.Writer, header Header) (*Recorder, error) {
+	return newRecorder(w, header, time.Now)
+}
+
+func newRecorder(w io.Writer, header Header, now func() time.Time) (*Recorder, error) {
+	start := now()
+	header.Version = 2
+	if header.Timestamp == 0 {
+		header.Timestamp = start.Unix()
+	}
+	line, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(w, "%s\n", line); err != nil {
+		return nil, fmt.Errorf("failed to write asciicast header: %w", err)
+	}
+	return &Recorder{
+		w:       w,
+		start:   start,
+		now:     now,
+		partial: make(map[string][]byte),
+	}, nil
+}
+
+// Output records data written to the terminal
+func (r *Recorder) Output(data []byte) error {
+	return r.record(EventOutput, data)
+}
+
+// Input records data typed by the user
+func (r *Recorder) Input(data []byte) error {
+	return r.record(EventInput, data)
+}
+
+// Resize records a change of terminal size
+func (r *Recorder) Resize(cols, rows int) error {
+	return r.record(EventResize, []byte(fmt.Sprintf("%dx%d", cols, rows)))
+}
+
+func (r *Recorder) record(eventType string, data []byte) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	data = append(r.partial[eventType], data...)
+	cut := incompleteSuffix(data)
+	r.partial[eventType] = append([]byte(nil), data[len(data)-cut:]...)
+	data = data[:len(data)-cut]
+	if len(data) == 0 {
+		return nil
+	}
+
+	elapsed := r.now().Sub(r.start).Seconds()
+	line, err := json.Marshal([]interface{}{
+		json.Number(strconv.FormatFloat(elapsed, 'f', 6, 64)),
+		eventType,
+		string(data),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(r.w, "%s\n", line)
+	return err
+}
+
+// incompleteSuffix returns the length of a truncated UTF-8 character at the
+// end of data, or 0 if there isn't one
+func incompleteSuffix(data []byte) int {
+	// A UTF-8 character is at most 4 bytes, so only the last 3 can be the
+	// start of an incomplete one
+	for i := 1; i <= 3 && i <= len(data); i++ {
+		b := data[len(data)-i]
+		if b < utf8.RuneSelf {
+			return 0
+		}
+		if utf8.RuneStart(b) {
+			if utf8.FullRune(data[len(data)-i:]) {
+				return 0
+			}
+			return i
+		}
+	}
+	return 0
+}
+
+// Read parses a recording
+func Read(r io.Reader) (Header, []Event, error) {
+	var header Header
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return header, nil, err
+		}
+		return header, nil, fmt.Errorf("empty asciicast file")
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return header, nil, fmt.Errorf("invalid asciicast header: %w", err)
+	}
+	if header.Version != 2 {
+		return header, nil, fmt.Errorf("unsupported asciicast version %d", header.Version)
+	}
+
+	var events []Event
+	lineNum := 1
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var raw []interface{}
+		if err := json.Unmarshal([]byte(line), &raw); err != nil {
+			return header, nil, fmt.Errorf("line %d: invalid event: %w", lineNum, err)
+		}
+		if len(raw) != 3 {
+			return header, nil, fmt.Errorf("line %d: expected 3 fields, got %d", lineNum, len(raw))
+		}
+		t, ok1 := raw[0].(float64)
+		eventType, ok2 := raw[1].(string)
+		data, ok3 := raw[2].(string)
+		if !ok1 || !ok2 || !ok3 {
+			return header, nil, fmt.Errorf("line %d: invalid event fields", lineNum)
+		}
+		events = append(events, Event{Time: t, Type: eventType, Data: data})
+	}
+	if err := scanner.Err(); err != nil {
+		return header, nil, err
+	}
+	return header, events, nil
+}
@@ -0,0 +1,144 @@
+package asciicast
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func fakeClock(start time.Time) (func() time.Time, func(time.Duration)) {
+	now := start
+	return func() time.Time { return now }, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestRecordAndRead(t *testing.T) {
+	var buf bytes.Buffer
+	now, advance := fakeClock(time.Unix(1700000000, 0))
+
+	rec, err := newRecorder(&buf, Header{Width: 80, Height: 24, Env: map[string]string{"TERM": "xterm"}}, now)
+	if err != nil {
+		t.Fatalf("newRecorder() error = %v", err)
+	}
+	advance(500 * time.Millisecond)
+	rec.Output([]byte("hello\r\n"))
+	advance(250 * time.Millisecond)
+	rec.Input([]byte("x"))
+	rec.Resize(100, 30)
+
+	header, events, err := Read(&buf)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if header.Version != 2 || header.Width != 80 || header.Height != 24 || header.Timestamp != 1700000000 {
+		t.Errorf("unexpected header: %+v", header)
+	}
+	if header.Env["TERM"] != "xterm" {
+		t.Errorf("expected env to round trip, got %v", header.Env)
+	}
+
+	want := []Event{
+		{Time: 0.5, Type: EventOutput, Data: "hello\r\n"},
+		{Time: 0.75, Type: EventInput, Data: "x"},
+		{Time: 0.75, Type: EventResize, Data: "100x30"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("expected %d events, got %d: %+v", len(want), len(events), events)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("event %d: got %+v, want %+v", i, events[i], want[i])
+		}
+	}
+}
+
+func TestRecordSplitUTF8(t *testing.T) {
+	var buf bytes.Buffer
+	rec, err := NewRecorder(&buf, Header{Width: 80, Height: 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("a⏺b")
+	rec.Output(data[:2]) // "a" and the first byte of ⏺
+	rec.Output(data[2:])
+
+	_, events, err := Read(&buf)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	var got strings.Builder
+	for _, e := range events {
+		got.WriteString(e.Data)
+	}
+	if got.String() != "a⏺b" {
+		t.Errorf("expected characters to be kept whole, got %q", got.String())
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	bad := []string{
+		"",
+		"not json\n",
+		`{"version": 1, "width": 80, "height": 24}` + "\n",
+		`{"version": 2, "width": 80, "height": 24}` + "\n" + `[1.0, "o"]` + "\n",
+	}
+	for _, input := range bad {
+		if _, _, err := Read(strings.NewReader(input)); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
+func TestPlay(t *testing.T) {
+	events := []Event{
+		{Time: 1, Type: EventOutput, Data: "a"},
+		{Time: 1.5, Type: EventInput, Data: "ignored"},
+		{Time: 2, Type: EventOutput, Data: "b"},
+		{Time: 12, Type: EventOutput, Data: "c"},
+	}
+
+	var slept []time.Duration
+	opts := PlayOptions{
+		Speed:     2,
+		IdleLimit: 4 * time.Second,
+		sleep: func(ctx context.Context, d time.Duration) error {
+			slept = append(slept, d)
+			return nil
+		},
+	}
+
+	var out bytes.Buffer
+	if err := Play(context.Background(), &out, events, opts); err != nil {
+		t.Fatalf("Play() error = %v", err)
+	}
+	if out.String() != "abc" {
+		t.Errorf("expected output %q, got %q", "abc", out.String())
+	}
+
+	// Delays are halved by the speed, and the 10s pause is capped first
+	want := []time.Duration{500 * time.Millisecond, 500 * time.Millisecond, 2 * time.Second}
+	if len(slept) != len(want) {
+		t.Fatalf("expected sleeps %v, got %v", want, slept)
+	}
+	for i := range want {
+		if slept[i] != want[i] {
+			t.Errorf("sleep %d: got %v, want %v", i, slept[i], want[i])
+		}
+	}
+}
+
+func TestPlayCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	events := []Event{{Time: 5, Type: EventOutput, Data: "late"}}
+	var out bytes.Buffer
+	if err := Play(ctx, &out, events, PlayOptions{}); err == nil {
+		t.Error("expected error from cancelled playback")
+	}
+	if out.Len() != 0 {
+		t.Errorf("expected no output, got %q", out.String())
+	}
+}
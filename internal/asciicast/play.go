@@ -0,0 +1,64 @@
+package asciicast
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// PlayOptions controls playback
+type PlayOptions struct {
+	Speed     float64       // Playback speed multiplier, 1 for real time
+	IdleLimit time.Duration // Cap on pauses between events, 0 for no cap
+
+	// sleep waits for d or until ctx is done. Overridable for tests.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// Play writes the output events to w with the recorded timing. Input and
+// resize events are skipped.
+func Play(ctx context.Context, w io.Writer, events []Event, opts PlayOptions) error {
+	speed := opts.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+	sleep := opts.sleep
+	if sleep == nil {
+		sleep = sleepContext
+	}
+
+	var last float64
+	for _, event := range events {
+		if event.Type != EventOutput {
+			continue
+		}
+
+		delay := time.Duration((event.Time - last) * float64(time.Second))
+		last = event.Time
+		if opts.IdleLimit > 0 && delay > opts.IdleLimit {
+			delay = opts.IdleLimit
+		}
+		delay = time.Duration(float64(delay) / speed)
+		if delay > 0 {
+			if err := sleep(ctx, delay); err != nil {
+				return err
+			}
+		}
+
+		if _, err := io.WriteString(w, event.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,150 @@
+package control
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Client is a connection to a clawde control socket. Calls are serialised, so
+// a Client is safe for concurrent use.
+type Client struct {
+	conn    net.Conn
+	scanner *bufio.Scanner
+
+	mutex  sync.Mutex
+	nextID int64
+	events []Event // Events that arrived while waiting for a response
+}
+
+// Dial connects to a control socket
+func Dial(path string) (*Client, error) {
+	conn, err := net.DialTimeout("unix", path, 2*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	return &Client{conn: conn, scanner: scanner}, nil
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Call sends a request and decodes the result into result, which may be nil
+func (c *Client) Call(method string, params, result interface{}) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.nextID++
+	id := c.nextID
+	rawID := json.RawMessage(strconv.FormatInt(id, 10))
+	req := Request{JSONRPC: "2.0", ID: rawID, Method: method}
+	if params != nil {
+		raw, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		req.Params = raw
+	}
+	line, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if _, err := c.conn.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	for {
+		msg, err := c.read()
+		if err != nil {
+			return err
+		}
+		if msg.event != nil {
+			c.events = append(c.events, *msg.event)
+			continue
+		}
+		resp := msg.response
+		if !bytes.Equal(resp.ID, rawID) {
+			return fmt.Errorf("unexpected response id")
+		}
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if result != nil && len(resp.Result) > 0 {
+			return json.Unmarshal(resp.Result, result)
+		}
+		return nil
+	}
+}
+
+// Subscribe asks the server for events. Use NextEvent to receive them.
+func (c *Client) Subscribe() error {
+	return c.Call(MethodSubscribe, nil, nil)
+}
+
+// NextEvent blocks until an event arrives. Don't call it concurrently with Call.
+func (c *Client) NextEvent() (Event, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.events) > 0 {
+		event := c.events[0]
+		c.events = c.events[1:]
+		return event, nil
+	}
+	for {
+		msg, err := c.read()
+		if err != nil {
+			return Event{}, err
+		}
+		if msg.event != nil {
+			return *msg.event, nil
+		}
+	}
+}
+
+type message struct {
+	response *Response
+	event    *Event
+}
+
+// read reads one message, which is either a response or an event notification
+func (c *Client) read() (message, error) {
+	if !c.scanner.Scan() {
+		if err := c.scanner.Err(); err != nil {
+			return message{}, err
+		}
+		return message{}, errors.New("connection closed")
+	}
+
+	var probe struct {
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+	}
+	line := c.scanner.Bytes()
+	if err := json.Unmarshal(line, &probe); err != nil {
+		return message{}, fmt.Errorf("invalid message from server: %w", err)
+	}
+	if probe.Method == methodEvent {
+		var event Event
+		if err := json.Unmarshal(probe.Params, &event); err != nil {
+			return message{}, fmt.Errorf("invalid event from server: %w", err)
+		}
+		return message{event: &event}, nil
+	}
+
+	var resp Response
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return message{}, fmt.Errorf("invalid response from server: %w", err)
+	}
+	return message{response: &resp}, nil
+}
@@ -0,0 +1,265 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startServer(t *testing.T) *Server {
+	t.Helper()
+	// Unix socket paths have a short length limit, so avoid t.TempDir()
+	dir, err := os.MkdirTemp("", "clawde")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	srv, err := Listen(filepath.Join(dir, "test.sock"))
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+func TestCall(t *testing.T) {
+	srv := startServer(t)
+
+	var sent SendTextParams
+	srv.Handle(MethodSendText, func(params json.RawMessage) (interface{}, error) {
+		if err := DecodeParams(params, &sent); err != nil {
+			return nil, err
+		}
+		return true, nil
+	})
+	srv.Handle(MethodGetState, func(params json.RawMessage) (interface{}, error) {
+		return State{VimMode: "insert", Idle: true}, nil
+	})
+	srv.Handle(MethodSubmit, func(params json.RawMessage) (interface{}, error) {
+		return nil, errors.New("broken")
+	})
+	go srv.Serve()
+
+	client, err := Dial(srv.Path())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer client.Close()
+
+	if err := client.Call(MethodSendText, SendTextParams{Text: "hello", Submit: true}, nil); err != nil {
+		t.Fatalf("send_text error = %v", err)
+	}
+	if sent.Text != "hello" || !sent.Submit {
+		t.Errorf("handler got %+v", sent)
+	}
+
+	var state State
+	if err := client.Call(MethodGetState, nil, &state); err != nil {
+		t.Fatalf("get_state error = %v", err)
+	}
+	if state.VimMode != "insert" || !state.Idle {
+		t.Errorf("unexpected state %+v", state)
+	}
+
+	var rpcErr *Error
+	err = client.Call("nope", nil, nil)
+	if !errors.As(err, &rpcErr) || rpcErr.Code != CodeMethodNotFound {
+		t.Errorf("expected method not found, got %v", err)
+	}
+	err = client.Call(MethodSendText, nil, nil)
+	if !errors.As(err, &rpcErr) || rpcErr.Code != CodeInvalidParams {
+		t.Errorf("expected invalid params, got %v", err)
+	}
+	err = client.Call(MethodSubmit, nil, nil)
+	if !errors.As(err, &rpcErr) || rpcErr.Code != CodeInternalError {
+		t.Errorf("expected internal error, got %v", err)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	srv := startServer(t)
+	go srv.Serve()
+
+	client, err := Dial(srv.Path())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer client.Close()
+
+	if err := client.Subscribe(); err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	srv.Publish(NewEvent(EventPromptSent, "fix the bug"))
+
+	event, err := client.NextEvent()
+	if err != nil {
+		t.Fatalf("NextEvent() error = %v", err)
+	}
+	var text string
+	json.Unmarshal(event.Data, &text)
+	if event.Type != EventPromptSent || text != "fix the bug" {
+		t.Errorf("unexpected event %+v", event)
+	}
+}
+
+func TestSubscriberThatNeverReads(t *testing.T) {
+	srv := startServer(t)
+	go srv.Serve()
+
+	conn, err := net.Dial("unix", srv.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Subscribe without an id, so there's no response to read
+	conn.Write([]byte(`{"jsonrpc": "2.0", "method": "subscribe"}` + "\n"))
+	deadline := time.Now().Add(time.Second)
+	for subscriberCount(srv) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Enough events to fill the socket buffer and the subscriber's queue
+	done := make(chan struct{})
+	go func() {
+		big := strings.Repeat("x", 16*1024)
+		for i := 0; i < 2*eventBuffer; i++ {
+			srv.Publish(NewEvent(EventPromptSent, big))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish blocked on a subscriber that isn't reading")
+	}
+	if n := subscriberCount(srv); n != 0 {
+		t.Errorf("expected the subscriber to be disconnected, %d left", n)
+	}
+}
+
+func subscriberCount(srv *Server) int {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	return len(srv.subscribers)
+}
+
+func TestInvalidJSON(t *testing.T) {
+	srv := startServer(t)
+	go srv.Serve()
+
+	conn, err := net.Dial("unix", srv.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("{not json\n"))
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if resp.Error == nil || resp.Error.Code != CodeParseError {
+		t.Errorf("expected parse error, got %+v", resp)
+	}
+}
+
+func TestStringIDIsEchoed(t *testing.T) {
+	srv := startServer(t)
+	srv.Handle("ping", func(params json.RawMessage) (interface{}, error) { return "pong", nil })
+	go srv.Serve()
+
+	conn, err := net.Dial("unix", srv.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte(`{"jsonrpc": "2.0", "id": "req-1", "method": "ping"}` + "\n"))
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if resp.Error != nil || string(resp.ID) != `"req-1"` || string(resp.Result) != `"pong"` {
+		t.Errorf("unexpected response id %s, result %s, error %v", resp.ID, resp.Result, resp.Error)
+	}
+}
+
+func TestPrivateDir(t *testing.T) {
+	base := t.TempDir()
+	if err := privateDir(filepath.Join(base, "new")); err != nil {
+		t.Errorf("expected a new directory to be accepted, got %v", err)
+	}
+
+	open := filepath.Join(base, "open")
+	os.Mkdir(open, 0755)
+	os.Chmod(open, 0755)
+	if err := privateDir(open); err == nil {
+		t.Error("expected a directory others can read to be refused")
+	}
+
+	link := filepath.Join(base, "link")
+	os.Symlink(filepath.Join(base, "new"), link)
+	if err := privateDir(link); err == nil {
+		t.Error("expected a symlink to be refused")
+	}
+}
+
+func TestListenReplacesStaleSocket(t *testing.T) {
+	srv := startServer(t)
+	path := srv.Path()
+
+	// In use: refuse to take over
+	go srv.Serve()
+	if _, err := Listen(path); err == nil {
+		t.Fatal("expected error for socket in use")
+	}
+
+	// Left behind without a listener: replace it
+	srv.Close()
+	os.WriteFile(path, nil, 0600)
+	srv2, err := Listen(path)
+	if err != nil {
+		t.Fatalf("expected stale socket to be replaced, got %v", err)
+	}
+	srv2.Close()
+}
+
+func TestRegistry(t *testing.T) {
+	srv := startServer(t)
+	go srv.Serve()
+	dir := filepath.Dir(srv.Path())
+
+	unregister, err := register(dir, SessionInfo{PID: os.Getpid(), Socket: srv.Path(), Dir: "/src"})
+	if err != nil {
+		t.Fatalf("register() error = %v", err)
+	}
+
+	// A session whose socket has gone is pruned
+	stale := SessionInfo{PID: os.Getpid(), Socket: filepath.Join(dir, "gone.sock")}
+	data, _ := json.Marshal(stale)
+	stalePath := filepath.Join(dir, "1.json")
+	os.WriteFile(stalePath, data, 0600)
+
+	sessions, err := listSessions(dir)
+	if err != nil {
+		t.Fatalf("listSessions() error = %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].Socket != srv.Path() || sessions[0].Dir != "/src" {
+		t.Errorf("unexpected sessions %+v", sessions)
+	}
+	if _, err := os.Stat(stalePath); !os.IsNotExist(err) {
+		t.Error("expected stale entry to be removed")
+	}
+
+	unregister()
+	sessions, _ = listSessions(dir)
+	if len(sessions) != 0 {
+		t.Errorf("expected no sessions after unregister, got %+v", sessions)
+	}
+}
@@ -0,0 +1,46 @@
+package control
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// RuntimeDir returns the directory for control sockets: $XDG_RUNTIME_DIR/clawde
+// if set, otherwise a per-user directory under the system temp dir
+func RuntimeDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "clawde")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("clawde-%d", os.Getuid()))
+}
+
+// SocketPath returns the control socket path for the clawde process with the
+// given pid
+func SocketPath(pid int) string {
+	return filepath.Join(RuntimeDir(), fmt.Sprintf("%d.sock", pid))
+}
+
+// privateDir creates dir if needed and checks that it is a real directory
+// owned by the current user, which nobody else can use. Under the shared temp
+// dir, another user could otherwise create it first and intercept sockets.
+func privateDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("%s is owned by another user", dir)
+	}
+	if perm := info.Mode().Perm(); perm != 0700 {
+		return fmt.Errorf("%s has mode %#o, expected 0700", dir, perm)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+// Package control is the protocol for driving a running clawde session over
+// a Unix domain socket.
+//
+// Messages are JSON-RPC 2.0, one JSON object per line. Clients send requests
+// and get one response per request. After a successful "subscribe" request
+// the server also sends "event" notifications on that connection.
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Methods
+const (
+	MethodSendText             = "send_text"
+	MethodSubmit               = "submit"
+	MethodSendKeys             = "send_keys"
+	MethodGetScreen            = "get_screen"
+	MethodGetState             = "get_state"
+	MethodTriggerCommentSearch = "trigger_comment_search"
+	MethodListComments         = "list_comments"
+	MethodSubscribe            = "subscribe"
+
+	// methodEvent is the notification sent to subscribers
+	methodEvent = "event"
+)
+
+// JSON-RPC error codes
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// Request is a JSON-RPC request. Notifications from the server use the same
+// shape without an ID. The ID can be a number or a string and is echoed back
+// unchanged.
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// Response is a JSON-RPC response
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"` // null if the request couldn't be read
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Error is a JSON-RPC error
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// Errorf creates an error with a JSON-RPC error code, for handlers to return
+func Errorf(code int, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// SendTextParams are the parameters for send_text
+type SendTextParams struct {
+	Text   string `json:"text"`
+	Submit bool   `json:"submit,omitempty"` // Press enter afterwards
+}
+
+// SendKeysParams are the parameters for send_keys. Keys use the same names as
+// the keymap, e.g. "C-a", "esc", "up".
+type SendKeysParams struct {
+	Keys []string `json:"keys"`
+}
+
+// Screen is the result of get_screen
+type Screen struct {
+	Cols       int      `json:"cols"`
+	Rows       int      `json:"rows"`
+	Lines      []string `json:"lines"`
+	CursorX    int      `json:"cursor_x"`
+	CursorY    int      `json:"cursor_y"`
+	Alternate  bool     `json:"alternate"`
+	Scrollback []string `json:"scrollback,omitempty"`
+}
+
+// GetScreenParams are the parameters for get_screen
+type GetScreenParams struct {
+	Scrollback bool `json:"scrollback,omitempty"` // Include lines that have scrolled off
+}
+
+// State is the result of get_state
+type State struct {
+	VimMode          string `json:"vim_mode"`
+	PermissionPrompt bool   `json:"permission_prompt"`
+	Busy             bool   `json:"busy"`
+	Idle             bool   `json:"idle"`
+	SlashMenu        bool   `json:"slash_menu"`
+	FileWatching     bool   `json:"file_watching"`
+	OutputThrottling bool   `json:"output_throttling"`
+}
+
+// Comment states. A comment sent to claude moves from queued to sent, then to
+// in-progress once claude starts working, and ends up resolved when its marker
+// is replaced with [ai] or abandoned if that doesn't happen in time. It is
+// removed instead if the marker goes some other way, e.g. the comment is
+// deleted. A queued comment is stuck if claude hasn't looked ready for a
+// prompt in time; it's still sent once it does.
+const (
+	CommentQueued     = "queued"
+	CommentStuck      = "stuck"
+	CommentSent       = "sent"
+	CommentInProgress = "in-progress"
+	CommentResolved   = "resolved"
+	CommentAbandoned  = "abandoned"
+	CommentRemoved    = "removed"
+)
+
+// CommentStatus is an AI comment that clawde has sent, as returned by
+// list_comments
+type CommentStatus struct {
+	File       string    `json:"file"`
+	Line       int       `json:"line"`
+	EndLine    int       `json:"end_line,omitempty"`
+	Content    string    `json:"content"`
+	ActionType string    `json:"action_type"`
+	State      string    `json:"state"`
+	Queued     time.Time `json:"queued"`
+	Updated    time.Time `json:"updated"`
+}
+
+// ListCommentsParams are the parameters for list_comments
+type ListCommentsParams struct {
+	All bool `json:"all,omitempty"` // Include resolved and abandoned comments
+}
+
+// Event types
+const (
+	EventState        = "state"         // The screen state changed, Data is a State
+	EventPromptSent   = "prompt_sent"   // clawde sent a prompt, Data is the text
+	EventCommentQuery = "comment_query" // A comment search ran, Data is the number of comments found
+	EventToggle       = "toggle"        // A feature was toggled, Data is {"feature", "enabled"}
+	EventComment      = "comment"       // A sent comment changed state, Data is a CommentStatus
+	EventExit         = "exit"          // The wrapped program exited
+)
+
+// Event is sent to subscribers
+type Event struct {
+	Type string          `json:"type"`
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// NewEvent creates an event with data encoded as JSON
+func NewEvent(eventType string, data interface{}) Event {
+	event := Event{Type: eventType, Time: time.Now()}
+	if data != nil {
+		if raw, err := json.Marshal(data); err == nil {
+			event.Data = raw
+		}
+	}
+	return event
+}
@@ -0,0 +1,113 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// SessionInfo describes a running clawde session. Each session writes one to
+// the runtime directory so that clients can find it.
+type SessionInfo struct {
+	PID      int       `json:"pid"`
+	Socket   string    `json:"socket"`
+	Dir      string    `json:"dir"` // Working directory
+	Started  time.Time `json:"started"`
+	Version  string    `json:"version"`
+	TmuxPane string    `json:"tmux_pane,omitempty"`
+}
+
+func infoPath(dir string, pid int) string {
+	return filepath.Join(dir, fmt.Sprintf("%d.json", pid))
+}
+
+// Register writes the session's info to the runtime directory. The returned
+// function removes it again.
+func Register(info SessionInfo) (func(), error) {
+	return register(RuntimeDir(), info)
+}
+
+func register(dir string, info SessionInfo) (func(), error) {
+	if err := privateDir(dir); err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	// Write then rename so readers never see a partial file
+	path := infoPath(dir, info.PID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	return func() { os.Remove(path) }, nil
+}
+
+// ListSessions returns the running sessions, oldest first. Entries left
+// behind by sessions that have exited are removed.
+func ListSessions() ([]SessionInfo, error) {
+	return listSessions(RuntimeDir())
+}
+
+func listSessions(dir string) ([]SessionInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var sessions []SessionInfo
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var info SessionInfo
+		if err := json.Unmarshal(data, &info); err != nil {
+			continue
+		}
+		if !isAlive(info) {
+			os.Remove(path)
+			continue
+		}
+		sessions = append(sessions, info)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Started.Before(sessions[j].Started)
+	})
+	return sessions, nil
+}
+
+// isAlive checks that the session's process exists and its socket accepts
+// connections
+func isAlive(info SessionInfo) bool {
+	if info.PID <= 0 || syscall.Kill(info.PID, 0) == syscall.ESRCH {
+		return false
+	}
+	conn, err := net.DialTimeout("unix", info.Socket, 500*time.Millisecond)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
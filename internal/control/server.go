@@ -0,0 +1,270 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// eventBuffer is how many events can wait for a subscriber before it is
+	// disconnected for not keeping up
+	eventBuffer = 256
+
+	// writeTimeout is how long a write to a client can take before the
+	// connection is closed
+	writeTimeout = 5 * time.Second
+)
+
+// HandlerFunc handles one request. params is nil if the request had none.
+// Returning an *Error sets the JSON-RPC error code; other errors are reported
+// as internal errors.
+type HandlerFunc func(params json.RawMessage) (interface{}, error)
+
+// Server accepts control connections on a Unix socket
+type Server struct {
+	path     string
+	listener net.Listener
+	handlers map[string]HandlerFunc
+
+	mutex       sync.Mutex
+	conns       map[*serverConn]bool
+	subscribers map[*serverConn]bool
+	closed      bool
+}
+
+type serverConn struct {
+	conn       net.Conn
+	writeMutex sync.Mutex
+	enc        *json.Encoder
+
+	events chan Request  // Events waiting to be written, once subscribed
+	done   chan struct{} // Closed when the connection ends
+}
+
+func (c *serverConn) send(v interface{}) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return c.enc.Encode(v)
+}
+
+// writeEvents sends a subscriber its events, so that Publish never waits for
+// a client
+func (c *serverConn) writeEvents() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case event := <-c.events:
+			if err := c.send(event); err != nil {
+				c.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// Listen creates the socket, replacing a stale one left by a previous run.
+// The socket's directory is created if needed, and it must belong to the
+// current user with no access for anyone else.
+func Listen(path string) (*Server, error) {
+	if err := privateDir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err == nil {
+		// A socket nobody is listening on is left over from a crash
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, errors.New("control socket already in use: " + path)
+		}
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return &Server{
+		path:        path,
+		listener:    listener,
+		handlers:    make(map[string]HandlerFunc),
+		conns:       make(map[*serverConn]bool),
+		subscribers: make(map[*serverConn]bool),
+	}, nil
+}
+
+// Path returns the socket path
+func (s *Server) Path() string {
+	return s.path
+}
+
+// Handle registers the handler for a method. Register handlers before calling
+// Serve. The subscribe method is handled by the server itself.
+func (s *Server) Handle(method string, handler HandlerFunc) {
+	s.handlers[method] = handler
+}
+
+// Serve accepts connections until Close is called
+func (s *Server) Serve() error {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			s.mutex.Lock()
+			closed := s.closed
+			s.mutex.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		sc := &serverConn{
+			conn:   conn,
+			enc:    json.NewEncoder(conn),
+			events: make(chan Request, eventBuffer),
+			done:   make(chan struct{}),
+		}
+		s.mutex.Lock()
+		s.conns[sc] = true
+		s.mutex.Unlock()
+		go s.serveConn(sc)
+	}
+}
+
+// Publish queues an event for every subscribed connection. It doesn't block:
+// a subscriber that has fallen too far behind is disconnected.
+func (s *Server) Publish(event Event) {
+	s.mutex.Lock()
+	subscribers := make([]*serverConn, 0, len(s.subscribers))
+	for sc := range s.subscribers {
+		subscribers = append(subscribers, sc)
+	}
+	s.mutex.Unlock()
+
+	params, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	notification := Request{JSONRPC: "2.0", Method: methodEvent, Params: params}
+	for _, sc := range subscribers {
+		select {
+		case sc.events <- notification:
+		default:
+			s.mutex.Lock()
+			delete(s.subscribers, sc)
+			s.mutex.Unlock()
+			sc.conn.Close()
+		}
+	}
+}
+
+// Close stops the server, closes all connections and removes the socket
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	s.closed = true
+	for sc := range s.conns {
+		sc.conn.Close()
+	}
+	s.mutex.Unlock()
+
+	err := s.listener.Close()
+	os.Remove(s.path)
+	return err
+}
+
+func (s *Server) serveConn(sc *serverConn) {
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, sc)
+		delete(s.subscribers, sc)
+		s.mutex.Unlock()
+		close(sc.done)
+		sc.conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(sc.conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		resp := s.dispatch(sc, line)
+		if resp == nil {
+			continue // Notification from the client: no response
+		}
+		if err := sc.send(resp); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) dispatch(sc *serverConn, line []byte) *Response {
+	var req Request
+	if err := json.Unmarshal(line, &req); err != nil {
+		return errorResponse(nil, Errorf(CodeParseError, "invalid JSON: %v", err))
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return errorResponse(req.ID, Errorf(CodeInvalidRequest, "invalid request"))
+	}
+
+	var result interface{}
+	var err error
+	if req.Method == MethodSubscribe {
+		s.mutex.Lock()
+		if !s.subscribers[sc] {
+			s.subscribers[sc] = true
+			go sc.writeEvents()
+		}
+		s.mutex.Unlock()
+		result = true
+	} else if handler, ok := s.handlers[req.Method]; ok {
+		result, err = handler(req.Params)
+	} else {
+		err = Errorf(CodeMethodNotFound, "unknown method %q", req.Method)
+	}
+
+	if len(req.ID) == 0 {
+		return nil
+	}
+	if err != nil {
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) {
+			rpcErr = Errorf(CodeInternalError, "%v", err)
+		}
+		return errorResponse(req.ID, rpcErr)
+	}
+
+	raw, err := json.Marshal(result)
+	if err != nil {
+		return errorResponse(req.ID, Errorf(CodeInternalError, "failed to encode result: %v", err))
+	}
+	return &Response{JSONRPC: "2.0", ID: req.ID, Result: raw}
+}
+
+func errorResponse(id json.RawMessage, err *Error) *Response {
+	return &Response{JSONRPC: "2.0", ID: id, Error: err}
+}
+
+// DecodeParams decodes request parameters, reporting bad input as an invalid
+// params error
+func DecodeParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 {
+		return Errorf(CodeInvalidParams, "missing params")
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return Errorf(CodeInvalidParams, "invalid params: %v", err)
+	}
+	return nil
+}
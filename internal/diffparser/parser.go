@@ -46,8 +46,25 @@ var (
 	breakPattern = regexp.MustCompile(`^\s*\.\.\.`)
 	// Matches: end of diff markers (other tool calls, thinking marker)
 	endPattern = regexp.MustCompile(`^∴|^⏺ [^UW]`)
+	// Matches: any tool call, e.g. ⏺ Bash(go test ./...) or ⏺ github - get_issue (MCP)(number: 1)
+	toolCallPattern = regexp.MustCompile(`^⏺ (\w+|[^()]+ \(MCP\))\((.*)\)\s*$`)
 )
 
+// ParseToolCall reports whether a line of Claude's output starts a tool call,
+// and if so returns the tool name and its arguments
+func ParseToolCall(line string) (tool, args string, ok bool) {
+	m := toolCallPattern.FindStringSubmatch(line)
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
+// IsDiffTool reports whether a tool's output is a diff that Parse understands
+func IsDiffTool(tool string) bool {
+	return tool == "Update" || tool == "Write"
+}
+
 // Parse extracts file diffs from Claude's terminal output
 func Parse(content string) []FileDiff {
 	var diffs []FileDiff
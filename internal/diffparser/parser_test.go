@@ -533,3 +533,27 @@ func TestParseWriteWithBreaks(t *testing.T) {
 		t.Errorf("expected second hunk to start at line 61, got %d", d.Hunks[1].StartLine)
 	}
 }
+
+func TestParseToolCall(t *testing.T) {
+	tests := []struct {
+		line     string
+		wantTool string
+		wantArgs string
+		wantOK   bool
+	}{
+		{"⏺ Update(/path/to/file.go)", "Update", "/path/to/file.go", true},
+		{"⏺ Bash(go test ./...)", "Bash", "go test ./...", true},
+		{"⏺ github - get_issue (MCP)(number: 1)", "github - get_issue (MCP)", "number: 1", true},
+		{"⏺ I'll fix the bug (see below)", "", "", false},
+		{"⏺ Done.", "", "", false},
+		{"  ⎿  Added 1 line", "", "", false},
+	}
+
+	for _, tt := range tests {
+		tool, args, ok := ParseToolCall(tt.line)
+		if ok != tt.wantOK || tool != tt.wantTool || args != tt.wantArgs {
+			t.Errorf("ParseToolCall(%q) = %q, %q, %v; want %q, %q, %v",
+				tt.line, tool, args, ok, tt.wantTool, tt.wantArgs, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,447 @@
+// Package gitignore decides whether paths are ignored by .gitignore files,
+// without running git. It follows git's rules: patterns from deeper
+// directories take precedence, the last matching pattern wins, "!" re-includes
+// a path, a trailing "/" only matches directories, and nothing inside an
+// ignored directory can be re-included.
+//
+// Patterns are also read from .git/info/exclude, git's core.excludesFile, and
+// .clawdeignore files, which use the same syntax and take precedence over the
+// .gitignore in the same directory. A nested repository, such as a submodule,
+// has its own rules, and those of the repository around it don't apply.
+package gitignore
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/mattduck/clawde/internal/gitrepo"
+)
+
+// Files is the per-directory ignore files, in increasing precedence
+var Files = []string{".gitignore", ".clawdeignore"}
+
+// pattern is one line of an ignore file
+type pattern struct {
+	re       *regexp.Regexp
+	negate   bool
+	dirOnly  bool
+	nameOnly bool // Matched against the name at any depth, since it has no "/"
+}
+
+func (p pattern) match(rel, name string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if p.nameOnly {
+		return p.re.MatchString(name)
+	}
+	return p.re.MatchString(rel)
+}
+
+// Matcher checks paths against the ignore files of a repository. Patterns are
+// read as they are needed and cached until Changed reports their file has
+// changed. It is safe for concurrent use.
+type Matcher struct {
+	root string
+
+	mutex        sync.Mutex
+	global       []pattern            // core.excludesFile, then .git/info/exclude
+	globalLoaded bool                 // global has been read
+	globalFiles  []string             // Files global was read from, or would be if they existed
+	dirs         map[string][]pattern // By slash-separated directory relative to root, "" for root
+	repos        map[string]*Matcher  // Nested repositories by directory, nil for directories that aren't
+}
+
+// New returns a Matcher for the repository, or plain directory, at root
+func New(root string) *Matcher {
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	}
+	return &Matcher{
+		root:  root,
+		dirs:  make(map[string][]pattern),
+		repos: make(map[string]*Matcher),
+	}
+}
+
+// Root returns the directory paths are matched relative to
+func (m *Matcher) Root() string {
+	return m.root
+}
+
+// Ignored reports whether a path is ignored, either itself or because a
+// directory it's in is. Paths outside the root are never ignored.
+func (m *Matcher) Ignored(path string, isDir bool) bool {
+	rel, ok := m.rel(path)
+	if !ok || rel == "" {
+		return false
+	}
+	parts := strings.Split(rel, "/")
+	for _, part := range parts {
+		if part == ".git" {
+			return true
+		}
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for i := 1; i <= len(parts); i++ {
+		if m.match(parts[:i], i < len(parts) || isDir) {
+			return true
+		}
+		if i < len(parts) {
+			if nested := m.nested(strings.Join(parts[:i], "/")); nested != nil {
+				return nested.Ignored(path, isDir)
+			}
+		}
+	}
+	return false
+}
+
+// nested returns the Matcher for the repository at dir, or nil if dir isn't
+// one. Called with the lock held.
+func (m *Matcher) nested(dir string) *Matcher {
+	nested, ok := m.repos[dir]
+	if !ok {
+		path := filepath.Join(m.root, filepath.FromSlash(dir))
+		if gitrepo.IsRoot(path) {
+			nested = New(path)
+		}
+		m.repos[dir] = nested
+	}
+	return nested
+}
+
+// match checks one path against the patterns that apply to it, without
+// considering its parent directories. Called with the lock held.
+func (m *Matcher) match(parts []string, isDir bool) bool {
+	name := parts[len(parts)-1]
+	ignored := false
+	check := func(patterns []pattern, rel string) {
+		for _, p := range patterns {
+			if p.match(rel, name, isDir) {
+				ignored = !p.negate
+			}
+		}
+	}
+
+	if !m.globalLoaded {
+		m.global = m.loadGlobal()
+		m.globalLoaded = true
+	}
+	check(m.global, strings.Join(parts, "/"))
+	for i := 0; i < len(parts); i++ {
+		dir := strings.Join(parts[:i], "/")
+		patterns, ok := m.dirs[dir]
+		if !ok {
+			patterns = m.loadDir(dir)
+			m.dirs[dir] = patterns
+		}
+		check(patterns, strings.Join(parts[i:], "/"))
+	}
+	return ignored
+}
+
+// Changed drops the cached patterns of an ignore file that has changed, and
+// reports whether path was one. A .git directory or file appearing or going
+// also counts, since it changes which rules apply.
+func (m *Matcher) Changed(path string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	changed := false
+	for _, nested := range m.repos {
+		if nested != nil && nested.Changed(path) {
+			changed = true
+		}
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return changed
+	}
+	for _, file := range m.globalFiles {
+		if abs == file {
+			m.globalLoaded = false
+			return true
+		}
+	}
+	rel, ok := m.rel(abs)
+	if !ok || rel == "" {
+		return changed
+	}
+	dir := filepath.ToSlash(filepath.Dir(filepath.FromSlash(rel)))
+	if dir == "." {
+		dir = ""
+	}
+	switch {
+	case rel == ".git" || rel == ".git/config" || rel == ".git/info/exclude":
+		m.globalLoaded = false
+		return true
+	case filepath.Base(abs) == ".git":
+		delete(m.repos, dir)
+		return true
+	case IsIgnoreFile(abs):
+		delete(m.dirs, dir)
+		return true
+	}
+	return changed
+}
+
+// IsIgnoreFile reports whether path is a per-directory ignore file
+func IsIgnoreFile(path string) bool {
+	base := filepath.Base(path)
+	for _, name := range Files {
+		if base == name {
+			return true
+		}
+	}
+	return false
+}
+
+// rel returns path relative to the root, with forward slashes
+func (m *Matcher) rel(path string) (string, bool) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(m.root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	if rel == "." {
+		return "", true
+	}
+	return filepath.ToSlash(rel), true
+}
+
+func (m *Matcher) loadDir(dir string) []pattern {
+	var patterns []pattern
+	for _, name := range Files {
+		patterns = append(patterns, readPatterns(filepath.Join(m.root, filepath.FromSlash(dir), name))...)
+	}
+	return patterns
+}
+
+// loadGlobal reads the patterns that apply to the whole repository. Called
+// with the lock held.
+func (m *Matcher) loadGlobal() []pattern {
+	config, exclude := "", ""
+	if gitDir := gitrepo.Dir(m.root); gitDir != "" {
+		common := gitrepo.CommonDir(gitDir)
+		config = filepath.Join(common, "config")
+		exclude = filepath.Join(common, "info", "exclude")
+	}
+	excludes := excludesFile(config)
+	m.globalFiles = nil
+	for _, file := range []string{config, excludes, exclude} {
+		if file != "" {
+			m.globalFiles = append(m.globalFiles, file)
+		}
+	}
+
+	var patterns []pattern
+	if excludes != "" {
+		patterns = append(patterns, readPatterns(excludes)...)
+	}
+	if exclude != "" {
+		patterns = append(patterns, readPatterns(exclude)...)
+	}
+	return patterns
+}
+
+// readPatterns parses an ignore file. A file that can't be read has no
+// patterns.
+func readPatterns(path string) []pattern {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var patterns []pattern
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if p, ok := parsePattern(scanner.Text()); ok {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// parsePattern parses one line of an ignore file. Blank lines and comments
+// aren't patterns.
+func parsePattern(line string) (pattern, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	line = trimTrailingSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return pattern{}, false
+	}
+
+	var p pattern
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return pattern{}, false
+	}
+	// A "/" at the start or in the middle anchors the pattern to the
+	// directory of the ignore file
+	p.nameOnly = !strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	re, err := regexp.Compile("^" + globToRegexp(line) + "$")
+	if err != nil {
+		return pattern{}, false
+	}
+	p.re = re
+	return p, true
+}
+
+// trimTrailingSpace removes trailing spaces that aren't escaped with a
+// backslash
+func trimTrailingSpace(line string) string {
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+// globToRegexp converts a gitignore glob to a regular expression. "*", "?"
+// and character classes don't match "/", but "**" between slashes matches
+// any number of directories.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		atSegmentStart := i == 0 || glob[i-1] == '/'
+		switch c := glob[i]; {
+		case atSegmentStart && strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case atSegmentStart && glob[i:] == "**":
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case c == '[':
+			end := classEnd(glob, i)
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : end]
+			b.WriteString("[")
+			if strings.HasPrefix(class, "!") || strings.HasPrefix(class, "^") {
+				b.WriteString("^/")
+				class = class[1:]
+			}
+			b.WriteString(strings.ReplaceAll(class, `\`, `\\`))
+			b.WriteString("]")
+			i = end
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// classEnd returns the index of the "]" closing the character class that
+// starts at i, or -1 if it isn't closed
+func classEnd(glob string, i int) int {
+	j := i + 1
+	if j < len(glob) && (glob[j] == '!' || glob[j] == '^') {
+		j++
+	}
+	if j < len(glob) && glob[j] == ']' {
+		j++ // A "]" first in the class is part of it
+	}
+	for ; j < len(glob); j++ {
+		if glob[j] == ']' {
+			return j
+		}
+	}
+	return -1
+}
+
+// excludesFile returns the path of git's global ignore file: core.excludesFile
+// from the repository config, if there is one, or the user git config, or else
+// the default location
+func excludesFile(repoConfig string) string {
+	home, _ := os.UserHomeDir()
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" && home != "" {
+		configHome = filepath.Join(home, ".config")
+	}
+
+	// Later files take precedence
+	var configs []string
+	if repoConfig != "" {
+		configs = append(configs, repoConfig)
+	}
+	if home != "" {
+		configs = append([]string{filepath.Join(home, ".gitconfig")}, configs...)
+	}
+	if configHome != "" {
+		configs = append([]string{filepath.Join(configHome, "git", "config")}, configs...)
+	}
+	path := ""
+	for _, config := range configs {
+		if value := configValue(config, "core", "excludesfile"); value != "" {
+			path = value
+		}
+	}
+
+	if path == "" {
+		if configHome == "" {
+			return ""
+		}
+		return filepath.Join(configHome, "git", "ignore")
+	}
+	if rest, ok := strings.CutPrefix(path, "~/"); ok && home != "" {
+		path = filepath.Join(home, rest)
+	}
+	return path
+}
+
+// configValue reads a key from a git config file. It handles the simple
+// "[section]" and "key = value" lines that excludesFile is set with.
+func configValue(path, section, key string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	value := ""
+	inSection := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			name := strings.TrimSpace(strings.Trim(line, "[]"))
+			inSection = strings.EqualFold(name, section)
+			continue
+		}
+		if k, v, ok := strings.Cut(line, "="); ok && inSection && strings.EqualFold(strings.TrimSpace(k), key) {
+			value = strings.Trim(strings.TrimSpace(v), `"`)
+		}
+	}
+	return value
+}
@@ -0,0 +1,182 @@
+package gitignore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// isolate stops the user's own git config from affecting a test
+func isolate(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+}
+
+func TestIgnored(t *testing.T) {
+	isolate(t)
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, ".gitignore"), `# build output
+*.log
+!keep.log
+build/
+/only-root.txt
+docs/*.html
+**/generated/**
+a/**/z.txt
+secret/
+!secret/ok.txt
+`+"trailing\\  \n")
+	writeFile(t, filepath.Join(root, "sub", ".gitignore"), "!*.log\nlocal.txt\n")
+	writeFile(t, filepath.Join(root, "sub", ".clawdeignore"), "local.txt\n!local.txt\nfixtures/\n")
+	writeFile(t, filepath.Join(root, ".git", "info", "exclude"), "excluded.go\n")
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{path: "main.go", want: false},
+		{path: "debug.log", want: true},
+		{path: "deep/nested/debug.log", want: true},
+		{path: "keep.log", want: false},
+		{path: "build", isDir: true, want: true},
+		{path: "build", want: false}, // Only directories match "build/"
+		{path: "build/out.go", want: true},
+		{path: "x/build/out.go", want: true},
+		{path: "only-root.txt", want: true},
+		{path: "x/only-root.txt", want: false},
+		{path: "docs/index.html", want: true},
+		{path: "docs/api/index.html", want: false},
+		{path: "x/generated/a/b.go", want: true},
+		{path: "a/z.txt", want: true},
+		{path: "a/b/c/z.txt", want: true},
+		{path: "secret/ok.txt", want: true}, // Can't re-include inside an ignored directory
+		{path: "trailing ", want: true},
+		{path: "sub/debug.log", want: false}, // Deeper files take precedence
+		{path: "sub/local.txt", want: false}, // .clawdeignore takes precedence over .gitignore
+		{path: "sub/fixtures/a.go", want: true},
+		{path: "excluded.go", want: true},
+		{path: ".git/HEAD", want: true},
+		{path: "../outside.go", want: false},
+	}
+	m := New(root)
+	for _, tt := range tests {
+		if got := m.Ignored(filepath.Join(root, filepath.FromSlash(tt.path)), tt.isDir); got != tt.want {
+			t.Errorf("Ignored(%q, %v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestExcludesFile(t *testing.T) {
+	isolate(t)
+	root := t.TempDir()
+	global := filepath.Join(os.Getenv("XDG_CONFIG_HOME"), "git", "ignore")
+	writeFile(t, global, "*.swp\n")
+
+	m := New(root)
+	if !m.Ignored(filepath.Join(root, "a.go.swp"), false) {
+		t.Errorf("expected the default global ignore file to be used")
+	}
+
+	custom := filepath.Join(os.Getenv("HOME"), "ignore")
+	writeFile(t, custom, "*.bak\n")
+	writeFile(t, filepath.Join(root, ".git", "config"), "[core]\n\texcludesFile = ~/ignore\n")
+	if !m.Changed(filepath.Join(root, ".git", "config")) {
+		t.Fatalf("expected .git/config to be an ignore file")
+	}
+	if !m.Ignored(filepath.Join(root, "a.go.bak"), false) || m.Ignored(filepath.Join(root, "a.go.swp"), false) {
+		t.Errorf("expected core.excludesFile to replace the default")
+	}
+}
+
+func TestChanged(t *testing.T) {
+	isolate(t)
+	root := t.TempDir()
+	ignore := filepath.Join(root, "sub", ".gitignore")
+	writeFile(t, ignore, "*.tmp\n")
+
+	m := New(root)
+	path := filepath.Join(root, "sub", "a.tmp")
+	if !m.Ignored(path, false) {
+		t.Fatalf("expected %s to be ignored", path)
+	}
+
+	// Cached until Changed says otherwise
+	writeFile(t, ignore, "")
+	if !m.Ignored(path, false) {
+		t.Errorf("expected patterns to be cached")
+	}
+	if !m.Changed(ignore) {
+		t.Errorf("expected .gitignore to be an ignore file")
+	}
+	if m.Ignored(path, false) {
+		t.Errorf("expected %s not to be ignored after the change", path)
+	}
+
+	writeFile(t, filepath.Join(root, ".clawdeignore"), "sub/\n")
+	if !m.Changed(filepath.Join(root, ".clawdeignore")) || !m.Ignored(path, false) {
+		t.Errorf("expected a new .clawdeignore to be read")
+	}
+	if m.Changed(filepath.Join(root, "main.go")) {
+		t.Errorf("main.go isn't an ignore file")
+	}
+}
+
+func TestNestedRepositories(t *testing.T) {
+	isolate(t)
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, ".gitignore"), "*.out\n")
+	writeFile(t, filepath.Join(root, ".git", "info", "exclude"), "*.tmp\n")
+	// A submodule, whose exclude file is in the superproject's git directory
+	writeFile(t, filepath.Join(root, ".git", "modules", "sub", "info", "exclude"), "*.gen\n")
+	writeFile(t, filepath.Join(root, "sub", ".git"), "gitdir: ../.git/modules/sub\n")
+	writeFile(t, filepath.Join(root, "sub", ".gitignore"), "local/\n")
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{path: "a.out", want: true},
+		{path: "a.tmp", want: true},
+		{path: "sub/a.out", want: false}, // The outer rules don't apply
+		{path: "sub/a.tmp", want: false},
+		{path: "sub/a.gen", want: true},
+		{path: "sub/local/a.go", want: true},
+		{path: "sub/.git", want: true},
+	}
+	m := New(root)
+	for _, tt := range tests {
+		if got := m.Ignored(filepath.Join(root, filepath.FromSlash(tt.path)), false); got != tt.want {
+			t.Errorf("Ignored(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	// Changes inside the nested repository reach its rules
+	exclude := filepath.Join(root, ".git", "modules", "sub", "info", "exclude")
+	writeFile(t, exclude, "")
+	if !m.Changed(exclude) || m.Ignored(filepath.Join(root, "sub", "a.gen"), false) {
+		t.Errorf("expected the submodule's exclude file to be read again")
+	}
+
+	// A repository created inside the tree gets its own rules
+	inner := filepath.Join(root, "inner")
+	writeFile(t, filepath.Join(inner, "a.out"), "")
+	if !m.Ignored(filepath.Join(inner, "a.out"), false) {
+		t.Fatalf("expected inner/a.out to be ignored before inner is a repository")
+	}
+	writeFile(t, filepath.Join(inner, ".git", "HEAD"), "ref: refs/heads/main\n")
+	if !m.Changed(filepath.Join(inner, ".git")) || m.Ignored(filepath.Join(inner, "a.out"), false) {
+		t.Errorf("expected inner to be a nested repository after its .git appeared")
+	}
+}
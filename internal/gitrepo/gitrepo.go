@@ -0,0 +1,80 @@
+// Package gitrepo finds git repositories and their git directories without
+// running git. It handles worktrees and submodules, where .git is a file
+// pointing to the real git directory.
+package gitrepo
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Root returns the nearest directory at or above dir that has a .git
+// directory or file, and whether there is one
+func Root(dir string) (string, bool) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return dir, false
+	}
+	for d := abs; ; d = filepath.Dir(d) {
+		if IsRoot(d) {
+			return d, true
+		}
+		if filepath.Dir(d) == d {
+			return abs, false
+		}
+	}
+}
+
+// IsRoot reports whether dir is the top of a repository, worktree or
+// submodule
+func IsRoot(dir string) bool {
+	return Dir(dir) != ""
+}
+
+// Dir returns the git directory of the repository at root: root/.git, or
+// the directory a .git file points to. It returns "" if there isn't one.
+func Dir(root string) string {
+	path := filepath.Join(root, ".git")
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	if info.IsDir() {
+		return path
+	}
+
+	// Worktrees and submodules have a file with a line like
+	// "gitdir: ../.git/modules/sub"
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	target, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir:")
+	if !ok {
+		return ""
+	}
+	target = strings.TrimSpace(target)
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(root, target)
+	}
+	if info, err := os.Stat(target); err != nil || !info.IsDir() {
+		return ""
+	}
+	return filepath.Clean(target)
+}
+
+// CommonDir returns the directory holding what a git directory shares with
+// its other worktrees, such as config and info/exclude. For a git directory
+// that isn't a linked worktree's, that's itself.
+func CommonDir(gitDir string) string {
+	data, err := os.ReadFile(filepath.Join(gitDir, "commondir"))
+	if err != nil {
+		return gitDir
+	}
+	dir := strings.TrimSpace(string(data))
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(gitDir, dir)
+	}
+	return filepath.Clean(dir)
+}
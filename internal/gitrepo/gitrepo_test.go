@@ -0,0 +1,75 @@
+package gitrepo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRoot(t *testing.T) {
+	dir := t.TempDir()
+	repo := filepath.Join(dir, "repo")
+	writeFile(t, filepath.Join(repo, ".git", "HEAD"), "ref: refs/heads/main\n")
+	// A submodule's .git is a file pointing into the superproject's git
+	// directory
+	writeFile(t, filepath.Join(repo, ".git", "modules", "sub", "HEAD"), "ref: refs/heads/main\n")
+	writeFile(t, filepath.Join(repo, "sub", ".git"), "gitdir: ../.git/modules/sub\n")
+	writeFile(t, filepath.Join(repo, "sub", "pkg", "a.go"), "")
+	writeFile(t, filepath.Join(repo, "broken", ".git"), "gitdir: ../nowhere\n")
+	writeFile(t, filepath.Join(dir, "plain", "a.go"), "")
+
+	tests := []struct {
+		dir      string
+		wantRoot string
+		wantOK   bool
+		wantDir  string
+	}{
+		{dir: "repo", wantRoot: "repo", wantOK: true, wantDir: "repo/.git"},
+		{dir: "repo/sub/pkg", wantRoot: "repo/sub", wantOK: true, wantDir: "repo/.git/modules/sub"},
+		{dir: "repo/broken", wantRoot: "repo", wantOK: true, wantDir: "repo/.git"},
+		{dir: "plain", wantRoot: "plain", wantOK: false},
+	}
+	for _, tt := range tests {
+		root, ok := Root(filepath.Join(dir, tt.dir))
+		if root != filepath.Join(dir, tt.wantRoot) || ok != tt.wantOK {
+			t.Errorf("Root(%s) = %s, %v, want %s, %v", tt.dir, root, ok, tt.wantRoot, tt.wantOK)
+			continue
+		}
+		wantDir := ""
+		if tt.wantDir != "" {
+			wantDir = filepath.Join(dir, tt.wantDir)
+		}
+		if got := Dir(root); got != wantDir {
+			t.Errorf("Dir(%s) = %q, want %q", tt.wantRoot, got, wantDir)
+		}
+	}
+}
+
+func TestCommonDir(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "main", ".git")
+	linked := filepath.Join(main, "worktrees", "feature")
+	writeFile(t, filepath.Join(linked, "commondir"), "../..\n")
+	writeFile(t, filepath.Join(dir, "feature", ".git"), "gitdir: "+linked+"\n")
+
+	gitDir := Dir(filepath.Join(dir, "feature"))
+	if gitDir != linked {
+		t.Fatalf("Dir() = %q, want %q", gitDir, linked)
+	}
+	if got := CommonDir(gitDir); got != main {
+		t.Errorf("CommonDir() = %q for a worktree, want %q", got, main)
+	}
+	if got := CommonDir(main); got != main {
+		t.Errorf("CommonDir() = %q for the main git directory, want %q", got, main)
+	}
+}
@@ -0,0 +1,315 @@
+package input
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Key names use emacs-style modifier prefixes in a fixed order: "C-" for
+// control, "M-" for alt/meta and "S-" for shift, e.g. "C-n", "M-x", "C-M-a",
+// "S-up". Special keys have lowercase names: enter, tab, esc, backspace,
+// space, up, down, left, right, home, end, insert, delete, pgup, pgdn and
+// f1-f12. Printable characters are named by themselves.
+
+// modifiers is the xterm modifier bitmask (the CSI modifier parameter minus one)
+type modifiers int
+
+const (
+	modShift modifiers = 1
+	modAlt   modifiers = 2
+	modCtrl  modifiers = 4
+)
+
+func (m modifiers) prefix() string {
+	var sb strings.Builder
+	if m&modCtrl != 0 {
+		sb.WriteString("C-")
+	}
+	if m&modAlt != 0 {
+		sb.WriteString("M-")
+	}
+	if m&modShift != 0 {
+		sb.WriteString("S-")
+	}
+	return sb.String()
+}
+
+// csiLetterKeys are keys sent as CSI <letter> (or SS3 <letter>)
+var csiLetterKeys = map[byte]string{
+	'A': "up",
+	'B': "down",
+	'C': "right",
+	'D': "left",
+	'H': "home",
+	'F': "end",
+	'P': "f1",
+	'Q': "f2",
+	'R': "f3",
+	'S': "f4",
+}
+
+// csiTildeKeys are keys sent as CSI <code> ~
+var csiTildeKeys = map[int]string{
+	1:  "home",
+	2:  "insert",
+	3:  "delete",
+	4:  "end",
+	5:  "pgup",
+	6:  "pgdn",
+	7:  "home",
+	8:  "end",
+	11: "f1",
+	12: "f2",
+	13: "f3",
+	14: "f4",
+	15: "f5",
+	17: "f6",
+	18: "f7",
+	19: "f8",
+	20: "f9",
+	21: "f10",
+	23: "f11",
+	24: "f12",
+}
+
+// Name returns the canonical name of a key, or "" if it isn't recognised or is
+// pasted text
+func (k Key) Name() string {
+	b := k.Bytes
+	switch {
+	case k.Paste || len(b) == 0:
+		return ""
+	case len(b) == 1:
+		return byteName(b[0], 0)
+	case b[0] != 0x1b:
+		return string(b) // multi-byte character
+	case len(b) == 2:
+		return byteName(b[1], modAlt)
+	case b[1] >= utf8.RuneSelf:
+		return "M-" + string(b[1:]) // alt plus a multi-byte character
+	case b[1] == 'O' && len(b) == 3:
+		if name, ok := csiLetterKeys[b[2]]; ok {
+			return name
+		}
+		return ""
+	case b[1] == '[':
+		return csiName(b[2:])
+	}
+	return ""
+}
+
+// byteName names a single-byte key with the given extra modifiers
+func byteName(c byte, mods modifiers) string {
+	var base string
+	switch {
+	case c == '\r':
+		base = "enter"
+	case c == '\t':
+		base = "tab"
+	case c == 0x1b:
+		base = "esc"
+	case c == 0x7f:
+		base = "backspace"
+	case c == ' ':
+		base = "space"
+	case c == 0:
+		mods |= modCtrl
+		base = "space"
+	case c >= 1 && c <= 26:
+		mods |= modCtrl
+		base = string(rune('a' + c - 1))
+	case c == 0x1c:
+		mods |= modCtrl
+		base = "\\"
+	case c == 0x1d:
+		mods |= modCtrl
+		base = "]"
+	case c == 0x1e:
+		mods |= modCtrl
+		base = "^"
+	case c == 0x1f:
+		mods |= modCtrl
+		base = "/"
+	case c < 0x80:
+		base = string(rune(c))
+	default:
+		return ""
+	}
+	return mods.prefix() + base
+}
+
+// csiName names a CSI sequence given the bytes after "ESC ["
+func csiName(seq []byte) string {
+	if len(seq) == 0 {
+		return ""
+	}
+	final := seq[len(seq)-1]
+	params := strings.Split(string(seq[:len(seq)-1]), ";")
+
+	var mods modifiers
+	if len(params) >= 2 {
+		if m, err := strconv.Atoi(params[1]); err == nil && m > 1 {
+			mods = modifiers(m - 1)
+		}
+	}
+
+	switch {
+	case final == 'Z' && len(seq) == 1:
+		return "S-tab"
+	case final == '~':
+		code, err := strconv.Atoi(params[0])
+		if err != nil {
+			return ""
+		}
+		if name, ok := csiTildeKeys[code]; ok {
+			return mods.prefix() + name
+		}
+	default:
+		if name, ok := csiLetterKeys[final]; ok {
+			return mods.prefix() + name
+		}
+	}
+	return ""
+}
+
+// ParseKey returns the bytes a terminal sends for the named key. Names are
+// case-sensitive apart from the letter in a control combination ("C-N" is the
+// same as "C-n").
+func ParseKey(name string) ([]byte, error) {
+	var mods modifiers
+	rest := name
+	for len(rest) > 2 && rest[1] == '-' {
+		switch rest[0] {
+		case 'C':
+			mods |= modCtrl
+		case 'M':
+			mods |= modAlt
+		case 'S':
+			mods |= modShift
+		default:
+			return nil, fmt.Errorf("unknown modifier %q in key %q", rest[:2], name)
+		}
+		rest = rest[2:]
+	}
+	if rest == "" {
+		return nil, fmt.Errorf("empty key name %q", name)
+	}
+
+	// Keys that are sent as escape sequences take modifiers as a parameter
+	for final, base := range csiLetterKeys {
+		if rest != base {
+			continue
+		}
+		if mods == 0 && final >= 'P' && final <= 'S' {
+			return []byte("\x1bO" + string(final)), nil // f1-f4 are sent as SS3
+		}
+		if mods == 0 {
+			return []byte("\x1b[" + string(final)), nil
+		}
+		return []byte(fmt.Sprintf("\x1b[1;%d%c", mods+1, final)), nil
+	}
+	for _, code := range []int{2, 3, 4, 5, 6, 15, 17, 18, 19, 20, 21, 23, 24} {
+		if rest != csiTildeKeys[code] {
+			continue
+		}
+		if mods == 0 {
+			return []byte(fmt.Sprintf("\x1b[%d~", code)), nil
+		}
+		return []byte(fmt.Sprintf("\x1b[%d;%d~", code, mods+1)), nil
+	}
+	if rest == "tab" && mods == modShift {
+		return []byte("\x1b[Z"), nil
+	}
+
+	if mods&modShift != 0 {
+		return nil, fmt.Errorf("shift modifier is only supported on special keys: %q", name)
+	}
+
+	var c byte
+	switch rest {
+	case "enter":
+		c = '\r'
+	case "tab":
+		c = '\t'
+	case "esc":
+		c = 0x1b
+	case "backspace":
+		c = 0x7f
+	case "space":
+		c = ' '
+	default:
+		if len(rest) != 1 {
+			if mods&^modAlt == 0 && utf8.RuneCountInString(rest) == 1 && isPrintable(rest) {
+				// A multi-byte character, optionally with alt
+				if mods == modAlt {
+					return append([]byte{0x1b}, rest...), nil
+				}
+				return []byte(rest), nil
+			}
+			return nil, fmt.Errorf("unknown key %q", name)
+		}
+		c = rest[0]
+	}
+
+	if mods&modCtrl != 0 {
+		ctrl, ok := controlCode(c)
+		if !ok {
+			return nil, fmt.Errorf("no control code for key %q", name)
+		}
+		c = ctrl
+	}
+	if mods&modAlt != 0 {
+		return []byte{0x1b, c}, nil
+	}
+	return []byte{c}, nil
+}
+
+// controlCode returns the byte sent when c is typed with control held
+func controlCode(c byte) (byte, bool) {
+	switch {
+	case c >= 'a' && c <= 'z':
+		return c - 'a' + 1, true
+	case c >= 'A' && c <= 'Z':
+		return c - 'A' + 1, true
+	case c == ' ' || c == '@' || c == '2':
+		return 0, true
+	case c == '[':
+		return 0x1b, true
+	case c == '\\':
+		return 0x1c, true
+	case c == ']':
+		return 0x1d, true
+	case c == '^' || c == '6':
+		return 0x1e, true
+	case c == '/' || c == '_' || c == '7':
+		return 0x1f, true
+	case c == '?' || c == 0x7f:
+		return 0x7f, true
+	}
+	return 0, false
+}
+
+func isPrintable(s string) bool {
+	for _, r := range s {
+		if r < 0x20 || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// CanonicalName returns the canonical form of a key name, so that aliases
+// like "C-i" and "tab", or "C-N" and "C-n", compare equal
+func CanonicalName(name string) (string, error) {
+	b, err := ParseKey(name)
+	if err != nil {
+		return "", err
+	}
+	canonical := Key{Bytes: b}.Name()
+	if canonical == "" {
+		return "", fmt.Errorf("unsupported key %q", name)
+	}
+	return canonical, nil
+}
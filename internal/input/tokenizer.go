@@ -0,0 +1,188 @@
+// Package input splits raw terminal input into individual keystrokes.
+//
+// Terminals send some keys as multi-byte escape sequences (arrows, function
+// keys, Alt combinations) and a single read from stdin may contain several
+// keys, or only part of one. The Tokenizer buffers partial sequences between
+// reads so callers always see whole keys.
+//
+// Text pasted while the application has bracketed paste enabled arrives
+// between ESC[200~ and ESC[201~. It is returned as Paste keys rather than
+// split into keystrokes, so that callers can pass it through untouched.
+package input
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+var (
+	pasteStart = []byte("\x1b[200~")
+	pasteEnd   = []byte("\x1b[201~")
+)
+
+// Key is a single keystroke as sent by the terminal, or a chunk of pasted text
+type Key struct {
+	Bytes []byte
+	Paste bool // Part of a bracketed paste, including the start and end markers
+}
+
+// Tokenizer splits input into keys. It is not safe for concurrent use.
+type Tokenizer struct {
+	pending []byte
+	inPaste bool
+}
+
+// Feed adds input and returns the complete keys it contains. An incomplete
+// escape sequence or UTF-8 character at the end is held back until more input
+// arrives or Flush is called. Pasted text is returned as it arrives, so a large
+// paste spread over several reads produces several Paste keys.
+func (t *Tokenizer) Feed(data []byte) []Key {
+	buf := append(t.pending, data...)
+	t.pending = nil
+
+	var keys []Key
+	for len(buf) > 0 {
+		if t.inPaste {
+			var n int
+			keys, n = t.feedPaste(keys, buf)
+			if n == 0 {
+				break
+			}
+			buf = buf[n:]
+			continue
+		}
+
+		if bytes.HasPrefix(buf, pasteStart) {
+			keys = append(keys, Key{Bytes: append([]byte(nil), pasteStart...), Paste: true})
+			t.inPaste = true
+			buf = buf[len(pasteStart):]
+			continue
+		}
+
+		n := keyLength(buf)
+		if n == 0 {
+			// Incomplete sequence: keep it for the next read
+			t.pending = append([]byte(nil), buf...)
+			break
+		}
+		keys = append(keys, Key{Bytes: append([]byte(nil), buf[:n]...)})
+		buf = buf[n:]
+	}
+	return keys
+}
+
+// feedPaste consumes pasted text from the start of buf, returning the number
+// of bytes used. A possible partial end marker at the end of buf is kept in
+// pending and 0 is returned once nothing more can be consumed.
+func (t *Tokenizer) feedPaste(keys []Key, buf []byte) ([]Key, int) {
+	if i := bytes.Index(buf, pasteEnd); i >= 0 {
+		if i > 0 {
+			keys = append(keys, Key{Bytes: append([]byte(nil), buf[:i]...), Paste: true})
+		}
+		keys = append(keys, Key{Bytes: append([]byte(nil), pasteEnd...), Paste: true})
+		t.inPaste = false
+		return keys, i + len(pasteEnd)
+	}
+
+	// Hold back a tail that could be the start of the end marker
+	keep := 0
+	for n := len(pasteEnd) - 1; n > 0; n-- {
+		if n <= len(buf) && bytes.HasSuffix(buf, pasteEnd[:n]) {
+			keep = n
+			break
+		}
+	}
+	if len(buf) > keep {
+		keys = append(keys, Key{Bytes: append([]byte(nil), buf[:len(buf)-keep]...), Paste: true})
+	}
+	if keep > 0 {
+		t.pending = append([]byte(nil), buf[len(buf)-keep:]...)
+	}
+	return keys, 0
+}
+
+// InPaste reports whether the tokenizer is inside a bracketed paste
+func (t *Tokenizer) InPaste() bool {
+	return t.inPaste
+}
+
+// Pending reports whether an incomplete sequence is being held back. Callers
+// should call Flush if no more input arrives shortly, since a lone ESC is
+// indistinguishable from the start of a sequence until then. Bytes held back
+// inside a paste don't count: the end marker is always on its way.
+func (t *Tokenizer) Pending() bool {
+	return len(t.pending) > 0 && !t.inPaste
+}
+
+// Flush returns any held-back bytes as keys. A lone ESC becomes the escape
+// key; a truncated sequence is returned as a single key so nothing is lost.
+// Inside a paste nothing is flushed.
+func (t *Tokenizer) Flush() []Key {
+	if !t.Pending() {
+		return nil
+	}
+	key := Key{Bytes: t.pending}
+	t.pending = nil
+	return []Key{key}
+}
+
+// keyLength returns the length of the key at the start of buf, or 0 if buf
+// holds only the beginning of a longer sequence
+func keyLength(buf []byte) int {
+	if buf[0] >= utf8.RuneSelf {
+		return runeLength(buf)
+	}
+	if buf[0] != 0x1b {
+		return 1
+	}
+	if len(buf) == 1 {
+		return 0
+	}
+
+	switch buf[1] {
+	case '[':
+		// CSI: parameter bytes, intermediate bytes, then a final byte
+		for i := 2; i < len(buf); i++ {
+			b := buf[i]
+			if b >= 0x40 && b <= 0x7e {
+				return i + 1
+			}
+			if b < 0x20 || b > 0x7e {
+				// Not a valid CSI byte: treat ESC [ as Alt-[ and move on
+				return 2
+			}
+		}
+		return 0
+	case 'O':
+		// SS3: exactly one more byte
+		if len(buf) < 3 {
+			return 0
+		}
+		return 3
+	case 0x1b:
+		// ESC ESC: the first is a key on its own
+		return 1
+	}
+
+	if buf[1] >= utf8.RuneSelf {
+		// Alt plus a multi-byte character
+		n := runeLength(buf[1:])
+		if n == 0 {
+			return 0
+		}
+		return n + 1
+	}
+
+	// ESC followed by any other byte is Alt/Meta plus that key
+	return 2
+}
+
+// runeLength returns the length of the UTF-8 character at the start of buf, 0
+// if it is incomplete, or 1 for an invalid byte so it is passed on as-is
+func runeLength(buf []byte) int {
+	if utf8.FullRune(buf) {
+		_, n := utf8.DecodeRune(buf)
+		return n
+	}
+	return 0
+}
@@ -0,0 +1,230 @@
+package input
+
+import (
+	"testing"
+)
+
+func keyNames(keys []Key) []string {
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = k.Name()
+	}
+	return names
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestTokenizerSplitsKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"plain text", "ab", []string{"a", "b"}},
+		{"control keys", "\x0e\x10\x07\r", []string{"C-n", "C-p", "C-g", "enter"}},
+		{"arrow keys", "\x1b[A\x1b[B\x1bOC", []string{"up", "down", "right"}},
+		{"modified arrow", "\x1b[1;5A", []string{"C-up"}},
+		{"alt key", "\x1bx", []string{"M-x"}},
+		{"alt control key", "\x1b\x01", []string{"C-M-a"}},
+		{"tilde keys", "\x1b[3~\x1b[5~\x1b[15~", []string{"delete", "pgup", "f5"}},
+		{"shift tab", "\x1b[Z", []string{"S-tab"}},
+		{"ctrl slash", "\x1f", []string{"C-/"}},
+		{"esc esc", "\x1b\x1bx", []string{"esc", "M-x"}},
+		{"utf-8", "é日⏺", []string{"é", "日", "⏺"}},
+		{"alt utf-8", "\x1bé", []string{"M-é"}},
+		{"invalid utf-8", "\xffa", []string{"", "a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tok Tokenizer
+			got := keyNames(tok.Feed([]byte(tt.input)))
+			if !equalStrings(got, tt.want) {
+				t.Errorf("Feed(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+			if tok.Pending() {
+				t.Errorf("expected nothing pending")
+			}
+		})
+	}
+}
+
+func TestTokenizerSequenceSplitAcrossReads(t *testing.T) {
+	var tok Tokenizer
+
+	if keys := tok.Feed([]byte("a\x1b")); len(keys) != 1 || keys[0].Name() != "a" {
+		t.Fatalf("expected just 'a', got %q", keyNames(keys))
+	}
+	if !tok.Pending() {
+		t.Fatal("expected ESC to be pending")
+	}
+	if keys := tok.Feed([]byte("[1;")); len(keys) != 0 {
+		t.Fatalf("expected no keys from partial CSI, got %q", keyNames(keys))
+	}
+	keys := tok.Feed([]byte("3Cb"))
+	if got := keyNames(keys); !equalStrings(got, []string{"M-right", "b"}) {
+		t.Errorf("expected M-right then b, got %q", got)
+	}
+}
+
+func TestTokenizerFlushLoneEscape(t *testing.T) {
+	var tok Tokenizer
+
+	if keys := tok.Feed([]byte{0x1b}); len(keys) != 0 {
+		t.Fatalf("expected lone ESC to be held back, got %q", keyNames(keys))
+	}
+	keys := tok.Flush()
+	if got := keyNames(keys); !equalStrings(got, []string{"esc"}) {
+		t.Errorf("expected esc after flush, got %q", got)
+	}
+	if tok.Pending() {
+		t.Error("expected nothing pending after flush")
+	}
+}
+
+func TestTokenizerUTF8SplitAcrossReads(t *testing.T) {
+	var tok Tokenizer
+	input := []byte("日")
+
+	if keys := tok.Feed(input[:1]); len(keys) != 0 {
+		t.Fatalf("expected partial character to be held back, got %q", keyNames(keys))
+	}
+	keys := tok.Feed(input[1:])
+	if got := keyNames(keys); !equalStrings(got, []string{"日"}) {
+		t.Errorf("expected whole character, got %q", got)
+	}
+}
+
+func TestTokenizerBracketedPaste(t *testing.T) {
+	var tok Tokenizer
+	keys := tok.Feed([]byte("a\x1b[200~line one\rline\x1f two\x1b[201~\r"))
+
+	var pasted []byte
+	for _, k := range keys {
+		if k.Paste {
+			pasted = append(pasted, k.Bytes...)
+		}
+	}
+	want := "\x1b[200~line one\rline\x1f two\x1b[201~"
+	if string(pasted) != want {
+		t.Errorf("pasted bytes = %q, want %q", pasted, want)
+	}
+
+	if !equalStrings([]string{keys[0].Name()}, []string{"a"}) || keys[0].Paste {
+		t.Errorf("expected key before paste to be a keystroke, got %+v", keys[0])
+	}
+	last := keys[len(keys)-1]
+	if last.Paste || last.Name() != "enter" {
+		t.Errorf("expected enter after paste to be a keystroke, got %+v", last)
+	}
+	for _, k := range keys {
+		if k.Paste && k.Name() != "" {
+			t.Errorf("expected pasted key to have no name, got %q", k.Name())
+		}
+	}
+}
+
+func TestTokenizerPasteSplitAcrossReads(t *testing.T) {
+	var tok Tokenizer
+	reads := []string{"\x1b[20", "0~abc\r", "def\x1b[2", "01", "~x"}
+
+	var pasted []byte
+	var typed []string
+	for i, r := range reads {
+		for _, k := range tok.Feed([]byte(r)) {
+			if k.Paste {
+				pasted = append(pasted, k.Bytes...)
+			} else {
+				typed = append(typed, k.Name())
+			}
+		}
+		if i > 0 && i < len(reads)-1 && !tok.InPaste() {
+			t.Errorf("read %d: expected to be inside paste", i)
+		}
+		if i > 0 && tok.Pending() {
+			// Only a possible end marker is held back, which shouldn't be
+			// flushed on a timeout
+			t.Errorf("read %d: expected nothing pending for flush", i)
+		}
+	}
+
+	if want := "\x1b[200~abc\rdef\x1b[201~"; string(pasted) != want {
+		t.Errorf("pasted bytes = %q, want %q", pasted, want)
+	}
+	if !equalStrings(typed, []string{"x"}) {
+		t.Errorf("typed keys = %q, want [x]", typed)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"C-n", "\x0e"},
+		{"C-N", "\x0e"},
+		{"C-/", "\x1f"},
+		{"C-]", "\x1d"},
+		{"M-x", "\x1bx"},
+		{"C-M-a", "\x1b\x01"},
+		{"enter", "\r"},
+		{"esc", "\x1b"},
+		{"up", "\x1b[A"},
+		{"C-up", "\x1b[1;5A"},
+		{"f1", "\x1bOP"},
+		{"delete", "\x1b[3~"},
+		{"S-delete", "\x1b[3;2~"},
+		{"S-tab", "\x1b[Z"},
+		{"é", "é"},
+		{"M-é", "\x1bé"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseKey(tt.name)
+			if err != nil {
+				t.Fatalf("ParseKey(%q) error = %v", tt.name, err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("ParseKey(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+
+	for _, bad := range []string{"", "X-a", "S-a", "C-é", "nonsense"} {
+		if _, err := ParseKey(bad); err == nil {
+			t.Errorf("ParseKey(%q) expected error", bad)
+		}
+	}
+}
+
+func TestCanonicalName(t *testing.T) {
+	tests := map[string]string{
+		"C-i":   "tab",
+		"C-m":   "enter",
+		"C-_":   "C-/",
+		"M-C-a": "C-M-a",
+		"C-[":   "esc",
+		"S-up":  "S-up",
+	}
+	for in, want := range tests {
+		got, err := CanonicalName(in)
+		if err != nil {
+			t.Errorf("CanonicalName(%q) error = %v", in, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("CanonicalName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
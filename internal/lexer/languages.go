@@ -0,0 +1,290 @@
+package lexer
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Language is a language whose comments can be found, and the files it is
+// used for
+type Language struct {
+	Name       string
+	Extensions []string // Including the dot, e.g. ".go"
+	Filenames  []string // Whole file names, e.g. "Makefile"
+	Syntax     Syntax
+	Symbols    []SymbolPattern // Recognise functions, types and so on, tried in order
+}
+
+var (
+	cStrings  = []StringSyntax{{Delim: `"`}, {Delim: "'"}}
+	cBlock    = []Delimiters{{Start: "/*", End: "*/"}}
+	htmlBlock = []Delimiters{{Start: "<!--", End: "-->"}}
+
+	jsSyntax = Syntax{
+		LineComments:  []string{"//"},
+		BlockComments: cBlock,
+		Strings: []StringSyntax{
+			{Delim: "`", Multiline: true},
+			{Delim: `"`},
+			{Delim: "'"},
+		},
+		RegexLiterals: true,
+	}
+	shellSyntax = Syntax{
+		LineComments: []string{"#"},
+		Strings: []StringSyntax{
+			{Delim: `"`, Multiline: true},
+			{Delim: "'", Multiline: true, Raw: true},
+		},
+		ShellVariables:     true,
+		CommentsAfterSpace: true,
+	}
+	lispSyntax = Syntax{
+		LineComments: []string{";"},
+		Strings:      []StringSyntax{{Delim: `"`, Multiline: true}},
+	}
+)
+
+// languages are the built-in languages
+var languages = []*Language{
+	{
+		Name:       "Go",
+		Extensions: []string{".go"},
+		Syntax: Syntax{
+			LineComments:  []string{"//"},
+			BlockComments: cBlock,
+			Strings: []StringSyntax{
+				{Delim: "`", Multiline: true, Raw: true},
+				{Delim: `"`},
+				{Delim: "'"},
+			},
+		},
+		Symbols: goSymbols,
+	},
+	{
+		Name:       "JavaScript",
+		Extensions: []string{".js", ".mjs", ".cjs", ".jsx"},
+		Syntax:     jsSyntax,
+		Symbols:    jsSymbols,
+	},
+	{
+		Name:       "TypeScript",
+		Extensions: []string{".ts", ".mts", ".cts", ".tsx"},
+		Syntax:     jsSyntax,
+		Symbols:    jsSymbols,
+	},
+	{
+		Name:       "Python",
+		Extensions: []string{".py", ".pyi"},
+		Syntax: Syntax{
+			LineComments: []string{"#"},
+			Strings: []StringSyntax{
+				{Delim: `"""`, Multiline: true},
+				{Delim: "'''", Multiline: true},
+				{Delim: `"`},
+				{Delim: "'"},
+			},
+			DocStrings:   true, // Docstrings count as comments
+			IndentBlocks: true,
+		},
+		Symbols: pythonSymbols,
+	},
+	{
+		Name:       "Rust",
+		Extensions: []string{".rs"},
+		Syntax: Syntax{
+			LineComments:   []string{"//"},
+			BlockComments:  cBlock,
+			Strings:        []StringSyntax{{Delim: `"`, Multiline: true}, {Delim: "'"}},
+			NestedComments: true,
+			Lifetimes:      true,
+		},
+		Symbols: rustSymbols,
+	},
+	{
+		Name:       "Java",
+		Extensions: []string{".java"},
+		Syntax: Syntax{
+			LineComments:  []string{"//"},
+			BlockComments: cBlock,
+			Strings:       []StringSyntax{{Delim: `"""`, Multiline: true}, {Delim: `"`}, {Delim: "'"}},
+		},
+		Symbols: javaSymbols,
+	},
+	{
+		Name:       "Kotlin",
+		Extensions: []string{".kt", ".kts"},
+		Syntax: Syntax{
+			LineComments:   []string{"//"},
+			BlockComments:  cBlock,
+			Strings:        []StringSyntax{{Delim: `"""`, Multiline: true, Raw: true}, {Delim: `"`}, {Delim: "'"}},
+			NestedComments: true,
+		},
+		Symbols: kotlinSymbols,
+	},
+	{
+		Name:       "C",
+		Extensions: []string{".c", ".h"},
+		Syntax:     Syntax{LineComments: []string{"//"}, BlockComments: cBlock, Strings: cStrings},
+		Symbols:    cSymbols,
+	},
+	{
+		Name:       "C++",
+		Extensions: []string{".cc", ".cpp", ".cxx", ".hh", ".hpp", ".hxx"},
+		Syntax:     Syntax{LineComments: []string{"//"}, BlockComments: cBlock, Strings: cStrings},
+		Symbols:    cppSymbols,
+	},
+	{
+		Name:       "Ruby",
+		Extensions: []string{".rb", ".rake", ".gemspec"},
+		Filenames:  []string{"Rakefile", "Gemfile"},
+		Syntax: Syntax{
+			LineComments:  []string{"#"},
+			BlockComments: []Delimiters{{Start: "=begin", End: "=end", LineStart: true}},
+			Strings:       []StringSyntax{{Delim: `"`, Multiline: true}, {Delim: "'", Multiline: true}},
+		},
+	},
+	{
+		Name:       "Shell",
+		Extensions: []string{".sh", ".bash", ".zsh"},
+		Syntax:     shellSyntax,
+		Symbols:    shellSymbols,
+	},
+	{
+		Name:       "SQL",
+		Extensions: []string{".sql"},
+		Syntax: Syntax{
+			LineComments:  []string{"--"},
+			BlockComments: cBlock,
+			Strings:       []StringSyntax{{Delim: "'", Multiline: true, Raw: true}, {Delim: `"`, Raw: true}},
+		},
+	},
+	{
+		Name:       "Lua",
+		Extensions: []string{".lua"},
+		Syntax: Syntax{
+			LineComments:  []string{"--"},
+			BlockComments: []Delimiters{{Start: "--[[", End: "]]"}},
+			Strings: []StringSyntax{
+				{Delim: "[[", End: "]]", Multiline: true, Raw: true},
+				{Delim: `"`},
+				{Delim: "'"},
+			},
+		},
+	},
+	{
+		Name:       "Haskell",
+		Extensions: []string{".hs"},
+		Syntax: Syntax{
+			LineComments:   []string{"--"},
+			BlockComments:  []Delimiters{{Start: "{-", End: "-}"}},
+			Strings:        []StringSyntax{{Delim: `"`}}, // ' is also used in names, like x'
+			NestedComments: true,
+		},
+	},
+	{
+		Name:       "YAML",
+		Extensions: []string{".yml", ".yaml"},
+		Syntax: Syntax{
+			LineComments: []string{"#"},
+			// Single quotes are left out because apostrophes in unquoted
+			// values are more common than '#' in quoted ones
+			Strings:            []StringSyntax{{Delim: `"`}},
+			CommentsAfterSpace: true,
+		},
+	},
+	{
+		Name:       "TOML",
+		Extensions: []string{".toml"},
+		Syntax: Syntax{
+			LineComments: []string{"#"},
+			Strings: []StringSyntax{
+				{Delim: `"""`, Multiline: true},
+				{Delim: "'''", Multiline: true, Raw: true},
+				{Delim: `"`},
+				{Delim: "'", Raw: true},
+			},
+		},
+	},
+	{
+		// Text between tags is prose, so quotes there don't start strings
+		Name:       "HTML",
+		Extensions: []string{".html", ".htm", ".xml", ".svg", ".vue"},
+		Syntax:     Syntax{BlockComments: htmlBlock},
+	},
+	{
+		Name:       "Markdown",
+		Extensions: []string{".md", ".markdown"},
+		Syntax:     Syntax{BlockComments: htmlBlock},
+	},
+	{
+		Name:       "Lisp",
+		Extensions: []string{".el", ".lisp", ".clj", ".cljs", ".cljc", ".scm"},
+		Syntax:     lispSyntax,
+	},
+	{
+		Name:       "Makefile",
+		Extensions: []string{".mk"},
+		Filenames:  []string{"Makefile", "makefile", "GNUmakefile"},
+		Syntax:     Syntax{LineComments: []string{"#"}},
+	},
+	{
+		Name:       "Dockerfile",
+		Extensions: []string{".dockerfile"},
+		Filenames:  []string{"Dockerfile", "Containerfile"},
+		Syntax:     Syntax{LineComments: []string{"#"}, Strings: cStrings},
+	},
+}
+
+var (
+	byExtension = make(map[string]*Language)
+	byFilename  = make(map[string]*Language)
+)
+
+func init() {
+	for _, lang := range languages {
+		Register(lang)
+	}
+}
+
+// Register adds a language, replacing any language already registered for
+// its extensions and file names. It isn't safe to call concurrently with
+// ForFile, so register languages at startup.
+func Register(lang *Language) {
+	for _, ext := range lang.Extensions {
+		byExtension[ext] = lang
+	}
+	for _, name := range lang.Filenames {
+		byFilename[name] = lang
+	}
+}
+
+// Snapshot saves the registered languages and returns a function that
+// restores them, so tests can register languages without affecting others
+func Snapshot() (restore func()) {
+	extensions := make(map[string]*Language, len(byExtension))
+	for ext, lang := range byExtension {
+		extensions[ext] = lang
+	}
+	filenames := make(map[string]*Language, len(byFilename))
+	for name, lang := range byFilename {
+		filenames[name] = lang
+	}
+	return func() {
+		byExtension, byFilename = extensions, filenames
+	}
+}
+
+// ForFile returns the language of a file from its name or extension, or nil
+// if the language isn't known
+func ForFile(path string) *Language {
+	base := filepath.Base(path)
+	if lang, ok := byFilename[base]; ok {
+		return lang
+	}
+	ext := filepath.Ext(base)
+	if lang, ok := byExtension[ext]; ok {
+		return lang
+	}
+	return byExtension[strings.ToLower(ext)]
+}
@@ -0,0 +1,547 @@
+// Package lexer finds the comments and code blocks in source code. It
+// tokenizes just enough of each language (strings, comments and, for
+// JavaScript, regex literals) to tell real comments and brackets apart from
+// ones inside literals.
+package lexer
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Delimiters are the start and end tokens of a block comment or string.
+// End is empty for line comments.
+type Delimiters struct {
+	Start string
+	End   string
+
+	// LineStart only matches the tokens at the start of a line, as with
+	// Ruby's =begin and =end
+	LineStart bool
+}
+
+// StringSyntax describes one kind of string literal
+type StringSyntax struct {
+	Delim     string // Opening and closing delimiter, e.g. `"` or `"""`
+	End       string // Closing delimiter if it differs from Delim, e.g. Lua's "]]"
+	Multiline bool   // The literal can span lines
+	Raw       bool   // Backslash does not escape the next character
+}
+
+func (s StringSyntax) end() string {
+	if s.End != "" {
+		return s.End
+	}
+	return s.Delim
+}
+
+// Syntax describes the comments and literals of a language
+type Syntax struct {
+	LineComments  []string
+	BlockComments []Delimiters
+	Strings       []StringSyntax // Longer delimiters must come first
+
+	// NestedComments allows block comments inside block comments, as in Rust
+	// and Haskell
+	NestedComments bool
+
+	// RegexLiterals enables JavaScript-style /regex/ literals
+	RegexLiterals bool
+
+	// DocStrings treats a multiline string that is the first statement of
+	// the file, or of a def or class, as a block comment, as with Python
+	// docstrings
+	DocStrings bool
+
+	// ShellVariables treats "$#" and "${...}" as code, as in shell, where
+	// they can contain a "#"
+	ShellVariables bool
+
+	// CommentsAfterSpace only starts a line comment at the start of a line or
+	// after whitespace, as in shell and YAML, where "foo#bar" is a word
+	CommentsAfterSpace bool
+
+	// Lifetimes treats a quote followed by an identifier, as in Rust's 'a, as
+	// code rather than the start of a character literal
+	Lifetimes bool
+
+	// IndentBlocks finds blocks from indentation after a line ending in ":",
+	// as in Python, rather than from braces
+	IndentBlocks bool
+}
+
+// Comment is a comment found in source code
+type Comment struct {
+	Text    string     // The comment without its delimiters
+	Delims  Delimiters // The delimiters used
+	Line    int        // First line (1-indexed)
+	EndLine int        // Last line (1-indexed), the same as Line for one-line comments
+	Block   bool       // Block comment or docstring rather than a line comment
+	Inline  bool       // There is code before the comment on its first line
+}
+
+// Block is a block of code, such as a function body
+type Block struct {
+	Line    int // First line of the statement that opens the block (1-indexed)
+	EndLine int // Line the block ends on (1-indexed)
+}
+
+// Keywords after which a JavaScript "/" starts a regex rather than a division
+var regexKeywords = map[string]bool{
+	"return": true, "typeof": true, "instanceof": true, "in": true, "of": true,
+	"new": true, "delete": true, "void": true, "throw": true, "case": true,
+	"do": true, "else": true, "yield": true, "await": true,
+}
+
+type scanner struct {
+	src    string
+	syntax *Syntax
+	pos    int
+	line   int
+
+	lineHasCode  bool // Code has been seen on the current line
+	prevCodeLine int  // The last line before the current one with code on it
+	afterValue   bool // The last token was a value, so "/" is a division
+	lastDocLine  int  // The line the last docstring ended on
+
+	comments []Comment
+
+	// Bracket tracking, for blocks
+	braces      []int        // Start lines of the open brace blocks
+	parens      int          // Open parentheses and square brackets
+	parenStart  int          // Line the outermost open parenthesis is on
+	closedLine  int          // Line the last outermost parenthesis closed on
+	closedStart int          // Line that parenthesis opened on
+	headers     map[int]int  // Lines ending a block header with ":", to the line the statement started on
+	lineEnds    map[int]byte // The last code character on each line
+	blocks      []Block
+}
+
+// Comments returns the comments in src in the order they appear
+func Comments(src string, syntax *Syntax) []Comment {
+	s := newScanner(src, syntax)
+	s.scan()
+	return s.comments
+}
+
+// Blocks returns the code blocks in src, outer blocks before the blocks they
+// contain. Blocks are delimited by braces, or by indentation if the syntax
+// has IndentBlocks.
+func Blocks(src string, syntax *Syntax) []Block {
+	s := newScanner(src, syntax)
+	s.scan()
+	blocks := s.blocks
+	if syntax.IndentBlocks {
+		blocks = s.indentBlocks()
+	}
+	sort.SliceStable(blocks, func(i, j int) bool {
+		if blocks[i].Line != blocks[j].Line {
+			return blocks[i].Line < blocks[j].Line
+		}
+		return blocks[i].EndLine > blocks[j].EndLine
+	})
+	return blocks
+}
+
+func newScanner(src string, syntax *Syntax) *scanner {
+	return &scanner{
+		src:      src,
+		syntax:   syntax,
+		line:     1,
+		headers:  make(map[int]int),
+		lineEnds: make(map[int]byte),
+	}
+}
+
+func (s *scanner) scan() {
+	for s.pos < len(s.src) {
+		c := s.src[s.pos]
+		switch {
+		case c == '\n':
+			if s.lineHasCode {
+				s.prevCodeLine = s.line
+			}
+			s.line++
+			s.pos++
+			s.lineHasCode = false
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f':
+			s.pos++
+		case s.syntax.ShellVariables && c == '$' && s.shellVariable():
+		case s.blockComment(): // Before line comments, for Lua's --[[
+		case s.lineComment():
+		case s.stringLiteral():
+		case s.syntax.RegexLiterals && c == '/' && !s.afterValue && s.regexLiteral():
+		case isWordChar(c):
+			start := s.pos
+			for s.pos < len(s.src) && isWordChar(s.src[s.pos]) {
+				s.pos++
+			}
+			s.lineHasCode = true
+			s.afterValue = !regexKeywords[s.src[start:s.pos]]
+			s.lineEnds[s.line] = c
+		default:
+			s.bracket(c)
+			s.pos++
+			s.lineHasCode = true
+			s.afterValue = c == ')' || c == ']'
+			s.lineEnds[s.line] = c
+		}
+	}
+}
+
+// bracket tracks the brackets that delimit blocks. A block's statement can
+// start on an earlier line than its brace when it has a parameter list that
+// spans lines, or when the brace is on a line of its own.
+func (s *scanner) bracket(c byte) {
+	statementStart := s.line
+	if s.closedLine == s.line {
+		statementStart = s.closedStart
+	} else if c == '{' && !s.lineHasCode && s.closedLine == s.prevCodeLine && s.lineEnds[s.prevCodeLine] == ')' {
+		statementStart = s.closedStart
+	}
+
+	switch c {
+	case '(', '[':
+		if s.parens == 0 {
+			s.parenStart = s.line
+		}
+		s.parens++
+	case ')', ']':
+		if s.parens > 0 {
+			s.parens--
+			if s.parens == 0 {
+				s.closedLine, s.closedStart = s.line, s.parenStart
+			}
+		}
+	case '{':
+		s.braces = append(s.braces, statementStart)
+	case '}':
+		if n := len(s.braces); n > 0 {
+			s.blocks = append(s.blocks, Block{Line: s.braces[n-1], EndLine: s.line})
+			s.braces = s.braces[:n-1]
+		}
+	case ':':
+		if s.parens == 0 && len(s.braces) == 0 {
+			s.headers[s.line] = statementStart
+		}
+	}
+}
+
+// indentBlocks finds blocks that start with a header line ending in ":" and
+// continue while lines are indented further than the header
+func (s *scanner) indentBlocks() []Block {
+	lines := strings.Split(s.src, "\n")
+	var blocks []Block
+	for line, start := range s.headers {
+		if s.lineEnds[line] != ':' {
+			continue // The ":" wasn't the end of the line, e.g. a lambda
+		}
+		indent := indentation(lines[start-1])
+		end := line
+		for next := line + 1; next <= len(lines); next++ {
+			text := lines[next-1]
+			if strings.TrimSpace(text) == "" {
+				continue
+			}
+			if indentation(text) <= indent {
+				break
+			}
+			end = next
+		}
+		if end > line {
+			blocks = append(blocks, Block{Line: start, EndLine: end})
+		}
+	}
+	return blocks
+}
+
+// indentation returns the width of a line's leading whitespace, counting a
+// tab as one column
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t"))
+}
+
+// atLineStart reports whether pos is at the start of a line
+func (s *scanner) atLineStart(pos int) bool {
+	return pos == 0 || s.src[pos-1] == '\n'
+}
+
+func (s *scanner) lineComment() bool {
+	if s.syntax.CommentsAfterSpace && !s.atLineStart(s.pos) && !strings.ContainsRune(" \t\r\f", rune(s.src[s.pos-1])) {
+		return false
+	}
+	for _, token := range s.syntax.LineComments {
+		if !strings.HasPrefix(s.src[s.pos:], token) {
+			continue
+		}
+		end := strings.IndexByte(s.src[s.pos:], '\n')
+		if end < 0 {
+			end = len(s.src)
+		} else {
+			end += s.pos
+		}
+		s.comments = append(s.comments, Comment{
+			Text:    strings.TrimSuffix(s.src[s.pos+len(token):end], "\r"),
+			Delims:  Delimiters{Start: token},
+			Line:    s.line,
+			EndLine: s.line,
+			Inline:  s.lineHasCode,
+		})
+		s.pos = end
+		return true
+	}
+	return false
+}
+
+func (s *scanner) blockComment() bool {
+	for _, delims := range s.syntax.BlockComments {
+		if !strings.HasPrefix(s.src[s.pos:], delims.Start) || (delims.LineStart && !s.atLineStart(s.pos)) {
+			continue
+		}
+		textStart := s.pos + len(delims.Start)
+		textEnd := len(s.src) // Unterminated: the comment runs to the end
+		end := textEnd
+		if s.syntax.NestedComments {
+			depth := 1
+			for i := textStart; i < len(s.src); i++ {
+				if strings.HasPrefix(s.src[i:], delims.Start) {
+					depth++
+					i += len(delims.Start) - 1
+				} else if strings.HasPrefix(s.src[i:], delims.End) {
+					depth--
+					if depth == 0 {
+						textEnd, end = i, i+len(delims.End)
+						break
+					}
+					i += len(delims.End) - 1
+				}
+			}
+		} else if i := s.indexEnd(textStart, delims); i >= 0 {
+			textEnd = i
+			end = textEnd + len(delims.End)
+		}
+		s.addBlock(s.src[textStart:textEnd], delims, s.src[s.pos:end])
+		s.pos = end
+		return true
+	}
+	return false
+}
+
+// indexEnd returns the position of the first end token at or after pos, or -1
+func (s *scanner) indexEnd(pos int, delims Delimiters) int {
+	for pos <= len(s.src) {
+		i := strings.Index(s.src[pos:], delims.End)
+		if i < 0 {
+			return -1
+		}
+		if !delims.LineStart || s.atLineStart(pos+i) {
+			return pos + i
+		}
+		pos += i + 1
+	}
+	return -1
+}
+
+// addBlock records a block comment spanning raw, which starts at s.pos
+func (s *scanner) addBlock(text string, delims Delimiters, raw string) {
+	lines := strings.Count(raw, "\n")
+	s.comments = append(s.comments, Comment{
+		Text:    text,
+		Delims:  delims,
+		Line:    s.line,
+		EndLine: s.line + lines,
+		Block:   true,
+		Inline:  s.lineHasCode,
+	})
+	if lines > 0 {
+		s.line += lines
+		s.lineHasCode = false
+	}
+}
+
+func (s *scanner) stringLiteral() bool {
+	for _, str := range s.syntax.Strings {
+		if !strings.HasPrefix(s.src[s.pos:], str.Delim) {
+			continue
+		}
+		if s.syntax.Lifetimes && str.Delim == "'" && s.isLifetime() {
+			return false
+		}
+
+		closing := str.end()
+		textStart := s.pos + len(str.Delim)
+		textEnd, end := len(s.src), len(s.src)
+		for i := textStart; i < len(s.src); i++ {
+			if !str.Raw && s.src[i] == '\\' {
+				i++
+				continue
+			}
+			if strings.HasPrefix(s.src[i:], closing) {
+				textEnd, end = i, i+len(closing)
+				break
+			}
+			if s.src[i] == '\n' && !str.Multiline {
+				// Unterminated: stop at the end of the line
+				textEnd, end = i, i
+				break
+			}
+		}
+
+		if s.syntax.DocStrings && str.Multiline && s.docstringAllowed() && s.restOfLineIsBlank(end) {
+			s.lastDocLine = s.line + strings.Count(s.src[s.pos:end], "\n")
+			s.addBlock(s.src[textStart:textEnd], Delimiters{Start: str.Delim, End: closing}, s.src[s.pos:end])
+		} else {
+			lines := strings.Count(s.src[s.pos:end], "\n")
+			s.line += lines
+			s.lineHasCode = true
+			s.lineEnds[s.line] = '"'
+		}
+		s.pos = end
+		s.afterValue = true
+		return true
+	}
+	return false
+}
+
+// docstringAllowed reports whether a string starting at s.pos would be the
+// first statement of the file, or of a def or class
+func (s *scanner) docstringAllowed() bool {
+	if s.lineHasCode || s.lastDocLine > s.prevCodeLine {
+		return false
+	}
+	if s.prevCodeLine == 0 {
+		return true
+	}
+	start, ok := s.headers[s.prevCodeLine]
+	if !ok || s.lineEnds[s.prevCodeLine] != ':' {
+		return false
+	}
+	header := strings.Fields(s.lineText(start))
+	if len(header) > 0 && header[0] == "async" {
+		header = header[1:]
+	}
+	return len(header) > 0 && (header[0] == "def" || header[0] == "class")
+}
+
+// lineText returns the text of a line (1-indexed)
+func (s *scanner) lineText(line int) string {
+	start := 0
+	for i := 1; i < line; i++ {
+		next := strings.IndexByte(s.src[start:], '\n')
+		if next < 0 {
+			return ""
+		}
+		start += next + 1
+	}
+	text := s.src[start:]
+	if end := strings.IndexByte(text, '\n'); end >= 0 {
+		text = text[:end]
+	}
+	return text
+}
+
+// shellVariable skips "$#" or a "${...}" expansion starting at s.pos. It
+// returns false for other uses of "$", which are read as words.
+func (s *scanner) shellVariable() bool {
+	rest := s.src[s.pos:]
+	end := 0
+	switch {
+	case strings.HasPrefix(rest, "$#"):
+		end = 2
+	case strings.HasPrefix(rest, "${"):
+		depth := 0
+		for i := 1; i < len(rest) && rest[i] != '\n' && end == 0; i++ {
+			switch rest[i] {
+			case '{':
+				depth++
+			case '}':
+				depth--
+				if depth == 0 {
+					end = i + 1
+				}
+			}
+		}
+		if end == 0 {
+			return false // Unterminated, so read as a word
+		}
+	default:
+		return false
+	}
+	s.pos += end
+	s.lineHasCode = true
+	s.afterValue = true
+	s.lineEnds[s.line] = '}'
+	return true
+}
+
+// isLifetime reports whether the quote at s.pos starts a lifetime like 'a
+// rather than a character literal like 'a'
+func (s *scanner) isLifetime() bool {
+	r, size := utf8.DecodeRuneInString(s.src[s.pos+1:])
+	if r == utf8.RuneError || r == '\\' {
+		return false
+	}
+	next := s.pos + 1 + size
+	return isWordChar(s.src[s.pos+1]) && (next >= len(s.src) || s.src[next] != '\'')
+}
+
+// restOfLineIsBlank reports whether only whitespace or a line comment follows
+// pos on its line
+func (s *scanner) restOfLineIsBlank(pos int) bool {
+	rest := s.src[pos:]
+	if i := strings.IndexByte(rest, '\n'); i >= 0 {
+		rest = rest[:i]
+	}
+	rest = strings.TrimSpace(rest)
+	if rest == "" {
+		return true
+	}
+	for _, token := range s.syntax.LineComments {
+		if strings.HasPrefix(rest, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// regexLiteral skips a regex literal starting at s.pos. It returns false if
+// the line ends before the closing slash, in which case the slash is left to
+// be treated as an operator.
+func (s *scanner) regexLiteral() bool {
+	if s.pos+1 < len(s.src) && (s.src[s.pos+1] == '/' || s.src[s.pos+1] == '*') {
+		return false
+	}
+	inClass := false
+	for i := s.pos + 1; i < len(s.src); i++ {
+		switch s.src[i] {
+		case '\\':
+			i++
+		case '[':
+			inClass = true
+		case ']':
+			inClass = false
+		case '\n':
+			return false
+		case '/':
+			if inClass {
+				continue
+			}
+			// Skip flags
+			i++
+			for i < len(s.src) && isWordChar(s.src[i]) {
+				i++
+			}
+			s.pos = i
+			s.lineHasCode = true
+			s.afterValue = true
+			return true
+		}
+	}
+	return false
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,356 @@
+package lexer
+
+import "testing"
+
+func TestComments(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		src  string
+		want []Comment
+	}{
+		{
+			name: "go line and block comments",
+			file: "x.go",
+			src:  "// top\nx := 1 // inline\n/* a\n b */ y",
+			want: []Comment{
+				{Text: " top", Delims: Delimiters{Start: "//"}, Line: 1, EndLine: 1},
+				{Text: " inline", Delims: Delimiters{Start: "//"}, Line: 2, EndLine: 2, Inline: true},
+				{Text: " a\n b ", Delims: Delimiters{Start: "/*", End: "*/"}, Line: 3, EndLine: 4, Block: true},
+			},
+		},
+		{
+			name: "go strings hide comment tokens",
+			file: "x.go",
+			src:  "url := \"http://x\" // real\ns := `/* not\na comment */`\nr := '\\''\n// after",
+			want: []Comment{
+				{Text: " real", Delims: Delimiters{Start: "//"}, Line: 1, EndLine: 1, Inline: true},
+				{Text: " after", Delims: Delimiters{Start: "//"}, Line: 5, EndLine: 5},
+			},
+		},
+		{
+			name: "escaped quote does not end a string",
+			file: "x.go",
+			src:  `s := "say \"// hi\"" // real`,
+			want: []Comment{
+				{Text: " real", Delims: Delimiters{Start: "//"}, Line: 1, EndLine: 1, Inline: true},
+			},
+		},
+		{
+			name: "unterminated string stops at end of line",
+			file: "x.go",
+			src:  "s := \"oops\n// real",
+			want: []Comment{
+				{Text: " real", Delims: Delimiters{Start: "//"}, Line: 2, EndLine: 2},
+			},
+		},
+		{
+			name: "javascript regex and template literals",
+			file: "x.js",
+			src:  "const re = /https?:\\/\\//g;\nconst t = `// not\n${x}`;\nconst d = a / b; // real",
+			want: []Comment{
+				{Text: " real", Delims: Delimiters{Start: "//"}, Line: 4, EndLine: 4, Inline: true},
+			},
+		},
+		{
+			name: "javascript regex after keyword",
+			file: "x.js",
+			src:  "return /\\/\\//.test(s) // real",
+			want: []Comment{
+				{Text: " real", Delims: Delimiters{Start: "//"}, Line: 1, EndLine: 1, Inline: true},
+			},
+		},
+		{
+			name: "python docstrings are comments",
+			file: "x.py",
+			src:  "def f():\n    \"\"\"Doc\n    more\"\"\"\n    return '#not' # real",
+			want: []Comment{
+				{Text: "Doc\n    more", Delims: Delimiters{Start: `"""`, End: `"""`}, Line: 2, EndLine: 3, Block: true},
+				{Text: " real", Delims: Delimiters{Start: "#"}, Line: 4, EndLine: 4, Inline: true},
+			},
+		},
+		{
+			name: "python docstrings of modules and classes",
+			file: "x.py",
+			src:  "#!/usr/bin/env python\n\"\"\"Module\"\"\"\n\"\"\"Not a docstring\"\"\"\nclass A(\n    B,\n):\n    # note\n    '''Class'''\n    async def f(self):\n        '''Method'''",
+			want: []Comment{
+				{Text: "!/usr/bin/env python", Delims: Delimiters{Start: "#"}, Line: 1, EndLine: 1},
+				{Text: "Module", Delims: Delimiters{Start: `"""`, End: `"""`}, Line: 2, EndLine: 2, Block: true},
+				{Text: " note", Delims: Delimiters{Start: "#"}, Line: 7, EndLine: 7},
+				{Text: "Class", Delims: Delimiters{Start: "'''", End: "'''"}, Line: 8, EndLine: 8, Block: true},
+				{Text: "Method", Delims: Delimiters{Start: "'''", End: "'''"}, Line: 10, EndLine: 10, Block: true},
+			},
+		},
+		{
+			name: "python strings after the first statement are not docstrings",
+			file: "x.py",
+			src:  "x = 1\n\"\"\"Not\"\"\"\nif x:\n    \"\"\"Not\"\"\"\ndef f():\n    x = 1\n    \"\"\"Not\"\"\"\n\"\"\"Not\"\"\"",
+			want: nil,
+		},
+		{
+			name: "shell variables with hashes are code",
+			file: "x.sh",
+			src:  "echo $# ${#args[@]} ${path##*/} # real\nx=${y:-{}} # also real",
+			want: []Comment{
+				{Text: " real", Delims: Delimiters{Start: "#"}, Line: 1, EndLine: 1, Inline: true},
+				{Text: " also real", Delims: Delimiters{Start: "#"}, Line: 2, EndLine: 2, Inline: true},
+			},
+		},
+		{
+			name: "ruby block comments only at the start of a line",
+			file: "x.rb",
+			src:  "x =begin_value # real\n=begin\nin comment\n  =end still\n=end\ny",
+			want: []Comment{
+				{Text: " real", Delims: Delimiters{Start: "#"}, Line: 1, EndLine: 1, Inline: true},
+				{Text: "\nin comment\n  =end still\n", Delims: Delimiters{Start: "=begin", End: "=end", LineStart: true}, Line: 2, EndLine: 5, Block: true},
+			},
+		},
+		{
+			name: "shell hash inside a word is code",
+			file: "x.sh",
+			src:  "echo foo#bar\t# real\n# also real",
+			want: []Comment{
+				{Text: " real", Delims: Delimiters{Start: "#"}, Line: 1, EndLine: 1, Inline: true},
+				{Text: " also real", Delims: Delimiters{Start: "#"}, Line: 2, EndLine: 2},
+			},
+		},
+		{
+			name: "yaml url fragments are not comments",
+			file: "x.yaml",
+			src:  "url: http://x/#frag # real",
+			want: []Comment{
+				{Text: " real", Delims: Delimiters{Start: "#"}, Line: 1, EndLine: 1, Inline: true},
+			},
+		},
+		{
+			name: "python string expressions are not comments",
+			file: "x.py",
+			src:  "x = \"\"\"# not\n\"\"\"\nf('''a''', b)\n# real",
+			want: []Comment{
+				{Text: " real", Delims: Delimiters{Start: "#"}, Line: 4, EndLine: 4},
+			},
+		},
+		{
+			name: "rust nested block comments and lifetimes",
+			file: "x.rs",
+			src:  "/* a /* b */ c */\nfn f<'a>(x: &'a str) -> char { '\\'' } // real",
+			want: []Comment{
+				{Text: " a /* b */ c ", Delims: Delimiters{Start: "/*", End: "*/"}, Line: 1, EndLine: 1, Block: true},
+				{Text: " real", Delims: Delimiters{Start: "//"}, Line: 2, EndLine: 2, Inline: true},
+			},
+		},
+		{
+			name: "lua block comments and long strings",
+			file: "x.lua",
+			src:  "--[[ block\n]]\ns = [[ -- not ]] -- real",
+			want: []Comment{
+				{Text: " block\n", Delims: Delimiters{Start: "--[[", End: "]]"}, Line: 1, EndLine: 2, Block: true},
+				{Text: " real", Delims: Delimiters{Start: "--"}, Line: 3, EndLine: 3, Inline: true},
+			},
+		},
+		{
+			name: "haskell nested comments",
+			file: "x.hs",
+			src:  "{- a {- b -} -}\nx' = \"--\" -- real",
+			want: []Comment{
+				{Text: " a {- b -} ", Delims: Delimiters{Start: "{-", End: "-}"}, Line: 1, EndLine: 1, Block: true},
+				{Text: " real", Delims: Delimiters{Start: "--"}, Line: 2, EndLine: 2, Inline: true},
+			},
+		},
+		{
+			name: "html comments in prose",
+			file: "x.html",
+			src:  "<p>Don't</p>\n<!-- real -->",
+			want: []Comment{
+				{Text: " real ", Delims: Delimiters{Start: "<!--", End: "-->"}, Line: 2, EndLine: 2, Block: true},
+			},
+		},
+		{
+			name: "sql strings",
+			file: "x.sql",
+			src:  "SELECT '--not' -- real",
+			want: []Comment{
+				{Text: " real", Delims: Delimiters{Start: "--"}, Line: 1, EndLine: 1, Inline: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Comments(tt.src, &ForFile(tt.file).Syntax)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d comments, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("comment %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBlocks(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		src  string
+		want []Block
+	}{
+		{
+			name: "go functions and nested blocks",
+			file: "x.go",
+			src:  "func a() {\n\tif x {\n\t\ty()\n\t}\n}\n\nfunc b(\n\tx int,\n) error {\n\treturn nil\n}",
+			want: []Block{{Line: 1, EndLine: 5}, {Line: 2, EndLine: 4}, {Line: 7, EndLine: 11}},
+		},
+		{
+			name: "braces in strings and comments are ignored",
+			file: "x.go",
+			src:  "func a() {\n\ts := \"}\" // }\n\t/* { */\n}",
+			want: []Block{{Line: 1, EndLine: 4}},
+		},
+		{
+			name: "python indentation",
+			file: "x.py",
+			src:  "class A:\n    def f(self,\n          x):\n        d = {'a': 1}\n\n        return x\n\n    g = lambda y: y\nprint(1)",
+			want: []Block{{Line: 1, EndLine: 8}, {Line: 2, EndLine: 6}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Blocks(tt.src, &ForFile(tt.file).Syntax)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d blocks, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("block %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSymbols(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		src  string
+		want []Symbol
+	}{
+		{
+			name: "go functions, methods and types",
+			file: "x.go",
+			src:  "type T struct {\n\tx int\n}\n\nfunc (t *T) Get() int {\n\tif t != nil {\n\t\treturn t.x\n\t}\n\treturn 0\n}\n\nfunc run(\n\tx int,\n) {\n}",
+			want: []Symbol{
+				{Kind: "type", Name: "T", Line: 1, EndLine: 3},
+				{Kind: "method", Name: "Get", Line: 5, EndLine: 10},
+				{Kind: "function", Name: "run", Line: 12, EndLine: 15},
+			},
+		},
+		{
+			name: "python methods are functions in a class",
+			file: "x.py",
+			src:  "class A:\n    def f(self):\n        if x:\n            pass\n\ndef g():\n    return 1",
+			want: []Symbol{
+				{Kind: "class", Name: "A", Line: 1, EndLine: 4},
+				{Kind: "method", Name: "f", Line: 2, EndLine: 4},
+				{Kind: "function", Name: "g", Line: 6, EndLine: 7},
+			},
+		},
+		{
+			name: "javascript classes and arrow functions",
+			file: "x.ts",
+			src:  "export class Store {\n  async load(id: string) {\n    for (const x of y) {\n    }\n  }\n}\nconst save = async (s) => {\n  call(s, () => {\n  })\n}",
+			want: []Symbol{
+				{Kind: "class", Name: "Store", Line: 1, EndLine: 6},
+				{Kind: "method", Name: "load", Line: 2, EndLine: 5},
+				{Kind: "function", Name: "save", Line: 7, EndLine: 10},
+			},
+		},
+		{
+			name: "rust impl blocks",
+			file: "x.rs",
+			src:  "impl<T> Display for Wrapper<T> {\n    fn fmt(&self) -> Result {\n    }\n}",
+			want: []Symbol{
+				{Kind: "impl", Name: "Wrapper", Line: 1, EndLine: 4},
+				{Kind: "method", Name: "fmt", Line: 2, EndLine: 3},
+			},
+		},
+		{
+			name: "c functions but not control flow",
+			file: "x.c",
+			src:  "static int *parse(const char *s)\n{\n    while (*s) {\n    }\n}",
+			want: []Symbol{
+				{Kind: "function", Name: "parse", Line: 1, EndLine: 5},
+			},
+		},
+		{
+			name: "languages without patterns have no symbols",
+			file: "x.rb",
+			src:  "def f\nend",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Symbols(tt.src, ForFile(tt.file))
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d symbols, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("symbol %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestForFile(t *testing.T) {
+	tests := map[string]string{
+		"main.go":            "Go",
+		"src/app.tsx":        "TypeScript",
+		"lib.rs":             "Rust",
+		"Main.JAVA":          "Java",
+		"script.sh":          "Shell",
+		"config/app.yaml":    "YAML",
+		"README.md":          "Markdown",
+		"Makefile":           "Makefile",
+		"docker/Dockerfile":  "Dockerfile",
+		"Gemfile":            "Ruby",
+		"include/widget.hpp": "C++",
+		"queries/report.sql": "SQL",
+	}
+	for path, want := range tests {
+		lang := ForFile(path)
+		if lang == nil || lang.Name != want {
+			t.Errorf("ForFile(%q) = %v, want %s", path, lang, want)
+		}
+	}
+
+	for _, path := range []string{"notes.txt", "LICENSE", "image.png"} {
+		if lang := ForFile(path); lang != nil {
+			t.Errorf("ForFile(%q) = %s, want nil", path, lang.Name)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	t.Cleanup(Snapshot())
+	Register(&Language{
+		Name:       "Jinja",
+		Extensions: []string{".j2"},
+		Syntax:     Syntax{BlockComments: []Delimiters{{Start: "{#", End: "#}"}}},
+	})
+
+	lang := ForFile("templates/page.j2")
+	if lang == nil || lang.Name != "Jinja" {
+		t.Fatalf("ForFile() = %v, want Jinja", lang)
+	}
+	got := Comments("<p>{{ x }}</p>{# note #}", &lang.Syntax)
+	if len(got) != 1 || got[0].Text != " note " || !got[0].Inline {
+		t.Errorf("unexpected comments %+v", got)
+	}
+}
@@ -0,0 +1,161 @@
+package lexer
+
+import (
+	"regexp"
+	"strings"
+)
+
+// SymbolPattern recognises a block's opening statement as a named symbol.
+// The pattern's first group is the name.
+type SymbolPattern struct {
+	Kind    string // e.g. "function", "method", "type" or "class"
+	Pattern *regexp.Regexp
+	Member  bool // Only matches blocks directly inside a class or type
+}
+
+// Symbol is a named block, such as a function or class
+type Symbol struct {
+	Kind    string
+	Name    string
+	Line    int // First line of the statement that declares it (1-indexed)
+	EndLine int // Last line (1-indexed)
+}
+
+// maxHeaderLines is how many lines of a block's opening statement are matched
+// against symbol patterns
+const maxHeaderLines = 4
+
+// containerKinds are the symbol kinds whose functions are methods
+var containerKinds = map[string]bool{"class": true, "type": true, "impl": true}
+
+// notSymbols are keywords that open blocks but look like a function name to
+// the looser patterns
+var notSymbols = map[string]bool{
+	"if": true, "else": true, "for": true, "foreach": true, "while": true,
+	"do": true, "switch": true, "catch": true, "try": true, "with": true,
+	"return": true, "match": true, "new": true, "sizeof": true, "typeof": true,
+	"function": true, "synchronized": true,
+}
+
+func symbolPatterns(kind string, member bool, patterns ...string) []SymbolPattern {
+	var out []SymbolPattern
+	for _, p := range patterns {
+		out = append(out, SymbolPattern{Kind: kind, Pattern: regexp.MustCompile(`^\s*` + p), Member: member})
+	}
+	return out
+}
+
+func joinPatterns(groups ...[]SymbolPattern) []SymbolPattern {
+	var out []SymbolPattern
+	for _, g := range groups {
+		out = append(out, g...)
+	}
+	return out
+}
+
+var (
+	goSymbols = joinPatterns(
+		symbolPatterns("method", false, `func\s*\([^)]*\)\s*(\w+)`),
+		symbolPatterns("function", false, `func\s+(\w+)`),
+		symbolPatterns("type", false, `type\s+(\w+)`),
+	)
+	jsSymbols = joinPatterns(
+		symbolPatterns("class", false, `(?:export\s+)?(?:default\s+)?(?:abstract\s+)?class\s+(\w+)`),
+		symbolPatterns("type", false, `(?:export\s+)?(?:interface|enum)\s+(\w+)`, `(?:export\s+)?type\s+(\w+)[^=]*=`),
+		symbolPatterns("function", false,
+			`(?:export\s+)?(?:default\s+)?(?:async\s+)?function\s*\*?\s*(\w+)`,
+			`(?:export\s+)?(?:const|let|var)\s+(\w+)\s*(?::[^=]+)?=\s*(?:async\s+)?(?:function\b|\([^)]*\)\s*(?::[^=]+)?=>|\w+\s*=>)`),
+		symbolPatterns("method", true, `(?:(?:public|private|protected|static|async|get|set|override|readonly)\s+)*\*?(\w+)\s*\(`),
+	)
+	pythonSymbols = joinPatterns(
+		symbolPatterns("class", false, `class\s+(\w+)`),
+		symbolPatterns("function", false, `(?:async\s+)?def\s+(\w+)`),
+	)
+	rustSymbols = joinPatterns(
+		symbolPatterns("function", false, `(?:pub(?:\([^)]*\))?\s+)?(?:(?:async|const|unsafe|extern\s+"[^"]*")\s+)*fn\s+(\w+)`),
+		symbolPatterns("type", false, `(?:pub(?:\([^)]*\))?\s+)?(?:unsafe\s+)?(?:struct|enum|union|trait)\s+(\w+)`),
+		symbolPatterns("impl", false, `(?:unsafe\s+)?impl\b(?:\s*<[^>]*>)?\s+(?:[\w:<>, ]+?\s+for\s+)?(?:\w+::)*(\w+)`),
+	)
+	javaSymbols = joinPatterns(
+		symbolPatterns("class", false, `(?:(?:public|private|protected|static|final|abstract|sealed|non-sealed)\s+)*(?:class|interface|enum|record|@interface)\s+(\w+)`),
+		symbolPatterns("method", true,
+			`(?:(?:public|private|protected|static|final|abstract|synchronized|native|default)\s+)*(?:<[^>]*>\s*)?[\w.<>\[\], ?]+\s+(\w+)\s*\(`,
+			`(?:(?:public|private|protected)\s+)?(\w+)\s*\(`),
+	)
+	kotlinSymbols = joinPatterns(
+		symbolPatterns("class", false, `(?:\w+\s+)*(?:class|interface|object)\s+(\w+)`),
+		symbolPatterns("function", false, `(?:\w+\s+)*fun\s+(?:<[^>]*>\s*)?(?:[\w.]+\.)?(\w+)`),
+	)
+	cSymbols = joinPatterns(
+		symbolPatterns("type", false, `(?:typedef\s+)?(?:struct|union|enum)\s+(\w+)`),
+		symbolPatterns("function", false, `(?:[\w*]+[\s*]+)+(\w+)\s*\(`),
+	)
+	cppSymbols = joinPatterns(
+		symbolPatterns("class", false, `(?:template\s*<[^>]*>\s*)?(?:class|struct|union)\s+(\w+)`),
+		symbolPatterns("type", false, `enum\s+(?:class\s+)?(\w+)`),
+		symbolPatterns("namespace", false, `namespace\s+(\w+)`),
+		symbolPatterns("method", false, `(?:[\w:*&<>,]+[\s*&]+)*\w+::(~?\w+)\s*\(`),
+		symbolPatterns("function", false, `(?:template\s*<[^>]*>\s*)?(?:[\w:*&<>,]+[\s*&]+)+(\w+)\s*\(`),
+		symbolPatterns("method", true, `(?:(?:virtual|explicit|inline|static)\s+)*(~?\w+)\s*\(`),
+	)
+	shellSymbols = symbolPatterns("function", false, `function\s+([\w.-]+)`, `([\w.-]+)\s*\(\)`)
+)
+
+// Symbols returns the named blocks in src, outer symbols before the symbols
+// they contain. Functions directly inside a class or type are methods.
+func Symbols(src string, lang *Language) []Symbol {
+	if len(lang.Symbols) == 0 {
+		return nil
+	}
+	lines := strings.Split(src, "\n")
+
+	var symbols []Symbol
+	var open []Symbol // Symbols enclosing the current block, outermost first
+	for _, b := range Blocks(src, &lang.Syntax) {
+		for len(open) > 0 && open[len(open)-1].EndLine < b.EndLine {
+			open = open[:len(open)-1]
+		}
+		var parent *Symbol
+		if len(open) > 0 {
+			parent = &open[len(open)-1]
+		}
+
+		sym, ok := matchSymbol(lang.Symbols, blockHeader(lines, b), parent)
+		if !ok {
+			continue
+		}
+		sym.Line, sym.EndLine = b.Line, b.EndLine
+		symbols = append(symbols, sym)
+		open = append(open, sym)
+	}
+	return symbols
+}
+
+// blockHeader returns the statement that opens a block, up to its brace
+func blockHeader(lines []string, b Block) string {
+	end := min(b.EndLine, b.Line+maxHeaderLines-1, len(lines))
+	header := strings.Join(lines[b.Line-1:end], " ")
+	if i := strings.Index(header, "{"); i >= 0 {
+		header = header[:i]
+	}
+	return header
+}
+
+func matchSymbol(patterns []SymbolPattern, header string, parent *Symbol) (Symbol, bool) {
+	inContainer := parent != nil && containerKinds[parent.Kind]
+	for _, p := range patterns {
+		if p.Member && !inContainer {
+			continue
+		}
+		m := p.Pattern.FindStringSubmatch(header)
+		if m == nil || notSymbols[m[1]] {
+			continue
+		}
+		kind := p.Kind
+		if kind == "function" && inContainer {
+			kind = "method"
+		}
+		return Symbol{Kind: kind, Name: m[1]}, true
+	}
+	return Symbol{}, false
+}
@@ -0,0 +1,258 @@
+// Package processed remembers which AI comments have already been sent to
+// claude, so that they aren't sent again after a restart.
+package processed
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Entry is a comment that has been processed
+type Entry struct {
+	Fingerprint string    `json:"fingerprint"`
+	File        string    `json:"file"`
+	Line        int       `json:"line"` // Where the comment was when it was processed
+	Content     string    `json:"content"`
+	ActionType  string    `json:"action_type"`
+	Processed   time.Time `json:"processed"`
+}
+
+// Store is a set of processed comments, saved as a JSON file. It is safe for
+// concurrent use.
+type Store struct {
+	path   string // Empty for a store that is only kept in memory
+	expiry time.Duration
+	now    func() time.Time
+
+	mutex   sync.Mutex
+	entries map[string]Entry
+}
+
+// New creates a store saved at path, or kept only in memory if path is empty.
+// Entries older than expiry are dropped; zero means they never expire. Call
+// Load to read existing entries.
+func New(path string, expiry time.Duration) *Store {
+	return &Store{
+		path:    path,
+		expiry:  expiry,
+		now:     time.Now,
+		entries: make(map[string]Entry),
+	}
+}
+
+// DefaultPath returns the store location for a repository:
+// $XDG_STATE_HOME/clawde/processed/<name>-<hash>.json, where the hash
+// distinguishes repositories with the same directory name
+func DefaultPath(root string) string {
+	stateDir := os.Getenv("XDG_STATE_HOME")
+	if stateDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		stateDir = filepath.Join(home, ".local", "state")
+	}
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	}
+	sum := sha256.Sum256([]byte(root))
+	name := fmt.Sprintf("%s-%x.json", filepath.Base(root), sum[:4])
+	return filepath.Join(stateDir, "clawde", "processed", name)
+}
+
+// Path returns the file the store is saved to
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Load reads the saved entries. A missing file is not an error.
+func (s *Store) Load() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries, err := s.read()
+	if err != nil {
+		return err
+	}
+	for fp, e := range entries {
+		s.entries[fp] = e
+	}
+	s.prune()
+	return nil
+}
+
+// Contains reports whether a comment with the fingerprint has been processed
+func (s *Store) Contains(fingerprint string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	e, ok := s.entries[fingerprint]
+	return ok && !s.expired(e)
+}
+
+// Add records a processed comment and saves the store
+func (s *Store) Add(e Entry) error {
+	return s.AddAll([]Entry{e})
+}
+
+// AddAll records processed comments and saves the store once
+func (s *Store) AddAll(entries []Entry) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+	for _, e := range entries {
+		if e.Processed.IsZero() {
+			e.Processed = now
+		}
+		s.entries[e.Fingerprint] = e
+	}
+
+	// Another clawde in the same repository may have added entries since we
+	// loaded, so merge them before writing. The lock stops it writing in
+	// between.
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	saved, err := s.read()
+	if err != nil {
+		return err
+	}
+	for fp, saved := range saved {
+		if _, ok := s.entries[fp]; !ok {
+			s.entries[fp] = saved
+		}
+	}
+	s.prune()
+	return s.write()
+}
+
+// Entries returns the unexpired entries, oldest first
+func (s *Store) Entries() []Entry {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.prune()
+	entries := make([]Entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Processed.Before(entries[j].Processed)
+	})
+	return entries
+}
+
+// Clear forgets every entry and removes the saved file
+func (s *Store) Clear() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.entries = make(map[string]Entry)
+	if s.path == "" {
+		return nil
+	}
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *Store) expired(e Entry) bool {
+	return s.expiry > 0 && s.now().Sub(e.Processed) > s.expiry
+}
+
+func (s *Store) prune() {
+	for fp, e := range s.entries {
+		if s.expired(e) {
+			delete(s.entries, fp)
+		}
+	}
+}
+
+// lock takes an advisory lock on the file next to the store, shared by every
+// clawde using it, and returns a function that releases it
+func (s *Store) lock() (func(), error) {
+	if s.path == "" {
+		return func() {}, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(s.path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("lock processed comment store %s: %w", s.path, err)
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+func (s *Store) read() (map[string]Entry, error) {
+	if s.path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid processed comment store %s: %w", s.path, err)
+	}
+	byFingerprint := make(map[string]Entry, len(entries))
+	for _, e := range entries {
+		byFingerprint[e.Fingerprint] = e
+	}
+	return byFingerprint, nil
+}
+
+// write saves the entries, replacing the file atomically
+func (s *Store) write() error {
+	if s.path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+
+	entries := make([]Entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Processed.Before(entries[j].Processed)
+	})
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
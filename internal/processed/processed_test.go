@@ -0,0 +1,117 @@
+package processed
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "processed.json")
+
+	s := New(path, 0)
+	if err := s.Load(); err != nil {
+		t.Fatalf("Load() of missing file error = %v", err)
+	}
+	if err := s.Add(Entry{Fingerprint: "abc", File: "main.go", Content: "Fix this AI!", ActionType: "!"}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	reopened := New(path, 0)
+	if err := reopened.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !reopened.Contains("abc") {
+		t.Error("expected entry to survive a reload")
+	}
+	if entries := reopened.Entries(); len(entries) != 1 || entries[0].File != "main.go" || entries[0].Processed.IsZero() {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+
+	if err := reopened.Clear(); err != nil {
+		t.Fatalf("Clear() error = %v", err)
+	}
+	again := New(path, 0)
+	again.Load()
+	if again.Contains("abc") {
+		t.Error("expected Clear to remove saved entries")
+	}
+}
+
+func TestStoreMergesConcurrentWriters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "processed.json")
+	a, b := New(path, 0), New(path, 0)
+
+	a.Add(Entry{Fingerprint: "one"})
+	b.Add(Entry{Fingerprint: "two"})
+
+	s := New(path, 0)
+	s.Load()
+	if !s.Contains("one") || !s.Contains("two") {
+		t.Errorf("expected both writers' entries, got %+v", s.Entries())
+	}
+}
+
+func TestStoreLocksAroundWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "processed.json")
+
+	// Without the lock, writers that read before each other's writes would
+	// lose entries
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s := New(path, 0)
+			for j := 0; j < 10; j++ {
+				if err := s.AddAll([]Entry{{Fingerprint: fmt.Sprintf("%d-%d", i, j)}}); err != nil {
+					t.Errorf("AddAll() error = %v", err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	s := New(path, 0)
+	if err := s.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got := len(s.Entries()); got != 80 {
+		t.Errorf("expected 80 entries, got %d", got)
+	}
+}
+
+func TestStoreExpiry(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := New("", 24*time.Hour)
+	s.now = func() time.Time { return now }
+
+	s.Add(Entry{Fingerprint: "old", Processed: now.Add(-48 * time.Hour)})
+	s.Add(Entry{Fingerprint: "new"})
+
+	if s.Contains("old") {
+		t.Error("expected expired entry to be forgotten")
+	}
+	if !s.Contains("new") {
+		t.Error("expected recent entry to be kept")
+	}
+
+	now = now.Add(25 * time.Hour)
+	if s.Contains("new") {
+		t.Error("expected entry to expire")
+	}
+}
+
+func TestDefaultPath(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", "/state")
+	a, b := DefaultPath("/src/one/app"), DefaultPath("/src/two/app")
+	if a == b {
+		t.Error("expected repositories with the same name to get different stores")
+	}
+	if !strings.HasPrefix(a, "/state/clawde/processed/app-") {
+		t.Errorf("unexpected path %s", a)
+	}
+}
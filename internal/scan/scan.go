@@ -0,0 +1,223 @@
+// Package scan searches many files for any of a set of strings, with a fixed
+// number of workers, reading each file in chunks and stopping at the first
+// match.
+package scan
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	chunkSize = 32 * 1024
+
+	// binaryCheckSize is how much of a file is checked for NUL bytes, the same
+	// as git uses
+	binaryCheckSize = 8000
+)
+
+// Result is what scanning one file found
+type Result int
+
+const (
+	NoMatch Result = iota
+	Match
+	Binary   // The file looks like a binary, so wasn't searched
+	TooLarge // The file is over MaxFileSize
+)
+
+// Stats counts the work a scan has done
+type Stats struct {
+	Files    int64 // Files scanned so far
+	Matched  int64
+	Binary   int64
+	TooLarge int64
+	Errors   int64
+	Bytes    int64 // Bytes read
+	Duration time.Duration
+}
+
+// Scanner searches files for strings
+type Scanner struct {
+	Needles          []string // Matched case-insensitively, for ASCII letters
+	MaxFileSize      int64    // Larger files are skipped. 0 is no limit.
+	Workers          int      // Files scanned at once. At least one is used.
+	Progress         func(Stats)
+	ProgressInterval time.Duration // How often Progress is called during a scan
+}
+
+// Run scans the paths sent on paths until it's closed or ctx is done, and
+// returns the ones that match, in no particular order. The sender should
+// stop when ctx is done.
+func (s *Scanner) Run(ctx context.Context, paths <-chan string) ([]string, Stats, error) {
+	start := time.Now()
+	needles := lowerNeedles(s.Needles)
+
+	var (
+		stats   counters
+		mutex   sync.Mutex
+		matched []string
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < max(1, s.Workers); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, chunkSize)
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case path, ok := <-paths:
+					if !ok {
+						return
+					}
+					result, n, err := scanFile(path, needles, s.MaxFileSize, buf)
+					stats.add(result, n, err)
+					if result == Match {
+						mutex.Lock()
+						matched = append(matched, path)
+						mutex.Unlock()
+					}
+				}
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	if s.Progress != nil && s.ProgressInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(s.ProgressInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					s.Progress(stats.snapshot(time.Since(start)))
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(done)
+	return matched, stats.snapshot(time.Since(start)), ctx.Err()
+}
+
+func scanFile(path string, needles [][]byte, maxSize int64, buf []byte) (Result, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return NoMatch, 0, err
+	}
+	defer f.Close()
+
+	if maxSize > 0 {
+		info, err := f.Stat()
+		if err != nil {
+			return NoMatch, 0, err
+		}
+		if info.Size() > maxSize {
+			return TooLarge, 0, nil
+		}
+	}
+	return search(f, needles, buf)
+}
+
+// search reads r in chunks until one of the needles is found. The end of each
+// chunk is kept so that needles split across chunks are still found.
+func search(r io.Reader, needles [][]byte, buf []byte) (Result, int64, error) {
+	overlap := 0
+	for _, n := range needles {
+		overlap = max(overlap, len(n)-1)
+	}
+	if overlap >= len(buf)/2 {
+		buf = make([]byte, 2*overlap+chunkSize)
+	}
+
+	var total int64
+	kept := 0
+	for {
+		n, err := io.ReadFull(r, buf[kept:])
+		if total < binaryCheckSize {
+			check := buf[kept : kept+min(n, binaryCheckSize-int(total))]
+			if bytes.IndexByte(check, 0) >= 0 {
+				return Binary, total + int64(n), nil
+			}
+		}
+		total += int64(n)
+
+		window := buf[:kept+n]
+		lowerASCII(window[kept:])
+		for _, needle := range needles {
+			if bytes.Contains(window, needle) {
+				return Match, total, nil
+			}
+		}
+
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return NoMatch, total, nil
+		} else if err != nil {
+			return NoMatch, total, err
+		}
+		kept = min(overlap, len(window))
+		copy(buf, window[len(window)-kept:])
+	}
+}
+
+func lowerNeedles(needles []string) [][]byte {
+	lowered := make([][]byte, 0, len(needles))
+	for _, n := range needles {
+		if n != "" {
+			b := []byte(n)
+			lowerASCII(b)
+			lowered = append(lowered, b)
+		}
+	}
+	return lowered
+}
+
+func lowerASCII(b []byte) {
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + ('a' - 'A')
+		}
+	}
+}
+
+type counters struct {
+	files, matched, binary, tooLarge, errors, bytes atomic.Int64
+}
+
+func (c *counters) add(result Result, n int64, err error) {
+	c.files.Add(1)
+	c.bytes.Add(n)
+	switch {
+	case err != nil:
+		c.errors.Add(1)
+	case result == Match:
+		c.matched.Add(1)
+	case result == Binary:
+		c.binary.Add(1)
+	case result == TooLarge:
+		c.tooLarge.Add(1)
+	}
+}
+
+func (c *counters) snapshot(elapsed time.Duration) Stats {
+	return Stats{
+		Files:    c.files.Load(),
+		Matched:  c.matched.Load(),
+		Binary:   c.binary.Load(),
+		TooLarge: c.tooLarge.Load(),
+		Errors:   c.errors.Load(),
+		Bytes:    c.bytes.Load(),
+		Duration: elapsed,
+	}
+}
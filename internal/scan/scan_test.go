@@ -0,0 +1,88 @@
+package scan
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSearch(t *testing.T) {
+	needles := lowerNeedles([]string{"AI!", "@Claude?"})
+	tests := []struct {
+		name string
+		src  string
+		want Result
+	}{
+		{name: "match", src: "x := 1 // fix ai!", want: Match},
+		{name: "no match", src: "x := 1 // AI", want: NoMatch},
+		{name: "match across chunks", src: strings.Repeat("x", chunkSize-4) + "@CLAUDE? y", want: Match},
+		{name: "binary", src: "\x7fELF\x00\x00AI!", want: Binary},
+		{name: "NUL after the check is text", src: strings.Repeat("x", binaryCheckSize) + "\x00 AI!", want: Match},
+		{name: "empty", src: "", want: NoMatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := search(strings.NewReader(tt.src), needles, make([]byte, chunkSize))
+			if err != nil {
+				t.Fatalf("search() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("search() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.go":   "// AI! do it",
+		"b.go":   "// nothing here",
+		"c.go":   "// ai? why",
+		"big.go": strings.Repeat("// AI!\n", 100),
+		"bin.go": "\x00AI!",
+	}
+	var paths []string
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	ch := make(chan string, len(paths))
+	for _, p := range paths {
+		ch <- p
+	}
+	close(ch)
+
+	s := &Scanner{Needles: []string{"AI!", "AI?"}, MaxFileSize: 100, Workers: 2}
+	matched, stats, err := s.Run(context.Background(), ch)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	slices.Sort(matched)
+	want := []string{filepath.Join(dir, "a.go"), filepath.Join(dir, "c.go")}
+	if !slices.Equal(matched, want) {
+		t.Errorf("matched = %v, want %v", matched, want)
+	}
+	if stats.Files != 5 || stats.Matched != 2 || stats.Binary != 1 || stats.TooLarge != 1 || stats.Errors != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Nothing is ever sent, so Run only returns because ctx is done
+	ch := make(chan string)
+	s := &Scanner{Needles: []string{"AI!"}, Workers: 4}
+	if _, _, err := s.Run(ctx, ch); err != context.Canceled {
+		t.Errorf("Run() error = %v, want context.Canceled", err)
+	}
+}
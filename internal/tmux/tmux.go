@@ -153,3 +153,12 @@ func StopPipePane(paneID string) error {
 	}
 	return nil
 }
+
+// SplitWindow opens a new pane beside the current one running a shell command
+func SplitWindow(command string) error {
+	cmd := exec.Command("tmux", "split-window", "-h", command)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to split window: %w", err)
+	}
+	return nil
+}
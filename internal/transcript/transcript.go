@@ -0,0 +1,256 @@
+// Package transcript turns Claude's terminal output into a transcript of the
+// conversation: user prompts, assistant messages and tool calls.
+//
+// It works on plain text lines from the screen model rather than the raw
+// output stream, so colours and cursor movement are already gone. Lines should
+// only be added once Claude has finished drawing them, which in practice means
+// once they have scrolled off the top of the screen.
+package transcript
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattduck/clawde/internal/diffparser"
+)
+
+// Entry kinds
+const (
+	KindUser      = "user"
+	KindAssistant = "assistant"
+	KindTool      = "tool"
+)
+
+// Entry is one item in the conversation
+type Entry struct {
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"`
+	Text string    `json:"text,omitempty"` // Message text, or the tool's output
+	Tool string    `json:"tool,omitempty"`
+	Args string    `json:"args,omitempty"`
+	Diff string    `json:"diff,omitempty"` // Unified diff for tools that edit files
+}
+
+// EntryWriter receives entries as they are completed
+type EntryWriter interface {
+	WriteEntry(Entry) error
+}
+
+// Markers at the start of lines in Claude's output
+const (
+	userMarker      = "> "
+	assistantMarker = "⏺ "
+	resultMarker    = "⎿"
+	indent          = "  "
+)
+
+// Extractor groups lines into entries and writes each entry once. It is safe
+// for concurrent use.
+type Extractor struct {
+	mutex sync.Mutex
+	w     EntryWriter
+	now   func() time.Time
+
+	current *Entry
+	raw     []string // Lines of the current entry as they appeared
+	blanks  int      // Blank lines seen since the last line of the current entry
+
+	// Claude redraws the conversation in some cases (e.g. after a resize),
+	// so the entries just written can scroll past again, in the same order.
+	// Entries that repeat recent ones are held until it's clear whether
+	// they're a redraw, which replays up to the last entry, or a genuine
+	// repeat, which goes on to something else.
+	recent     []string // Keys of the last recentEntries entries written
+	held       []Entry  // Entries that may be a redraw
+	candidates []int    // Where in recent the held entries may have started
+
+	err error // First write error
+}
+
+// NewExtractor creates an extractor that writes entries to w
+func NewExtractor(w EntryWriter) *Extractor {
+	return &Extractor{w: w, now: time.Now}
+}
+
+// AddLine adds a finished line of output
+func (e *Extractor) AddLine(line string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.addLine(line)
+}
+
+// Flush adds the lines still on screen and writes the entry in progress.
+// Call it when the session ends.
+func (e *Extractor) Flush(lines []string) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for _, line := range lines {
+		e.addLine(line)
+	}
+	e.finish()
+	e.release()
+	return e.err
+}
+
+// Err returns the first error from the writer, if any
+func (e *Extractor) Err() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.err
+}
+
+func (e *Extractor) addLine(line string) {
+	line = strings.TrimRight(line, " ")
+
+	switch {
+	case strings.HasPrefix(line, userMarker):
+		e.start(Entry{Kind: KindUser, Text: strings.TrimPrefix(line, userMarker)}, line)
+
+	case strings.HasPrefix(line, assistantMarker):
+		if tool, args, ok := diffparser.ParseToolCall(line); ok {
+			e.start(Entry{Kind: KindTool, Tool: tool, Args: args}, line)
+		} else {
+			e.start(Entry{Kind: KindAssistant, Text: strings.TrimPrefix(line, assistantMarker)}, line)
+		}
+
+	case line == "":
+		if e.current != nil {
+			e.blanks++
+		}
+
+	case e.current != nil && strings.HasPrefix(line, indent):
+		e.continueEntry(line)
+
+	default:
+		// Anything else is UI chrome (input box, status lines, spinners)
+		// and ends the current entry
+		e.finish()
+	}
+}
+
+func (e *Extractor) start(entry Entry, line string) {
+	e.finish()
+	entry.Time = e.now()
+	e.current = &entry
+	e.raw = []string{line}
+}
+
+func (e *Extractor) continueEntry(line string) {
+	text := strings.TrimPrefix(line, indent)
+	if e.current.Kind == KindTool {
+		// Output is "  ⎿  first line" then indented to line up with it
+		if strings.HasPrefix(text, resultMarker) {
+			text = strings.TrimPrefix(strings.TrimPrefix(text, resultMarker), indent)
+		} else {
+			text = strings.TrimPrefix(text, "   ")
+		}
+	}
+
+	if e.current.Text != "" {
+		e.current.Text += strings.Repeat("\n", e.blanks) + "\n"
+	}
+	e.current.Text += text
+
+	for ; e.blanks > 0; e.blanks-- {
+		e.raw = append(e.raw, "")
+	}
+	e.raw = append(e.raw, line)
+}
+
+func (e *Extractor) finish() {
+	entry := e.current
+	raw := e.raw
+	e.current, e.raw, e.blanks = nil, nil, 0
+	if entry == nil {
+		return
+	}
+
+	entry.Text = strings.TrimRight(entry.Text, "\n")
+	if entry.Kind == KindTool && diffparser.IsDiffTool(entry.Tool) {
+		if diffs := diffparser.Parse(strings.Join(raw, "\n")); len(diffs) > 0 {
+			entry.Diff = diffs[0].ToUnified()
+		}
+	}
+
+	e.dedupe(*entry)
+}
+
+// recentEntries is how many entries back a redraw is recognised
+const recentEntries = 32
+
+// dedupe writes an entry unless it turns out to be part of a redraw
+func (e *Extractor) dedupe(entry Entry) {
+	key := entryKey(entry)
+
+	if len(e.held) > 0 {
+		var next []int
+		for _, i := range e.candidates {
+			if j := i + len(e.held); j < len(e.recent) && e.recent[j] == key {
+				next = append(next, i)
+			}
+		}
+		if len(next) > 0 {
+			e.hold(entry, next)
+			return
+		}
+		// Not a redraw after all
+		e.release()
+	}
+
+	var candidates []int
+	for i, k := range e.recent {
+		if k == key {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) > 0 {
+		e.hold(entry, candidates)
+		return
+	}
+	e.write(entry, key)
+}
+
+// hold adds an entry to a possible redraw, and drops the redraw once it has
+// reached the last entry written
+func (e *Extractor) hold(entry Entry, candidates []int) {
+	e.held = append(e.held, entry)
+	e.candidates = candidates
+	for _, i := range candidates {
+		if i+len(e.held) == len(e.recent) {
+			e.held, e.candidates = nil, nil
+			return
+		}
+	}
+}
+
+// release writes the held entries, which weren't a redraw
+func (e *Extractor) release() {
+	held := e.held
+	e.held, e.candidates = nil, nil
+	for _, entry := range held {
+		e.write(entry, entryKey(entry))
+	}
+}
+
+func (e *Extractor) write(entry Entry, key string) {
+	e.recent = append(e.recent, key)
+	if len(e.recent) > recentEntries {
+		e.recent = e.recent[len(e.recent)-recentEntries:]
+	}
+	if err := e.w.WriteEntry(entry); err != nil && e.err == nil {
+		e.err = err
+	}
+}
+
+// entryKey identifies an entry's content, ignoring when it was seen
+func entryKey(entry Entry) string {
+	h := sha256.New()
+	for _, s := range []string{entry.Kind, entry.Tool, entry.Args, entry.Text} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
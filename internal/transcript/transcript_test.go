@@ -0,0 +1,217 @@
+package transcript
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type recordingWriter struct {
+	entries []Entry
+}
+
+func (r *recordingWriter) WriteEntry(e Entry) error {
+	r.entries = append(r.entries, e)
+	return nil
+}
+
+const session = `> fix the failing test
+  in parser.go
+
+⏺ I'll look at the test first.
+
+  It fails on empty input.
+
+⏺ Bash(go test ./...)
+  ⎿  FAIL parser
+     ok   other
+
+⏺ Update(parser.go)
+  ⎿  Updated parser.go with 1 addition and 1 removal
+      10 -  return nil
+      10 +  return []string{}
+
+⏺ Fixed.
+
+✻ Thinking…
+────────────────────────────────
+  -- INSERT --`
+
+func extract(t *testing.T, text string) []Entry {
+	t.Helper()
+	w := &recordingWriter{}
+	e := NewExtractor(w)
+	for _, line := range strings.Split(text, "\n") {
+		e.AddLine(line)
+	}
+	if err := e.Flush(nil); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	return w.entries
+}
+
+func TestExtractEntries(t *testing.T) {
+	entries := extract(t, session)
+
+	want := []Entry{
+		{Kind: KindUser, Text: "fix the failing test\nin parser.go"},
+		{Kind: KindAssistant, Text: "I'll look at the test first.\n\nIt fails on empty input."},
+		{Kind: KindTool, Tool: "Bash", Args: "go test ./...", Text: "FAIL parser\nok   other"},
+		{Kind: KindTool, Tool: "Update", Args: "parser.go"},
+		{Kind: KindAssistant, Text: "Fixed."},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("expected %d entries, got %d: %+v", len(want), len(entries), entries)
+	}
+	for i, w := range want {
+		got := entries[i]
+		if got.Kind != w.Kind || got.Tool != w.Tool || got.Args != w.Args {
+			t.Errorf("entry %d: got %s %s(%s), want %s %s(%s)", i, got.Kind, got.Tool, got.Args, w.Kind, w.Tool, w.Args)
+		}
+		if w.Text != "" && got.Text != w.Text {
+			t.Errorf("entry %d: text = %q, want %q", i, got.Text, w.Text)
+		}
+		if got.Time.IsZero() {
+			t.Errorf("entry %d: expected a timestamp", i)
+		}
+	}
+
+	diff := entries[3].Diff
+	if !strings.Contains(diff, "-  return nil") || !strings.Contains(diff, "+  return []string{}") {
+		t.Errorf("expected unified diff for Update, got:\n%s", diff)
+	}
+}
+
+func TestRedrawIsDeduplicated(t *testing.T) {
+	// The whole conversation scrolling past twice, as after a full redraw
+	entries := extract(t, session+"\n"+session)
+	if len(entries) != 5 {
+		t.Errorf("expected redrawn entries to be dropped, got %d entries", len(entries))
+	}
+}
+
+func TestRepeatsAreKept(t *testing.T) {
+	// The same prompt and test run again later are new entries, not a redraw
+	entries := extract(t, `> yes
+
+⏺ Bash(go test ./...)
+  ⎿  FAIL parser
+
+⏺ Fixed it.
+
+> yes
+
+⏺ Bash(go test ./...)
+  ⎿  FAIL parser
+
+⏺ Still failing.
+
+────
+`)
+	var texts []string
+	for _, e := range entries {
+		texts = append(texts, e.Kind+":"+e.Text)
+	}
+	want := "user:yes tool:FAIL parser assistant:Fixed it. user:yes tool:FAIL parser assistant:Still failing."
+	if got := strings.Join(texts, " "); got != want {
+		t.Errorf("entries = %s, want %s", got, want)
+	}
+}
+
+func TestPartialRedrawIsDeduplicated(t *testing.T) {
+	// A redraw of the last few entries, after a session long enough that the
+	// start has left the window of recent entries
+	var b strings.Builder
+	for i := 0; i < recentEntries; i++ {
+		fmt.Fprintf(&b, "> prompt %d\n\n⏺ reply %d\n\n", i, i)
+	}
+	tail := "> prompt 99\n\n⏺ reply 99\n\n"
+	b.WriteString(tail + tail + "────\n")
+	entries := extract(t, b.String())
+	if len(entries) != 2*recentEntries+2 {
+		t.Errorf("expected the redrawn entries to be dropped, got %d entries", len(entries))
+	}
+}
+
+func TestFlushIncludesScreenLines(t *testing.T) {
+	w := &recordingWriter{}
+	e := NewExtractor(w)
+	e.AddLine("> hello")
+	if len(w.entries) != 0 {
+		t.Fatalf("expected entry to be held until it is complete, got %+v", w.entries)
+	}
+
+	e.Flush([]string{"⏺ Hi there", "", "────", "> "})
+	if len(w.entries) != 2 || w.entries[1].Text != "Hi there" {
+		t.Errorf("unexpected entries: %+v", w.entries)
+	}
+}
+
+func TestMarkdownWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, FormatMarkdown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NewExtractor(w)
+	for _, line := range strings.Split(session, "\n") {
+		e.AddLine(line)
+	}
+	e.Flush(nil)
+
+	out := buf.String()
+	for _, want := range []string{
+		"## User\n\nfix the failing test\nin parser.go\n",
+		"### Bash(go test ./...)\n\n```\nFAIL parser\nok   other\n```",
+		"### Update(parser.go)\n\n```diff\n",
+		"## Assistant\n\nFixed.\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected markdown to contain %q, got:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "INSERT") || strings.Contains(out, "Thinking") {
+		t.Errorf("expected UI chrome to be left out, got:\n%s", out)
+	}
+}
+
+func TestJSONLWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, FormatJSONL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NewExtractor(w)
+	for _, line := range strings.Split(session, "\n") {
+		e.AddLine(line)
+	}
+	e.Flush(nil)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("expected 5 JSON lines, got %d", len(lines))
+	}
+	var entry Entry
+	if err := json.Unmarshal([]byte(lines[2]), &entry); err != nil {
+		t.Fatalf("invalid JSON line: %v", err)
+	}
+	if entry.Kind != KindTool || entry.Tool != "Bash" {
+		t.Errorf("unexpected entry: %+v", entry)
+	}
+}
+
+func TestFormatFromPath(t *testing.T) {
+	tests := map[string]string{
+		"session.md":    FormatMarkdown,
+		"session.jsonl": FormatJSONL,
+		"session.JSON":  FormatJSONL,
+		"session":       FormatMarkdown,
+	}
+	for path, want := range tests {
+		if got := FormatFromPath(path); got != want {
+			t.Errorf("FormatFromPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
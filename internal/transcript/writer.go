@@ -0,0 +1,74 @@
+package transcript
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Output formats
+const (
+	FormatMarkdown = "markdown"
+	FormatJSONL    = "jsonl"
+)
+
+// FormatFromPath picks the output format from a file extension, defaulting
+// to Markdown
+func FormatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl", ".json":
+		return FormatJSONL
+	}
+	return FormatMarkdown
+}
+
+// NewWriter returns a writer for the given format
+func NewWriter(w io.Writer, format string) (EntryWriter, error) {
+	switch format {
+	case FormatMarkdown:
+		return &markdownWriter{w: w}, nil
+	case FormatJSONL:
+		return &jsonlWriter{enc: json.NewEncoder(w)}, nil
+	}
+	return nil, fmt.Errorf("unknown transcript format %q", format)
+}
+
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func (j *jsonlWriter) WriteEntry(entry Entry) error {
+	return j.enc.Encode(entry)
+}
+
+type markdownWriter struct {
+	w io.Writer
+}
+
+func (m *markdownWriter) WriteEntry(entry Entry) error {
+	var sb strings.Builder
+	switch entry.Kind {
+	case KindUser:
+		fmt.Fprintf(&sb, "## User\n\n%s\n\n", entry.Text)
+	case KindAssistant:
+		fmt.Fprintf(&sb, "## Assistant\n\n%s\n\n", entry.Text)
+	case KindTool:
+		fmt.Fprintf(&sb, "### %s(%s)\n\n", entry.Tool, entry.Args)
+		if entry.Diff != "" {
+			fmt.Fprintf(&sb, "```diff\n%s```\n\n", ensureNewline(entry.Diff))
+		} else if entry.Text != "" {
+			fmt.Fprintf(&sb, "```\n%s```\n\n", ensureNewline(entry.Text))
+		}
+	}
+	_, err := io.WriteString(m.w, sb.String())
+	return err
+}
+
+func ensureNewline(s string) string {
+	if strings.HasSuffix(s, "\n") {
+		return s
+	}
+	return s + "\n"
+}
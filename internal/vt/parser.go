@@ -0,0 +1,432 @@
+package vt
+
+import (
+	"unicode/utf8"
+)
+
+// parserState is the state of the escape sequence state machine
+type parserState int
+
+const (
+	stateGround parserState = iota
+	stateEscape
+	stateEscapeIntermediate
+	stateCSI
+	stateOSC
+	stateString // DCS, SOS, PM and APC: consumed and ignored
+	stateStringEscape
+)
+
+// maxParams bounds the number of CSI parameters we collect
+const maxParams = 16
+
+// parser is a byte-at-a-time VT escape sequence parser. It keeps its state
+// between calls so sequences split across writes are handled correctly.
+type parser struct {
+	state parserState
+
+	// UTF-8 assembly
+	utf8Buf  [utf8.UTFMax]byte
+	utf8Len  int
+	utf8Need int
+
+	// CSI collection
+	params       [maxParams]int
+	paramCount   int
+	private      byte // '?', '>', '<' or '=' prefix, 0 if none
+	intermediate byte
+
+	// OSC handling: tracks whether the previous byte was ESC (for ST)
+	oscEscape bool
+}
+
+func (p *parser) resetCSI() {
+	p.paramCount = 0
+	p.private = 0
+	p.intermediate = 0
+	for i := range p.params {
+		p.params[i] = 0
+	}
+}
+
+// feed processes a single byte of output
+func (p *parser) feed(s *Screen, b byte) {
+	// CAN and SUB abort any sequence in progress
+	if b == 0x18 || b == 0x1a {
+		p.state = stateGround
+		p.utf8Need = 0
+		return
+	}
+
+	switch p.state {
+	case stateGround:
+		p.ground(s, b)
+
+	case stateEscape:
+		p.escape(s, b)
+
+	case stateEscapeIntermediate:
+		// Charset designation (ESC ( B etc.) and other two-byte sequences
+		if b >= 0x20 && b <= 0x2f {
+			return
+		}
+		p.state = stateGround
+
+	case stateCSI:
+		p.csi(s, b)
+
+	case stateOSC:
+		switch {
+		case b == 0x07:
+			p.state = stateGround
+		case b == 0x1b:
+			p.oscEscape = true
+		case p.oscEscape && b == '\\':
+			p.oscEscape = false
+			p.state = stateGround
+		default:
+			p.oscEscape = false
+		}
+
+	case stateString:
+		if b == 0x1b {
+			p.state = stateStringEscape
+		}
+
+	case stateStringEscape:
+		if b == '\\' {
+			p.state = stateGround
+		} else {
+			p.state = stateString
+		}
+	}
+}
+
+func (p *parser) ground(s *Screen, b byte) {
+	// Continue a multi-byte UTF-8 sequence
+	if p.utf8Need > 0 {
+		if b&0xc0 == 0x80 {
+			p.utf8Buf[p.utf8Len] = b
+			p.utf8Len++
+			if p.utf8Len == p.utf8Need {
+				r, _ := utf8.DecodeRune(p.utf8Buf[:p.utf8Len])
+				p.utf8Need = 0
+				s.print(r)
+			}
+			return
+		}
+		// Invalid continuation: drop the partial rune and handle b normally
+		p.utf8Need = 0
+		s.print(utf8.RuneError)
+	}
+
+	switch {
+	case b == 0x1b:
+		p.state = stateEscape
+	case b < 0x20 || b == 0x7f:
+		p.control(s, b)
+	case b < 0x80:
+		s.print(rune(b))
+	case b&0xe0 == 0xc0:
+		p.startUTF8(b, 2)
+	case b&0xf0 == 0xe0:
+		p.startUTF8(b, 3)
+	case b&0xf8 == 0xf0:
+		p.startUTF8(b, 4)
+	default:
+		s.print(utf8.RuneError)
+	}
+}
+
+func (p *parser) startUTF8(b byte, need int) {
+	p.utf8Buf[0] = b
+	p.utf8Len = 1
+	p.utf8Need = need
+}
+
+// control handles C0 control characters
+func (p *parser) control(s *Screen, b byte) {
+	switch b {
+	case '\r':
+		s.cursor.X = 0
+		s.wrapPending = false
+	case '\n', 0x0b, 0x0c:
+		s.lineFeed()
+	case 0x08: // backspace
+		if s.cursor.X > 0 {
+			s.cursor.X--
+		}
+		s.wrapPending = false
+	case '\t':
+		s.tab(1)
+	}
+	// BEL, NUL, DEL and the rest are ignored
+}
+
+// escape handles the byte following ESC
+func (p *parser) escape(s *Screen, b byte) {
+	p.state = stateGround
+	switch b {
+	case '[':
+		p.resetCSI()
+		p.state = stateCSI
+	case ']':
+		p.oscEscape = false
+		p.state = stateOSC
+	case 'P', 'X', '^', '_':
+		p.state = stateString
+	case '(', ')', '*', '+', '-', '.', '/', '#', '%', ' ':
+		p.state = stateEscapeIntermediate
+	case '7':
+		s.saveCursor()
+	case '8':
+		s.restoreCursor()
+	case 'D':
+		s.lineFeed()
+	case 'E':
+		s.cursor.X = 0
+		s.lineFeed()
+	case 'M':
+		s.reverseIndex()
+	case 'c':
+		s.reset()
+	case 0x1b:
+		// ESC ESC: stay in escape state
+		p.state = stateEscape
+	}
+	// Anything else (ESC =, ESC >, ...) has no effect on the grid
+}
+
+// param returns CSI parameter i, or def when it is missing or zero
+func (p *parser) param(i, def int) int {
+	if i >= p.paramCount || p.params[i] == 0 {
+		return def
+	}
+	return p.params[i]
+}
+
+func (p *parser) csi(s *Screen, b byte) {
+	switch {
+	case b >= '0' && b <= '9':
+		if p.paramCount == 0 {
+			p.paramCount = 1
+		}
+		idx := p.paramCount - 1
+		if p.params[idx] < 100000 {
+			p.params[idx] = p.params[idx]*10 + int(b-'0')
+		}
+		return
+	case b == ';' || b == ':':
+		if p.paramCount == 0 {
+			p.paramCount = 1
+		}
+		if p.paramCount < maxParams {
+			p.paramCount++
+		}
+		return
+	case b == '?' || b == '>' || b == '<' || b == '=':
+		p.private = b
+		return
+	case b >= 0x20 && b <= 0x2f:
+		p.intermediate = b
+		return
+	case b == 0x1b:
+		// ESC aborts the sequence and starts a new one
+		p.state = stateEscape
+		return
+	case b < 0x20:
+		// Control characters are executed in the middle of a sequence
+		p.control(s, b)
+		return
+	case b >= 0x40 && b <= 0x7e:
+		p.state = stateGround
+		p.dispatchCSI(s, b)
+		return
+	}
+	// Anything else aborts the sequence
+	p.state = stateGround
+}
+
+func (p *parser) dispatchCSI(s *Screen, final byte) {
+	if p.private != 0 && p.private != '?' {
+		return // xterm extensions we don't model (e.g. CSI > c)
+	}
+	if p.intermediate != 0 {
+		return // e.g. DECSCUSR (CSI SP q)
+	}
+
+	if p.private == '?' {
+		switch final {
+		case 'h':
+			p.setPrivateModes(s, true)
+		case 'l':
+			p.setPrivateModes(s, false)
+		}
+		return
+	}
+
+	switch final {
+	case '@':
+		s.insertChars(p.param(0, 1))
+	case 'A':
+		s.moveCursor(s.cursor.X, s.cursor.Y-p.param(0, 1))
+	case 'B', 'e':
+		s.moveCursor(s.cursor.X, s.cursor.Y+p.param(0, 1))
+	case 'C', 'a':
+		s.moveCursor(s.cursor.X+p.param(0, 1), s.cursor.Y)
+	case 'D':
+		s.moveCursor(s.cursor.X-p.param(0, 1), s.cursor.Y)
+	case 'E':
+		s.moveCursor(0, s.cursor.Y+p.param(0, 1))
+	case 'F':
+		s.moveCursor(0, s.cursor.Y-p.param(0, 1))
+	case 'G', '`':
+		s.moveCursor(p.param(0, 1)-1, s.cursor.Y)
+	case 'H', 'f':
+		s.moveCursor(p.param(1, 1)-1, p.param(0, 1)-1)
+	case 'I':
+		s.tab(p.param(0, 1))
+	case 'J':
+		s.eraseDisplay(p.rawParam(0))
+	case 'K':
+		s.eraseLine(p.rawParam(0))
+	case 'L':
+		s.insertLines(p.param(0, 1))
+	case 'M':
+		s.deleteLines(p.param(0, 1))
+	case 'P':
+		s.deleteChars(p.param(0, 1))
+	case 'S':
+		s.scrollUp(p.param(0, 1))
+	case 'T':
+		s.scrollDown(p.param(0, 1))
+	case 'X':
+		s.eraseCells(s.cursor.Y, s.cursor.X, s.cursor.X+p.param(0, 1))
+	case 'Z':
+		s.backTab(p.param(0, 1))
+	case 'd':
+		s.moveCursor(s.cursor.X, p.param(0, 1)-1)
+	case 'm':
+		p.sgr(s)
+	case 'r':
+		s.setScrollRegion(p.param(0, 1)-1, p.param(1, s.rows)-1)
+	case 's':
+		s.saveCursor()
+	case 'u':
+		s.restoreCursor()
+	}
+	// Device status reports, window ops etc. are answered by the real
+	// terminal, so there is nothing to do here
+}
+
+// rawParam returns parameter i without substituting a default for zero
+func (p *parser) rawParam(i int) int {
+	if i >= p.paramCount {
+		return 0
+	}
+	return p.params[i]
+}
+
+func (p *parser) setPrivateModes(s *Screen, on bool) {
+	count := p.paramCount
+	if count == 0 {
+		count = 1
+	}
+	for i := 0; i < count; i++ {
+		switch p.params[i] {
+		case 7:
+			s.autoWrap = on
+		case 25:
+			s.cursorVisible = on
+		case 47, 1047:
+			s.setAlternate(on, false)
+		case 1049:
+			s.setAlternate(on, true)
+		case 1048:
+			if on {
+				s.saveCursor()
+			} else {
+				s.restoreCursor()
+			}
+		case 2004:
+			s.bracketedPaste = on
+		}
+	}
+}
+
+// sgr applies Select Graphic Rendition parameters to the current attributes
+func (p *parser) sgr(s *Screen) {
+	if p.paramCount == 0 {
+		s.attr = defaultAttr
+		return
+	}
+	for i := 0; i < p.paramCount; i++ {
+		n := p.params[i]
+		switch {
+		case n == 0:
+			s.attr = defaultAttr
+		case n == 1:
+			s.attr.Bold = true
+		case n == 2:
+			s.attr.Dim = true
+		case n == 3:
+			s.attr.Italic = true
+		case n == 4:
+			s.attr.Underline = true
+		case n == 7:
+			s.attr.Reverse = true
+		case n == 22:
+			s.attr.Bold = false
+			s.attr.Dim = false
+		case n == 23:
+			s.attr.Italic = false
+		case n == 24:
+			s.attr.Underline = false
+		case n == 27:
+			s.attr.Reverse = false
+		case n >= 30 && n <= 37:
+			s.attr.Fg = n - 30
+		case n == 39:
+			s.attr.Fg = -1
+		case n >= 40 && n <= 47:
+			s.attr.Bg = n - 40
+		case n == 49:
+			s.attr.Bg = -1
+		case n >= 90 && n <= 97:
+			s.attr.Fg = n - 90 + 8
+		case n >= 100 && n <= 107:
+			s.attr.Bg = n - 100 + 8
+		case n == 38 || n == 48:
+			colour, consumed := p.extendedColour(i + 1)
+			if n == 38 {
+				s.attr.Fg = colour
+			} else {
+				s.attr.Bg = colour
+			}
+			i += consumed
+		}
+	}
+}
+
+// extendedColour parses the arguments of an SGR 38/48 sequence starting at
+// parameter i. Truecolour values are recorded as -1 since cells only track
+// palette indexes. It returns the colour and how many parameters it used.
+func (p *parser) extendedColour(i int) (int, int) {
+	if i >= p.paramCount {
+		return -1, 0
+	}
+	switch p.params[i] {
+	case 5:
+		if i+1 < p.paramCount {
+			return p.params[i+1], 2
+		}
+		return -1, 1
+	case 2:
+		used := 4
+		if i+used > p.paramCount {
+			used = p.paramCount - i
+		}
+		return -1, used
+	}
+	return -1, 1
+}
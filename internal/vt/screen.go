@@ -0,0 +1,645 @@
+// Package vt implements a small in-memory VT100/xterm screen model.
+//
+// It is fed the same bytes that are written to the real terminal and keeps
+// track of the visible grid of cells, the cursor and the alternate screen, so
+// that callers can ask "what is on screen right now" without shelling out to
+// a terminal multiplexer.
+package vt
+
+import (
+	"strings"
+	"sync"
+)
+
+// DefaultScrollback is the number of lines kept after they scroll off the top
+// of the primary screen
+const DefaultScrollback = 5000
+
+// Attr holds the graphic rendition of a cell
+type Attr struct {
+	Fg        int // -1 for default, otherwise a 256-colour palette index
+	Bg        int // -1 for default, otherwise a 256-colour palette index
+	Bold      bool
+	Dim       bool
+	Italic    bool
+	Underline bool
+	Reverse   bool
+}
+
+var defaultAttr = Attr{Fg: -1, Bg: -1}
+
+// Cell is a single character position on the screen
+type Cell struct {
+	Char rune // 0 for the trailing half of a wide character
+	Attr Attr
+}
+
+var blankCell = Cell{Char: ' ', Attr: defaultAttr}
+
+// Cursor is a zero-indexed cursor position
+type Cursor struct {
+	X int
+	Y int
+}
+
+// buffer is one of the two screens (primary or alternate)
+type buffer struct {
+	lines [][]Cell
+}
+
+func newBuffer(cols, rows int) *buffer {
+	b := &buffer{lines: make([][]Cell, rows)}
+	for i := range b.lines {
+		b.lines[i] = blankLine(cols)
+	}
+	return b
+}
+
+func blankLine(cols int) []Cell {
+	line := make([]Cell, cols)
+	for i := range line {
+		line[i] = blankCell
+	}
+	return line
+}
+
+// savedState is the cursor state stored by DECSC / CSI s
+type savedState struct {
+	cursor Cursor
+	attr   Attr
+}
+
+// Screen is a terminal screen model. It is safe for concurrent use: one
+// goroutine can Write output while others read the current state.
+type Screen struct {
+	mu sync.RWMutex
+
+	cols int
+	rows int
+
+	primary   *buffer
+	alternate *buffer
+	buf       *buffer // the active buffer
+	altActive bool
+
+	cursor      Cursor
+	saved       savedState
+	altSaved    savedState // cursor saved when entering the alternate screen
+	attr        Attr
+	wrapPending bool
+
+	scrollTop    int
+	scrollBottom int
+
+	autoWrap       bool
+	cursorVisible  bool
+	bracketedPaste bool
+
+	scrollback    []string
+	maxScrollback int
+	onScroll      func(line string)
+
+	p parser
+}
+
+// New creates a screen of the given size
+func New(cols, rows int) *Screen {
+	if cols < 1 {
+		cols = 80
+	}
+	if rows < 1 {
+		rows = 24
+	}
+	s := &Screen{
+		cols:          cols,
+		rows:          rows,
+		maxScrollback: DefaultScrollback,
+	}
+	s.reset()
+	return s
+}
+
+// reset restores the power-on state, keeping the size and scrollback
+func (s *Screen) reset() {
+	s.primary = newBuffer(s.cols, s.rows)
+	s.alternate = newBuffer(s.cols, s.rows)
+	s.buf = s.primary
+	s.altActive = false
+	s.cursor = Cursor{}
+	s.saved = savedState{attr: defaultAttr}
+	s.altSaved = savedState{attr: defaultAttr}
+	s.attr = defaultAttr
+	s.wrapPending = false
+	s.scrollTop = 0
+	s.scrollBottom = s.rows - 1
+	s.autoWrap = true
+	s.cursorVisible = true
+	s.bracketedPaste = false
+}
+
+// Write feeds terminal output into the model. It never returns an error so
+// the screen can be used directly as an io.Writer alongside the real terminal.
+func (s *Screen) Write(data []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, b := range data {
+		s.p.feed(s, b)
+	}
+	return len(data), nil
+}
+
+// Resize changes the screen dimensions. Lines that no longer fit at the
+// bottom of the primary screen are pushed into the scrollback so the cursor
+// stays on the same content.
+func (s *Screen) Resize(cols, rows int) {
+	if cols < 1 || rows < 1 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rows < s.rows && s.cursor.Y >= rows {
+		// Drop lines from the top so that the cursor row survives
+		excess := s.cursor.Y - rows + 1
+		for i := 0; i < excess; i++ {
+			s.pushScrollback(s.primary.lines[i])
+		}
+		s.primary.lines = s.primary.lines[excess:]
+		s.alternate.lines = s.alternate.lines[excess:]
+		s.cursor.Y -= excess
+	}
+
+	s.primary.lines = resizeLines(s.primary.lines, cols, rows)
+	s.alternate.lines = resizeLines(s.alternate.lines, cols, rows)
+
+	s.cols = cols
+	s.rows = rows
+	s.scrollTop = 0
+	s.scrollBottom = rows - 1
+	s.wrapPending = false
+	s.clampCursor()
+}
+
+func resizeLines(lines [][]Cell, cols, rows int) [][]Cell {
+	if len(lines) > rows {
+		lines = lines[:rows]
+	}
+	for i, line := range lines {
+		switch {
+		case len(line) > cols:
+			lines[i] = line[:cols]
+		case len(line) < cols:
+			extended := blankLine(cols)
+			copy(extended, line)
+			lines[i] = extended
+		}
+	}
+	for len(lines) < rows {
+		lines = append(lines, blankLine(cols))
+	}
+	return lines
+}
+
+// Size returns the screen dimensions
+func (s *Screen) Size() (cols, rows int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cols, s.rows
+}
+
+// Cursor returns the current cursor position
+func (s *Screen) Cursor() Cursor {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cursor
+}
+
+// CursorVisible reports whether the program has hidden the cursor
+func (s *Screen) CursorVisible() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cursorVisible
+}
+
+// IsAlternate reports whether the alternate screen is active
+func (s *Screen) IsAlternate() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.altActive
+}
+
+// BracketedPaste reports whether the program has enabled bracketed paste mode
+func (s *Screen) BracketedPaste() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.bracketedPaste
+}
+
+// Cell returns the cell at the given position, or a blank cell when out of range
+func (s *Screen) Cell(x, y int) Cell {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if y < 0 || y >= s.rows || x < 0 || x >= s.cols {
+		return blankCell
+	}
+	return s.buf.lines[y][x]
+}
+
+// Lines returns the text of each visible row with trailing spaces removed
+func (s *Screen) Lines() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	lines := make([]string, s.rows)
+	for i, line := range s.buf.lines {
+		lines[i] = lineText(line)
+	}
+	return lines
+}
+
+// UndimmedLines is like Lines, but with dim text replaced by spaces. Programs
+// draw placeholders and hints dim, so this leaves what was actually typed.
+func (s *Screen) UndimmedLines() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	lines := make([]string, s.rows)
+	cells := make([]Cell, s.cols)
+	for i, line := range s.buf.lines {
+		for x, c := range line {
+			if c.Attr.Dim && c.Char != 0 {
+				c.Char = ' '
+			}
+			cells[x] = c
+		}
+		lines[i] = lineText(cells[:len(line)])
+	}
+	return lines
+}
+
+// Text returns the visible screen contents as a single newline-separated string
+func (s *Screen) Text() string {
+	return strings.Join(s.Lines(), "\n")
+}
+
+// Scrollback returns the lines that have scrolled off the top of the primary
+// screen, oldest first
+func (s *Screen) Scrollback() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]string, len(s.scrollback))
+	copy(out, s.scrollback)
+	return out
+}
+
+// SetScrollbackLimit sets how many scrolled-off lines are kept. Zero disables
+// the scrollback.
+func (s *Screen) SetScrollbackLimit(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxScrollback = n
+	s.trimScrollback()
+}
+
+func lineText(line []Cell) string {
+	var sb strings.Builder
+	for _, c := range line {
+		if c.Char == 0 {
+			continue // trailing half of a wide character
+		}
+		sb.WriteRune(c.Char)
+	}
+	return strings.TrimRight(sb.String(), " ")
+}
+
+// SetScrollHandler registers a function that is called with the text of each
+// line as it scrolls off the top of the primary screen. Lines only scroll off
+// once the program has moved past them, so they won't be redrawn. The handler
+// runs while the screen is locked and must not call back into it.
+func (s *Screen) SetScrollHandler(fn func(line string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onScroll = fn
+}
+
+func (s *Screen) pushScrollback(line []Cell) {
+	text := lineText(line)
+	if s.onScroll != nil {
+		s.onScroll(text)
+	}
+	if s.maxScrollback <= 0 {
+		return
+	}
+	s.scrollback = append(s.scrollback, text)
+	s.trimScrollback()
+}
+
+func (s *Screen) trimScrollback() {
+	if len(s.scrollback) > s.maxScrollback {
+		s.scrollback = append([]string(nil), s.scrollback[len(s.scrollback)-s.maxScrollback:]...)
+	}
+}
+
+func (s *Screen) clampCursor() {
+	if s.cursor.X < 0 {
+		s.cursor.X = 0
+	}
+	if s.cursor.X >= s.cols {
+		s.cursor.X = s.cols - 1
+	}
+	if s.cursor.Y < 0 {
+		s.cursor.Y = 0
+	}
+	if s.cursor.Y >= s.rows {
+		s.cursor.Y = s.rows - 1
+	}
+}
+
+// blank returns an empty cell carrying the current background colour, which
+// is what erase operations fill with
+func (s *Screen) blank() Cell {
+	return Cell{Char: ' ', Attr: Attr{Fg: -1, Bg: s.attr.Bg}}
+}
+
+// print writes a printable rune at the cursor
+func (s *Screen) print(r rune) {
+	width := runeWidth(r)
+	if width == 0 {
+		return // combining characters are not tracked
+	}
+	if width == 2 && s.cols < 2 {
+		width = 1 // A wide character can't fit at all, so it gets one column
+	}
+
+	if s.wrapPending {
+		if s.autoWrap {
+			s.cursor.X = 0
+			s.lineFeed()
+		}
+		s.wrapPending = false
+	}
+
+	if width == 2 && s.cursor.X == s.cols-1 {
+		// A wide character does not fit in the last column
+		if s.autoWrap {
+			s.buf.lines[s.cursor.Y][s.cursor.X] = s.blank()
+			s.cursor.X = 0
+			s.lineFeed()
+		} else {
+			return
+		}
+	}
+
+	line := s.buf.lines[s.cursor.Y]
+	line[s.cursor.X] = Cell{Char: r, Attr: s.attr}
+	if width == 2 {
+		line[s.cursor.X+1] = Cell{Char: 0, Attr: s.attr}
+	}
+
+	if s.cursor.X+width >= s.cols {
+		s.cursor.X = s.cols - 1
+		s.wrapPending = true
+	} else {
+		s.cursor.X += width
+	}
+}
+
+// lineFeed moves the cursor down, scrolling the region if at its bottom
+func (s *Screen) lineFeed() {
+	s.wrapPending = false
+	if s.cursor.Y == s.scrollBottom {
+		s.scrollUp(1)
+	} else if s.cursor.Y < s.rows-1 {
+		s.cursor.Y++
+	}
+}
+
+// reverseIndex moves the cursor up, scrolling the region down if at its top
+func (s *Screen) reverseIndex() {
+	s.wrapPending = false
+	if s.cursor.Y == s.scrollTop {
+		s.scrollDown(1)
+	} else if s.cursor.Y > 0 {
+		s.cursor.Y--
+	}
+}
+
+// scrollUp scrolls the scroll region up by n lines
+func (s *Screen) scrollUp(n int) {
+	height := s.scrollBottom - s.scrollTop + 1
+	if n > height {
+		n = height
+	}
+	lines := s.buf.lines
+	for i := 0; i < n; i++ {
+		if s.buf == s.primary && s.scrollTop == 0 {
+			s.pushScrollback(lines[s.scrollTop])
+		}
+		copy(lines[s.scrollTop:s.scrollBottom], lines[s.scrollTop+1:s.scrollBottom+1])
+		lines[s.scrollBottom] = s.blankLine()
+	}
+}
+
+// scrollDown scrolls the scroll region down by n lines
+func (s *Screen) scrollDown(n int) {
+	height := s.scrollBottom - s.scrollTop + 1
+	if n > height {
+		n = height
+	}
+	lines := s.buf.lines
+	for i := 0; i < n; i++ {
+		copy(lines[s.scrollTop+1:s.scrollBottom+1], lines[s.scrollTop:s.scrollBottom])
+		lines[s.scrollTop] = s.blankLine()
+	}
+}
+
+func (s *Screen) blankLine() []Cell {
+	line := make([]Cell, s.cols)
+	b := s.blank()
+	for i := range line {
+		line[i] = b
+	}
+	return line
+}
+
+// eraseCells blanks cells [from, to) on row y
+func (s *Screen) eraseCells(y, from, to int) {
+	if from < 0 {
+		from = 0
+	}
+	if to > s.cols {
+		to = s.cols
+	}
+	b := s.blank()
+	line := s.buf.lines[y]
+	for x := from; x < to; x++ {
+		line[x] = b
+	}
+}
+
+// eraseDisplay implements ED (CSI J)
+func (s *Screen) eraseDisplay(mode int) {
+	switch mode {
+	case 0: // cursor to end of screen
+		s.eraseCells(s.cursor.Y, s.cursor.X, s.cols)
+		for y := s.cursor.Y + 1; y < s.rows; y++ {
+			s.eraseCells(y, 0, s.cols)
+		}
+	case 1: // start of screen to cursor
+		for y := 0; y < s.cursor.Y; y++ {
+			s.eraseCells(y, 0, s.cols)
+		}
+		s.eraseCells(s.cursor.Y, 0, s.cursor.X+1)
+	case 2: // whole screen
+		for y := 0; y < s.rows; y++ {
+			s.eraseCells(y, 0, s.cols)
+		}
+	case 3: // scrollback only
+		s.scrollback = nil
+	}
+}
+
+// eraseLine implements EL (CSI K)
+func (s *Screen) eraseLine(mode int) {
+	switch mode {
+	case 0:
+		s.eraseCells(s.cursor.Y, s.cursor.X, s.cols)
+	case 1:
+		s.eraseCells(s.cursor.Y, 0, s.cursor.X+1)
+	case 2:
+		s.eraseCells(s.cursor.Y, 0, s.cols)
+	}
+}
+
+// insertLines implements IL (CSI L)
+func (s *Screen) insertLines(n int) {
+	if s.cursor.Y < s.scrollTop || s.cursor.Y > s.scrollBottom {
+		return
+	}
+	top := s.scrollTop
+	s.scrollTop = s.cursor.Y
+	s.scrollDown(n)
+	s.scrollTop = top
+	s.cursor.X = 0
+}
+
+// deleteLines implements DL (CSI M)
+func (s *Screen) deleteLines(n int) {
+	if s.cursor.Y < s.scrollTop || s.cursor.Y > s.scrollBottom {
+		return
+	}
+	top := s.scrollTop
+	s.scrollTop = s.cursor.Y
+	// Deleted lines are not history, so bypass the scrollback
+	height := s.scrollBottom - s.scrollTop + 1
+	if n > height {
+		n = height
+	}
+	lines := s.buf.lines
+	for i := 0; i < n; i++ {
+		copy(lines[s.scrollTop:s.scrollBottom], lines[s.scrollTop+1:s.scrollBottom+1])
+		lines[s.scrollBottom] = s.blankLine()
+	}
+	s.scrollTop = top
+	s.cursor.X = 0
+}
+
+// insertChars implements ICH (CSI @)
+func (s *Screen) insertChars(n int) {
+	line := s.buf.lines[s.cursor.Y]
+	x := s.cursor.X
+	if n > s.cols-x {
+		n = s.cols - x
+	}
+	copy(line[x+n:], line[x:s.cols-n])
+	s.eraseCells(s.cursor.Y, x, x+n)
+}
+
+// deleteChars implements DCH (CSI P)
+func (s *Screen) deleteChars(n int) {
+	line := s.buf.lines[s.cursor.Y]
+	x := s.cursor.X
+	if n > s.cols-x {
+		n = s.cols - x
+	}
+	copy(line[x:], line[x+n:])
+	s.eraseCells(s.cursor.Y, s.cols-n, s.cols)
+}
+
+// moveCursor sets an absolute cursor position, clamped to the screen
+func (s *Screen) moveCursor(x, y int) {
+	s.cursor = Cursor{X: x, Y: y}
+	s.wrapPending = false
+	s.clampCursor()
+}
+
+func (s *Screen) saveCursor() {
+	s.saved = savedState{cursor: s.cursor, attr: s.attr}
+}
+
+func (s *Screen) restoreCursor() {
+	s.cursor = s.saved.cursor
+	s.attr = s.saved.attr
+	s.wrapPending = false
+	s.clampCursor()
+}
+
+// setAlternate switches between the primary and alternate screens. When
+// saveCursor is set (mode 1049) the cursor is saved on entry and restored on
+// exit, and the alternate screen is cleared on entry.
+func (s *Screen) setAlternate(on bool, saveCursor bool) {
+	if on == s.altActive {
+		return
+	}
+	if on {
+		if saveCursor {
+			s.altSaved = savedState{cursor: s.cursor, attr: s.attr}
+		}
+		s.alternate = newBuffer(s.cols, s.rows)
+		s.buf = s.alternate
+	} else {
+		s.buf = s.primary
+		if saveCursor {
+			s.cursor = s.altSaved.cursor
+			s.attr = s.altSaved.attr
+			s.clampCursor()
+		}
+	}
+	s.altActive = on
+	s.wrapPending = false
+}
+
+// setScrollRegion implements DECSTBM (CSI r). Arguments are zero-indexed.
+func (s *Screen) setScrollRegion(top, bottom int) {
+	if bottom >= s.rows {
+		bottom = s.rows - 1
+	}
+	if top < 0 {
+		top = 0
+	}
+	if top >= bottom {
+		top, bottom = 0, s.rows-1
+	}
+	s.scrollTop = top
+	s.scrollBottom = bottom
+	s.moveCursor(0, 0)
+}
+
+// tab moves the cursor to the next tab stop (every 8 columns)
+func (s *Screen) tab(n int) {
+	for i := 0; i < n; i++ {
+		next := (s.cursor.X/8 + 1) * 8
+		if next >= s.cols {
+			next = s.cols - 1
+		}
+		s.cursor.X = next
+	}
+}
+
+// backTab moves the cursor to the previous tab stop
+func (s *Screen) backTab(n int) {
+	for i := 0; i < n; i++ {
+		if s.cursor.X == 0 {
+			return
+		}
+		s.cursor.X = ((s.cursor.X - 1) / 8) * 8
+	}
+}
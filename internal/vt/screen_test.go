@@ -0,0 +1,272 @@
+package vt
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPlainText(t *testing.T) {
+	s := New(20, 3)
+	s.Write([]byte("hello\r\nworld"))
+
+	lines := s.Lines()
+	if lines[0] != "hello" || lines[1] != "world" || lines[2] != "" {
+		t.Errorf("unexpected lines: %q", lines)
+	}
+
+	cur := s.Cursor()
+	if cur.X != 5 || cur.Y != 1 {
+		t.Errorf("expected cursor at (5,1), got (%d,%d)", cur.X, cur.Y)
+	}
+}
+
+func TestSplitWrites(t *testing.T) {
+	s := New(20, 3)
+	// Escape sequence and a multi-byte rune split across writes
+	input := []byte("ab\x1b[2;3Hx⏺y")
+	for _, b := range input {
+		s.Write([]byte{b})
+	}
+
+	lines := s.Lines()
+	if lines[0] != "ab" {
+		t.Errorf("line 0: expected %q, got %q", "ab", lines[0])
+	}
+	if lines[1] != "  x⏺y" {
+		t.Errorf("line 1: expected %q, got %q", "  x⏺y", lines[1])
+	}
+}
+
+func TestScrollingAndScrollback(t *testing.T) {
+	s := New(10, 2)
+	s.Write([]byte("one\r\ntwo\r\nthree"))
+
+	lines := s.Lines()
+	if lines[0] != "two" || lines[1] != "three" {
+		t.Errorf("unexpected lines: %q", lines)
+	}
+
+	sb := s.Scrollback()
+	if len(sb) != 1 || sb[0] != "one" {
+		t.Errorf("unexpected scrollback: %q", sb)
+	}
+}
+
+func TestScrollHandler(t *testing.T) {
+	s := New(10, 2)
+	var scrolled []string
+	s.SetScrollHandler(func(line string) {
+		scrolled = append(scrolled, line)
+	})
+
+	s.Write([]byte("one\r\ntwo\r\nthree\r\nfour"))
+	// The alternate screen has no history
+	s.Write([]byte("\x1b[?1049hx\r\ny\r\nz\x1b[?1049l"))
+
+	if len(scrolled) != 2 || scrolled[0] != "one" || scrolled[1] != "two" {
+		t.Errorf("unexpected scrolled lines: %q", scrolled)
+	}
+}
+
+func TestAutoWrap(t *testing.T) {
+	s := New(5, 2)
+	s.Write([]byte("abcdefg"))
+
+	lines := s.Lines()
+	if lines[0] != "abcde" || lines[1] != "fg" {
+		t.Errorf("unexpected lines: %q", lines)
+	}
+}
+
+func TestEraseOperations(t *testing.T) {
+	s := New(10, 3)
+	s.Write([]byte("aaaaaaaaaa\r\nbbbbbbbbbb\r\ncccccccccc"))
+
+	// Erase to end of line from column 4 on row 1
+	s.Write([]byte("\x1b[2;5H\x1b[K"))
+	if got := s.Lines()[1]; got != "bbbb" {
+		t.Errorf("EL 0: expected %q, got %q", "bbbb", got)
+	}
+
+	// Erase from cursor to end of screen
+	s.Write([]byte("\x1b[J"))
+	if got := s.Lines()[2]; got != "" {
+		t.Errorf("ED 0: expected empty line, got %q", got)
+	}
+
+	// Erase whole screen
+	s.Write([]byte("\x1b[2J"))
+	if got := strings.TrimSpace(s.Text()); got != "" {
+		t.Errorf("ED 2: expected empty screen, got %q", got)
+	}
+}
+
+func TestCursorMovementRedraw(t *testing.T) {
+	// This mirrors how Claude redraws its input box: move up, clear the
+	// lines and draw them again
+	s := New(30, 5)
+	s.Write([]byte("> draft\r\n  -- NORMAL --"))
+	s.Write([]byte("\x1b[1A\r\x1b[2K> draft\r\n\x1b[2K  -- INSERT --"))
+
+	text := s.Text()
+	if !strings.Contains(text, "-- INSERT --") {
+		t.Errorf("expected INSERT indicator on screen, got %q", text)
+	}
+	if strings.Contains(text, "-- NORMAL --") {
+		t.Errorf("expected NORMAL indicator to be overwritten, got %q", text)
+	}
+}
+
+func TestAlternateScreen(t *testing.T) {
+	s := New(10, 2)
+	s.Write([]byte("main"))
+	s.Write([]byte("\x1b[?1049h"))
+
+	if !s.IsAlternate() {
+		t.Fatal("expected alternate screen to be active")
+	}
+	s.Write([]byte("\x1b[Hpager"))
+	if got := s.Lines()[0]; got != "pager" {
+		t.Errorf("alternate: expected %q, got %q", "pager", got)
+	}
+
+	s.Write([]byte("\x1b[?1049l"))
+	if s.IsAlternate() {
+		t.Fatal("expected primary screen to be active")
+	}
+	if got := s.Lines()[0]; got != "main" {
+		t.Errorf("primary: expected %q, got %q", "main", got)
+	}
+	if cur := s.Cursor(); cur.X != 4 {
+		t.Errorf("expected cursor restored to column 4, got %d", cur.X)
+	}
+}
+
+func TestScrollRegion(t *testing.T) {
+	s := New(10, 4)
+	s.Write([]byte("header\r\na\r\nb\r\nfooter"))
+
+	// Restrict scrolling to rows 2-3 and scroll the region
+	s.Write([]byte("\x1b[2;3r\x1b[3;1H\nc"))
+
+	lines := s.Lines()
+	expected := []string{"header", "b", "c", "footer"}
+	for i, want := range expected {
+		if lines[i] != want {
+			t.Errorf("line %d: expected %q, got %q", i, want, lines[i])
+		}
+	}
+
+	// Region scrolling must not add to scrollback
+	if sb := s.Scrollback(); len(sb) != 0 {
+		t.Errorf("expected no scrollback, got %q", sb)
+	}
+}
+
+func TestSGRAndOSCIgnoredInText(t *testing.T) {
+	s := New(20, 2)
+	s.Write([]byte("\x1b]0;title\x07\x1b[1;31mred\x1b[0m plain"))
+
+	if got := s.Lines()[0]; got != "red plain" {
+		t.Errorf("expected %q, got %q", "red plain", got)
+	}
+
+	cell := s.Cell(0, 0)
+	if !cell.Attr.Bold || cell.Attr.Fg != 1 {
+		t.Errorf("expected bold red attributes, got %+v", cell.Attr)
+	}
+	if cell := s.Cell(4, 0); cell.Attr != defaultAttr {
+		t.Errorf("expected default attributes after reset, got %+v", cell.Attr)
+	}
+}
+
+func TestWideCharacters(t *testing.T) {
+	s := New(10, 2)
+	s.Write([]byte("日本x"))
+
+	if got := s.Lines()[0]; got != "日本x" {
+		t.Errorf("expected %q, got %q", "日本x", got)
+	}
+	if cur := s.Cursor(); cur.X != 5 {
+		t.Errorf("expected cursor at column 5, got %d", cur.X)
+	}
+}
+
+func TestWideCharactersOnNarrowScreen(t *testing.T) {
+	s := New(1, 3)
+	s.Write([]byte("日本"))
+
+	if got := s.Lines(); got[0] != "日" || got[1] != "本" {
+		t.Errorf("expected one wide character per line, got %q", got)
+	}
+	if cur := s.Cursor(); cur.X != 0 || cur.Y != 1 {
+		t.Errorf("expected cursor at 0,1, got %d,%d", cur.X, cur.Y)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		cols int
+		want string
+	}{
+		{"hello", 10, "hello"},
+		{"hello", 3, "hel"},
+		{"日本語", 4, "日本"},
+		{"日本語", 5, "日本"},
+		{"e\u0301x", 1, "e\u0301"},
+	}
+	for _, tt := range tests {
+		got := Truncate(tt.s, tt.cols)
+		if got != tt.want {
+			t.Errorf("Truncate(%q, %d) = %q, want %q", tt.s, tt.cols, got, tt.want)
+		}
+		if StringWidth(got) > tt.cols {
+			t.Errorf("Truncate(%q, %d) is %d columns wide", tt.s, tt.cols, StringWidth(got))
+		}
+	}
+}
+
+func TestResize(t *testing.T) {
+	s := New(10, 4)
+	s.Write([]byte("1\r\n2\r\n3\r\n4"))
+	s.Resize(5, 2)
+
+	lines := s.Lines()
+	if len(lines) != 2 || lines[0] != "3" || lines[1] != "4" {
+		t.Errorf("unexpected lines after shrink: %q", lines)
+	}
+	if sb := s.Scrollback(); len(sb) != 2 || sb[0] != "1" || sb[1] != "2" {
+		t.Errorf("unexpected scrollback after shrink: %q", sb)
+	}
+
+	s.Resize(10, 4)
+	if cols, rows := s.Size(); cols != 10 || rows != 4 {
+		t.Errorf("expected size 10x4, got %dx%d", cols, rows)
+	}
+}
+
+func TestBracketedPasteMode(t *testing.T) {
+	s := New(10, 2)
+	s.Write([]byte("\x1b[?2004h"))
+	if !s.BracketedPaste() {
+		t.Error("expected bracketed paste to be enabled")
+	}
+	s.Write([]byte("\x1b[?2004l"))
+	if s.BracketedPaste() {
+		t.Error("expected bracketed paste to be disabled")
+	}
+}
+
+func TestUndimmedLines(t *testing.T) {
+	s := New(30, 2)
+	s.Write([]byte("> \x1b[2mTry \"fix lint errors\"\x1b[22m\r\n> typed \x1b[2mhint\x1b[0m"))
+
+	lines := s.UndimmedLines()
+	if lines[0] != ">" || lines[1] != "> typed" {
+		t.Errorf("unexpected lines: %q", lines)
+	}
+	if got := s.Lines()[0]; got != `> Try "fix lint errors"` {
+		t.Errorf("expected Lines to keep dim text, got %q", got)
+	}
+}
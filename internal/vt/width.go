@@ -0,0 +1,58 @@
+package vt
+
+// StringWidth returns the number of terminal columns s occupies
+func StringWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		width += runeWidth(r)
+	}
+	return width
+}
+
+// Truncate shortens s to fit in cols terminal columns
+func Truncate(s string, cols int) string {
+	width := 0
+	for i, r := range s {
+		width += runeWidth(r)
+		if width > cols {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// runeWidth returns the number of terminal columns a rune occupies: 0 for
+// combining marks and zero-width characters, 2 for East Asian wide and emoji
+// ranges, and 1 for everything else.
+//
+// This is a deliberately small table rather than a full Unicode width
+// implementation -- it only needs to be good enough to keep our cursor in step
+// with the real terminal for the text that Claude draws.
+func runeWidth(r rune) int {
+	switch {
+	case r == 0:
+		return 0
+	case r < 0x300:
+		return 1
+	case r >= 0x300 && r <= 0x36F, // combining diacritical marks
+		r >= 0x200B && r <= 0x200F, // zero width space/joiners, direction marks
+		r >= 0xFE00 && r <= 0xFE0F: // variation selectors
+		return 0
+	case r >= 0x1100 && r <= 0x115F, // Hangul Jamo
+		r >= 0x2E80 && r <= 0x303E, // CJK radicals, punctuation
+		r >= 0x3041 && r <= 0x33FF, // Hiragana, Katakana, CJK compatibility
+		r >= 0x3400 && r <= 0x4DBF, // CJK extension A
+		r >= 0x4E00 && r <= 0x9FFF, // CJK unified ideographs
+		r >= 0xA000 && r <= 0xA4CF, // Yi
+		r >= 0xAC00 && r <= 0xD7A3, // Hangul syllables
+		r >= 0xF900 && r <= 0xFAFF, // CJK compatibility ideographs
+		r >= 0xFE30 && r <= 0xFE4F, // CJK compatibility forms
+		r >= 0xFF00 && r <= 0xFF60, // fullwidth forms
+		r >= 0xFFE0 && r <= 0xFFE6,
+		r >= 0x1F300 && r <= 0x1F64F, // misc symbols and pictographs, emoticons
+		r >= 0x1F900 && r <= 0x1F9FF, // supplemental symbols and pictographs
+		r >= 0x20000 && r <= 0x3FFFD: // CJK extensions B onwards
+		return 2
+	}
+	return 1
+}